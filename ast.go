@@ -22,6 +22,29 @@ const (
 	BlockNode
 	VarDeclNode
 	TypeNode
+	ArrayTypeNode
+	SubrangeNode
+	IndexNode
+	RecordTypeNode
+	FieldNode
+	WithNode
+	TypeDeclNode
+	ConstDeclNode
+	EnumNode
+	CallNode
+	ForNode
+	CaseNode
+	SetTypeNode
+	SetConstructorNode
+	StrNode
+	ProcedureCallNode
+	PointerTypeNode
+	DerefNode
+	NilNode
+	ProcedureDeclNode
+	ParamNode
+	ProceduralTypeNode
+	FileTypeNode
 )
 
 // Type ...
@@ -29,11 +52,154 @@ func (nt NodeType) Type() NodeType {
 	return nt
 }
 
+// Commented ...
+// Embedded in nodes that keep the comments found before them
+type Commented struct {
+	Comments []Comment
+}
+
+// SetComments ...
+func (c *Commented) SetComments(comments []Comment) {
+	c.Comments = comments
+}
+
+// StatementToken ...
+// Returns the first token of a simple statement, ok is false for
+// compound statements and for nodes that are not statements.
+func StatementToken(n Node) (tok Token, ok bool) {
+	switch node := n.(type) {
+	case *Assign:
+		return ExprToken(node.Left), true
+	case *For:
+		return node.Tok, true
+	case *Case:
+		return node.Tok, true
+	case *ProcedureCall:
+		return node.Tok, true
+	}
+	return Token{}, false
+}
+
+// ExprToken ...
+// Returns the first token of an expression or of a type spec, for
+// reporting errors
+func ExprToken(n Node) Token {
+	switch node := n.(type) {
+	case *TypeN:
+		return node.Tok
+	case *Enum:
+		return node.Tok
+	case *SetType:
+		return node.Tok
+	case *FileType:
+		return node.Tok
+	case *SetConstructor:
+		return node.Tok
+	case *PointerType:
+		return node.Tok
+	case *Nil:
+		return node.Tok
+	case *Subrange:
+		return ExprToken(node.Low)
+	case *Call:
+		return node.Tok
+	case *Num:
+		return node.Tok
+	case *Str:
+		return node.Tok
+	case *Var:
+		return node.Tok
+	case *UnaryOp:
+		return node.Tok
+	case *BinOp:
+		return ExprToken(node.Left)
+	case *Index:
+		return ExprToken(node.Array)
+	case *Field:
+		return ExprToken(node.Record)
+	case *Deref:
+		return ExprToken(node.Pointer)
+	}
+	return Token{}
+}
+
+// BaseVar ...
+// Returns the variable a variable reference such as a[i, j].x
+// starts from. A field named inside a WITH statement starts from
+// the record variable of the WITH. It is nil for a reference such
+// as p^.x that goes through a pointer, into a variable of the heap.
+func BaseVar(n Node) *Var {
+	switch node := n.(type) {
+	case *Index:
+		return BaseVar(node.Array)
+	case *Field:
+		return BaseVar(node.Record)
+	case *Deref:
+		return nil
+	case *Var:
+		if node.With != nil {
+			return BaseVar(node.With.Records[node.WithIndex])
+		}
+	}
+	return n.(*Var)
+}
+
+// ResolveType ...
+// Follows type names to the type spec they were declared with
+func ResolveType(n Node) Node {
+	for {
+		node, ok := n.(*TypeN)
+		if !ok || node.Decl == nil {
+			return n
+		}
+		n = node.Decl.TNode
+	}
+}
+
+// BranchArms ...
+// Returns labels for the arms of a statement choosing between
+// them, in the order the interpreter numbers them when it reports
+// the arm taken to its observers. It is nil for other nodes.
+func BranchArms(n Node) []string {
+	switch node := n.(type) {
+	case *For:
+		return []string{"body", "exit"}
+	case *Case:
+		var arms []string
+		for _, arm := range node.Arms {
+			arms = append(arms, caseLabels(arm))
+		}
+		return append(arms, "else")
+	}
+	return nil
+}
+
+// Bounds ...
+// Range of the values of an ordinal type
+type Bounds struct {
+	Low, High int
+}
+
+// Domain ...
+// The numbers a variable of a numeric or ordinal type may hold.
+// Ordinal types hold integers only, within Range unless the type is
+// INTEGER.
+type Domain struct {
+	Ordinal bool
+	Range   *Bounds
+}
+
 // BinOp ...
 type BinOp struct {
 	NodeType
+	// Tok is the operator
+	Tok         Token
 	Op          int
 	Left, Right Node
+	// Concat is set by the semantic analyzer when + joins strings or
+	// characters, Integer when the operator gives an INTEGER
+	Concat  bool
+	Integer bool
 }
 
 // NewBinOp ...
@@ -53,6 +219,8 @@ func (n *BinOp) String() string {
 // UnaryOp ...
 type UnaryOp struct {
 	NodeType
+	// Tok is the operator
+	Tok  Token
 	Op   int
 	Expr Node
 }
@@ -90,10 +258,34 @@ func (n *Num) String() string {
 	return "Num"
 }
 
+// Str ...
+// A string constant, of type CHAR when it is a single character
+type Str struct {
+	NodeType
+	Tok   Token
+	Value string
+}
+
+// NewStr ...
+func NewStr(token Token) *Str {
+	return &Str{
+		NodeType: StrNode,
+		Tok:      token,
+		Value:    token.Svalue,
+	}
+}
+
+func (n *Str) String() string {
+	return "Str"
+}
+
 // Compound ...
 type Compound struct {
 	NodeType
+	Commented
 	Children []Node
+	// EndComments appear between the last statement and END
+	EndComments []Comment
 }
 
 // NewCompound ...
@@ -119,8 +311,15 @@ func (n *Compound) String() string {
 // Assign ...
 type Assign struct {
 	NodeType
+	Commented
 	Op          int
 	Left, Right Node
+	// Range bounds the values that may be assigned to a subrange
+	// variable, or the elements of a set assigned to a set variable.
+	// CharToString is set when a CHAR is assigned to a STRING. Both
+	// are set by the semantic analyzer.
+	Range        *Bounds
+	CharToString bool
 }
 
 // NewAssign ...
@@ -142,6 +341,18 @@ type Var struct {
 	NodeType
 	Tok   Token
 	Value string
+	// With is set by the semantic analyzer when the name is a field
+	// of the WithIndex-th record of an enclosing WITH statement.
+	// Otherwise Level is set to the nesting level of the scope the
+	// name is declared in, 0 for the predefined names.
+	With      *With
+	WithIndex int
+	Level     int
+	// Routine is set when the name is of a procedure or function,
+	// standing for the routine itself, or for a call of it without
+	// arguments when Invoke is set
+	Routine *ProcedureDecl
+	Invoke  bool
 }
 
 // NewVar ...
@@ -160,6 +371,7 @@ func (n *Var) String() string {
 // NoOp ...
 type NoOp struct {
 	NodeType
+	Commented
 }
 
 // NewNoOp ...
@@ -176,8 +388,12 @@ func (n *NoOp) String() string {
 // Program ...
 type Program struct {
 	NodeType
+	Commented
+	Tok       Token
 	Name      string
 	BlockNode Node
+	// EndComments appear after the final DOT
+	EndComments []Comment
 }
 
 // NewProgram ...
@@ -196,6 +412,7 @@ func (n *Program) String() string {
 // Block ...
 type Block struct {
 	NodeType
+	Commented
 	Decls        []Node
 	CompoundStmt Node
 }
@@ -216,8 +433,15 @@ func (n *Block) String() string {
 // VarDecl ...
 type VarDecl struct {
 	NodeType
+	Commented
 	VNode Node
 	TNode Node
+	// KeywordComments appear before the VAR keyword of the section
+	// the declaration is the first of
+	KeywordComments []Comment
+	// Domain is set by the semantic analyzer for variables holding
+	// a number, for debuggers to check the values they set
+	Domain *Domain
 }
 
 // NewVarDecl ...
@@ -233,11 +457,72 @@ func (n *VarDecl) String() string {
 	return "VarDecl"
 }
 
+// TypeDecl ...
+// Name = TNode in a TYPE section
+type TypeDecl struct {
+	NodeType
+	Commented
+	// Tok is the declared name
+	Tok   Token
+	Name  string
+	TNode Node
+	// KeywordComments appear before the TYPE keyword of the section
+	// the declaration is the first of
+	KeywordComments []Comment
+}
+
+// NewTypeDecl ...
+func NewTypeDecl(tok Token, tnode Node) *TypeDecl {
+	return &TypeDecl{
+		NodeType: TypeDeclNode,
+		Tok:      tok,
+		Name:     tok.Svalue,
+		TNode:    tnode,
+	}
+}
+
+func (n *TypeDecl) String() string {
+	return "TypeDecl"
+}
+
+// ConstDecl ...
+// Name = Expr in a CONST section
+type ConstDecl struct {
+	NodeType
+	Commented
+	// Tok is the declared name
+	Tok  Token
+	Name string
+	Expr Node
+	// KeywordComments appear before the CONST keyword of the section
+	// the declaration is the first of
+	KeywordComments []Comment
+}
+
+// NewConstDecl ...
+func NewConstDecl(tok Token, expr Node) *ConstDecl {
+	return &ConstDecl{
+		NodeType: ConstDeclNode,
+		Tok:      tok,
+		Name:     tok.Svalue,
+		Expr:     expr,
+	}
+}
+
+func (n *ConstDecl) String() string {
+	return "ConstDecl"
+}
+
 // TypeN ...
+// A builtin type, or a type declared in a TYPE section when Tok
+// is an identifier
 type TypeN struct {
 	NodeType
 	Tok   Token
 	Value float64
+	// Decl is the declaration of a named type, it is set by the
+	// semantic analyzer
+	Decl *TypeDecl
 }
 
 // NewTypeN ...
@@ -252,3 +537,560 @@ func NewTypeN(tok Token) *TypeN {
 func (n *TypeN) String() string {
 	return "TypeN"
 }
+
+// ArrayType ...
+// ARRAY[lo..hi, ...] OF Elem, with one index type per dimension,
+// a Subrange, an Enum or a TypeN naming either
+type ArrayType struct {
+	NodeType
+	Tok     Token
+	Indexes []Node
+	Elem    Node
+}
+
+// NewArrayType ...
+func NewArrayType(tok Token, indexes []Node, elem Node) *ArrayType {
+	return &ArrayType{
+		NodeType: ArrayTypeNode,
+		Tok:      tok,
+		Indexes:  indexes,
+		Elem:     elem,
+	}
+}
+
+func (n *ArrayType) String() string {
+	return "ArrayType"
+}
+
+// Subrange ...
+// Low..High, the bounds are constant expressions. A subrange type
+// or the index type of an array.
+type Subrange struct {
+	NodeType
+	Low, High Node
+}
+
+// NewSubrange ...
+func NewSubrange(low, high Node) *Subrange {
+	return &Subrange{
+		NodeType: SubrangeNode,
+		Low:      low,
+		High:     high,
+	}
+}
+
+func (n *Subrange) String() string {
+	return "Subrange"
+}
+
+// Enum ...
+// (Names...), an enumerated type
+type Enum struct {
+	NodeType
+	// Tok is the '('
+	Tok   Token
+	Names []Token
+}
+
+// NewEnum ...
+func NewEnum(tok Token, names []Token) *Enum {
+	return &Enum{
+		NodeType: EnumNode,
+		Tok:      tok,
+		Names:    names,
+	}
+}
+
+func (n *Enum) String() string {
+	return "Enum"
+}
+
+// SetType ...
+// SET OF Elem, Elem is an ordinal type spec
+type SetType struct {
+	NodeType
+	Tok  Token
+	Elem Node
+}
+
+// NewSetType ...
+func NewSetType(tok Token, elem Node) *SetType {
+	return &SetType{
+		NodeType: SetTypeNode,
+		Tok:      tok,
+		Elem:     elem,
+	}
+}
+
+func (n *SetType) String() string {
+	return "SetType"
+}
+
+// PointerType ...
+// ^Elem, Elem is a type name that may be declared after the pointer
+// type, so that records can point to each other
+type PointerType struct {
+	NodeType
+	// Tok is the '^'
+	Tok  Token
+	Elem Node
+}
+
+// NewPointerType ...
+func NewPointerType(tok Token, elem Node) *PointerType {
+	return &PointerType{
+		NodeType: PointerTypeNode,
+		Tok:      tok,
+		Elem:     elem,
+	}
+}
+
+func (n *PointerType) String() string {
+	return "PointerType"
+}
+
+// Nil ...
+// The pointer that points to nothing
+type Nil struct {
+	NodeType
+	Tok Token
+}
+
+// NewNil ...
+func NewNil(tok Token) *Nil {
+	return &Nil{
+		NodeType: NilNode,
+		Tok:      tok,
+	}
+}
+
+func (n *Nil) String() string {
+	return "Nil"
+}
+
+// SetConstructor ...
+// [Elems...], each element is an expression or a Subrange of them
+type SetConstructor struct {
+	NodeType
+	// Tok is the '['
+	Tok   Token
+	Elems []Node
+}
+
+// NewSetConstructor ...
+func NewSetConstructor(tok Token, elems []Node) *SetConstructor {
+	return &SetConstructor{
+		NodeType: SetConstructorNode,
+		Tok:      tok,
+		Elems:    elems,
+	}
+}
+
+func (n *SetConstructor) String() string {
+	return "SetConstructor"
+}
+
+// Call ...
+// Name(Args...), a call of a function
+type Call struct {
+	NodeType
+	// Tok is the function name
+	Tok  Token
+	Name string
+	Args []Node
+	// Range holds the bounds of the argument type for Low, High,
+	// and for Succ and Pred of a bounded type, Integer is set for
+	// Succ and Pred of an INTEGER. Decl is the function called unless
+	// it is a builtin or the call goes through the procedural
+	// Variable, and Ranges hold the bounds of its subrange parameters.
+	// They are set by the semantic analyzer.
+	Range    *Bounds
+	Integer  bool
+	Ranges   []*Bounds
+	Decl     *ProcedureDecl
+	Variable *Var
+}
+
+// NewCall ...
+func NewCall(tok Token, args []Node) *Call {
+	return &Call{
+		NodeType: CallNode,
+		Tok:      tok,
+		Name:     tok.Svalue,
+		Args:     args,
+	}
+}
+
+func (n *Call) String() string {
+	return "Call"
+}
+
+// ProcedureDecl ...
+// PROCEDURE Name(Params); BlockNode
+// or FUNCTION Name(Params) : Result; BlockNode
+type ProcedureDecl struct {
+	NodeType
+	Commented
+	// Tok is the procedure name
+	Tok    Token
+	Name   string
+	Params []*Param
+	// Result is the result type name of a function, nil for a
+	// procedure
+	Result Node
+	// BlockNode is nil for a FORWARD declaration, which announces a
+	// procedure whose body is declared later in the same block
+	BlockNode Node
+	// Level is the nesting level of the procedure's scope, set by
+	// the semantic analyzer
+	Level int
+	// ResultDomain is the Domain of the result of a function
+	ResultDomain *Domain
+}
+
+// NewProcedureDecl ...
+func NewProcedureDecl(tok Token, params []*Param, result Node, block Node) *ProcedureDecl {
+	return &ProcedureDecl{
+		NodeType:  ProcedureDeclNode,
+		Tok:       tok,
+		Name:      tok.Svalue,
+		Params:    params,
+		Result:    result,
+		BlockNode: block,
+	}
+}
+
+// ProceduralType ...
+// PROCEDURE(Params) or FUNCTION(Params) : Result, the type of the
+// variables and parameters that hold a procedure or function
+type ProceduralType struct {
+	NodeType
+	// Tok is the PROCEDURE or FUNCTION keyword
+	Tok    Token
+	Params []*Param
+	// Result is nil for a procedure
+	Result Node
+}
+
+// NewProceduralType ...
+func NewProceduralType(tok Token, params []*Param, result Node) *ProceduralType {
+	return &ProceduralType{
+		NodeType: ProceduralTypeNode,
+		Tok:      tok,
+		Params:   params,
+		Result:   result,
+	}
+}
+
+func (n *ProceduralType) String() string {
+	return "ProceduralType"
+}
+
+// FileType ...
+// FILE OF Elem, a file of components of the type Elem. Encoding
+// holds how the scalars of a component are stored, in the order
+// they are stored, and Ranges the bounds each of them must be
+// within. Both are set by the semantic analyzer.
+type FileType struct {
+	NodeType
+	Tok      Token
+	Elem     Node
+	Encoding []int
+	Ranges   []*Bounds
+}
+
+// NewFileType ...
+func NewFileType(tok Token, elem Node) *FileType {
+	return &FileType{
+		NodeType: FileTypeNode,
+		Tok:      tok,
+		Elem:     elem,
+	}
+}
+
+func (n *FileType) String() string {
+	return "FileType"
+}
+
+func (n *ProcedureDecl) String() string {
+	return "ProcedureDecl"
+}
+
+// Param ...
+// A formal parameter, there is one for each name of a group such as
+// VAR a, b : INTEGER, sharing the type. Mode is ValueArg, VarArg or
+// ConstArg.
+type Param struct {
+	NodeType
+	// Tok is the parameter name
+	Tok   Token
+	Name  string
+	Mode  int
+	TNode Node
+	// Domain is set by the semantic analyzer, as for a VarDecl
+	Domain *Domain
+}
+
+// NewParam ...
+func NewParam(tok Token, mode int, tnode Node) *Param {
+	return &Param{
+		NodeType: ParamNode,
+		Tok:      tok,
+		Name:     tok.Svalue,
+		Mode:     mode,
+		TNode:    tnode,
+	}
+}
+
+func (n *Param) String() string {
+	return "Param"
+}
+
+// Argument passing modes of a procedure call
+const (
+	// ValueArg is evaluated and passed to the procedure
+	ValueArg = iota
+	// VarArg is a variable the procedure reads and assigns
+	VarArg
+	// OutArg is a variable the procedure assigns only
+	OutArg
+	// ConstArg is evaluated and passed to the procedure, which may
+	// not assign to it
+	ConstArg
+)
+
+// ProcedureCall ...
+// Name(Args...) as a statement, a call of a procedure
+type ProcedureCall struct {
+	NodeType
+	Commented
+	// Tok is the procedure name
+	Tok  Token
+	Name string
+	Args []Node
+	// Modes holds the passing mode of each argument and Ranges the
+	// bounds of the subrange parameters and of the variables Read
+	// and Val read into. Decl is the procedure called unless it is
+	// a builtin or the call goes through the procedural Variable.
+	// Integer is set when Val reads into an INTEGER variable and
+	// Alloc is the type spec of the variables New allocates. Kinds
+	// holds the argument kind of each value Read reads or Write
+	// writes, after the file. They are set by the semantic analyzer.
+	Modes    []int
+	Ranges   []*Bounds
+	Decl     *ProcedureDecl
+	Variable *Var
+	Integer  bool
+	Alloc    Node
+	Kinds    []int
+}
+
+// NewProcedureCall ...
+func NewProcedureCall(tok Token, args []Node) *ProcedureCall {
+	return &ProcedureCall{
+		NodeType: ProcedureCallNode,
+		Tok:      tok,
+		Name:     tok.Svalue,
+		Args:     args,
+	}
+}
+
+func (n *ProcedureCall) String() string {
+	return "ProcedureCall"
+}
+
+// For ...
+// FOR Var := Start TO|DOWNTO End DO Body
+type For struct {
+	NodeType
+	Commented
+	Tok        Token
+	Var        Node
+	Start, End Node
+	Down       bool
+	Body       Node
+	// Range bounds the values of a subrange loop variable, it is set
+	// by the semantic analyzer
+	Range *Bounds
+}
+
+// NewFor ...
+func NewFor(tok Token, v Node, start Node, down bool, end Node, body Node) *For {
+	return &For{
+		NodeType: ForNode,
+		Tok:      tok,
+		Var:      v,
+		Start:    start,
+		End:      end,
+		Down:     down,
+		Body:     body,
+	}
+}
+
+func (n *For) String() string {
+	return "For"
+}
+
+// Case ...
+// CASE Expr OF Arms... ELSE Else END
+type Case struct {
+	NodeType
+	Commented
+	Tok  Token
+	Expr Node
+	Arms []*CaseArm
+	// Else holds the statements of the ELSE arm, it is nil when
+	// there is none
+	Else []Node
+	// EndComments appear between the last arm and END
+	EndComments []Comment
+	// Ranges are the values of the labels in ascending order, they
+	// are set by the semantic analyzer
+	Ranges []CaseRange
+}
+
+// CaseArm ...
+// Labels: Body, a label is a constant expression or a Subrange
+type CaseArm struct {
+	Commented
+	Labels []Node
+	Body   Node
+}
+
+// CaseRange ...
+// The selector values Low..High choose the arm numbered Arm
+type CaseRange struct {
+	Low, High int
+	Arm       int
+}
+
+// NewCase ...
+func NewCase(tok Token, expr Node, arms []*CaseArm) *Case {
+	return &Case{
+		NodeType: CaseNode,
+		Tok:      tok,
+		Expr:     expr,
+		Arms:     arms,
+	}
+}
+
+func (n *Case) String() string {
+	return "Case"
+}
+
+// Index ...
+// Array[Indexes...], Array is a Var or another Index
+type Index struct {
+	NodeType
+	// Tok is the opening bracket
+	Tok     Token
+	Array   Node
+	Indexes []Node
+}
+
+// NewIndex ...
+func NewIndex(tok Token, array Node, indexes []Node) *Index {
+	return &Index{
+		NodeType: IndexNode,
+		Tok:      tok,
+		Array:    array,
+		Indexes:  indexes,
+	}
+}
+
+func (n *Index) String() string {
+	return "Index"
+}
+
+// RecordType ...
+// RECORD fields END, each field is declared by a VarDecl
+type RecordType struct {
+	NodeType
+	Tok    Token
+	Fields []Node
+	// EndComments appear between the last field and END
+	EndComments []Comment
+}
+
+// NewRecordType ...
+func NewRecordType(tok Token, fields []Node) *RecordType {
+	return &RecordType{
+		NodeType: RecordTypeNode,
+		Tok:      tok,
+		Fields:   fields,
+	}
+}
+
+func (n *RecordType) String() string {
+	return "RecordType"
+}
+
+// Field ...
+// Record.Name, Record is any variable reference
+type Field struct {
+	NodeType
+	// Tok is the field name
+	Tok    Token
+	Record Node
+	Name   string
+}
+
+// NewField ...
+func NewField(tok Token, record Node) *Field {
+	return &Field{
+		NodeType: FieldNode,
+		Tok:      tok,
+		Record:   record,
+		Name:     tok.Svalue,
+	}
+}
+
+func (n *Field) String() string {
+	return "Field"
+}
+
+// Deref ...
+// Pointer^, the variable Pointer points to
+type Deref struct {
+	NodeType
+	// Tok is the '^'
+	Tok     Token
+	Pointer Node
+}
+
+// NewDeref ...
+func NewDeref(tok Token, pointer Node) *Deref {
+	return &Deref{
+		NodeType: DerefNode,
+		Tok:      tok,
+		Pointer:  pointer,
+	}
+}
+
+func (n *Deref) String() string {
+	return "Deref"
+}
+
+// With ...
+// WITH Records DO Body
+type With struct {
+	NodeType
+	Commented
+	Tok     Token
+	Records []Node
+	Body    Node
+}
+
+// NewWith ...
+func NewWith(tok Token, records []Node, body Node) *With {
+	return &With{
+		NodeType: WithNode,
+		Tok:      tok,
+		Records:  records,
+		Body:     body,
+	}
+}
+
+func (n *With) String() string {
+	return "With"
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// stringFunction ...
+// Runs a builtin function on strings, ok is false for the other
+// builtins
+func (in *Interpreter) stringFunction(node *Call) (value Value, ok bool) {
+	switch node.Name {
+	case "Length":
+		return float64(len(text(in.Visit(node.Args[0])))), true
+	case "Copy":
+		s := text(in.Visit(node.Args[0]))
+		index, count := in.integer(node.Args[1]), in.integer(node.Args[2])
+		return copyString(s, index, count), true
+	case "Pos":
+		sub, s := text(in.Visit(node.Args[0])), text(in.Visit(node.Args[1]))
+		if sub == "" {
+			return 0.0, true
+		}
+		return float64(strings.Index(s, sub) + 1), true
+	case "Concat":
+		var b strings.Builder
+		for _, arg := range node.Args {
+			b.WriteString(text(in.Visit(arg)))
+		}
+		return b.String(), true
+	case "UpCase":
+		c := in.number(node.Args[0])
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		return c, true
+	case "IntToStr":
+		return strconv.Itoa(in.integer(node.Args[0])), true
+	}
+	return nil, false
+}
+
+// copyString ...
+// The count characters of s from index on, as many as there are.
+// An index below 1 counts from the first character.
+func copyString(s string, index, count int) string {
+	if index < 1 {
+		index = 1
+	}
+	if index > len(s) || count <= 0 {
+		return ""
+	}
+	end := len(s)
+	if count < end-index+1 {
+		end = index - 1 + count
+	}
+	return s[index-1 : end]
+}
+
+// VisitProcedureCall ...
+// Runs a procedure declared by the program, one held by a
+// procedural variable or a builtin one. The variables a builtin
+// assigns are reported to the observers like assignments.
+func (in *Interpreter) VisitProcedureCall(n Node) Value {
+	node := n.(*ProcedureCall)
+	switch {
+	case node.Decl != nil:
+		in.invoke(in.closure(node.Decl), node.Args, node.Ranges, node.Tok)
+		return nil
+	case node.Variable != nil:
+		in.invoke(in.Visit(node.Variable).(Routine), node.Args, node.Ranges, node.Tok)
+		return nil
+	}
+	if in.fileProcedure(node) {
+		return nil
+	}
+	switch node.Name {
+	case "Insert":
+		source := text(in.Visit(node.Args[0]))
+		get, set, name := in.reference(node.Args[1])
+		index := in.integer(node.Args[2])
+		s := get().(string)
+		if index < 1 {
+			index = 1
+		}
+		if index > len(s) {
+			index = len(s) + 1
+		}
+		in.assign(set, name, s[:index-1]+source+s[index-1:], node.Args[1])
+	case "Delete":
+		get, set, name := in.reference(node.Args[0])
+		index, count := in.integer(node.Args[1]), in.integer(node.Args[2])
+		s := get().(string)
+		if index >= 1 && index <= len(s) && count > 0 {
+			in.assign(set, name, s[:index-1]+s[index-1+min(count, len(s)-index+1):], node.Args[0])
+		}
+	case "Str":
+		value := in.number(node.Args[0])
+		_, set, name := in.reference(node.Args[1])
+		in.assign(set, name, formatValue(value), node.Args[1])
+	case "Val":
+		s := text(in.Visit(node.Args[0]))
+		_, setx, namex := in.reference(node.Args[1])
+		_, setcode, namecode := in.reference(node.Args[2])
+		length := scanNumber(s, node.Integer)
+		code := 0.0
+		if length == len(s) && length > 0 {
+			x, _ := strconv.ParseFloat(s, 64)
+			if node.Integer && math.Abs(x) > MaxInteger {
+				code = float64(length)
+			} else {
+				in.checkBounds(node.Args[1], x, node.Ranges[1])
+				in.assign(setx, namex, x, node.Args[1])
+			}
+		} else {
+			code = float64(length + 1)
+		}
+		in.assign(setcode, namecode, code, node.Args[2])
+	case "New":
+		_, set, name := in.reference(node.Args[0])
+		in.assign(set, name, in.Heap.New(in.zeroValue(node.Alloc), node.Tok), node.Args[0])
+	case "Dispose":
+		p := in.Visit(node.Args[0]).(Pointer)
+		switch {
+		case p.Cell == nil:
+			in.runtimeError(node.Tok, "Dispose of NIL pointer %s", exprString(node.Args[0]))
+		case p.Cell.Disposed != nil:
+			in.runtimeError(node.Tok, "Dispose of %s, disposed already at %d:%d", exprString(node.Args[0]), p.Cell.Disposed.Line, p.Cell.Disposed.Column)
+		}
+		in.Heap.Dispose(p, node.Tok)
+	default:
+		in.Error()
+	}
+	return nil
+}
+
+// assign ...
+// Sets a variable and tells the observers, n is the argument
+// naming the variable
+func (in *Interpreter) assign(set func(Value), name string, value Value, n Node) {
+	set(value)
+	for _, o := range in.Observers {
+		o.Assign(name, value, n)
+	}
+}
+
+// scanNumber ...
+// Returns the length of the longest prefix of s that is a number,
+// signed and without spaces, in the syntax of Pascal. Integers have
+// no fraction and no exponent.
+func scanNumber(s string, integer bool) int {
+	digits := func(i int) int {
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	sign := func(i int) int {
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		return i
+	}
+	start := sign(0)
+	end := digits(start)
+	if end == start {
+		return 0
+	}
+	if integer {
+		return end
+	}
+	if end < len(s) && s[end] == '.' {
+		if fraction := digits(end + 1); fraction > end+1 {
+			end = fraction
+		}
+	}
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exponent := sign(end + 1)
+		if e := digits(exponent); e > exponent {
+			end = e
+		}
+	}
+	return end
+}
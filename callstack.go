@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Activation record types
+const (
+	ProgramAR = iota
+	ProcedureAR
+	FunctionAR
+)
+
+var arTypeStr = []string{
+	"PROGRAM",
+	"PROCEDURE",
+	"FUNCTION",
+}
+
+// ActivationRecord ...
+// Holds the variables of one running program or procedure body.
+// Link is the static link, to the record of the body the procedure
+// is declared in, nil for the program. Constants marks the members
+// that are constants, enumeration names or CONST parameters, and
+// Domains holds the Domain of every variable declared in the body,
+// whether it has a value or not. Decl is the Program or the
+// ProcedureDecl whose body runs in the record. Debuggers keep the
+// statement last started in the body in Statement, so the record
+// goes away with it when the body returns.
+type ActivationRecord struct {
+	Name         string
+	Type         int
+	NestingLevel int
+	Decl         Node
+	Members      map[string]Value
+	Constants    map[string]bool
+	Domains      map[string]*Domain
+	Link         *ActivationRecord
+	Statement    Token
+}
+
+// NewActivationRecord ...
+func NewActivationRecord(name string, artype int, nestinglevel int) *ActivationRecord {
+	return &ActivationRecord{
+		Name:         name,
+		Type:         artype,
+		NestingLevel: nestinglevel,
+		Members:      make(map[string]Value),
+		Constants:    make(map[string]bool),
+		Domains:      make(map[string]*Domain),
+	}
+}
+
+// Enclosing ...
+// The record at the nesting level, found by following the static
+// links from ar. Returns nil if there is none.
+func (ar *ActivationRecord) Enclosing(level int) *ActivationRecord {
+	for ar != nil && ar.NestingLevel > level {
+		ar = ar.Link
+	}
+	if ar != nil && ar.NestingLevel == level {
+		return ar
+	}
+	return nil
+}
+
+// Find ...
+// The record holding name, searching ar and then the records its
+// static links lead to. Returns nil if there is none.
+func (ar *ActivationRecord) Find(name string) *ActivationRecord {
+	for ; ar != nil; ar = ar.Link {
+		if _, exists := ar.Members[name]; exists {
+			return ar
+		}
+	}
+	return nil
+}
+
+// Declaring ...
+// The record declaring the variable name, searching like Find
+// whether or not the variable has a value. Returns nil if there is
+// none or if a constant of that name comes first.
+func (ar *ActivationRecord) Declaring(name string) *ActivationRecord {
+	for ; ar != nil; ar = ar.Link {
+		if ar.Constants[name] {
+			return nil
+		}
+		if _, declared := ar.Domains[name]; declared {
+			return ar
+		}
+	}
+	return nil
+}
+
+// Alias ...
+// The member for a VAR parameter, standing for the variable passed
+type Alias struct {
+	Get func() Value
+	Set func(Value)
+}
+
+// Get ...
+// Reading a VAR parameter reads the variable it stands for
+func (ar *ActivationRecord) Get(name string) (Value, bool) {
+	val, exists := ar.Members[name]
+	if alias, ok := val.(*Alias); ok {
+		return alias.Get(), true
+	}
+	return val, exists
+}
+
+// SetConstant ...
+// Defines a constant, which debuggers may not set
+func (ar *ActivationRecord) SetConstant(name string, val Value) {
+	ar.Members[name] = val
+	ar.Constants[name] = true
+}
+
+// Set ...
+// Setting a VAR parameter sets the variable it stands for
+func (ar *ActivationRecord) Set(name string, val Value) {
+	if alias, ok := ar.Members[name].(*Alias); ok {
+		alias.Set(val)
+		return
+	}
+	ar.Members[name] = val
+}
+
+func (ar *ActivationRecord) String() string {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%d: %s %s\n", ar.NestingLevel, arTypeStr[ar.Type], ar.Name)
+	names := make([]string, 0, len(ar.Members))
+	for name := range ar.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buffer, "   %-10s: %s\n", name, formatValue(ar.Members[name]))
+	}
+	return buffer.String()
+}
+
+// MaxCallDepth ...
+// The most records the call stack holds, so runaway recursion stops
+// with a runtime error before it exhausts the Go stack
+const MaxCallDepth = 10000
+
+// CallStack ...
+type CallStack struct {
+	records []*ActivationRecord
+}
+
+// Push ...
+func (cs *CallStack) Push(ar *ActivationRecord) {
+	cs.records = append(cs.records, ar)
+}
+
+// Pop ...
+func (cs *CallStack) Pop() *ActivationRecord {
+	ar := cs.records[len(cs.records)-1]
+	cs.records = cs.records[:len(cs.records)-1]
+	return ar
+}
+
+// Peek ...
+// The record of the body that is currently running
+func (cs *CallStack) Peek() *ActivationRecord {
+	return cs.records[len(cs.records)-1]
+}
+
+// Depth ...
+func (cs *CallStack) Depth() int {
+	return len(cs.records)
+}
+
+// Records ...
+// The records from the innermost call outwards
+func (cs *CallStack) Records() []*ActivationRecord {
+	records := make([]*ActivationRecord, len(cs.records))
+	for i, ar := range cs.records {
+		records[len(cs.records)-1-i] = ar
+	}
+	return records
+}
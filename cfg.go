@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BasicBlock ...
+// A straight-line run of statements with a single entry at
+// the top and a single exit at the bottom.
+type BasicBlock struct {
+	ID    int
+	Label string
+	Stmts []Node
+	Succs []*BasicBlock
+	Preds []*BasicBlock
+}
+
+// CFG ...
+// Control-flow graph of one program or procedure body.
+type CFG struct {
+	Name string
+	// Procedure is the procedure the body belongs to, nil for the
+	// program
+	Procedure *ProcedureDecl
+	// Decls are the declarations local to the body
+	Decls  []Node
+	Entry  *BasicBlock
+	Exit   *BasicBlock
+	Blocks []*BasicBlock
+}
+
+// NewBlock ...
+func (c *CFG) NewBlock(label string) *BasicBlock {
+	b := &BasicBlock{ID: len(c.Blocks), Label: label}
+	c.Blocks = append(c.Blocks, b)
+	return b
+}
+
+// Link ...
+// Add an edge from -> to
+func (c *CFG) Link(from, to *BasicBlock) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// Reachable ...
+// Returns the set of blocks that can be reached from the entry block.
+func (c *CFG) Reachable() map[*BasicBlock]bool {
+	seen := make(map[*BasicBlock]bool)
+	work := []*BasicBlock{c.Entry}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		work = append(work, b.Succs...)
+	}
+	return seen
+}
+
+// CFGBuilder ...
+// Walks statement nodes and splits them into basic blocks.
+// Expressions never change the flow of control, so only
+// statement nodes are registered in the VisitMap.
+type CFGBuilder struct {
+	VisitMap map[NodeType]func(n Node)
+
+	cfgs    []*CFG
+	cfg     *CFG
+	current *BasicBlock
+}
+
+// NewCFGBuilder ...
+func NewCFGBuilder() *CFGBuilder {
+	cb := &CFGBuilder{}
+	cb.VisitMap = make(map[NodeType]func(n Node))
+	cb.VisitMap[ProgramNode] = cb.VisitProgram
+	cb.VisitMap[BlockNode] = cb.VisitBlock
+	cb.VisitMap[CompoundNode] = cb.VisitCompound
+	cb.VisitMap[AssignNode] = cb.VisitAssign
+	cb.VisitMap[NoOpNode] = cb.VisitNoOp
+	cb.VisitMap[WithNode] = cb.VisitWith
+	cb.VisitMap[ForNode] = cb.VisitFor
+	cb.VisitMap[CaseNode] = cb.VisitCase
+	cb.VisitMap[ProcedureCallNode] = cb.VisitProcedureCall
+	cb.VisitMap[ProcedureDeclNode] = cb.VisitProcedureDecl
+	return cb
+}
+
+// Build ...
+// Returns one CFG per program/procedure body found in the tree.
+func (cb *CFGBuilder) Build(n Node) []*CFG {
+	cb.cfgs = nil
+	cb.Visit(n)
+	return cb.cfgs
+}
+
+// Visit ...
+func (cb *CFGBuilder) Visit(n Node) {
+	cb.VisitMap[n.Type()](n)
+}
+
+// VisitProgram ...
+func (cb *CFGBuilder) VisitProgram(n Node) {
+	node := n.(*Program)
+	cb.body(&CFG{Name: node.Name}, node.BlockNode)
+}
+
+// VisitProcedureDecl ...
+// A procedure body gets a CFG of its own, the body declaring it
+// carries on where it was. FORWARD declarations have no body.
+func (cb *CFGBuilder) VisitProcedureDecl(n Node) {
+	node := n.(*ProcedureDecl)
+	if node.BlockNode == nil {
+		return
+	}
+	cfg, current := cb.cfg, cb.current
+	cb.body(&CFG{Name: node.Name, Procedure: node}, node.BlockNode)
+	cb.cfg, cb.current = cfg, current
+}
+
+// body ...
+// Builds the CFG of a program or procedure body
+func (cb *CFGBuilder) body(c *CFG, block Node) {
+	cb.cfg = c
+	cb.cfg.Entry = cb.cfg.NewBlock("entry")
+	cb.current = cb.cfg.NewBlock("")
+	cb.cfg.Link(cb.cfg.Entry, cb.current)
+	cb.Visit(block)
+	cb.cfg.Exit = cb.cfg.NewBlock("exit")
+	cb.cfg.Link(cb.current, cb.cfg.Exit)
+	cb.cfgs = append(cb.cfgs, cb.cfg)
+}
+
+// VisitBlock ...
+// The procedures declared in the block are built first
+func (cb *CFGBuilder) VisitBlock(n Node) {
+	node := n.(*Block)
+	for _, decl := range node.Decls {
+		if _, ok := decl.(*ProcedureDecl); ok {
+			cb.Visit(decl)
+		}
+	}
+	cb.cfg.Decls = node.Decls
+	cb.Visit(node.CompoundStmt)
+}
+
+// VisitCompound ...
+func (cb *CFGBuilder) VisitCompound(n Node) {
+	node := n.(*Compound)
+	for _, child := range node.Children {
+		cb.Visit(child)
+	}
+}
+
+// VisitAssign ...
+func (cb *CFGBuilder) VisitAssign(n Node) {
+	cb.current.Stmts = append(cb.current.Stmts, n)
+}
+
+// VisitProcedureCall ...
+func (cb *CFGBuilder) VisitProcedureCall(n Node) {
+	cb.current.Stmts = append(cb.current.Stmts, n)
+}
+
+// VisitNoOp ...
+func (cb *CFGBuilder) VisitNoOp(n Node) {}
+
+// VisitWith ...
+// The WITH statement itself stands for finding its records, the
+// body follows it in the same block
+func (cb *CFGBuilder) VisitWith(n Node) {
+	cb.current.Stmts = append(cb.current.Stmts, n)
+	cb.Visit(n.(*With).Body)
+}
+
+// VisitFor ...
+// The FOR statement stands for the loop test, in a block of its
+// own that the body loops back to
+func (cb *CFGBuilder) VisitFor(n Node) {
+	test := cb.cfg.NewBlock("")
+	cb.cfg.Link(cb.current, test)
+	test.Stmts = append(test.Stmts, n)
+	cb.current = cb.cfg.NewBlock("")
+	cb.cfg.Link(test, cb.current)
+	cb.Visit(n.(*For).Body)
+	cb.cfg.Link(cb.current, test)
+	cb.current = cb.cfg.NewBlock("")
+	cb.cfg.Link(test, cb.current)
+}
+
+// VisitCase ...
+// The CASE statement stands for choosing an arm and ends its block.
+// Every arm starts a block of its own, and so does the ELSE arm,
+// left empty when absent, before all of them join.
+func (cb *CFGBuilder) VisitCase(n Node) {
+	node := n.(*Case)
+	cb.current.Stmts = append(cb.current.Stmts, n)
+	choice := cb.current
+	var ends []*BasicBlock
+	for _, arm := range node.Arms {
+		cb.current = cb.cfg.NewBlock("")
+		cb.cfg.Link(choice, cb.current)
+		cb.Visit(arm.Body)
+		ends = append(ends, cb.current)
+	}
+	cb.current = cb.cfg.NewBlock("")
+	cb.cfg.Link(choice, cb.current)
+	for _, stmt := range node.Else {
+		cb.Visit(stmt)
+	}
+	ends = append(ends, cb.current)
+	cb.current = cb.cfg.NewBlock("")
+	for _, end := range ends {
+		cb.cfg.Link(end, cb.current)
+	}
+}
+
+// WriteDot ...
+// Writes the graphs in Graphviz format. Blocks that can not be
+// reached from the entry are drawn greyed out.
+func WriteDot(w io.Writer, cfgs []*CFG) {
+	var buffer bytes.Buffer
+	buffer.WriteString("digraph cfggraph {\n")
+	buffer.WriteString("  node [shape=box, fontsize=12, fontname=\"Courier\"];\n")
+	for i, c := range cfgs {
+		reachable := c.Reachable()
+		fmt.Fprintf(&buffer, "  subgraph cluster%d {\n", i)
+		fmt.Fprintf(&buffer, "    label=\"%s\";\n", dotEscape(c.Name))
+		for _, b := range c.Blocks {
+			attrs := ""
+			if b.Label != "" {
+				attrs = ", shape=ellipse"
+			}
+			if !reachable[b] {
+				attrs += ", style=dashed, fontcolor=grey, color=grey"
+			}
+			fmt.Fprintf(&buffer, "    C%dB%d [label=\"%s\\l\"%s]\n", i, b.ID, dotEscape(b.String()), attrs)
+		}
+		for _, b := range c.Blocks {
+			for _, s := range b.Succs {
+				fmt.Fprintf(&buffer, "    C%dB%d -> C%dB%d\n", i, b.ID, i, s.ID)
+			}
+		}
+		buffer.WriteString("  }\n")
+	}
+	buffer.WriteString("}\n")
+	w.Write(buffer.Bytes())
+}
+
+// WriteMermaid ...
+// Writes the graphs as a Mermaid flowchart.
+func WriteMermaid(w io.Writer, cfgs []*CFG) {
+	var buffer bytes.Buffer
+	buffer.WriteString("flowchart TD\n")
+	for i, c := range cfgs {
+		reachable := c.Reachable()
+		fmt.Fprintf(&buffer, "  subgraph C%d [\"%s\"]\n", i, mermaidEscape(c.Name))
+		for _, b := range c.Blocks {
+			label := mermaidEscape(b.String())
+			if b.Label != "" {
+				fmt.Fprintf(&buffer, "    C%dB%d([\"%s\"])\n", i, b.ID, label)
+			} else {
+				fmt.Fprintf(&buffer, "    C%dB%d[\"%s\"]\n", i, b.ID, label)
+			}
+			if !reachable[b] {
+				fmt.Fprintf(&buffer, "    class C%dB%d unreachable\n", i, b.ID)
+			}
+		}
+		for _, b := range c.Blocks {
+			for _, s := range b.Succs {
+				fmt.Fprintf(&buffer, "    C%dB%d --> C%dB%d\n", i, b.ID, i, s.ID)
+			}
+		}
+		buffer.WriteString("  end\n")
+	}
+	buffer.WriteString("  classDef unreachable stroke-dasharray: 5 5, color: grey\n")
+	w.Write(buffer.Bytes())
+}
+
+func (b *BasicBlock) String() string {
+	if b.Label != "" {
+		return b.Label
+	}
+	lines := []string{fmt.Sprintf("B%d", b.ID)}
+	for _, stmt := range b.Stmts {
+		lines = append(lines, stmtString(stmt))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func dotEscape(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return strings.Replace(s, "\n", "\\l", -1)
+}
+
+func mermaidEscape(s string) string {
+	s = strings.Replace(s, "\"", "#quot;", -1)
+	return strings.Replace(s, "\n", "<br/>", -1)
+}
+
+// GenerateCFG ...
+// Writes cfggraph.dot and cfggraph.mmd and renders cfg.png
+// next to the AST graph.
+func GenerateCFG(cfgs []*CFG) {
+	f, err := os.Create("cfggraph.dot")
+	if err != nil {
+		panic(err)
+	}
+	WriteDot(f, cfgs)
+	f.Close()
+
+	f, err = os.Create("cfggraph.mmd")
+	if err != nil {
+		panic(err)
+	}
+	WriteMermaid(f, cfgs)
+	f.Close()
+
+	runDot("cfggraph.dot", "cfg.png")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildCFGs parses source and builds its graphs, failing the test on
+// errors
+func buildCFGs(t *testing.T, source string) []*CFG {
+	t.Helper()
+	tree, err := ParseSource(source)
+	if err != nil {
+		t.Fatalf("parse: %s\n%s", err, source)
+	}
+	return NewCFGBuilder().Build(tree)
+}
+
+// edges lists the edges of c by block ID, as in "0->1 1->2"
+func edges(c *CFG) string {
+	var list []string
+	for _, b := range c.Blocks {
+		for _, s := range b.Succs {
+			list = append(list, fmt.Sprintf("%d->%d", b.ID, s.ID))
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+// blocks lists the statements of the blocks of c, one block per
+// entry, joined by "; "
+func blocks(c *CFG) []string {
+	var list []string
+	for _, b := range c.Blocks {
+		if b.Label != "" {
+			list = append(list, b.Label)
+			continue
+		}
+		var stmts []string
+		for _, stmt := range b.Stmts {
+			stmts = append(stmts, stmtString(stmt))
+		}
+		list = append(list, strings.Join(stmts, "; "))
+	}
+	return list
+}
+
+func TestCFGEdges(t *testing.T) {
+	tests := []struct {
+		name   string
+		decls  string
+		stmts  string
+		edges  string
+		blocks []string
+	}{
+		{
+			name:   "straight line",
+			decls:  "VAR a, b : INTEGER;",
+			stmts:  "a := 1; b := a",
+			edges:  "0->1 1->2",
+			blocks: []string{"entry", "a := 1; b := a", "exit"},
+		},
+		{
+			name:   "FOR loops back to its test",
+			decls:  "VAR i, s : INTEGER;",
+			stmts:  "s := 0; FOR i := 1 TO 3 DO s := s + i; s := -s",
+			edges:  "0->1 1->2 2->3 2->4 3->2 4->5",
+			blocks: []string{"entry", "s := 0", "FOR i := 1 TO 3 DO", "s := s + i", "s := -s", "exit"},
+		},
+		{
+			name:   "nested FOR",
+			decls:  "VAR i, j, s : INTEGER;",
+			stmts:  "FOR i := 1 TO 3 DO FOR j := i DOWNTO 1 DO s := j",
+			edges:  "0->1 1->2 2->3 2->7 3->4 4->5 4->6 5->4 6->2 7->8",
+			blocks: []string{"entry", "", "FOR i := 1 TO 3 DO", "", "FOR j := i DOWNTO 1 DO", "s := j", "", "", "exit"},
+		},
+		{
+			name:   "CASE with ELSE",
+			decls:  "VAR s : INTEGER;",
+			stmts:  "CASE s OF 1: s := 2; 2..4: s := 3 ELSE s := 0 END; s := 1",
+			edges:  "0->1 1->2 1->3 1->4 2->5 3->5 4->5 5->6",
+			blocks: []string{"entry", "CASE s OF", "s := 2", "s := 3", "s := 0", "s := 1", "exit"},
+		},
+		{
+			name:   "CASE without ELSE falls through an empty block",
+			decls:  "VAR s : INTEGER;",
+			stmts:  "CASE s OF 1: s := 2 END",
+			edges:  "0->1 1->2 1->3 2->4 3->4 4->5",
+			blocks: []string{"entry", "CASE s OF", "s := 2", "", "", "exit"},
+		},
+		{
+			name:   "FOR inside a CASE arm",
+			decls:  "VAR i, s : INTEGER;",
+			stmts:  "CASE s OF 1: FOR i := 1 TO s DO s := i END",
+			edges:  "0->1 1->2 1->6 2->3 3->4 3->5 4->3 5->7 6->7 7->8",
+			blocks: []string{"entry", "CASE s OF", "", "FOR i := 1 TO s DO", "s := i", "", "", "", "exit"},
+		},
+		{
+			name:   "WITH stays in its block",
+			decls:  "VAR r : RECORD a : INTEGER END;",
+			stmts:  "WITH r DO a := 1",
+			edges:  "0->1 1->2",
+			blocks: []string{"entry", "WITH r DO; a := 1", "exit"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfgs := buildCFGs(t, program(test.decls, test.stmts))
+			if len(cfgs) != 1 {
+				t.Fatalf("got %d graphs, want 1", len(cfgs))
+			}
+			if got := edges(cfgs[0]); got != test.edges {
+				t.Errorf("edges %s, want %s", got, test.edges)
+			}
+			if got := blocks(cfgs[0]); strings.Join(got, " | ") != strings.Join(test.blocks, " | ") {
+				t.Errorf("blocks %q, want %q", got, test.blocks)
+			}
+		})
+	}
+}
+
+func TestCFGProcedures(t *testing.T) {
+	cfgs := buildCFGs(t, `PROGRAM Main;
+VAR g : INTEGER;
+PROCEDURE Outer;
+   PROCEDURE Inner;
+   BEGIN
+      g := 2
+   END;
+BEGIN
+   Inner
+END;
+PROCEDURE Later; FORWARD;
+BEGIN
+   Outer
+END.
+`)
+	var names []string
+	for _, c := range cfgs {
+		names = append(names, c.Name)
+		if reachable := c.Reachable(); len(reachable) != len(c.Blocks) {
+			t.Errorf("%s: %d of %d blocks reachable", c.Name, len(reachable), len(c.Blocks))
+		}
+	}
+	if got := strings.Join(names, " "); got != "Inner Outer Main" {
+		t.Errorf("graphs for %s, want Inner Outer Main", got)
+	}
+	if cfgs[0].Procedure == nil || cfgs[0].Procedure.Name != "Inner" || cfgs[2].Procedure != nil {
+		t.Errorf("procedures %v, %v", cfgs[0].Procedure, cfgs[2].Procedure)
+	}
+}
+
+// unreachableCFG ...
+// A graph with a block no edge leads to, which no statement of the
+// language can give
+func unreachableCFG(t *testing.T) []*CFG {
+	t.Helper()
+	cfgs := buildCFGs(t, program("VAR a : INTEGER;", `a := 1`))
+	c := cfgs[0]
+	dead := c.NewBlock("")
+	dead.Stmts = c.Blocks[1].Stmts
+	c.Link(dead, c.Exit)
+	c.Name = `Say "hi"`
+	return cfgs
+}
+
+func TestWriteDot(t *testing.T) {
+	var out bytes.Buffer
+	WriteDot(&out, unreachableCFG(t))
+	want := `digraph cfggraph {
+  node [shape=box, fontsize=12, fontname="Courier"];
+  subgraph cluster0 {
+    label="Say \"hi\"";
+    C0B0 [label="entry\l", shape=ellipse]
+    C0B1 [label="B1\la := 1\l"]
+    C0B2 [label="exit\l", shape=ellipse]
+    C0B3 [label="B3\la := 1\l", style=dashed, fontcolor=grey, color=grey]
+    C0B0 -> C0B1
+    C0B1 -> C0B2
+    C0B3 -> C0B2
+  }
+}
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	var out bytes.Buffer
+	WriteMermaid(&out, unreachableCFG(t))
+	want := `flowchart TD
+  subgraph C0 ["Say #quot;hi#quot;"]
+    C0B0(["entry"])
+    C0B1["B1<br/>a := 1"]
+    C0B2(["exit"])
+    C0B3["B3<br/>a := 1"]
+    class C0B3 unreachable
+    C0B0 --> C0B1
+    C0B1 --> C0B2
+    C0B3 --> C0B2
+  end
+  classDef unreachable stroke-dasharray: 5 5, color: grey
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Coverage ...
+// Observer recording which statements and which branch arms a run
+// executed. The statements are found through the control-flow
+// graphs of the program, so statements that never run are known.
+type Coverage struct {
+	// Statements in source order
+	Statements []*StatementCoverage
+
+	stmts map[Node]*StatementCoverage
+}
+
+// StatementCoverage ...
+type StatementCoverage struct {
+	Node  Node
+	Tok   Token
+	Count int
+	// Arms is nil unless the statement branches
+	Arms []*ArmCoverage
+}
+
+// ArmCoverage ...
+type ArmCoverage struct {
+	Label string
+	Count int
+}
+
+// NewCoverage ...
+func NewCoverage(tree Node) *Coverage {
+	c := &Coverage{stmts: make(map[Node]*StatementCoverage)}
+	for _, cfg := range NewCFGBuilder().Build(tree) {
+		for _, b := range cfg.Blocks {
+			for _, stmt := range b.Stmts {
+				tok, ok := StatementToken(stmt)
+				if !ok {
+					continue
+				}
+				sc := &StatementCoverage{Node: stmt, Tok: tok}
+				for _, label := range BranchArms(stmt) {
+					sc.Arms = append(sc.Arms, &ArmCoverage{Label: label})
+				}
+				c.Statements = append(c.Statements, sc)
+				c.stmts[stmt] = sc
+			}
+		}
+	}
+	sort.SliceStable(c.Statements, func(i, j int) bool {
+		a, b := c.Statements[i].Tok, c.Statements[j].Tok
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c
+}
+
+// EnterNode ...
+func (c *Coverage) EnterNode(n Node) {
+	if sc, exists := c.stmts[n]; exists {
+		sc.Count++
+	}
+}
+
+// ExitNode ...
+func (c *Coverage) ExitNode(n Node) {}
+
+// Assign ...
+func (c *Coverage) Assign(name string, value Value, n Node) {}
+
+// Call ...
+func (c *Coverage) Call(ar *ActivationRecord) {}
+
+// Return ...
+func (c *Coverage) Return(ar *ActivationRecord) {}
+
+// Branch ...
+func (c *Coverage) Branch(n Node, arm int) {
+	if sc, exists := c.stmts[n]; exists && arm < len(sc.Arms) {
+		sc.Arms[arm].Count++
+	}
+}
+
+// Totals ...
+// Returns the number of statements and branch arms, and how many
+// of them were executed
+func (c *Coverage) Totals() (stmts, stmtsRun, arms, armsRun int) {
+	for _, sc := range c.Statements {
+		stmts++
+		if sc.Count > 0 {
+			stmtsRun++
+		}
+		for _, arm := range sc.Arms {
+			arms++
+			if arm.Count > 0 {
+				armsRun++
+			}
+		}
+	}
+	return
+}
+
+func percent(run, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(run)/float64(total))
+}
+
+// WriteSummary ...
+func (c *Coverage) WriteSummary(w io.Writer) {
+	stmts, stmtsRun, arms, armsRun := c.Totals()
+	fmt.Fprintf(w, "statements: %d of %d executed (%s)\n", stmtsRun, stmts, percent(stmtsRun, stmts))
+	fmt.Fprintf(w, "branches:   %d of %d taken (%s)\n", armsRun, arms, percent(armsRun, arms))
+	var missed []string
+	for _, sc := range c.Statements {
+		line := fmt.Sprint(sc.Tok.Line)
+		if sc.Count == 0 && (len(missed) == 0 || missed[len(missed)-1] != line) {
+			missed = append(missed, line)
+		}
+	}
+	if len(missed) > 0 {
+		fmt.Fprintf(w, "missed lines: %s\n", strings.Join(missed, ", "))
+	}
+}
+
+// coverageLine ...
+// One source line with the statements starting on it
+type coverageLine struct {
+	Number int
+	Text   string
+	Stmts  []*StatementCoverage
+}
+
+// Count is the highest count of the statements on the line
+func (l coverageLine) Count() int {
+	count := 0
+	for _, sc := range l.Stmts {
+		if sc.Count > count {
+			count = sc.Count
+		}
+	}
+	return count
+}
+
+// Status is "none" without statements, else "covered", "partial"
+// or "missed"
+func (l coverageLine) Status() string {
+	if len(l.Stmts) == 0 {
+		return "none"
+	}
+	run, arms, armsRun := 0, 0, 0
+	for _, sc := range l.Stmts {
+		if sc.Count > 0 {
+			run++
+		}
+		for _, arm := range sc.Arms {
+			arms++
+			if arm.Count > 0 {
+				armsRun++
+			}
+		}
+	}
+	switch {
+	case run == 0:
+		return "missed"
+	case run < len(l.Stmts) || armsRun < arms:
+		return "partial"
+	}
+	return "covered"
+}
+
+func (c *Coverage) lines(source string) []coverageLine {
+	var lines []coverageLine
+	for i, text := range strings.Split(source, "\n") {
+		lines = append(lines, coverageLine{Number: i + 1, Text: text})
+	}
+	for _, sc := range c.Statements {
+		if sc.Tok.Line >= 1 && sc.Tok.Line <= len(lines) {
+			l := &lines[sc.Tok.Line-1]
+			l.Stmts = append(l.Stmts, sc)
+		}
+	}
+	return lines
+}
+
+// WriteAnnotated ...
+// Writes the source with the execution count in front of every
+// line holding statements, "#####" when none of them ran and a
+// "*" after the count when only some of them or some of their
+// branch arms ran. The arms of a branching statement are listed
+// below its line.
+func (c *Coverage) WriteAnnotated(w io.Writer, source string) {
+	for _, l := range c.lines(source) {
+		count := "-"
+		switch l.Status() {
+		case "missed":
+			count = "#####"
+		case "partial":
+			count = fmt.Sprintf("%d*", l.Count())
+		case "covered":
+			count = fmt.Sprint(l.Count())
+		}
+		fmt.Fprintf(w, "%9s:%5d:%s\n", count, l.Number, l.Text)
+		for _, sc := range l.Stmts {
+			for _, arm := range sc.Arms {
+				fmt.Fprintf(w, "%9s  branch %s taken %d\n", "", arm.Label, arm.Count)
+			}
+		}
+	}
+}
+
+// WriteHTML ...
+// Writes a standalone HTML page with the summary and the source,
+// lines colored by their status
+func (c *Coverage) WriteHTML(w io.Writer, filename, source string) error {
+	var summary strings.Builder
+	c.WriteSummary(&summary)
+	return coverageTemplate.Execute(w, struct {
+		Filename string
+		Summary  string
+		Lines    []coverageLine
+	}{filename, summary.String(), c.lines(source)})
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Filename}} coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 8px; white-space: pre; }
+td.count, td.number { text-align: right; color: #666; }
+tr.covered td.source { background: #d4f7d4; }
+tr.partial td.source { background: #fff3c4; }
+tr.missed td.source { background: #f9d0d0; }
+.arms { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Filename}}</h1>
+<pre>{{.Summary}}</pre>
+<table>
+{{- range .Lines}}
+<tr class="{{.Status}}"><td class="count">{{if ne .Status "none"}}{{.Count}}{{end}}</td><td class="number">{{.Number}}</td><td class="source">{{.Text}}{{range .Stmts}}{{range .Arms}} <span class="arms">[{{.Label}}: {{.Count}}]</span>{{end}}{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const coverageTestProgram = `PROGRAM T;
+VAR i, s : INTEGER;
+PROCEDURE Unused;
+BEGIN
+   s := 0
+END;
+BEGIN
+   s := 0;
+   FOR i := 1 TO 3 DO
+      CASE i OF
+         1: s := s + 1;
+         2: s := s + 2
+      ELSE
+         s := s DIV (3 - i)
+      END;
+   s := -s
+END.
+`
+
+// cover runs source recording its coverage and returns it with
+// the error that stopped the program
+func cover(t *testing.T, source string) (*Coverage, error) {
+	t.Helper()
+	tree := analyze(t, source)
+	coverage := NewCoverage(tree)
+	in := NewInterpreter()
+	in.Observe(coverage)
+	return coverage, in.Interpret(tree)
+}
+
+func TestCoverageSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errors string
+		want   string
+	}{
+		{
+			name:   "complete run",
+			source: strings.Replace(coverageTestProgram, "3 - i", "4 - i", 1),
+			want: `statements: 7 of 8 executed (87.5%)
+branches:   5 of 5 taken (100.0%)
+missed lines: 5
+`,
+		},
+		{
+			name:   "stopped by a runtime error",
+			source: coverageTestProgram,
+			errors: "runtime error: 14:17: division by zero",
+			want: `statements: 6 of 8 executed (75.0%)
+branches:   4 of 5 taken (80.0%)
+missed lines: 5, 16
+`,
+		},
+		{
+			name:   "no branches",
+			source: program("VAR a : INTEGER;", "a := 1"),
+			want: `statements: 1 of 1 executed (100.0%)
+branches:   0 of 0 taken (-)
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coverage, err := cover(t, test.source)
+			if (err == nil) != (test.errors == "") || err != nil && err.Error() != test.errors {
+				t.Fatalf("got error %v, want %q", err, test.errors)
+			}
+			var out strings.Builder
+			coverage.WriteSummary(&out)
+			if out.String() != test.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestCoverageAnnotated(t *testing.T) {
+	coverage, err := cover(t, coverageTestProgram)
+	if err == nil {
+		t.Fatal("the program should stop dividing by zero")
+	}
+	var out strings.Builder
+	coverage.WriteAnnotated(&out, coverageTestProgram)
+	want := `        -:    1:PROGRAM T;
+        -:    2:VAR i, s : INTEGER;
+        -:    3:PROCEDURE Unused;
+        -:    4:BEGIN
+    #####:    5:   s := 0
+        -:    6:END;
+        -:    7:BEGIN
+        1:    8:   s := 0;
+       1*:    9:   FOR i := 1 TO 3 DO
+           branch body taken 3
+           branch exit taken 0
+        3:   10:      CASE i OF
+           branch 1 taken 1
+           branch 2 taken 1
+           branch else taken 1
+        1:   11:         1: s := s + 1;
+        1:   12:         2: s := s + 2
+        -:   13:      ELSE
+        1:   14:         s := s DIV (3 - i)
+        -:   15:      END;
+    #####:   16:   s := -s
+        -:   17:END.
+        -:   18:
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCoverageHTML(t *testing.T) {
+	source := strings.Replace(coverageTestProgram, "TO 3", "TO 2", 1)
+	coverage, err := cover(t, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := coverage.WriteHTML(&out, "a<b>.pas", source); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>a&lt;b&gt;.pas coverage</title>",
+		"missed lines: 5, 14\n",
+		`<tr class="none"><td class="count"></td><td class="number">4</td><td class="source">BEGIN</td></tr>`,
+		`<tr class="missed"><td class="count">0</td><td class="number">5</td><td class="source">   s := 0</td></tr>`,
+		`<tr class="covered"><td class="count">1</td><td class="number">9</td><td class="source">   FOR i := 1 TO 2 DO <span class="arms">[body: 2]</span> <span class="arms">[exit: 1]</span></td></tr>`,
+		`<tr class="partial"><td class="count">2</td><td class="number">10</td><td class="source">      CASE i OF <span class="arms">[1: 1]</span> <span class="arms">[2: 1]</span> <span class="arms">[else: 0]</span></td></tr>`,
+		`<tr class="covered"><td class="count">1</td><td class="number">11</td><td class="source">         1: s := s &#43; 1;</td></tr>`,
+		`<tr class="missed"><td class="count">0</td><td class="number">14</td><td class="source">         s := s DIV (3 - i)</td></tr>`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("page lacks %s:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SyntaxElement ...
+// Either a *SyntaxNode or a *SyntaxToken
+type SyntaxElement interface {
+	writeText(buffer *bytes.Buffer)
+}
+
+// SyntaxNode ...
+// Interior node of the lossless concrete syntax tree. Unlike the
+// AST it keeps every token, including the punctuation, together
+// with the trivia around it, so String() gives back the source
+// exactly as it was read.
+type SyntaxNode struct {
+	Kind     string
+	Children []SyntaxElement
+}
+
+// SyntaxToken ...
+// Leaf of the concrete syntax tree
+type SyntaxToken struct {
+	Tok Token
+}
+
+func (n *SyntaxNode) writeText(buffer *bytes.Buffer) {
+	for _, child := range n.Children {
+		child.writeText(buffer)
+	}
+}
+
+func (t *SyntaxToken) writeText(buffer *bytes.Buffer) {
+	for _, trivia := range t.Tok.Leading {
+		buffer.WriteString(trivia.Text)
+	}
+	buffer.WriteString(t.Tok.Text)
+	for _, trivia := range t.Tok.Trailing {
+		buffer.WriteString(trivia.Text)
+	}
+}
+
+// String ...
+// The source text covered by the node, trivia included
+func (n *SyntaxNode) String() string {
+	var buffer bytes.Buffer
+	n.writeText(&buffer)
+	return buffer.String()
+}
+
+// Tokens ...
+// All tokens below the node in source order
+func (n *SyntaxNode) Tokens() []*SyntaxToken {
+	var tokens []*SyntaxToken
+	for _, child := range n.Children {
+		switch child := child.(type) {
+		case *SyntaxNode:
+			tokens = append(tokens, child.Tokens()...)
+		case *SyntaxToken:
+			tokens = append(tokens, child)
+		}
+	}
+	return tokens
+}
+
+// Dump ...
+// Indented outline of the tree, one element per line
+func (n *SyntaxNode) Dump() string {
+	var buffer bytes.Buffer
+	n.dump(&buffer, 0)
+	return buffer.String()
+}
+
+func (n *SyntaxNode) dump(buffer *bytes.Buffer, depth int) {
+	fmt.Fprintf(buffer, "%*s%s\n", depth*2, "", n.Kind)
+	for _, child := range n.Children {
+		switch child := child.(type) {
+		case *SyntaxNode:
+			child.dump(buffer, depth+1)
+		case *SyntaxToken:
+			fmt.Fprintf(buffer, "%*s%s %q\n", depth*2+2, "", strmap[child.Tok.Type], child.Tok.Text)
+		}
+	}
+}
+
+// cstBuilder ...
+// Stack of the syntax nodes the parser is currently inside
+type cstBuilder struct {
+	stack []*SyntaxNode
+}
+
+func (b *cstBuilder) top() *SyntaxNode {
+	return b.stack[len(b.stack)-1]
+}
+
+// open ...
+// Start a node, the following tokens and nodes become its children
+func (b *cstBuilder) open(kind string) {
+	b.stack = append(b.stack, &SyntaxNode{Kind: kind})
+}
+
+// close ...
+// Finish the innermost node and add it to its parent
+func (b *cstBuilder) close() *SyntaxNode {
+	node := b.top()
+	b.stack = b.stack[:len(b.stack)-1]
+	if len(b.stack) > 0 {
+		b.top().Children = append(b.top().Children, node)
+	}
+	return node
+}
+
+// token ...
+func (b *cstBuilder) token(tok Token) {
+	b.top().Children = append(b.top().Children, &SyntaxToken{Tok: tok})
+}
+
+// mark ...
+// Position in the current node for a later wrap
+func (b *cstBuilder) mark() int {
+	return len(b.top().Children)
+}
+
+// wrap ...
+// Move the children added since mark into a new node of kind.
+// Used for left-associative operators, where the left operand
+// is parsed before it is known that it needs a parent.
+func (b *cstBuilder) wrap(mark int, kind string) {
+	top := b.top()
+	node := &SyntaxNode{Kind: kind}
+	node.Children = append(node.Children, top.Children[mark:]...)
+	top.Children = append(top.Children[:mark], node)
+}
+
+// The parser calls these while it builds the AST. They do
+// nothing unless the lexer keeps trivia.
+
+func (p *Parser) open(kind string) {
+	if p.cst != nil {
+		p.cst.open(kind)
+	}
+}
+
+func (p *Parser) close() {
+	if p.cst != nil {
+		p.cst.close()
+	}
+}
+
+func (p *Parser) mark() int {
+	if p.cst == nil {
+		return 0
+	}
+	return p.cst.mark()
+}
+
+func (p *Parser) wrap(mark int, kind string) {
+	if p.cst != nil {
+		p.cst.wrap(mark, kind)
+	}
+}
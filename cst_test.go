@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// syntax parses source keeping trivia and returns its syntax tree
+func syntax(t *testing.T, source string) *SyntaxNode {
+	t.Helper()
+	lexer := NewLexer(source)
+	lexer.KeepTrivia = true
+	parser := NewParser(lexer)
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = r.(*Error)
+			}
+		}()
+		parser.Parse()
+	}()
+	if err != nil {
+		t.Fatalf("parse: %s\n%s", err, source)
+	}
+	return parser.Syntax
+}
+
+const irregular = "  (* leading *)program  Odd ;{no space}\r\n" +
+	"const\tN=3 ;  { trailing }\r\n" +
+	"type R = record a:integer ; { last field }\n\t\tend;\n" +
+	"var   s : string;\tr:R;\n" +
+	"  x,y:array [1..N]of real ;\n" +
+	"procedure P ( var v : integer ) ; forward;\n" +
+	"procedure P(var v:integer);begin v:=v+1 end ;\n" +
+	"BEGIN (* body *)\n" +
+	"\ts := 'it''s'#13#10 ;r.a:=N DIV 2;\n\n\n" +
+	"   x[1] := -  1.5 ; P ( r.a )\n" +
+	"END . { after the end }\n\n  "
+
+func TestSyntaxRoundTrip(t *testing.T) {
+	sources := map[string]string{
+		"sample 1":           pascalsample1,
+		"sample 2":           pascalsample2,
+		"irregular spacing":  irregular,
+		"no final newline":   "PROGRAM P;BEGIN END.",
+		"comment at the end": "PROGRAM P;\nBEGIN\nEND.\n{ one }\n(* two *)",
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			tree := syntax(t, source)
+			if got := tree.String(); got != source {
+				t.Errorf("got\n%q\nwant\n%q", got, source)
+			}
+			var text strings.Builder
+			for _, tok := range tree.Tokens() {
+				for _, trivia := range tok.Tok.Leading {
+					text.WriteString(trivia.Text)
+				}
+				text.WriteString(tok.Tok.Text)
+				for _, trivia := range tok.Tok.Trailing {
+					text.WriteString(trivia.Text)
+				}
+			}
+			if text.String() != source {
+				t.Errorf("tokens give\n%q\nwant\n%q", text.String(), source)
+			}
+		})
+	}
+}
+
+func TestSyntaxNodes(t *testing.T) {
+	tree := syntax(t, irregular)
+	if tree.Kind != "File" || len(tree.Children) != 2 {
+		t.Fatalf("got %s with %d children, want File with the program and EOF", tree.Kind, len(tree.Children))
+	}
+	program := tree.Children[0].(*SyntaxNode)
+	if program.Kind != "Program" {
+		t.Errorf("got %s, want Program", program.Kind)
+	}
+	eof := tree.Children[1].(*SyntaxToken)
+	if eof.Tok.Type != EOF || len(eof.Tok.Leading) == 0 {
+		t.Errorf("EOF token %+v should carry the final trivia", eof.Tok)
+	}
+	first := tree.Tokens()[0]
+	if first.Tok.Text != "program" || len(first.Tok.Leading) != 2 || first.Tok.Leading[1].Kind != CommentTrivia {
+		t.Errorf("first token %q has leading trivia %+v", first.Tok.Text, first.Tok.Leading)
+	}
+	dump := tree.Dump()
+	for _, want := range []string{"ConstDecl", "TypeDecl", "RecordType", "ArrayType", "ProcedureDecl", "Assign", "ProcedureCall", "BinOp", "UnaryOp"} {
+		if !strings.Contains(dump, " "+want+"\n") {
+			t.Errorf("no %s node in\n%s", want, dump)
+		}
+	}
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DAPServer ...
+// Debug Adapter Protocol server for `spi dap`. The program runs in
+// its own goroutine with the server as the interpreter's Hook; when
+// it pauses, the goroutine blocks until a run request resumes it,
+// while the server keeps answering requests about the paused state.
+type DAPServer struct {
+	stepControl
+	// Handlers maps a request command to its handler
+	Handlers map[string]func(args json.RawMessage) (interface{}, error)
+
+	reader *bufio.Reader
+	writer io.Writer
+	wmu    sync.Mutex // serializes writes and seq
+	seq    int
+
+	mu          sync.Mutex // guards stepControl and the fields below
+	path        string
+	tree        Node
+	interpreter *Interpreter
+	stopOnEntry bool
+	pauseNext   bool
+	stopped     bool
+	started     bool
+	// last is the statement that runs or ran last
+	last Token
+	// scopes holds the activation records handed out as scopes
+	// while paused, a variables reference is an index into it plus
+	// one
+	scopes []*ActivationRecord
+
+	resume chan int      // run mode sent to the paused program
+	done   chan struct{} // closed when the program goroutine ends
+	// resumeMode is sent on resume once the response to a
+	// run request has been written, -1 when there is none
+	resumeMode int
+}
+
+// DAP messages
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type dapStackFrame struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Source dapSource `json:"source"`
+	Line   int       `json:"line"`
+	Column int       `json:"column"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapThreadID is the only thread, the interpreter is single threaded
+const dapThreadID = 1
+
+// NewDAPServer ...
+func NewDAPServer(r io.Reader, w io.Writer) *DAPServer {
+	s := &DAPServer{
+		reader:     bufio.NewReader(r),
+		writer:     w,
+		resume:     make(chan int),
+		done:       make(chan struct{}),
+		resumeMode: -1,
+	}
+	s.Breakpoints = make(map[int]bool)
+	s.Handlers = make(map[string]func(args json.RawMessage) (interface{}, error))
+	s.Handlers["initialize"] = s.Initialize
+	s.Handlers["launch"] = s.Launch
+	s.Handlers["setBreakpoints"] = s.SetBreakpoints
+	s.Handlers["setExceptionBreakpoints"] = s.SetExceptionBreakpoints
+	s.Handlers["configurationDone"] = s.ConfigurationDone
+	s.Handlers["threads"] = s.Threads
+	s.Handlers["stackTrace"] = s.StackTrace
+	s.Handlers["scopes"] = s.Scopes
+	s.Handlers["variables"] = s.Variables
+	s.Handlers["setVariable"] = s.SetVariable
+	s.Handlers["evaluate"] = s.Evaluate
+	s.Handlers["continue"] = s.runHandler(debugContinue)
+	s.Handlers["next"] = s.runHandler(debugStepOver)
+	s.Handlers["stepIn"] = s.runHandler(debugStepInto)
+	s.Handlers["stepOut"] = s.runHandler(debugStepOut)
+	s.Handlers["pause"] = s.Pause
+	s.Handlers["disconnect"] = s.Disconnect
+	s.Handlers["terminate"] = s.Disconnect
+	return s
+}
+
+// Serve ...
+// Handles requests until the client disconnects or closes the stream
+func (s *DAPServer) Serve() error {
+	for {
+		body, err := readMessage(s.reader)
+		if err == io.EOF {
+			s.Disconnect(nil)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request dapRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return err
+		}
+		response := dapResponse{Type: "response", RequestSeq: request.Seq, Command: request.Command}
+		handler, exists := s.Handlers[request.Command]
+		if !exists {
+			response.Message = "unsupported request " + request.Command
+			s.write(&response)
+			continue
+		}
+		result, err := handler(request.Arguments)
+		if err != nil {
+			response.Message = err.Error()
+		} else {
+			response.Success = true
+			response.Body = result
+		}
+		s.write(&response)
+		if s.resumeMode >= 0 {
+			s.resume <- s.resumeMode
+			s.resumeMode = -1
+		}
+		switch request.Command {
+		case "initialize":
+			s.event("initialized", nil)
+		case "disconnect", "terminate":
+			return nil
+		}
+	}
+}
+
+// write ...
+// Numbers and writes a response or an event
+func (s *DAPServer) write(message interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	switch m := message.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+	writeMessage(s.writer, message)
+}
+
+func (s *DAPServer) event(event string, body interface{}) {
+	s.write(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+// Initialize ...
+func (s *DAPServer) Initialize(args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"supportsConfigurationDoneRequest": true,
+		"supportsSetVariable":              true,
+		"supportsTerminateRequest":         true,
+	}, nil
+}
+
+// Launch ...
+// Loads and checks the program, it starts on configurationDone
+func (s *DAPServer) Launch(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	text, err := os.ReadFile(a.Program)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := ParseSource(string(text))
+	if err != nil {
+		return nil, err
+	}
+	if errs := NewSemanticAnalyzer().Analyze(tree); len(errs) > 0 {
+		var messages []string
+		for _, e := range errs {
+			messages = append(messages, e.Error())
+		}
+		return nil, fmt.Errorf("%s", strings.Join(messages, "\n"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = a.Program
+	s.tree = tree
+	s.stopOnEntry = a.StopOnEntry
+	s.interpreter = NewInterpreter()
+	s.interpreter.Hook = s
+	return nil, nil
+}
+
+// SetBreakpoints ...
+// Replaces all breakpoints, there is a single source file
+func (s *DAPServer) SetBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Breakpoints = make(map[int]bool)
+	breakpoints := []map[string]interface{}{}
+	for _, bp := range a.Breakpoints {
+		s.Breakpoints[bp.Line] = true
+		breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "line": bp.Line})
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// SetExceptionBreakpoints ...
+// Runtime errors always end the program, there is nothing to set
+func (s *DAPServer) SetExceptionBreakpoints(args json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// ConfigurationDone ...
+// Starts the program
+func (s *DAPServer) ConfigurationDone(args json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.interpreter == nil {
+		return nil, fmt.Errorf("no program launched")
+	}
+	s.mode = debugContinue
+	if s.stopOnEntry {
+		s.mode = debugStepInto
+	}
+	s.started = true
+	go s.run()
+	return nil, nil
+}
+
+// run ...
+// Interprets the program, reporting how it ended. A failure of the
+// interpreter is reported like a runtime error, at the statement
+// that ran last.
+func (s *DAPServer) run() {
+	defer close(s.done)
+	exitCode := 0
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if r == errDebugQuit {
+					return
+				}
+				exitCode = 1
+				s.mu.Lock()
+				err := runtimeStop(r, s.last)
+				s.mu.Unlock()
+				s.event("output", map[string]string{"category": "stderr", "output": err.Error() + "\n"})
+			}
+		}()
+		if err := s.interpreter.Interpret(s.tree); err != nil {
+			exitCode = 1
+			s.event("output", map[string]string{"category": "stderr", "output": err.Error() + "\n"})
+		}
+	}()
+	s.event("exited", map[string]int{"exitCode": exitCode})
+	s.event("terminated", nil)
+}
+
+// BeforeStatement ...
+// Runs on the program goroutine, blocking it while paused
+func (s *DAPServer) BeforeStatement(n Node, tok Token) {
+	s.mu.Lock()
+	s.interpreter.CallStack.Peek().Statement = tok
+	s.last = tok
+	reason := "step"
+	switch {
+	case s.Breakpoints[tok.Line] && s.mode != debugStepInto:
+		reason = "breakpoint"
+	case s.pauseNext:
+		reason = "pause"
+	case s.stopOnEntry:
+		reason = "entry"
+	}
+	depth := s.interpreter.CallStack.Depth()
+	if !s.shouldPause(tok.Line, depth) && !s.pauseNext {
+		s.mu.Unlock()
+		return
+	}
+	s.depth = depth
+	s.pauseNext = false
+	s.stopOnEntry = false
+	s.stopped = true
+	s.scopes = nil
+	s.mu.Unlock()
+
+	s.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	})
+	mode, ok := <-s.resume
+	if !ok {
+		panic(errDebugQuit)
+	}
+	s.mu.Lock()
+	s.mode = mode
+	s.stopped = false
+	s.mu.Unlock()
+}
+
+// runHandler ...
+// Handler for a request that resumes the paused program in mode
+func (s *DAPServer) runHandler(mode int) func(args json.RawMessage) (interface{}, error) {
+	return func(args json.RawMessage) (interface{}, error) {
+		s.mu.Lock()
+		stopped := s.stopped
+		s.mu.Unlock()
+		if !stopped {
+			return nil, fmt.Errorf("program is not paused")
+		}
+		s.resumeMode = mode
+		if mode == debugContinue {
+			return map[string]bool{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	}
+}
+
+// Pause ...
+func (s *DAPServer) Pause(args json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pauseNext = true
+	return nil, nil
+}
+
+// Disconnect ...
+// Ends the program if it is still running
+func (s *DAPServer) Disconnect(args json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	started := s.started
+	s.started = false
+	s.mu.Unlock()
+	if started {
+		close(s.resume)
+		<-s.done
+	}
+	return nil, nil
+}
+
+// Threads ...
+func (s *DAPServer) Threads(args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"threads": []map[string]interface{}{{"id": dapThreadID, "name": "main"}},
+	}, nil
+}
+
+// pausedRecords ...
+// The activation records from the innermost outwards, only
+// available while the program is paused
+func (s *DAPServer) pausedRecords() ([]*ActivationRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return nil, fmt.Errorf("program is not paused")
+	}
+	return s.interpreter.CallStack.Records(), nil
+}
+
+// StackTrace ...
+// One frame per activation record, frame ids start at 1
+func (s *DAPServer) StackTrace(args json.RawMessage) (interface{}, error) {
+	records, err := s.pausedRecords()
+	if err != nil {
+		return nil, err
+	}
+	frames := []dapStackFrame{}
+	for i, ar := range records {
+		tok := ar.Statement
+		frames = append(frames, dapStackFrame{
+			ID:     i + 1,
+			Name:   ar.Name,
+			Source: dapSource{Name: s.path[strings.LastIndex(s.path, "/")+1:], Path: s.path},
+			Line:   tok.Line,
+			Column: tok.Column,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// Scopes ...
+// A frame has a scope for its activation record and one for each
+// record its static links lead to, innermost first, the variables
+// a nested procedure can reach. The scope of the program is called
+// Globals, the others are called after their procedure.
+func (s *DAPServer) Scopes(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	ar, err := s.record(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scopes := []dapScope{}
+	for link := ar; link != nil; link = link.Link {
+		name := link.Name
+		switch {
+		case link.Type == ProgramAR:
+			name = "Globals"
+		case link == ar:
+			name = "Locals"
+		}
+		scopes = append(scopes, dapScope{Name: name, VariablesReference: s.scopeReference(link)})
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+// scopeReference ...
+// The variables reference of an activation record, the same for
+// every frame it is a scope of
+func (s *DAPServer) scopeReference(ar *ActivationRecord) int {
+	for i, scope := range s.scopes {
+		if scope == ar {
+			return i + 1
+		}
+	}
+	s.scopes = append(s.scopes, ar)
+	return len(s.scopes)
+}
+
+// scope ...
+// The activation record of a variables reference
+func (s *DAPServer) scope(reference int) (*ActivationRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return nil, fmt.Errorf("program is not paused")
+	}
+	if reference < 1 || reference > len(s.scopes) {
+		return nil, fmt.Errorf("invalid variables reference %d", reference)
+	}
+	return s.scopes[reference-1], nil
+}
+
+// record ...
+// The activation record of a frame id
+func (s *DAPServer) record(frameID int) (*ActivationRecord, error) {
+	records, err := s.pausedRecords()
+	if err != nil {
+		return nil, err
+	}
+	if frameID < 1 || frameID > len(records) {
+		return nil, fmt.Errorf("invalid frame %d", frameID)
+	}
+	return records[frameID-1], nil
+}
+
+// Variables ...
+func (s *DAPServer) Variables(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	ar, err := s.scope(a.VariablesReference)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range ar.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	variables := []dapVariable{}
+	for _, name := range names {
+		variables = append(variables, dapVariable{Name: name, Value: formatValue(ar.Members[name])})
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+// SetVariable ...
+func (s *DAPServer) SetVariable(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	ar, err := s.scope(a.VariablesReference)
+	if err != nil {
+		return nil, err
+	}
+	val, err := strconv.ParseFloat(a.Value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", a.Value)
+	}
+	if ar, err = settable(ar, a.Name, val); err != nil {
+		return nil, err
+	}
+	ar.Set(a.Name, val)
+	return map[string]string{"value": formatValue(val)}, nil
+}
+
+// Evaluate ...
+// Looks up a variable name in the given or innermost frame and the
+// frames of the bodies enclosing it
+func (s *DAPServer) Evaluate(args json.RawMessage) (interface{}, error) {
+	var a struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, err
+	}
+	if a.FrameID == 0 {
+		a.FrameID = 1
+	}
+	ar, err := s.record(a.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(a.Expression)
+	if ar = ar.Find(name); ar == nil {
+		return nil, fmt.Errorf("%s has no value", a.Expression)
+	}
+	val, _ := ar.Get(name)
+	return map[string]interface{}{"result": formatValue(val), "variablesReference": 0}, nil
+}
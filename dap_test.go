@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dapMessage ...
+// A response or an event, decoded as far as the tests need
+type dapMessage struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// dapClient ...
+// Drives a DAPServer serving on a pair of pipes, one request at a
+// time. Events read while waiting for a response are kept for
+// waitEvent.
+type dapClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan dapMessage
+	events   []dapMessage
+	seq      int
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	c := &dapClient{t: t, in: inWriter, messages: make(chan dapMessage, 100)}
+	go func() {
+		NewDAPServer(inReader, outWriter).Serve()
+		outWriter.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		reader := bufio.NewReader(outReader)
+		for {
+			body, err := readMessage(reader)
+			if err != nil {
+				return
+			}
+			var message dapMessage
+			if err := json.Unmarshal(body, &message); err != nil {
+				return
+			}
+			c.messages <- message
+		}
+	}()
+	t.Cleanup(func() { inWriter.Close() })
+	return c
+}
+
+// next ...
+// The next message the server writes
+func (c *dapClient) next() dapMessage {
+	c.t.Helper()
+	select {
+	case message, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("server closed the stream")
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return dapMessage{}
+}
+
+// send ...
+// Sends a request and returns its response
+func (c *dapClient) send(command string, arguments interface{}) dapMessage {
+	c.t.Helper()
+	c.seq++
+	writeMessage(c.in, map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+	for {
+		message := c.next()
+		if message.Type == "event" {
+			c.events = append(c.events, message)
+			continue
+		}
+		if message.RequestSeq != c.seq {
+			c.t.Fatalf("%s: response to request %d, want %d", command, message.RequestSeq, c.seq)
+		}
+		return message
+	}
+}
+
+// request ...
+// Sends a request and returns the body of its successful response
+func (c *dapClient) request(command string, arguments interface{}) json.RawMessage {
+	c.t.Helper()
+	message := c.send(command, arguments)
+	if !message.Success {
+		c.t.Fatalf("%s failed: %s", command, message.Message)
+	}
+	return message.Body
+}
+
+// fail ...
+// Sends a request that must fail and returns the error message
+func (c *dapClient) fail(command string, arguments interface{}) string {
+	c.t.Helper()
+	message := c.send(command, arguments)
+	if message.Success {
+		c.t.Fatalf("%s succeeded, want it to fail", command)
+	}
+	return message.Message
+}
+
+// waitEvent ...
+// Returns the body of the next event, which must be the one named
+func (c *dapClient) waitEvent(event string) json.RawMessage {
+	c.t.Helper()
+	var message dapMessage
+	if len(c.events) > 0 {
+		message, c.events = c.events[0], c.events[1:]
+	} else {
+		message = c.next()
+	}
+	if message.Type != "event" || message.Event != event {
+		c.t.Fatalf("got %s %s%s, want event %s", message.Type, message.Command, message.Event, event)
+	}
+	return message.Body
+}
+
+func decode(t *testing.T, body json.RawMessage, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decoding %s: %s", body, err)
+	}
+}
+
+const dapTestProgram = `PROGRAM Nest;
+VAR g : INTEGER;
+PROCEDURE Outer;
+VAR o : INTEGER;
+   PROCEDURE Inner;
+   VAR i : INTEGER;
+   BEGIN
+      i := o + g;
+      g := i
+   END;
+BEGIN
+   o := 2;
+   Inner
+END;
+BEGIN
+   g := 1;
+   Outer
+END.
+`
+
+func TestDAPSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nest.pas")
+	if err := os.WriteFile(path, []byte(dapTestProgram), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newDAPClient(t)
+	c.request("initialize", map[string]string{"adapterID": "spi"})
+	c.waitEvent("initialized")
+	c.request("launch", map[string]interface{}{"program": path})
+	var breakpoints struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+			Line     int  `json:"line"`
+		} `json:"breakpoints"`
+	}
+	decode(t, c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 9}},
+	}), &breakpoints)
+	if len(breakpoints.Breakpoints) != 1 || !breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[0].Line != 9 {
+		t.Errorf("setBreakpoints: got %+v", breakpoints.Breakpoints)
+	}
+	c.request("setExceptionBreakpoints", map[string]interface{}{"filters": []string{}})
+	c.request("configurationDone", nil)
+
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+	decode(t, c.waitEvent("stopped"), &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("stopped for %q, want breakpoint", stopped.Reason)
+	}
+
+	var threads struct {
+		Threads []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"threads"`
+	}
+	decode(t, c.request("threads", nil), &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != dapThreadID {
+		t.Errorf("threads: got %+v, want thread %d only", threads.Threads, dapThreadID)
+	}
+
+	var trace struct {
+		StackFrames []dapStackFrame `json:"stackFrames"`
+	}
+	decode(t, c.request("stackTrace", map[string]int{"threadId": dapThreadID}), &trace)
+	var frames []string
+	for _, frame := range trace.StackFrames {
+		frames = append(frames, frame.Name)
+	}
+	if len(frames) != 3 || frames[0] != "Inner" || frames[1] != "Outer" || frames[2] != "Nest" || trace.StackFrames[0].Line != 9 {
+		t.Fatalf("stackTrace: got %+v, want Inner at line 9, Outer, Nest", trace.StackFrames)
+	}
+
+	var scopes struct {
+		Scopes []dapScope `json:"scopes"`
+	}
+	decode(t, c.request("scopes", map[string]int{"frameId": trace.StackFrames[0].ID}), &scopes)
+	want := []struct{ scope, name, value string }{
+		{"Locals", "i", "3"},
+		{"Outer", "o", "2"},
+		{"Globals", "g", "1"},
+	}
+	if len(scopes.Scopes) != len(want) {
+		t.Fatalf("scopes: got %+v, want Locals, Outer and Globals", scopes.Scopes)
+	}
+	for i, w := range want {
+		scope := scopes.Scopes[i]
+		if scope.Name != w.scope {
+			t.Errorf("scope %d: got %s, want %s", i, scope.Name, w.scope)
+		}
+		var variables struct {
+			Variables []dapVariable `json:"variables"`
+		}
+		decode(t, c.request("variables", map[string]int{"variablesReference": scope.VariablesReference}), &variables)
+		found := false
+		for _, v := range variables.Variables {
+			if v.Name == w.name {
+				found = true
+				if v.Value != w.value {
+					t.Errorf("%s: %s = %s, want %s", w.scope, v.Name, v.Value, w.value)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: no variable %s in %+v", w.scope, w.name, variables.Variables)
+		}
+	}
+
+	var outer struct {
+		Scopes []dapScope `json:"scopes"`
+	}
+	decode(t, c.request("scopes", map[string]int{"frameId": trace.StackFrames[1].ID}), &outer)
+	if len(outer.Scopes) != 2 || outer.Scopes[0].VariablesReference != scopes.Scopes[1].VariablesReference {
+		t.Errorf("scopes of Outer: got %+v, want its record as Locals and Globals", outer.Scopes)
+	}
+
+	for _, e := range []struct {
+		expression string
+		frame      int
+		result     string
+	}{
+		{"i", trace.StackFrames[0].ID, "3"},
+		{" o ", trace.StackFrames[0].ID, "2"},
+		{"g", 0, "1"},
+		{"o", trace.StackFrames[1].ID, "2"},
+	} {
+		var evaluated struct {
+			Result string `json:"result"`
+		}
+		decode(t, c.request("evaluate", map[string]interface{}{"expression": e.expression, "frameId": e.frame}), &evaluated)
+		if evaluated.Result != e.result {
+			t.Errorf("evaluate %q in frame %d: got %s, want %s", e.expression, e.frame, evaluated.Result, e.result)
+		}
+	}
+	if message := c.fail("evaluate", map[string]interface{}{"expression": "i", "frameId": trace.StackFrames[1].ID}); message != "i has no value" {
+		t.Errorf("evaluate i in Outer: got %q, want %q", message, "i has no value")
+	}
+
+	locals := scopes.Scopes[0].VariablesReference
+	for _, set := range []struct{ name, value, message string }{
+		{"i", "2.5", "i takes integers only, not 2.5"},
+		{"h", "1", "h is not a variable"},
+		{"i", "x", `invalid value "x"`},
+	} {
+		args := map[string]interface{}{"variablesReference": locals, "name": set.name, "value": set.value}
+		if message := c.fail("setVariable", args); message != set.message {
+			t.Errorf("setVariable %s = %s: got %q, want %q", set.name, set.value, message, set.message)
+		}
+	}
+	var set struct {
+		Value string `json:"value"`
+	}
+	decode(t, c.request("setVariable", map[string]interface{}{"variablesReference": scopes.Scopes[2].VariablesReference, "name": "g", "value": "-4"}), &set)
+	if set.Value != "-4" {
+		t.Errorf("setVariable g: got %s, want -4", set.Value)
+	}
+
+	c.request("continue", map[string]int{"threadId": dapThreadID})
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	decode(t, c.waitEvent("exited"), &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("exit code %d, want 0", exited.ExitCode)
+	}
+	c.waitEvent("terminated")
+	c.request("disconnect", nil)
+}
+
+const dapLoopProgram = `PROGRAM Loop;
+VAR i, j : INTEGER;
+BEGIN
+   FOR i := 1 TO 1000000000 DO
+      j := i
+END.
+`
+
+func TestDAPPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "loop.pas")
+	if err := os.WriteFile(path, []byte(dapLoopProgram), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newDAPClient(t)
+	c.request("initialize", map[string]string{"adapterID": "spi"})
+	c.waitEvent("initialized")
+	c.request("launch", map[string]interface{}{"program": path})
+	c.request("configurationDone", nil)
+	if message := c.fail("continue", map[string]int{"threadId": dapThreadID}); message != "program is not paused" {
+		t.Errorf("continue while running: got %q, want %q", message, "program is not paused")
+	}
+
+	c.request("pause", map[string]int{"threadId": dapThreadID})
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+	decode(t, c.waitEvent("stopped"), &stopped)
+	if stopped.Reason != "pause" {
+		t.Errorf("stopped for %q, want pause", stopped.Reason)
+	}
+	// The pause may come before the loop starts, a step is inside it
+	c.request("next", map[string]int{"threadId": dapThreadID})
+	c.waitEvent("stopped")
+	var trace struct {
+		StackFrames []dapStackFrame `json:"stackFrames"`
+	}
+	decode(t, c.request("stackTrace", map[string]int{"threadId": dapThreadID}), &trace)
+	if len(trace.StackFrames) != 1 || trace.StackFrames[0].Line != 5 {
+		t.Errorf("stackTrace: got %+v, want Loop at line 5", trace.StackFrames)
+	}
+	var evaluated struct {
+		Result string `json:"result"`
+	}
+	decode(t, c.request("evaluate", map[string]interface{}{"expression": "i"}), &evaluated)
+	if evaluated.Result == "" || evaluated.Result == "0" {
+		t.Errorf("evaluate i: got %q, want the count of the loop", evaluated.Result)
+	}
+
+	c.request("disconnect", nil)
+	decode(t, c.waitEvent("exited"), &struct{}{})
+	c.waitEvent("terminated")
+}
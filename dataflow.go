@@ -0,0 +1,581 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Warning ...
+// A diagnostic that does not stop the program from running.
+type Warning struct {
+	Tok     Token
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("warning: %d:%d: %s", w.Tok.Line, w.Tok.Column, w.Message)
+}
+
+// varSet ...
+// Set of variable names used as data-flow facts
+type varSet map[string]bool
+
+func (s varSet) copy() varSet {
+	c := make(varSet, len(s))
+	for name := range s {
+		c[name] = true
+	}
+	return c
+}
+
+func (s varSet) equal(o varSet) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for name := range s {
+		if !o[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary ...
+// What a call to a procedure does to the variables of its caller.
+// Reads and Writes hold the outer variables the procedure may read
+// before assigning them and may assign, Params the parameters it
+// may read before assigning them and Refs every outer variable its
+// body names.
+type Summary struct {
+	Reads, Writes, Params, Refs varSet
+}
+
+// NewSummary ...
+// The summary of a procedure that does nothing
+func NewSummary() *Summary {
+	return &Summary{Reads: make(varSet), Writes: make(varSet), Params: make(varSet), Refs: make(varSet)}
+}
+
+func (s *Summary) equal(o *Summary) bool {
+	return s.Reads.equal(o.Reads) && s.Writes.equal(o.Writes) && s.Params.equal(o.Params) && s.Refs.equal(o.Refs)
+}
+
+// DataFlow ...
+// Definite assignment and liveness of the variables declared
+// in a single program or procedure body, computed over its CFG.
+type DataFlow struct {
+	CFG *CFG
+	// Summaries hold the effect of calling each procedure
+	Summaries map[*ProcedureDecl]*Summary
+	// Declared maps the name of each local variable to its declaration
+	Declared map[string]*Var
+	// Zeroed holds the variables that have a value from the start,
+	// arrays and records are created with every element set to zero
+	// and files closed
+	Zeroed varSet
+	// Files holds the file variables. What is done to a file is seen
+	// outside the program, so no assignment to one is dead.
+	Files varSet
+	// AssignedIn/AssignedOut hold the variables that are assigned on
+	// every path reaching the start/end of a block
+	AssignedIn, AssignedOut map[*BasicBlock]varSet
+	// LiveIn/LiveOut hold the variables whose current value may still
+	// be read after the start/end of a block
+	LiveIn, LiveOut map[*BasicBlock]varSet
+}
+
+// NewDataFlow ...
+func NewDataFlow(c *CFG, summaries map[*ProcedureDecl]*Summary) *DataFlow {
+	df := &DataFlow{CFG: c, Summaries: summaries}
+	df.Declared = make(map[string]*Var)
+	df.Zeroed = make(varSet)
+	df.Files = make(varSet)
+	for _, decl := range c.Decls {
+		if vardecl, ok := decl.(*VarDecl); ok {
+			v := vardecl.VNode.(*Var)
+			df.Declared[v.Value] = v
+			switch ResolveType(vardecl.TNode).(type) {
+			case *ArrayType, *RecordType:
+				df.Zeroed[v.Value] = true
+			default:
+				if isFileType(vardecl.TNode) {
+					df.Zeroed[v.Value] = true
+					df.Files[v.Value] = true
+				}
+			}
+		}
+	}
+	df.definiteAssignment()
+	df.liveness()
+	return df
+}
+
+// AnalyzeDataFlow ...
+// Runs the data-flow analysis on every body in the tree and
+// returns the warnings ordered by position.
+func AnalyzeDataFlow(tree Node) []Warning {
+	var warnings []Warning
+	cfgs := NewCFGBuilder().Build(tree)
+	summaries := Summarize(cfgs)
+	for _, c := range cfgs {
+		warnings = append(warnings, NewDataFlow(c, summaries).Warnings()...)
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i].Tok, warnings[j].Tok
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return warnings
+}
+
+// Summarize ...
+// Computes the summary of every procedure body among cfgs. The
+// summaries start empty and grow until they no longer change,
+// since procedures may call each other.
+func Summarize(cfgs []*CFG) map[*ProcedureDecl]*Summary {
+	summaries := make(map[*ProcedureDecl]*Summary)
+	for _, c := range cfgs {
+		if c.Procedure != nil {
+			summaries[c.Procedure] = NewSummary()
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, c := range cfgs {
+			if c.Procedure == nil {
+				continue
+			}
+			s := NewDataFlow(c, summaries).Summary()
+			if !s.equal(summaries[c.Procedure]) {
+				summaries[c.Procedure] = s
+				changed = true
+			}
+		}
+	}
+	return summaries
+}
+
+// Summary ...
+// Summarizes a procedure body from the variables live on entry to
+// it and the variables its statements assign
+func (df *DataFlow) Summary() *Summary {
+	s := NewSummary()
+	params := make(varSet)
+	for _, param := range df.CFG.Procedure.Params {
+		params[param.Name] = true
+	}
+	// The name of a function stands for its result in its body
+	params[df.CFG.Procedure.Name] = true
+	outer := func(name string) bool {
+		_, local := df.Declared[name]
+		return !local && !params[name]
+	}
+	for name := range df.LiveIn[df.CFG.Entry] {
+		if name == df.CFG.Procedure.Name {
+			continue
+		}
+		if params[name] {
+			s.Params[name] = true
+		} else if outer(name) {
+			s.Reads[name] = true
+		}
+	}
+	for _, b := range df.CFG.Blocks {
+		for _, stmt := range b.Stmts {
+			for _, v := range df.stmtUses(stmt) {
+				if outer(v.Value) {
+					s.Refs[v.Value] = true
+				}
+			}
+			for _, v := range append(df.stmtDefs(stmt), df.callDefs(stmt)...) {
+				if outer(v.Value) {
+					s.Refs[v.Value] = true
+					s.Writes[v.Value] = true
+				}
+			}
+		}
+	}
+	return s
+}
+
+// definiteAssignment ...
+// Forward must-analysis, a variable is assigned at a point only
+// if it is assigned along every path from the entry.
+func (df *DataFlow) definiteAssignment() {
+	all := make(varSet)
+	for name := range df.Declared {
+		all[name] = true
+	}
+	df.AssignedIn = make(map[*BasicBlock]varSet)
+	df.AssignedOut = make(map[*BasicBlock]varSet)
+	for _, b := range df.CFG.Blocks {
+		df.AssignedOut[b] = all
+	}
+	df.AssignedIn[df.CFG.Entry] = df.Zeroed.copy()
+	df.AssignedOut[df.CFG.Entry] = df.Zeroed.copy()
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range df.CFG.Blocks {
+			if b == df.CFG.Entry {
+				continue
+			}
+			in := all
+			if len(b.Preds) > 0 {
+				in = df.AssignedOut[b.Preds[0]].copy()
+				for _, p := range b.Preds[1:] {
+					for name := range in {
+						if !df.AssignedOut[p][name] {
+							delete(in, name)
+						}
+					}
+				}
+			}
+			out := in.copy()
+			for _, stmt := range b.Stmts {
+				for _, v := range append(df.stmtDefs(stmt), df.callDefs(stmt)...) {
+					out[v.Value] = true
+				}
+			}
+			df.AssignedIn[b] = in
+			if !out.equal(df.AssignedOut[b]) {
+				df.AssignedOut[b] = out
+				changed = true
+			}
+		}
+	}
+}
+
+// liveness ...
+// Backward may-analysis, a variable is live at a point if its
+// value may be read along some path to the exit. The variables of
+// the program are live at its exit, where they are printed.
+func (df *DataFlow) liveness() {
+	df.LiveIn = make(map[*BasicBlock]varSet)
+	df.LiveOut = make(map[*BasicBlock]varSet)
+	for _, b := range df.CFG.Blocks {
+		df.LiveIn[b] = make(varSet)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(df.CFG.Blocks) - 1; i >= 0; i-- {
+			b := df.CFG.Blocks[i]
+			out := make(varSet)
+			if b == df.CFG.Exit && df.CFG.Procedure == nil {
+				for name := range df.Declared {
+					out[name] = true
+				}
+			}
+			for _, s := range b.Succs {
+				for name := range df.LiveIn[s] {
+					out[name] = true
+				}
+			}
+			in := out.copy()
+			for j := len(b.Stmts) - 1; j >= 0; j-- {
+				for _, v := range df.stmtDefs(b.Stmts[j]) {
+					delete(in, v.Value)
+				}
+				for _, v := range df.stmtUses(b.Stmts[j]) {
+					in[v.Value] = true
+				}
+			}
+			df.LiveOut[b] = out
+			if !in.equal(df.LiveIn[b]) {
+				df.LiveIn[b] = in
+				changed = true
+			}
+		}
+	}
+}
+
+// Warnings ...
+// Reports reads of possibly uninitialized variables, declared
+// variables that are never used and assignments that are never read.
+// A loop variable the body does not read is not a dead store, nor
+// is anything done to a file. The variables that procedures nested
+// in the body name are used.
+func (df *DataFlow) Warnings() []Warning {
+	var warnings []Warning
+	used := make(varSet)
+	for _, procedure := range nestedProcedures(df.CFG.Decls) {
+		if s := df.Summaries[procedure]; s != nil {
+			for name := range s.Refs {
+				used[name] = true
+			}
+		}
+	}
+	reachable := df.CFG.Reachable()
+	for _, b := range df.CFG.Blocks {
+		assigned := df.AssignedIn[b].copy()
+		for _, stmt := range b.Stmts {
+			for _, v := range df.stmtUses(stmt) {
+				used[v.Value] = true
+				if _, declared := df.Declared[v.Value]; declared && !assigned[v.Value] && reachable[b] {
+					warnings = append(warnings, Warning{v.Tok,
+						fmt.Sprintf("variable '%s' may be used before it is assigned", v.Value)})
+				}
+			}
+			for _, v := range append(df.stmtDefs(stmt), df.callDefs(stmt)...) {
+				used[v.Value] = true
+				assigned[v.Value] = true
+			}
+		}
+
+		live := df.LiveOut[b].copy()
+		for j := len(b.Stmts) - 1; j >= 0; j-- {
+			_, loop := b.Stmts[j].(*For)
+			defs := df.stmtDefs(b.Stmts[j])
+			for _, v := range defs {
+				if _, declared := df.Declared[v.Value]; declared && !live[v.Value] && reachable[b] && !loop && !df.Files[v.Value] {
+					warnings = append(warnings, Warning{v.Tok,
+						fmt.Sprintf("value assigned to '%s' is never used", v.Value)})
+					live[v.Value] = true
+				}
+			}
+			for _, v := range defs {
+				delete(live, v.Value)
+			}
+			for _, v := range df.stmtUses(b.Stmts[j]) {
+				live[v.Value] = true
+			}
+		}
+	}
+	for name, v := range df.Declared {
+		if !used[name] {
+			warnings = append(warnings, Warning{v.Tok,
+				fmt.Sprintf("variable '%s' is declared but never used", name)})
+		}
+	}
+	return warnings
+}
+
+// nestedProcedures ...
+// The procedures declared with bodies in decls and, in turn, in
+// their bodies
+func nestedProcedures(decls []Node) []*ProcedureDecl {
+	var procedures []*ProcedureDecl
+	for _, decl := range decls {
+		if procedure, ok := decl.(*ProcedureDecl); ok && procedure.BlockNode != nil {
+			procedures = append(procedures, procedure)
+			procedures = append(procedures, nestedProcedures(procedure.BlockNode.(*Block).Decls)...)
+		}
+	}
+	return procedures
+}
+
+// stmtDefs ...
+// Variables written by a statement. Assigning an element or a
+// field writes the array or record it belongs to, assigning through
+// a pointer writes no variable. A procedure call writes the
+// variables passed to it by reference.
+func (df *DataFlow) stmtDefs(n Node) []*Var {
+	switch node := n.(type) {
+	case *Assign:
+		return baseVars(node.Left)
+	case *For:
+		return baseVars(node.Var)
+	case *ProcedureCall:
+		var defs []*Var
+		for i, arg := range node.Args {
+			if mode := df.argMode(node, i); mode == VarArg || mode == OutArg {
+				defs = append(defs, baseVars(arg)...)
+			}
+		}
+		return defs
+	}
+	return nil
+}
+
+// callDefs ...
+// Outer variables the procedures and functions a statement calls
+// may assign. They count as assigned but, since the procedure may
+// leave them alone, they do not end the life of the values they had
+// before. Calls through procedural variables are not followed.
+func (df *DataFlow) callDefs(n Node) []*Var {
+	var defs []*Var
+	if node, ok := n.(*ProcedureCall); ok {
+		if s := df.Summaries[node.Decl]; node.Decl != nil && s != nil {
+			defs = callVars(node.Tok, s.Writes)
+		}
+	}
+	for _, expr := range stmtExprs(n) {
+		walkExpr(expr, func(n Node) {
+			if tok, decl := calledFunction(n); decl != nil && df.Summaries[decl] != nil {
+				defs = append(defs, callVars(tok, df.Summaries[decl].Writes)...)
+			}
+		})
+	}
+	return defs
+}
+
+// stmtExprs ...
+// The expressions and variable references of a simple statement
+func stmtExprs(n Node) []Node {
+	switch node := n.(type) {
+	case *Assign:
+		return []Node{node.Right, node.Left}
+	case *With:
+		return node.Records
+	case *For:
+		return []Node{node.Start, node.End}
+	case *Case:
+		return []Node{node.Expr}
+	case *ProcedureCall:
+		return node.Args
+	}
+	return nil
+}
+
+// calledFunction ...
+// The function an expression node calls by name, if any. Naming a
+// procedure or function as a value counts as calling it, since it
+// may be called through the value.
+func calledFunction(n Node) (Token, *ProcedureDecl) {
+	switch node := n.(type) {
+	case *Call:
+		return node.Tok, node.Decl
+	case *Var:
+		return node.Tok, node.Routine
+	}
+	return Token{}, nil
+}
+
+// callVars ...
+// Stands the variables named in a summary for the call at tok, in
+// name order
+func callVars(tok Token, names varSet) []*Var {
+	var vars []*Var
+	for name := range names {
+		vars = append(vars, &Var{NodeType: VarNode, Tok: tok, Value: name})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Value < vars[j].Value })
+	return vars
+}
+
+// baseVars ...
+// The variable a variable reference starts from, if it does not go
+// through a pointer
+func baseVars(n Node) []*Var {
+	if v := BaseVar(n); v != nil {
+		return []*Var{v}
+	}
+	return nil
+}
+
+// argMode ...
+// The passing mode of the i-th argument of a procedure call. A VAR
+// parameter the procedure assigns before reading it is only written.
+func (df *DataFlow) argMode(node *ProcedureCall, i int) int {
+	if i >= len(node.Modes) {
+		return ValueArg
+	}
+	if node.Decl != nil && node.Modes[i] == VarArg {
+		if s := df.Summaries[node.Decl]; s != nil && !s.Params[node.Decl.Params[i].Name] {
+			return OutArg
+		}
+	}
+	return node.Modes[i]
+}
+
+// stmtUses ...
+// Variables read by a statement. Assigning an element or a field
+// reads the indexes, and the array or record since the rest of it
+// is kept. A WITH statement reads its records, a FOR statement
+// its bounds and a CASE statement its selector. A procedure call
+// reads its arguments, except for the variables that it only
+// assigns to, and the outer variables the procedure reads.
+func (df *DataFlow) stmtUses(n Node) []*Var {
+	switch node := n.(type) {
+	case *Assign:
+		uses := df.exprVars(node.Right)
+		if v, ok := node.Left.(*Var); !ok || v.With != nil {
+			uses = append(uses, df.exprVars(node.Left)...)
+		}
+		return uses
+	case *With:
+		var uses []*Var
+		for _, record := range node.Records {
+			uses = append(uses, df.exprVars(record)...)
+		}
+		return uses
+	case *For:
+		return append(df.exprVars(node.Start), df.exprVars(node.End)...)
+	case *Case:
+		return df.exprVars(node.Expr)
+	case *ProcedureCall:
+		var uses []*Var
+		if node.Variable != nil {
+			uses = df.exprVars(node.Variable)
+		}
+		for i, arg := range node.Args {
+			if v, ok := arg.(*Var); df.argMode(node, i) != OutArg || !ok || v.With != nil {
+				uses = append(uses, df.exprVars(arg)...)
+			}
+		}
+		if s := df.Summaries[node.Decl]; node.Decl != nil && s != nil {
+			uses = append(uses, callVars(node.Tok, s.Reads)...)
+		}
+		return uses
+	}
+	return nil
+}
+
+// exprVars ...
+// Variables read while evaluating an expression, in source order.
+// Reading a field named inside a WITH statement reads the record
+// variable of the WITH. Calling a function reads the outer variables
+// it reads.
+func (df *DataFlow) exprVars(n Node) []*Var {
+	var vars []*Var
+	walkExpr(n, func(n Node) {
+		if tok, decl := calledFunction(n); decl != nil {
+			if s := df.Summaries[decl]; s != nil {
+				vars = append(vars, callVars(tok, s.Reads)...)
+			}
+		} else if v, ok := n.(*Var); ok {
+			vars = append(vars, baseVars(v)...)
+		}
+	})
+	return vars
+}
+
+// walkExpr ...
+// Calls f on an expression and every expression inside it, in
+// source order
+func walkExpr(n Node, f func(Node)) {
+	f(n)
+	switch node := n.(type) {
+	case *UnaryOp:
+		walkExpr(node.Expr, f)
+	case *BinOp:
+		walkExpr(node.Left, f)
+		walkExpr(node.Right, f)
+	case *Index:
+		walkExpr(node.Array, f)
+		for _, index := range node.Indexes {
+			walkExpr(index, f)
+		}
+	case *Field:
+		walkExpr(node.Record, f)
+	case *Deref:
+		walkExpr(node.Pointer, f)
+	case *Call:
+		if node.Variable != nil {
+			walkExpr(node.Variable, f)
+		}
+		for _, arg := range node.Args {
+			walkExpr(arg, f)
+		}
+	case *SetConstructor:
+		for _, elem := range node.Elems {
+			walkExpr(elem, f)
+		}
+	case *Subrange:
+		walkExpr(node.Low, f)
+		walkExpr(node.High, f)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// analyze parses and checks source, failing the test on errors
+func analyze(t *testing.T, source string) Node {
+	t.Helper()
+	tree, err := ParseSource(source)
+	if err != nil {
+		t.Fatalf("parse: %s\n%s", err, source)
+	}
+	if errs := NewSemanticAnalyzer().Analyze(tree); len(errs) > 0 {
+		t.Fatalf("%s\n%s", errs[0], source)
+	}
+	return tree
+}
+
+// names lists the names in s in order, as in "a b"
+func names(s varSet) string {
+	var list []string
+	for name := range s {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+const nestedWrite = `PROGRAM T;
+VAR g : INTEGER;
+PROCEDURE P;
+VAR l : INTEGER;
+   PROCEDURE Q;
+   BEGIN
+      l := 1
+   END;
+BEGIN
+   Q;
+   g := l
+END;
+BEGIN
+   P
+END.
+`
+
+const nestedRead = `PROGRAM T;
+VAR g : INTEGER;
+PROCEDURE P;
+VAR l : INTEGER;
+   PROCEDURE Q;
+   BEGIN
+      g := l
+   END;
+BEGIN
+   Q;
+   l := 1
+END;
+BEGIN
+   P
+END.
+`
+
+func TestDataFlowWarnings(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "assignment overwritten before it is read",
+			source: program("VAR a, b : INTEGER;", "a := 1; b := a; a := b; a := 0"),
+			want:   []string{"4:17: value assigned to 'a' is never used"},
+		},
+		{
+			name:   "program variables are printed at the exit",
+			source: pascalsample2,
+		},
+		{
+			name:   "unused variable and overwritten assignment",
+			source: program("VAR a, b, c : INTEGER;", "a := 1; a := 2; b := a"),
+			want: []string{
+				"2:11: variable 'c' is declared but never used",
+				"4:1: value assigned to 'a' is never used",
+			},
+		},
+		{
+			name:   "use before assignment",
+			source: program("VAR a, b : INTEGER;", "b := a; a := b"),
+			want: []string{
+				"4:6: variable 'a' may be used before it is assigned",
+			},
+		},
+		{
+			name:   "assigned on one CASE arm only",
+			source: program("VAR s, t : INTEGER;", "t := 1; CASE t OF 1: s := 1 END; t := s"),
+			want:   []string{"4:39: variable 's' may be used before it is assigned"},
+		},
+		{
+			name:   "assigned on every CASE arm",
+			source: program("VAR s, t : INTEGER;", "t := 1; CASE t OF 1: s := 1 ELSE s := 2 END; t := s"),
+		},
+		{
+			name:   "loop variable the body does not read",
+			source: program("VAR i, s : INTEGER;", "s := 0; FOR i := 1 TO 3 DO s := s + 1; i := s; i := 0"),
+			want:   []string{"4:40: value assigned to 'i' is never used"},
+		},
+		{
+			name:   "arrays and records start assigned",
+			source: program("VAR a : ARRAY[1..2] OF INTEGER; r : RECORD x : INTEGER END; i : INTEGER;", "i := a[1] + r.x; a[i] := i"),
+		},
+		{
+			name:   "nested procedure assigns an outer local",
+			source: nestedWrite,
+		},
+		{
+			name:   "nested procedure reads an outer local",
+			source: nestedRead,
+			want: []string{
+				"10:4: variable 'l' may be used before it is assigned",
+				"11:4: value assigned to 'l' is never used",
+			},
+		},
+		{
+			name: "VAR parameter only assigned",
+			source: program(`VAR a, b : INTEGER;
+PROCEDURE Init(VAR x : INTEGER);
+BEGIN
+   x := 1
+END;
+PROCEDURE Inc(VAR x : INTEGER);
+BEGIN
+   x := x + 1
+END;`, "Init(a); Inc(a); Inc(b); a := b"),
+			want: []string{
+				"12:14: value assigned to 'a' is never used",
+				"12:22: variable 'b' may be used before it is assigned",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, warning := range AnalyzeDataFlow(analyze(t, test.source)) {
+				got = append(got, strings.TrimPrefix(warning.String(), "warning: "))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSummaries(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		procedure string
+		reads     string
+		writes    string
+		params    string
+		refs      string
+	}{
+		{name: "writes an outer local", source: nestedWrite, procedure: "Q", writes: "l", refs: "l"},
+		{name: "writes through a call", source: nestedWrite, procedure: "P", writes: "g", refs: "g"},
+		{name: "reads an outer local", source: nestedRead, procedure: "Q", reads: "l", writes: "g", refs: "g l"},
+		{
+			name: "reads a parameter",
+			source: program(`VAR g : INTEGER;
+FUNCTION F(x, y : INTEGER) : INTEGER;
+BEGIN
+   y := g;
+   F := x + y
+END;`, "g := F(1, 2)"),
+			procedure: "F",
+			reads:     "g",
+			params:    "x",
+			refs:      "g",
+		},
+		{
+			name: "mutual recursion",
+			source: program(`VAR g, h : INTEGER;
+PROCEDURE B(n : INTEGER); FORWARD;
+PROCEDURE A(n : INTEGER);
+BEGIN
+   CASE n > 0 OF True: B(n - 1) END
+END;
+PROCEDURE B(n : INTEGER);
+BEGIN
+   h := g;
+   A(n)
+END;`, "g := 1; A(2); g := h"),
+			procedure: "A",
+			reads:     "g",
+			writes:    "h",
+			params:    "n",
+			refs:      "g h",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summaries := Summarize(NewCFGBuilder().Build(analyze(t, test.source)))
+			for decl, s := range summaries {
+				if decl.Name != test.procedure {
+					continue
+				}
+				got := []string{names(s.Reads), names(s.Writes), names(s.Params), names(s.Refs)}
+				want := []string{test.reads, test.writes, test.params, test.refs}
+				if strings.Join(got, "|") != strings.Join(want, "|") {
+					t.Errorf("reads|writes|params|refs %q, want %q", got, want)
+				}
+				return
+			}
+			t.Errorf("no summary for %s", test.procedure)
+		})
+	}
+}
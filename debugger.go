@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Debugger run modes, deciding where to pause next
+const (
+	debugContinue = iota
+	debugStepInto
+	debugStepOver
+	debugStepOut
+)
+
+// errDebugQuit ends the program when the user quits the debugger
+var errDebugQuit = errors.New("quit")
+
+// stepControl ...
+// Decides where a debugger pauses next, based on the breakpoints,
+// the last run command and the call depth it was given at.
+type stepControl struct {
+	Breakpoints map[int]bool
+
+	mode  int
+	depth int
+}
+
+// shouldPause ...
+// Reports whether to pause before a statement on line, running at
+// the given call depth. A pause records the depth for the next step.
+func (sc *stepControl) shouldPause(line, depth int) bool {
+	pause := sc.Breakpoints[line]
+	switch sc.mode {
+	case debugStepInto:
+		pause = true
+	case debugStepOver:
+		pause = pause || depth <= sc.depth
+	case debugStepOut:
+		pause = pause || depth < sc.depth
+	}
+	if pause {
+		sc.depth = depth
+	}
+	return pause
+}
+
+// Debugger ...
+// Source-level debugger driven by commands read from a stream.
+// It is installed as the interpreter's Hook and pauses before
+// statements on breakpoint lines or while stepping.
+type Debugger struct {
+	stepControl
+	Interpreter *Interpreter
+	// Commands maps each command name and alias to its handler. A
+	// handler returns true when the program should resume.
+	Commands map[string]func(args []string) bool
+
+	source  []string
+	scanner *bufio.Scanner
+	out     io.Writer
+
+	tok Token
+	// last is the statement that runs or ran last
+	last Token
+	// lines holds the lines statements start on
+	lines map[int]bool
+}
+
+// NewDebugger ...
+func NewDebugger(in *Interpreter, source string, r io.Reader, w io.Writer) *Debugger {
+	d := &Debugger{
+		Interpreter: in,
+		source:      strings.Split(strings.TrimSuffix(source, "\n"), "\n"),
+		scanner:     bufio.NewScanner(r),
+		out:         w,
+	}
+	d.Breakpoints = make(map[int]bool)
+	d.mode = debugStepInto
+	d.Commands = make(map[string]func(args []string) bool)
+	d.Commands["break"] = d.Break
+	d.Commands["b"] = d.Break
+	d.Commands["clear"] = d.Clear
+	d.Commands["continue"] = d.Continue
+	d.Commands["c"] = d.Continue
+	d.Commands["step"] = d.Step
+	d.Commands["s"] = d.Step
+	d.Commands["next"] = d.Next
+	d.Commands["n"] = d.Next
+	d.Commands["finish"] = d.Finish
+	d.Commands["print"] = d.Print
+	d.Commands["p"] = d.Print
+	d.Commands["set"] = d.Set
+	d.Commands["backtrace"] = d.Backtrace
+	d.Commands["bt"] = d.Backtrace
+	d.Commands["list"] = d.List
+	d.Commands["l"] = d.List
+	d.Commands["quit"] = d.Quit
+	d.Commands["q"] = d.Quit
+	d.Commands["help"] = d.Help
+	d.Commands["h"] = d.Help
+	in.Hook = d
+	return d
+}
+
+// Run ...
+// Interprets the program under the debugger. It starts paused
+// before the first statement. A failure of the interpreter stops
+// the program like a runtime error does.
+func (d *Debugger) Run(tree Node) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r == errDebugQuit {
+				err = errDebugQuit
+				return
+			}
+			err = runtimeStop(r, d.last)
+			fmt.Fprintf(d.out, "%s\n", err)
+		}
+	}()
+	d.lines = statementLines(tree)
+	if err := d.Interpreter.Interpret(tree); err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return err
+	}
+	fmt.Fprintf(d.out, "program finished\n")
+	return nil
+}
+
+// statementLines ...
+// The lines statements start on, found through the control-flow
+// graphs of the program as Coverage finds the statements
+func statementLines(tree Node) map[int]bool {
+	lines := make(map[int]bool)
+	for _, cfg := range NewCFGBuilder().Build(tree) {
+		for _, b := range cfg.Blocks {
+			for _, stmt := range b.Stmts {
+				if tok, ok := StatementToken(stmt); ok {
+					lines[tok.Line] = true
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// BeforeStatement ...
+func (d *Debugger) BeforeStatement(n Node, tok Token) {
+	d.Interpreter.CallStack.Peek().Statement = tok
+	d.last = tok
+	if !d.shouldPause(tok.Line, d.Interpreter.CallStack.Depth()) {
+		return
+	}
+	d.tok = tok
+	if d.Breakpoints[tok.Line] && d.mode == debugContinue {
+		fmt.Fprintf(d.out, "breakpoint at line %d\n", tok.Line)
+	}
+	d.showLine(tok.Line)
+	d.prompt()
+}
+
+// prompt ...
+// Reads and runs commands until one resumes the program
+func (d *Debugger) prompt() {
+	for {
+		fmt.Fprintf(d.out, "(spi) ")
+		if !d.scanner.Scan() {
+			panic(errDebugQuit)
+		}
+		fields := strings.Fields(d.scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		command, exists := d.Commands[fields[0]]
+		if !exists {
+			fmt.Fprintf(d.out, "unknown command %q, try help\n", fields[0])
+			continue
+		}
+		if command(fields[1:]) {
+			return
+		}
+	}
+}
+
+func (d *Debugger) showLine(line int) {
+	if line >= 1 && line <= len(d.source) {
+		fmt.Fprintf(d.out, "%4d  %s\n", line, d.source[line-1])
+	}
+}
+
+func (d *Debugger) lineArg(args []string) (int, bool) {
+	if len(args) != 1 {
+		fmt.Fprintf(d.out, "expected a line number\n")
+		return 0, false
+	}
+	line, err := strconv.Atoi(args[0])
+	if err != nil || line < 1 || line > len(d.source) {
+		fmt.Fprintf(d.out, "invalid line %q\n", args[0])
+		return 0, false
+	}
+	return line, true
+}
+
+// Break ...
+// break LINE, on a line without a statement the breakpoint goes to
+// the next line with one
+func (d *Debugger) Break(args []string) bool {
+	line, ok := d.lineArg(args)
+	if !ok {
+		return false
+	}
+	for ; line <= len(d.source); line++ {
+		if d.lines[line] {
+			d.Breakpoints[line] = true
+			fmt.Fprintf(d.out, "breakpoint set at line %d\n", line)
+			return false
+		}
+	}
+	fmt.Fprintf(d.out, "no statement on line %s or after it\n", args[0])
+	return false
+}
+
+// Clear ...
+// clear LINE
+func (d *Debugger) Clear(args []string) bool {
+	line, ok := d.lineArg(args)
+	if !ok {
+		return false
+	}
+	if !d.Breakpoints[line] {
+		fmt.Fprintf(d.out, "no breakpoint at line %d\n", line)
+		return false
+	}
+	delete(d.Breakpoints, line)
+	fmt.Fprintf(d.out, "breakpoint cleared at line %d\n", line)
+	return false
+}
+
+// Continue ...
+// Run until the next breakpoint
+func (d *Debugger) Continue(args []string) bool {
+	d.mode = debugContinue
+	return true
+}
+
+// Step ...
+// Pause at the very next statement, entering calls
+func (d *Debugger) Step(args []string) bool {
+	d.mode = debugStepInto
+	return true
+}
+
+// Next ...
+// Pause at the next statement of the current body or its callers
+func (d *Debugger) Next(args []string) bool {
+	d.mode = debugStepOver
+	return true
+}
+
+// Finish ...
+// Pause once the current body has returned to its caller
+func (d *Debugger) Finish(args []string) bool {
+	d.mode = debugStepOut
+	return true
+}
+
+// Print ...
+// print [NAME...], without names prints the whole activation record.
+// Names are looked up through the enclosing bodies as well.
+func (d *Debugger) Print(args []string) bool {
+	ar := d.Interpreter.CallStack.Peek()
+	if len(args) == 0 {
+		fmt.Fprint(d.out, ar)
+		return false
+	}
+	for _, name := range args {
+		if enclosing := ar.Find(name); enclosing != nil {
+			val, _ := enclosing.Get(name)
+			fmt.Fprintf(d.out, "%s = %s\n", name, formatValue(val))
+		} else {
+			fmt.Fprintf(d.out, "%s has no value\n", name)
+		}
+	}
+	return false
+}
+
+// Set ...
+// set NAME [=] VALUE
+func (d *Debugger) Set(args []string) bool {
+	if len(args) == 3 && args[1] == "=" {
+		args = []string{args[0], args[2]}
+	}
+	if len(args) != 2 {
+		fmt.Fprintf(d.out, "usage: set NAME = VALUE\n")
+		return false
+	}
+	val, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		fmt.Fprintf(d.out, "invalid value %q\n", args[1])
+		return false
+	}
+	ar, err := settable(d.Interpreter.CallStack.Peek(), args[0], val)
+	if err != nil {
+		fmt.Fprintf(d.out, "%s\n", err)
+		return false
+	}
+	ar.Set(args[0], val)
+	return false
+}
+
+// settable ...
+// The record declaring the variable name, searching ar and the
+// records its static links lead to, when val fits the declared type
+// of the variable. Only numbers and ordinals can be set, ordinals to
+// integers within their bounds.
+func settable(ar *ActivationRecord, name string, val float64) (*ActivationRecord, error) {
+	declaring := ar.Declaring(name)
+	if declaring == nil {
+		if ar.Find(name) != nil {
+			return nil, fmt.Errorf("%s is a constant", name)
+		}
+		return nil, fmt.Errorf("%s is not a variable", name)
+	}
+	domain := declaring.Domains[name]
+	switch {
+	case domain == nil:
+		return nil, fmt.Errorf("%s is not a number", name)
+	case domain.Ordinal && val != math.Trunc(val):
+		return nil, fmt.Errorf("%s takes integers only, not %v", name, val)
+	case domain.Range != nil && (val < float64(domain.Range.Low) || val > float64(domain.Range.High)):
+		return nil, fmt.Errorf("value %v out of range %d..%d of %s", val, domain.Range.Low, domain.Range.High, name)
+	case domain.Ordinal && math.Abs(val) > MaxInteger:
+		return nil, fmt.Errorf("value %v out of range %d..%d of %s", val, -MaxInteger, MaxInteger, name)
+	}
+	return declaring, nil
+}
+
+// Backtrace ...
+func (d *Debugger) Backtrace(args []string) bool {
+	for i, ar := range d.Interpreter.CallStack.Records() {
+		fmt.Fprintf(d.out, "#%d  %s %s at line %d\n", i, arTypeStr[ar.Type], ar.Name, ar.Statement.Line)
+	}
+	return false
+}
+
+// List ...
+// list [LINE], shows the source around LINE or the current line
+func (d *Debugger) List(args []string) bool {
+	center := d.tok.Line
+	if len(args) > 0 {
+		line, ok := d.lineArg(args)
+		if !ok {
+			return false
+		}
+		center = line
+	}
+	for line := center - 5; line <= center+5; line++ {
+		marker := "  "
+		if line == d.tok.Line {
+			marker = "=>"
+		} else if d.Breakpoints[line] {
+			marker = "* "
+		}
+		if line >= 1 && line <= len(d.source) {
+			fmt.Fprintf(d.out, "%s%4d  %s\n", marker, line, d.source[line-1])
+		}
+	}
+	return false
+}
+
+// Quit ...
+func (d *Debugger) Quit(args []string) bool {
+	panic(errDebugQuit)
+}
+
+// Help ...
+func (d *Debugger) Help(args []string) bool {
+	fmt.Fprint(d.out, debugHelp)
+	return false
+}
+
+var debugHelp = `break LINE        (b)   pause before statements on LINE
+clear LINE              remove the breakpoint on LINE
+continue          (c)   run to the next breakpoint
+step              (s)   run to the next statement, entering calls
+next              (n)   run to the next statement of this body
+finish                  run until this body returns
+print [NAME...]   (p)   show variables of the current activation record
+set NAME = VALUE        change a variable
+backtrace         (bt)  show the call stack
+list [LINE]       (l)   show the source around LINE
+quit              (q)   stop the program
+`
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const debugTestProgram = `PROGRAM D;
+CONST N = 3;
+TYPE
+   Color = (Red, Green, Blue);
+   Small = 1..5;
+VAR
+   i, j : INTEGER; s : STRING; x : REAL;
+   c : Color; k : Small; b : BOOLEAN;
+PROCEDURE P(CONST m : INTEGER);
+VAR l : INTEGER;
+BEGIN
+   l := m;
+   j := l
+END;
+BEGIN
+   i := 1;
+   s := 'a';
+   P(N);
+   i := i + N + j;
+   x := i
+END.
+`
+
+// debug runs debugTestProgram under the debugger with the commands,
+// one per line, and returns what the debugger printed and the globals.
+// The commands may quit the program.
+func debug(t *testing.T, commands string) (string, map[string]Value) {
+	t.Helper()
+	tree := analyze(t, debugTestProgram)
+	var out strings.Builder
+	in := NewInterpreter()
+	d := NewDebugger(in, debugTestProgram, strings.NewReader(commands), &out)
+	if err := d.Run(tree); err != nil && err != errDebugQuit {
+		t.Fatal(err)
+	}
+	return out.String(), in.GLOBALSCOPE
+}
+
+func TestDebuggerSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		output   string
+		globals  map[string]float64
+	}{
+		{
+			name:     "constants and enumeration names",
+			commands: "set N = 5\nset Red 1\nc\n",
+			output:   "N is a constant\n(spi) Red is a constant",
+			globals:  map[string]float64{"N": 3, "i": 7},
+		},
+		{
+			name:     "names that are no variables",
+			commands: "set missing 2\nset True 0\nc\n",
+			output:   "missing is not a variable\n(spi) True is not a variable",
+		},
+		{
+			name:     "variables that are not numbers",
+			commands: "set s 2\nc\n",
+			output:   "s is not a number",
+		},
+		{
+			name:     "integers only take integers",
+			commands: "set i = 2.5\nset c = 0.5\nset x = 2.5\nprint x\nc\n",
+			output:   "i takes integers only, not 2.5\n(spi) c takes integers only, not 0.5\n(spi) (spi) x = 2.5",
+		},
+		{
+			name:     "bounds of subranges and enumerations",
+			commands: "set k = 99\nset c = 3\nset b = 2\nset k = 5\nset c = 2\nset b = 1\nc\n",
+			output:   "value 99 out of range 1..5 of k\n(spi) value 3 out of range 0..2 of c\n(spi) value 2 out of range 0..1 of b\n(spi) (spi) (spi) (spi) program finished",
+			globals:  map[string]float64{"k": 5, "c": 2, "b": 1},
+		},
+		{
+			name:     "variables without a value yet",
+			commands: "set j = 10\nprint j\nb 19\nc\nset j = 4\nc\n",
+			output:   "j = 10",
+			globals:  map[string]float64{"i": 8, "x": 8},
+		},
+		{
+			name:     "locals, CONST parameters and globals from a procedure",
+			commands: "b 13\nc\nset m = 1\nset l = 4\nset i = 2\nc\n",
+			output:   "m is a constant",
+			globals:  map[string]float64{"i": 9, "j": 4},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, globals := debug(t, test.commands)
+			if !strings.Contains(output, test.output) || !strings.Contains(output, "program finished") {
+				t.Errorf("output lacks %q:\n%s", test.output, output)
+			}
+			for name, want := range test.globals {
+				if got := globals[name]; got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDebuggerSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		output   string
+		globals  map[string]Value
+	}{
+		{
+			name:     "step enters calls",
+			commands: "s\ns\ns\ns\nbt\nc\n",
+			output: `  16     i := 1;
+(spi)   17     s := 'a';
+(spi)   18     P(N);
+(spi)   12     l := m;
+(spi)   13     j := l
+(spi) #0  PROCEDURE P at line 13
+#1  PROGRAM D at line 18
+(spi) program finished
+`,
+			globals: map[string]Value{"i": 7.0, "x": 7.0},
+		},
+		{
+			name:     "next steps over calls",
+			commands: "n\nn\nn\nbt\nc\n",
+			output: `  16     i := 1;
+(spi)   17     s := 'a';
+(spi)   18     P(N);
+(spi)   19     i := i + N + j;
+(spi) #0  PROGRAM D at line 19
+(spi) program finished
+`,
+		},
+		{
+			name:     "finish returns to the caller",
+			commands: "b 12\nc\nfinish\nbt\nc\n",
+			output: `  16     i := 1;
+(spi) breakpoint set at line 12
+(spi) breakpoint at line 12
+  12     l := m;
+(spi)   19     i := i + N + j;
+(spi) #0  PROGRAM D at line 19
+(spi) program finished
+`,
+		},
+		{
+			name:     "breakpoints on lines without statements",
+			commands: "b 10\nb 21\nc\nbt\nc\n",
+			output: `  16     i := 1;
+(spi) breakpoint set at line 12
+(spi) no statement on line 21 or after it
+(spi) breakpoint at line 12
+  12     l := m;
+(spi) #0  PROCEDURE P at line 12
+#1  PROGRAM D at line 18
+(spi) program finished
+`,
+		},
+		{
+			name:     "clear",
+			commands: "b 12\nb 19\nclear 12\nclear 3\nc\nc\n",
+			output: `  16     i := 1;
+(spi) breakpoint set at line 12
+(spi) breakpoint set at line 19
+(spi) breakpoint cleared at line 12
+(spi) no breakpoint at line 3
+(spi) breakpoint at line 19
+  19     i := i + N + j;
+(spi) program finished
+`,
+		},
+		{
+			name:     "list marks the current line and breakpoints",
+			commands: "l\nb 18\nl 17\nc\nc\n",
+			output: `  16     i := 1;
+(spi)     11  BEGIN
+    12     l := m;
+    13     j := l
+    14  END;
+    15  BEGIN
+=>  16     i := 1;
+    17     s := 'a';
+    18     P(N);
+    19     i := i + N + j;
+    20     x := i
+    21  END.
+(spi) breakpoint set at line 18
+(spi)     12     l := m;
+    13     j := l
+    14  END;
+    15  BEGIN
+=>  16     i := 1;
+    17     s := 'a';
+*   18     P(N);
+    19     i := i + N + j;
+    20     x := i
+    21  END.
+(spi) breakpoint at line 18
+  18     P(N);
+(spi) program finished
+`,
+		},
+		{
+			name:     "quit stops the program",
+			commands: "n\nq\n",
+			output: `  16     i := 1;
+(spi)   17     s := 'a';
+(spi) `,
+			globals: map[string]Value{"i": 1.0, "s": nil},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, globals := debug(t, test.commands)
+			if output != test.output {
+				t.Errorf("got output\n%s\nwant\n%s", output, test.output)
+			}
+			for name, want := range test.globals {
+				if got := globals[name]; got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// Error kinds
+const (
+	LexerError = iota
+	ParserError
+	SemanticError
+	RuntimeError
+)
+
+var errorKindStr = []string{
+	"lexer error",
+	"parser error",
+	"semantic error",
+	"runtime error",
+}
+
+// Error ...
+// The lexer and the parser panic with an *Error when they can not
+// continue, the semantic analyzer collects them instead. The
+// interpreter panics with one when the program fails while running.
+type Error struct {
+	Kind    int
+	Tok     Token
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %d:%d: %s", errorKindStr[e.Kind], e.Tok.Line, e.Tok.Column, e.Message)
+}
+
+// runtimeStop ...
+// The error a panic of the running program stands for. A panic that
+// is not an *Error is a failure of the interpreter itself, reported
+// as a runtime error at tok so a debugger session can end cleanly.
+func runtimeStop(r interface{}, tok Token) *Error {
+	if e, ok := r.(*Error); ok {
+		return e
+	}
+	return &Error{Kind: RuntimeError, Tok: tok, Message: fmt.Sprint(r)}
+}
+
+// ParseSource ...
+// Parses a whole program, turning a lexer or parser panic into
+// the returned error.
+func ParseSource(text string) (tree Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return NewParser(NewLexer(text)).Parse(), nil
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+)
+
+// FileSystem ...
+// Where the interpreter keeps the files a program names with Assign.
+// Names are slash-separated and relative, as io/fs takes them.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+}
+
+// DirFS ...
+// The files of a directory and the directories below it. A name
+// that would lead outside of it, through .. or a symbolic link, is
+// an error.
+type DirFS string
+
+// ReadFile ...
+func (dir DirFS) ReadFile(name string) ([]byte, error) {
+	root, err := os.OpenRoot(string(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.ReadFile(name)
+}
+
+// WriteFile ...
+func (dir DirFS) WriteFile(name string, data []byte) error {
+	root, err := os.OpenRoot(string(dir))
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.WriteFile(name, data, 0644)
+}
+
+// MapFS ...
+// Files held in memory, their contents by name, so tests and tools
+// can run programs without touching the disk. Written files are
+// added to the map.
+type MapFS map[string][]byte
+
+// ReadFile ...
+func (m MapFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// WriteFile ...
+func (m MapFS) WriteFile(name string, data []byte) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	m[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// File modes. Reset and Rewrite open a typed file for updating,
+// reading and writing both.
+const (
+	FileClosed = iota
+	FileReading
+	FileWriting
+	FileUpdating
+)
+
+var fileModeStr = []string{"closed", "reading", "writing", "updating"}
+
+// Encodings ...
+// A typed file is a sequence of components with no header, each
+// stored as its scalars one after the other (see FileType), little
+// endian:
+//
+//	IntegerEncoding  INTEGER, enumerations other than BOOLEAN and
+//	                 their subranges, as 8 bytes of two's complement
+//	RealEncoding     REAL, as an 8 byte IEEE 754 double
+//	ByteEncoding     CHAR and BOOLEAN, as a single byte
+const (
+	IntegerEncoding = iota
+	RealEncoding
+	ByteEncoding
+)
+
+var encodingSize = []int{8, 8, 1}
+
+// FileValue ...
+// A file variable, bound to a file of the FileSystem by Assign. An
+// open file is held whole in Data, Pos being where the next Read
+// or Write starts. Close writes a file that was written back.
+// Encoding and Ranges are nil for a TEXT file.
+type FileValue struct {
+	Type     string
+	Name     string
+	Mode     int
+	Data     []byte
+	Pos      int
+	Dirty    bool
+	Elem     Node
+	Encoding []int
+	Ranges   []*Bounds
+}
+
+func (f *FileValue) String() string {
+	if f.Name == "" {
+		return f.Type
+	}
+	return fmt.Sprintf("%s %s %s", f.Type, quoteString(f.Name), fileModeStr[f.Mode])
+}
+
+// size ...
+// The size of a component of a typed file in bytes
+func (f *FileValue) size() int {
+	size := 0
+	for _, encoding := range f.Encoding {
+		size += encodingSize[encoding]
+	}
+	return size
+}
+
+// isFileType ...
+// Reports whether a type spec is a file type
+func isFileType(n Node) bool {
+	switch t := ResolveType(n).(type) {
+	case *FileType:
+		return true
+	case *TypeN:
+		return t.Tok.Type == TEXT
+	}
+	return false
+}
+
+// fileFunction ...
+// Runs a builtin function on files, ok is false for the other
+// builtins
+func (in *Interpreter) fileFunction(node *Call) (value Value, ok bool) {
+	switch node.Name {
+	case "Eof", "Eoln", "FilePos", "FileSize":
+	default:
+		return nil, false
+	}
+	f := in.Visit(node.Args[0]).(*FileValue)
+	if f.Mode == FileClosed {
+		in.runtimeError(node.Tok, "file %s is not open", exprString(node.Args[0]))
+	}
+	switch {
+	case node.Name == "FilePos":
+		return float64(f.Pos / f.size()), true
+	case node.Name == "FileSize":
+		return float64(len(f.Data) / f.size()), true
+	case f.Encoding != nil:
+		if f.Pos+f.size() > len(f.Data) {
+			return 1.0, true
+		}
+		return 0.0, true
+	}
+	eof := f.Mode == FileWriting || f.Pos >= len(f.Data)
+	if node.Name == "Eoln" {
+		eof = eof || f.Data[f.Pos] == '\n' || f.Data[f.Pos] == '\r'
+	}
+	if eof {
+		return 1.0, true
+	}
+	return 0.0, true
+}
+
+// fileProcedure ...
+// Runs a builtin procedure on files, ok is false for the other
+// builtins. Opening a file that is open closes it first.
+func (in *Interpreter) fileProcedure(node *ProcedureCall) bool {
+	switch node.Name {
+	case "Assign", "Reset", "Rewrite", "Append", "Close", "Read", "ReadLn", "Write", "WriteLn", "Seek", "Truncate":
+	default:
+		return false
+	}
+	f := in.Visit(node.Args[0]).(*FileValue)
+	name := exprString(node.Args[0])
+	switch node.Name {
+	case "Assign":
+		if f.Mode != FileClosed {
+			in.runtimeError(node.Tok, "Assign to open file %s", name)
+		}
+		f.Name = text(in.Visit(node.Args[1]))
+	case "Reset", "Append":
+		in.closeFile(node, f)
+		in.checkAssigned(node, f, name)
+		data, err := in.Files.ReadFile(f.Name)
+		if err != nil {
+			in.runtimeError(node.Tok, "cannot open file %s", fileError(err))
+		}
+		f.Data, f.Pos, f.Mode = data, 0, FileReading
+		if node.Name == "Append" {
+			f.Pos, f.Mode, f.Dirty = len(data), FileWriting, true
+		}
+		if f.Encoding != nil {
+			f.Mode = FileUpdating
+		}
+	case "Rewrite":
+		in.closeFile(node, f)
+		in.checkAssigned(node, f, name)
+		f.Data, f.Pos, f.Mode, f.Dirty = nil, 0, FileWriting, true
+		if f.Encoding != nil {
+			f.Mode = FileUpdating
+		}
+	case "Close":
+		if f.Mode == FileClosed {
+			in.runtimeError(node.Tok, "file %s is not open", name)
+		}
+		in.closeFile(node, f)
+	case "Seek":
+		in.checkMode(node, f, name, FileUpdating)
+		component := int(in.number(node.Args[1]))
+		if component < 0 || component > len(f.Data)/f.size() {
+			in.runtimeError(node.Tok, "Seek to component %d of file %s of %d components", component, name, len(f.Data)/f.size())
+		}
+		f.Pos = component * f.size()
+	case "Truncate":
+		in.checkMode(node, f, name, FileUpdating)
+		f.Data, f.Dirty = f.Data[:f.Pos], true
+	case "Read", "ReadLn":
+		if f.Encoding != nil {
+			in.checkMode(node, f, name, FileUpdating)
+			for _, arg := range node.Args[1:] {
+				if f.Pos+f.size() > len(f.Data) {
+					in.runtimeError(node.Tok, "read past end of file %s", name)
+				}
+				value := in.decode(f, arg)
+				_, set, varname := in.reference(arg)
+				in.assign(set, varname, value, arg)
+			}
+			break
+		}
+		in.checkMode(node, f, name, FileReading)
+		for i, arg := range node.Args[1:] {
+			value := in.readValue(node, f, name, node.Kinds[i])
+			in.checkBounds(arg, value, node.Ranges[i+1])
+			_, set, varname := in.reference(arg)
+			in.assign(set, varname, value, arg)
+		}
+		if node.Name == "ReadLn" {
+			for f.Pos < len(f.Data) && f.Data[f.Pos] != '\n' {
+				f.Pos++
+			}
+			f.Pos = min(f.Pos+1, len(f.Data))
+		}
+	case "Write", "WriteLn":
+		if f.Encoding != nil {
+			in.checkMode(node, f, name, FileUpdating)
+			for i, arg := range node.Args[1:] {
+				value := in.Visit(arg)
+				in.checkBounds(arg, value, node.Ranges[i+1])
+				in.encode(f, value, arg)
+			}
+			break
+		}
+		in.checkMode(node, f, name, FileWriting)
+		for i, arg := range node.Args[1:] {
+			f.Data = append(f.Data, in.spell(in.Visit(arg), node.Kinds[i])...)
+		}
+		if node.Name == "WriteLn" {
+			f.Data = append(f.Data, '\n')
+		}
+		f.Pos = len(f.Data)
+	}
+	return true
+}
+
+// checkAssigned ...
+// Stops the program when a file is opened before Assign names it
+func (in *Interpreter) checkAssigned(node *ProcedureCall, f *FileValue, name string) {
+	if f.Name == "" {
+		in.runtimeError(node.Tok, "file %s is not assigned", name)
+	}
+}
+
+// closeFile ...
+// Closes f if it is open, writing it back if it was written
+func (in *Interpreter) closeFile(node *ProcedureCall, f *FileValue) {
+	if f.Dirty {
+		if err := in.Files.WriteFile(f.Name, f.Data); err != nil {
+			in.runtimeError(node.Tok, "cannot write file %s", fileError(err))
+		}
+	}
+	f.Mode, f.Data, f.Pos, f.Dirty = FileClosed, nil, 0, false
+}
+
+// fileError ...
+// Spells an error of the FileSystem with the name the program gave
+func fileError(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return fmt.Sprintf("%s: %s", quoteString(pathErr.Path), pathErr.Err)
+	}
+	return err.Error()
+}
+
+// checkMode ...
+// Stops the program unless f is open in the mode a transfer needs.
+// A typed file is open for updating whenever it is open.
+func (in *Interpreter) checkMode(node *ProcedureCall, f *FileValue, name string, mode int) {
+	if f.Mode != mode && mode == FileUpdating {
+		in.runtimeError(node.Tok, "file %s is not open", name)
+	}
+	if f.Mode != mode {
+		in.runtimeError(node.Tok, "file %s is not open for %s", name, fileModeStr[mode])
+	}
+}
+
+// readValue ...
+// Reads a value of the given kind from f. A CHAR is the next
+// character, a STRING the rest of the line. Numbers are read past
+// blanks and line ends, and must end where a blank or line starts.
+func (in *Interpreter) readValue(node *ProcedureCall, f *FileValue, name string, kind int) Value {
+	switch kind {
+	case CharArg:
+		if f.Pos >= len(f.Data) {
+			in.runtimeError(node.Tok, "read past end of file %s", name)
+		}
+		f.Pos++
+		return float64(f.Data[f.Pos-1])
+	case StringArg:
+		start := f.Pos
+		for f.Pos < len(f.Data) && f.Data[f.Pos] != '\n' && f.Data[f.Pos] != '\r' {
+			f.Pos++
+		}
+		return string(f.Data[start:f.Pos])
+	}
+	for f.Pos < len(f.Data) && isBlank(f.Data[f.Pos]) {
+		f.Pos++
+	}
+	if f.Pos >= len(f.Data) {
+		in.runtimeError(node.Tok, "read past end of file %s", name)
+	}
+	rest := string(f.Data[f.Pos:])
+	length := scanNumber(rest, kind == IntegerArg)
+	if length == 0 || length < len(rest) && !isBlank(rest[length]) {
+		in.runtimeError(node.Tok, "invalid number in file %s", name)
+	}
+	f.Pos += length
+	value, _ := strconv.ParseFloat(rest[:length], 64)
+	if kind == IntegerArg && math.Abs(value) > MaxInteger {
+		in.runtimeError(node.Tok, "integer overflow reading file %s", name)
+	}
+	return value
+}
+
+// encode ...
+// Writes a component at Pos, over the one there if any, stopping
+// the program when an INTEGER is beyond MaxInteger. n is the
+// argument of Write the value is from.
+func (in *Interpreter) encode(f *FileValue, v Value, n Node) {
+	var data []byte
+	i := 0
+	scalars(v, nil, func(x float64, _ func(Value)) {
+		switch f.Encoding[i] {
+		case IntegerEncoding:
+			if math.Abs(x) > MaxInteger {
+				in.runtimeError(ExprToken(n), "integer overflow")
+			}
+			data = binary.LittleEndian.AppendUint64(data, uint64(int64(x)))
+		case RealEncoding:
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(x))
+		case ByteEncoding:
+			data = append(data, byte(x))
+		}
+		i++
+	})
+	if end := f.Pos + len(data); end > len(f.Data) {
+		f.Data = append(f.Data, make([]byte, end-len(f.Data))...)
+	}
+	f.Pos += copy(f.Data[f.Pos:], data)
+	f.Dirty = true
+}
+
+// decode ...
+// Reads the component at Pos into a new value, stopping the program
+// when a scalar is outside the bounds of its type or an INTEGER is
+// beyond MaxInteger. n is the argument of Read the value is for.
+func (in *Interpreter) decode(f *FileValue, n Node) Value {
+	v := in.zeroValue(f.Elem)
+	i := 0
+	scalars(v, func(x Value) { v = x }, func(_ float64, set func(Value)) {
+		data := f.Data[f.Pos:]
+		var x float64
+		switch f.Encoding[i] {
+		case IntegerEncoding:
+			x = float64(int64(binary.LittleEndian.Uint64(data)))
+			if math.Abs(x) > MaxInteger {
+				in.runtimeError(ExprToken(n), "integer overflow")
+			}
+		case RealEncoding:
+			x = math.Float64frombits(binary.LittleEndian.Uint64(data))
+		case ByteEncoding:
+			x = float64(data[0])
+		}
+		if f.Ranges[i] != nil {
+			in.checkRange(ExprToken(n), x, f.Ranges[i])
+		}
+		set(x)
+		f.Pos += encodingSize[f.Encoding[i]]
+		i++
+	})
+	return v
+}
+
+// scalars ...
+// Calls visit with each scalar of a file component in the order
+// they are stored, and a function that replaces it, set being the
+// one that replaces v
+func scalars(v Value, set func(Value), visit func(x float64, set func(Value))) {
+	switch v := v.(type) {
+	case *ArrayValue:
+		for i := range v.Elems {
+			scalars(v.Elems[i], func(x Value) { v.Elems[i] = x }, visit)
+		}
+	case *RecordValue:
+		for _, name := range v.Names {
+			scalars(v.Fields[name], func(x Value) { v.Fields[name] = x }, visit)
+		}
+	case float64:
+		visit(v, set)
+	}
+}
+
+// isBlank ...
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// spell ...
+// How Write writes a value of the given kind
+func (in *Interpreter) spell(v Value, kind int) string {
+	switch kind {
+	case CharArg, StringArg:
+		return text(v)
+	case BooleanArg:
+		return Booleans[int(v.(float64))]
+	}
+	return formatValue(v)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Formatter ...
+// Re-emits a tree as canonically formatted Pascal source:
+// upper case keywords, one statement per line, BEGIN/END
+// blocks indented and VAR declarations aligned on the colon.
+type Formatter struct {
+	VisitMap map[NodeType]func(n Node)
+	Indent   string
+
+	lines []string
+	depth int
+	// nesting counts the procedures being formatted, the ones
+	// declared inside them are indented
+	nesting int
+}
+
+// NewFormatter ...
+func NewFormatter() *Formatter {
+	f := &Formatter{Indent: "   "}
+	f.VisitMap = make(map[NodeType]func(n Node))
+	f.VisitMap[ProgramNode] = f.VisitProgram
+	f.VisitMap[BlockNode] = f.VisitBlock
+	f.VisitMap[CompoundNode] = f.VisitCompound
+	f.VisitMap[AssignNode] = f.VisitAssign
+	f.VisitMap[NoOpNode] = f.VisitNoOp
+	f.VisitMap[WithNode] = f.VisitWith
+	f.VisitMap[ForNode] = f.VisitFor
+	f.VisitMap[CaseNode] = f.VisitCase
+	f.VisitMap[ProcedureCallNode] = f.VisitProcedureCall
+	f.VisitMap[ProcedureDeclNode] = f.VisitProcedureDecl
+	return f
+}
+
+// Format ...
+func (f *Formatter) Format(n Node) string {
+	f.lines = nil
+	f.depth = 0
+	f.Visit(n)
+	return strings.Join(f.lines, "\n") + "\n"
+}
+
+// Visit ...
+func (f *Formatter) Visit(n Node) {
+	f.VisitMap[n.Type()](n)
+}
+
+// line ...
+// Start a new line at the current indentation
+func (f *Formatter) line(s string) {
+	if s != "" {
+		s = strings.Repeat(f.Indent, f.depth) + s
+	}
+	f.lines = append(f.lines, s)
+}
+
+// appendLast ...
+// Append to the last line that is not blank
+func (f *Formatter) appendLast(s string) {
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i] != "" {
+			f.lines[i] += s
+			return
+		}
+	}
+	f.line(s)
+}
+
+// comments ...
+// Trailing comments go at the end of the previous line,
+// all others on a line of their own.
+func (f *Formatter) comments(comments []Comment) {
+	for _, c := range comments {
+		if c.Trailing {
+			f.appendLast(" " + c.Text)
+		} else {
+			f.line(c.Text)
+		}
+	}
+}
+
+// VisitProgram ...
+func (f *Formatter) VisitProgram(n Node) {
+	node := n.(*Program)
+	f.comments(node.Comments)
+	f.line("PROGRAM " + node.Name + ";")
+	f.Visit(node.BlockNode)
+	f.appendLast(".")
+	f.comments(node.EndComments)
+}
+
+// VisitBlock ...
+func (f *Formatter) VisitBlock(n Node) {
+	node := n.(*Block)
+	f.comments(node.Comments)
+	for _, section := range declSections(node.Decls) {
+		switch first := section[0].(type) {
+		case *ConstDecl:
+			f.comments(first.KeywordComments)
+			f.line(keyword(CONST))
+			f.depth++
+			f.constDecls(section)
+		case *TypeDecl:
+			f.comments(first.KeywordComments)
+			f.line(keyword(TYPE))
+			f.depth++
+			f.typeDecls(section)
+		case *VarDecl:
+			f.comments(first.KeywordComments)
+			f.line(keyword(VAR))
+			f.depth++
+			f.varDecls(section)
+		case *ProcedureDecl:
+			f.procedureDecls(section)
+			continue
+		}
+		f.depth--
+		f.line("")
+	}
+	f.Visit(node.CompoundStmt)
+}
+
+// procedureDecls ...
+// Procedures declared inside a procedure are indented below it
+func (f *Formatter) procedureDecls(decls []Node) {
+	indent := 0
+	if f.nesting > 0 {
+		indent = 1
+	}
+	f.depth += indent
+	for _, decl := range decls {
+		f.Visit(decl)
+		f.line("")
+	}
+	f.depth -= indent
+}
+
+// VisitProcedureDecl ...
+// The block goes below the heading at the same indentation, the
+// FORWARD directive after it on the same line
+func (f *Formatter) VisitProcedureDecl(n Node) {
+	node := n.(*ProcedureDecl)
+	f.comments(node.Comments)
+	heading := keyword(PROCEDURE)
+	if node.Result != nil {
+		heading = keyword(FUNCTION)
+	}
+	heading += " " + node.Name + signatureString(node.Params, node.Result) + ";"
+	if node.BlockNode == nil {
+		f.line(heading + " " + keyword(FORWARD) + ";")
+		return
+	}
+	f.line(heading)
+	f.nesting++
+	f.Visit(node.BlockNode)
+	f.nesting--
+	f.appendLast(";")
+}
+
+// signatureString ...
+// Renders the parameters and the result type of a function
+func signatureString(params []*Param, result Node) string {
+	s := paramsString(params)
+	if result != nil {
+		s += " : " + typeString(result)
+	}
+	return s
+}
+
+// paramsString ...
+// Renders a formal parameter list, the names declared together
+// kept together, or nothing if there are no parameters
+func paramsString(params []*Param) string {
+	if len(params) == 0 {
+		return ""
+	}
+	var groups []string
+	for i := 0; i < len(params); {
+		if t, ok := params[i].TNode.(*ProceduralType); ok {
+			groups = append(groups, keyword(t.Tok.Type)+" "+params[i].Name+signatureString(t.Params, t.Result))
+			i++
+			continue
+		}
+		var names []string
+		j := i
+		for ; j < len(params) && params[j].TNode == params[i].TNode; j++ {
+			names = append(names, params[j].Name)
+		}
+		group := strings.Join(names, ", ") + " : " + typeString(params[i].TNode)
+		switch params[i].Mode {
+		case VarArg:
+			group = keyword(VAR) + " " + group
+		case ConstArg:
+			group = keyword(CONST) + " " + group
+		}
+		groups = append(groups, group)
+		i = j
+	}
+	return "(" + strings.Join(groups, "; ") + ")"
+}
+
+// declSections ...
+// Splits declarations into runs of the same kind, one CONST, TYPE
+// or VAR section each
+func declSections(decls []Node) [][]Node {
+	var sections [][]Node
+	for _, decl := range decls {
+		last := len(sections) - 1
+		if last >= 0 && sections[last][0].Type() == decl.Type() {
+			sections[last] = append(sections[last], decl)
+		} else {
+			sections = append(sections, []Node{decl})
+		}
+	}
+	return sections
+}
+
+// constDecls ...
+// Constant declarations with the names aligned on the equal sign
+func (f *Formatter) constDecls(decls []Node) {
+	width := 0
+	for _, decl := range decls {
+		if name := decl.(*ConstDecl).Name; len(name) > width {
+			width = len(name)
+		}
+	}
+	for i, decl := range decls {
+		constdecl := decl.(*ConstDecl)
+		if i > 0 {
+			f.comments(constdecl.KeywordComments)
+		}
+		f.comments(constdecl.Comments)
+		f.line(constdecl.Name + strings.Repeat(" ", width-len(constdecl.Name)) + " = " + exprString(constdecl.Expr) + ";")
+	}
+}
+
+// typeDecls ...
+// Type declarations with the names aligned on the equal sign
+func (f *Formatter) typeDecls(decls []Node) {
+	width := 0
+	for _, decl := range decls {
+		if name := decl.(*TypeDecl).Name; len(name) > width {
+			width = len(name)
+		}
+	}
+	for i, decl := range decls {
+		typedecl := decl.(*TypeDecl)
+		if i > 0 {
+			f.comments(typedecl.KeywordComments)
+		}
+		f.comments(typedecl.Comments)
+		f.typeSpec(typedecl.Name+strings.Repeat(" ", width-len(typedecl.Name))+" = ", typedecl.TNode)
+		f.appendLast(";")
+	}
+}
+
+// varDecls ...
+// Declarations of several names with one type spec are kept
+// together on one line, as they were written, and the names are
+// aligned on the colon. Used for variables and record fields.
+func (f *Formatter) varDecls(decls []Node) {
+	var groups [][]*VarDecl
+	for _, decl := range decls {
+		vardecl := decl.(*VarDecl)
+		last := len(groups) - 1
+		if last >= 0 && groups[last][0].TNode == vardecl.TNode {
+			groups[last] = append(groups[last], vardecl)
+		} else {
+			groups = append(groups, []*VarDecl{vardecl})
+		}
+	}
+	names := make([]string, len(groups))
+	width := 0
+	for i, group := range groups {
+		var list []string
+		for _, vardecl := range group {
+			list = append(list, vardecl.VNode.(*Var).Value)
+		}
+		names[i] = strings.Join(list, ", ")
+		if len(names[i]) > width {
+			width = len(names[i])
+		}
+	}
+	for i, group := range groups {
+		if i > 0 {
+			f.comments(group[0].KeywordComments)
+		}
+		f.comments(group[0].Comments)
+		f.typeSpec(names[i]+strings.Repeat(" ", width-len(names[i]))+" : ", group[0].TNode)
+		f.appendLast(";")
+	}
+}
+
+// typeSpec ...
+// Writes prefix followed by a type spec. The fields of a record
+// go on lines of their own, indented, with END below the line
+// the record starts on.
+func (f *Formatter) typeSpec(prefix string, n Node) {
+	switch node := n.(type) {
+	case *ArrayType:
+		f.typeSpec(prefix+arrayHead(node), node.Elem)
+	case *RecordType:
+		f.line(prefix + keyword(RECORD))
+		f.depth++
+		f.varDecls(node.Fields)
+		f.comments(node.EndComments)
+		f.depth--
+		f.line(keyword(END))
+	default:
+		f.line(prefix + typeString(n))
+	}
+}
+
+// VisitCompound ...
+func (f *Formatter) VisitCompound(n Node) {
+	node := n.(*Compound)
+	f.comments(node.Comments)
+	f.line("BEGIN")
+	f.depth++
+	for i, child := range node.Children {
+		f.Visit(child)
+		if i < len(node.Children)-1 {
+			f.appendLast(";")
+		}
+	}
+	f.comments(node.EndComments)
+	f.depth--
+	f.line("END")
+}
+
+// VisitAssign ...
+func (f *Formatter) VisitAssign(n Node) {
+	f.comments(n.(*Assign).Comments)
+	f.line(stmtString(n))
+}
+
+// VisitProcedureCall ...
+func (f *Formatter) VisitProcedureCall(n Node) {
+	f.comments(n.(*ProcedureCall).Comments)
+	f.line(stmtString(n))
+}
+
+// VisitWith ...
+// A compound body starts on the next line at the same indentation,
+// any other statement is indented below the WITH.
+func (f *Formatter) VisitWith(n Node) {
+	node := n.(*With)
+	f.comments(node.Comments)
+	f.line(stmtString(node))
+	if _, ok := node.Body.(*Compound); ok {
+		f.Visit(node.Body)
+		return
+	}
+	f.depth++
+	f.Visit(node.Body)
+	f.depth--
+}
+
+// VisitFor ...
+// Laid out like a WITH statement
+func (f *Formatter) VisitFor(n Node) {
+	node := n.(*For)
+	f.comments(node.Comments)
+	f.line(stmtString(node))
+	if _, ok := node.Body.(*Compound); ok {
+		f.Visit(node.Body)
+		return
+	}
+	f.depth++
+	f.Visit(node.Body)
+	f.depth--
+}
+
+// VisitCase ...
+// An assignment or an empty statement follows its labels on the
+// same line, any other statement is indented below them. The ELSE
+// arm lines up with the CASE.
+func (f *Formatter) VisitCase(n Node) {
+	node := n.(*Case)
+	f.comments(node.Comments)
+	f.line(stmtString(node))
+	f.depth++
+	for i, arm := range node.Arms {
+		f.comments(arm.Comments)
+		labels := caseLabels(arm) + ":"
+		switch body := arm.Body.(type) {
+		case *Assign:
+			f.comments(body.Comments)
+			f.line(labels + " " + stmtString(body))
+		case *NoOp:
+			f.comments(body.Comments)
+			f.line(labels)
+		default:
+			f.line(labels)
+			f.depth++
+			f.Visit(body)
+			f.depth--
+		}
+		if i < len(node.Arms)-1 {
+			f.appendLast(";")
+		}
+	}
+	f.depth--
+	if node.Else != nil {
+		f.line(keyword(ELSE))
+		f.depth++
+		for i, stmt := range node.Else {
+			f.Visit(stmt)
+			if i < len(node.Else)-1 {
+				f.appendLast(";")
+			}
+		}
+		f.depth--
+	}
+	f.depth++
+	f.comments(node.EndComments)
+	f.depth--
+	f.line(keyword(END))
+}
+
+// caseLabels ...
+// Renders the labels of a CASE arm, without the colon
+func caseLabels(arm *CaseArm) string {
+	var labels []string
+	for _, label := range arm.Labels {
+		labels = append(labels, exprRangeString(label))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// exprRangeString ...
+// Renders an expression, or a Subrange of two, back into Pascal source
+func exprRangeString(n Node) string {
+	if subrange, ok := n.(*Subrange); ok {
+		return exprString(subrange.Low) + ".." + exprString(subrange.High)
+	}
+	return exprString(n)
+}
+
+// VisitNoOp ...
+func (f *Formatter) VisitNoOp(n Node) {
+	f.comments(n.(*NoOp).Comments)
+}
+
+// keyword ...
+// Returns the reserved word that lexes to the given token type
+func keyword(tokType int) string {
+	for word, tok := range ReservedWords {
+		if tok.Type == tokType {
+			return word
+		}
+	}
+	return TokenStr[tokType]
+}
+
+// typeString ...
+// Renders a type spec back into Pascal source
+func typeString(n Node) string {
+	switch node := n.(type) {
+	case *TypeN:
+		if node.Tok.Type == IDENT {
+			return node.Tok.Svalue
+		}
+		return keyword(node.Tok.Type)
+	case *ArrayType:
+		return arrayHead(node) + typeString(node.Elem)
+	case *RecordType:
+		var fields []string
+		for _, field := range node.Fields {
+			decl := field.(*VarDecl)
+			fields = append(fields, decl.VNode.(*Var).Value+" : "+typeString(decl.TNode))
+		}
+		return keyword(RECORD) + " " + strings.Join(fields, "; ") + " " + keyword(END)
+	case *Enum:
+		var names []string
+		for _, name := range node.Names {
+			names = append(names, name.Svalue)
+		}
+		return "(" + strings.Join(names, ", ") + ")"
+	case *Subrange:
+		return exprString(node.Low) + ".." + exprString(node.High)
+	case *SetType:
+		return keyword(SET) + " " + keyword(OF) + " " + typeString(node.Elem)
+	case *PointerType:
+		return "^" + typeString(node.Elem)
+	case *ProceduralType:
+		return keyword(node.Tok.Type) + signatureString(node.Params, node.Result)
+	case *FileType:
+		return keyword(FILE) + " " + keyword(OF) + " " + typeString(node.Elem)
+	}
+	return n.String()
+}
+
+// arrayHead ...
+// ARRAY[...] OF, the part of an array type before the element type
+func arrayHead(n *ArrayType) string {
+	var indexes []string
+	for _, index := range n.Indexes {
+		indexes = append(indexes, typeString(index))
+	}
+	return keyword(ARRAY) + "[" + strings.Join(indexes, ", ") + "] " + keyword(OF) + " "
+}
+
+// stmtString ...
+// Renders a simple statement back into Pascal source.
+func stmtString(n Node) string {
+	switch node := n.(type) {
+	case *Assign:
+		return exprString(node.Left) + " := " + exprString(node.Right)
+	case *With:
+		var records []string
+		for _, record := range node.Records {
+			records = append(records, exprString(record))
+		}
+		return keyword(WITH) + " " + strings.Join(records, ", ") + " " + keyword(DO)
+	case *For:
+		direction := keyword(TO)
+		if node.Down {
+			direction = keyword(DOWNTO)
+		}
+		return keyword(FOR) + " " + exprString(node.Var) + " := " + exprString(node.Start) + " " +
+			direction + " " + exprString(node.End) + " " + keyword(DO)
+	case *Case:
+		return keyword(CASE) + " " + exprString(node.Expr) + " " + keyword(OF)
+	case *ProcedureCall:
+		if len(node.Args) == 0 {
+			return node.Name
+		}
+		return node.Name + "(" + argsString(node.Args) + ")"
+	}
+	return n.String()
+}
+
+// exprString ...
+// Renders an expression back into Pascal source, adding
+// parentheses only where precedence requires them.
+func exprString(n Node) string {
+	switch node := n.(type) {
+	case *Num:
+		s := strconv.FormatFloat(node.Value, 'f', -1, 64)
+		if node.Tok.Type == REALCONST && !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case *Var:
+		return node.Value
+	case *Index:
+		var indexes []string
+		for _, index := range node.Indexes {
+			indexes = append(indexes, exprString(index))
+		}
+		return exprString(node.Array) + "[" + strings.Join(indexes, ", ") + "]"
+	case *Field:
+		return exprString(node.Record) + "." + node.Name
+	case *Deref:
+		return exprString(node.Pointer) + "^"
+	case *Nil:
+		return keyword(NIL)
+	case *Str:
+		return quoteString(node.Value)
+	case *Call:
+		return node.Name + "(" + argsString(node.Args) + ")"
+	case *SetConstructor:
+		var elems []string
+		for _, elem := range node.Elems {
+			elems = append(elems, exprRangeString(elem))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case *UnaryOp:
+		expr := exprString(node.Expr)
+		if precedence(node.Expr) < precedence(node) {
+			expr = "(" + expr + ")"
+		}
+		return TokenStr[node.Op] + expr
+	case *BinOp:
+		left, right := exprString(node.Left), exprString(node.Right)
+		// relations do not chain, a relation inside another one
+		// always needs parentheses
+		if precedence(node.Left) < precedence(node) || precedence(node.Left) == 0 {
+			left = "(" + left + ")"
+		}
+		if precedence(node.Right) <= precedence(node) {
+			right = "(" + right + ")"
+		}
+		op := TokenStr[node.Op]
+		switch node.Op {
+		case INTEGERDIV, IN:
+			op = keyword(node.Op)
+		case FLOATDIV:
+			op = "/"
+		}
+		return left + " " + op + " " + right
+	}
+	return n.String()
+}
+
+// argsString ...
+// Renders the arguments of a call, without the parentheses
+func argsString(args []Node) string {
+	var list []string
+	for _, arg := range args {
+		list = append(list, exprString(arg))
+	}
+	return strings.Join(list, ", ")
+}
+
+// quoteString ...
+// Renders a string as a Pascal string constant, with #n codes for
+// the characters that can not be printed
+func quoteString(s string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' {
+			if quoted {
+				b.WriteByte('\'')
+				quoted = false
+			}
+			b.WriteString("#" + strconv.Itoa(int(c)))
+			continue
+		}
+		if !quoted {
+			b.WriteByte('\'')
+			quoted = true
+		}
+		if c == '\'' {
+			b.WriteByte('\'')
+		}
+		b.WriteByte(c)
+	}
+	if quoted {
+		b.WriteByte('\'')
+	}
+	if b.Len() == 0 {
+		return "''"
+	}
+	return b.String()
+}
+
+func precedence(n Node) int {
+	if node, ok := n.(*BinOp); ok {
+		switch node.Op {
+		case EQUAL, NOTEQUAL, LESS, LESSEQUAL, GREATER, GREATEREQUAL, IN:
+			return 0
+		case PLUS, MINUS:
+			return 1
+		default:
+			return 2
+		}
+	}
+	return 3
+}
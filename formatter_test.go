@@ -0,0 +1,333 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// format parses and formats source, failing the test on errors
+func format(t *testing.T, source string) string {
+	t.Helper()
+	tree, err := ParseSource(source)
+	if err != nil {
+		t.Fatalf("parse: %s\n%s", err, source)
+	}
+	return NewFormatter().Format(tree)
+}
+
+func TestFormatComments(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name: "trailing comment before a section keyword",
+			source: `PROGRAM P;
+CONST
+   x = 1; { m }
+VAR
+   v : INTEGER;
+BEGIN
+END.
+`,
+			want: `PROGRAM P;
+CONST
+   x = 1; { m }
+
+VAR
+   v : INTEGER;
+
+BEGIN
+END.
+`,
+		},
+		{
+			name: "comment after a section keyword",
+			source: `PROGRAM P;
+TYPE
+   T = INTEGER;
+VAR { v }
+   v : T;
+BEGIN
+END.
+`,
+			want: `PROGRAM P;
+TYPE
+   T = INTEGER;
+
+VAR { v }
+   v : T;
+
+BEGIN
+END.
+`,
+		},
+		{
+			name: "comment on a line before a section keyword",
+			source: `PROGRAM P;
+CONST
+   x = 1;
+{ types }
+TYPE
+   T = INTEGER; { t }
+BEGIN
+END.
+`,
+			want: `PROGRAM P;
+CONST
+   x = 1;
+
+{ types }
+TYPE
+   T = INTEGER; { t }
+
+BEGIN
+END.
+`,
+		},
+		{
+			name: "sections of the same kind merged",
+			source: `PROGRAM P;
+CONST a = 1; { a }
+CONST b = 2;
+BEGIN
+END.
+`,
+			want: `PROGRAM P;
+CONST
+   a = 1; { a }
+   b = 2;
+
+BEGIN
+END.
+`,
+		},
+		{
+			name: "comments before the END of a record",
+			source: `PROGRAM P;
+TYPE
+   R = RECORD
+      a : INTEGER;
+      b : REAL { last }
+      { more }
+   END;
+BEGIN
+END.
+`,
+			want: `PROGRAM P;
+TYPE
+   R = RECORD
+      a : INTEGER;
+      b : REAL; { last }
+      { more }
+   END;
+
+BEGIN
+END.
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := format(t, test.source)
+			if got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+			if again := format(t, got); again != got {
+				t.Errorf("formatting again gave\n%s\nwant\n%s", again, got)
+			}
+		})
+	}
+}
+
+func TestFormatKeywordCase(t *testing.T) {
+	source := `program Mixed;
+var
+   i, n : Integer; b : boolean;
+   s : string;
+Function Twice(x : integer) : integer;
+Begin
+   Twice := x * 2
+end;
+begin
+   b := true; s := 'ab'; n := 0;
+   for i := 1 To length(s) dO
+      n := n + Twice(ord(s[i]) - ORD('a'))
+End.
+`
+	want := `PROGRAM Mixed;
+VAR
+   i, n : INTEGER;
+   b    : BOOLEAN;
+   s    : STRING;
+
+FUNCTION Twice(x : INTEGER) : INTEGER;
+BEGIN
+   Twice := x * 2
+END;
+
+BEGIN
+   b := true;
+   s := 'ab';
+   n := 0;
+   FOR i := 1 TO length(s) DO
+      n := n + Twice(ord(s[i]) - ORD('a'))
+END.
+`
+	got := format(t, source)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	for _, source := range []string{source, got} {
+		globals, err := run(t, source, MapFS{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if globals["b"] != 1.0 || globals["n"] != 2.0 {
+			t.Errorf("b = %v, n = %v, want 1 and 2", globals["b"], globals["n"])
+		}
+	}
+	if _, err := run(t, strings.Replace(source, "Twice(ord", "twice(ord", 1), MapFS{}); err == nil || !strings.Contains(err.Error(), "identifier not found 'twice'") {
+		t.Errorf("got error %v, want names declared by the program to keep their case", err)
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	for _, source := range []string{pascalsample1, pascalsample2} {
+		once := format(t, source)
+		if twice := format(t, once); twice != once {
+			t.Errorf("formatting again gave\n%s\nwant\n%s", twice, once)
+		}
+	}
+}
+
+func TestFormatStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name: "CASE arms, ELSE and comments",
+			source: `PROGRAM P;
+VAR i, n : INTEGER;
+BEGIN
+   CASE i OF { pick }
+   1, 3: n := 1; { odd }
+   { even }
+   2..4 : BEGIN n := 2 END;
+   5: ;
+   6: Write(n)
+   ELSE n := 0; n := n+1
+   { nothing else }
+   END
+END.
+`,
+			want: `PROGRAM P;
+VAR
+   i, n : INTEGER;
+
+BEGIN
+   CASE i OF { pick }
+      1, 3: n := 1; { odd }
+      { even }
+      2..4:
+         BEGIN
+            n := 2
+         END;
+      5:;
+      6:
+         Write(n)
+   ELSE
+      n := 0;
+      n := n + 1
+      { nothing else }
+   END
+END.
+`,
+		},
+		{
+			name: "WITH bodies and comments",
+			source: `PROGRAM P;
+TYPE R = RECORD a, b : INTEGER END;
+VAR r : R;
+BEGIN
+   { fill }
+   WITH r DO a := 1; { a }
+   WITH r DO BEGIN b := 2; { b }
+   a := b END
+END.
+`,
+			want: `PROGRAM P;
+TYPE
+   R = RECORD
+      a, b : INTEGER;
+   END;
+
+VAR
+   r : R;
+
+BEGIN
+   { fill }
+   WITH r DO
+      a := 1; { a }
+   WITH r DO
+   BEGIN
+      b := 2; { b }
+      a := b
+   END
+END.
+`,
+		},
+		{
+			name: "procedure calls and comments",
+			source: `PROGRAM P;
+VAR n : INTEGER;
+PROCEDURE Add(VAR x : INTEGER; d : INTEGER);
+BEGIN x := x+d END;
+PROCEDURE Reset;
+BEGIN n := 0 END;
+BEGIN
+   { start }
+   Reset; { zero }
+   Add(n,   2*3); Add ( n , -1 );
+   WriteLn('n = ', n)
+END.
+`,
+			want: `PROGRAM P;
+VAR
+   n : INTEGER;
+
+PROCEDURE Add(VAR x : INTEGER; d : INTEGER);
+BEGIN
+   x := x + d
+END;
+
+PROCEDURE Reset;
+BEGIN
+   n := 0
+END;
+
+BEGIN
+   { start }
+   Reset; { zero }
+   Add(n, 2 * 3);
+   Add(n, -1);
+   WriteLn('n = ', n)
+END.
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := format(t, test.source)
+			if got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+			if again := format(t, got); again != got {
+				t.Errorf("formatting again gave\n%s\nwant\n%s", again, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// HeapCell ...
+// A variable created by New
+type HeapCell struct {
+	// ID numbers the cells in the order they were allocated, from 1
+	ID    int
+	Value Value
+	// Tok is the New that allocated the cell, Disposed the Dispose
+	// that freed it once it has been
+	Tok      Token
+	Disposed *Token
+}
+
+// Pointer ...
+// A pointer value, NIL when Cell is nil. Pointers are equal when
+// they point to the same cell.
+type Pointer struct {
+	Cell *HeapCell
+}
+
+func (p Pointer) String() string {
+	if p.Cell == nil {
+		return keyword(NIL)
+	}
+	return fmt.Sprintf("^%d", p.Cell.ID)
+}
+
+// Heap ...
+// The variables created by New that have not been disposed
+type Heap struct {
+	live  map[*HeapCell]bool
+	count int
+}
+
+// NewHeap ...
+func NewHeap() *Heap {
+	return &Heap{live: make(map[*HeapCell]bool)}
+}
+
+// New ...
+// Allocates a cell holding v
+func (h *Heap) New(v Value, tok Token) Pointer {
+	h.count++
+	cell := &HeapCell{ID: h.count, Value: v, Tok: tok}
+	h.live[cell] = true
+	return Pointer{cell}
+}
+
+// Dispose ...
+// Frees the cell p points to, which must not be disposed yet
+func (h *Heap) Dispose(p Pointer, tok Token) {
+	delete(h.live, p.Cell)
+	p.Cell.Value = nil
+	p.Cell.Disposed = &tok
+}
+
+// Leaks ...
+// Reports the cells never disposed, one warning for each New that
+// allocated any, in source order
+func (h *Heap) Leaks() []Warning {
+	type position struct{ line, column int }
+	counts := make(map[position]int)
+	var sites []Token
+	for cell := range h.live {
+		at := position{cell.Tok.Line, cell.Tok.Column}
+		if counts[at] == 0 {
+			sites = append(sites, cell.Tok)
+		}
+		counts[at]++
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Line != sites[j].Line {
+			return sites[i].Line < sites[j].Line
+		}
+		return sites[i].Column < sites[j].Column
+	})
+	var warnings []Warning
+	for _, tok := range sites {
+		message := "variable allocated here is never disposed"
+		if n := counts[position{tok.Line, tok.Column}]; n > 1 {
+			message = fmt.Sprintf("%d variables allocated here are never disposed", n)
+		}
+		warnings = append(warnings, Warning{tok, message})
+	}
+	return warnings
+}
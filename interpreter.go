@@ -1,17 +1,69 @@
 package main
 
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Hook ...
+// Lets tools such as the debugger pause the interpreter. Visit
+// calls BeforeStatement ahead of every statement that has a
+// position in the source, tok being its first token.
+type Hook interface {
+	BeforeStatement(n Node, tok Token)
+}
+
+// Observer ...
+// Receives events from the interpreter as the program runs.
+// EnterNode and ExitNode bracket the visit of every node. Assign
+// gets the assignment or FOR statement that set the variable, or
+// the argument naming the variable a builtin procedure sets. Branch
+// reports the arm a branching statement took, indexing the arms
+// given by BranchArms.
+type Observer interface {
+	EnterNode(n Node)
+	ExitNode(n Node)
+	Assign(name string, value Value, n Node)
+	Call(ar *ActivationRecord)
+	Return(ar *ActivationRecord)
+	Branch(n Node, arm int)
+}
+
 // Interpreter ...
 type Interpreter struct {
-	GLOBALSCOPE map[string]float64
-	VisitMap    map[NodeType]func(n Node) float64
-	parser      *Parser
+	// GLOBALSCOPE holds the members of the program's activation
+	// record, it is kept after the program has finished
+	GLOBALSCOPE map[string]Value
+	VisitMap    map[NodeType]func(n Node) Value
+	CallStack   *CallStack
+	Hook        Hook
+	Observers   []Observer
+	// Heap holds the variables created by New
+	Heap *Heap
+	// Files holds the files the program names with Assign, the
+	// current directory unless set otherwise
+	Files  FileSystem
+	parser *Parser
+	// withs holds the accessors of the records of the WITH
+	// statements being run
+	withs map[*With][]func() Value
+	// cases holds the dispatch of the CASE statements run so far
+	cases map[*Case]*caseDispatch
 }
 
 // NewInterpreter ...
 func NewInterpreter() *Interpreter {
 	in := &Interpreter{}
-	in.GLOBALSCOPE = make(map[string]float64)
-	in.VisitMap = make(map[NodeType]func(n Node) float64)
+	in.GLOBALSCOPE = make(map[string]Value)
+	in.CallStack = &CallStack{}
+	in.Heap = NewHeap()
+	in.Files = DirFS(".")
+	in.withs = make(map[*With][]func() Value)
+	in.cases = make(map[*Case]*caseDispatch)
+	in.VisitMap = make(map[NodeType]func(n Node) Value)
 	in.VisitMap[BinOpNode] = in.VisitBinOp
 	in.VisitMap[UnaryOpNode] = in.VisitUnaryOp
 	in.VisitMap[NumNode] = in.VisitNum
@@ -23,113 +75,917 @@ func NewInterpreter() *Interpreter {
 	in.VisitMap[BlockNode] = in.VisitBlock
 	in.VisitMap[VarDeclNode] = in.VisitVarDecl
 	in.VisitMap[TypeNode] = in.VisitType
+	in.VisitMap[TypeDeclNode] = in.VisitTypeDecl
+	in.VisitMap[ConstDeclNode] = in.VisitConstDecl
+	in.VisitMap[IndexNode] = in.VisitIndex
+	in.VisitMap[FieldNode] = in.VisitField
+	in.VisitMap[WithNode] = in.VisitWith
+	in.VisitMap[CallNode] = in.VisitCall
+	in.VisitMap[ForNode] = in.VisitFor
+	in.VisitMap[CaseNode] = in.VisitCase
+	in.VisitMap[SetConstructorNode] = in.VisitSetConstructor
+	in.VisitMap[StrNode] = in.VisitStr
+	in.VisitMap[ProcedureCallNode] = in.VisitProcedureCall
+	in.VisitMap[DerefNode] = in.VisitDeref
+	in.VisitMap[NilNode] = in.VisitNil
+	in.VisitMap[ProcedureDeclNode] = in.VisitProcedureDecl
 	return in
 }
 
+// Observe ...
+// Subscribe o to the events of the running program
+func (in *Interpreter) Observe(o Observer) {
+	in.Observers = append(in.Observers, o)
+}
+
 // Interpret ...
-func (in *Interpreter) Interpret(n Node) {
+// Runs the program, a runtime error stops it and is returned
+func (in *Interpreter) Interpret(n Node) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
 	in.Visit(n)
+	return nil
 }
 
 func (in *Interpreter) Error() {
 	panic("unknown node")
 }
 
+// runtimeError ...
+// Stops the program, reporting the failure at tok
+func (in *Interpreter) runtimeError(tok Token, format string, args ...interface{}) {
+	panic(&Error{Kind: RuntimeError, Tok: tok, Message: fmt.Sprintf(format, args...)})
+}
+
 // VisitProgram ...
-func (in *Interpreter) VisitProgram(n Node) float64 {
+func (in *Interpreter) VisitProgram(n Node) Value {
 	node := n.(*Program)
-	return in.Visit(node.BlockNode)
+	ar := NewActivationRecord(node.Name, ProgramAR, 1)
+	ar.Decl = node
+	ar.Members = in.GLOBALSCOPE
+	in.CallStack.Push(ar)
+	for _, o := range in.Observers {
+		o.Call(ar)
+	}
+	in.Visit(node.BlockNode)
+	for _, o := range in.Observers {
+		o.Return(ar)
+	}
+	in.CallStack.Pop()
+	return nil
 }
 
 // VisitVarDecl ...
-func (in *Interpreter) VisitVarDecl(n Node) float64 {
-	return 0
+// Arrays and records are created when they are declared, with
+// every element and field set to zero, procedural variables start
+// out holding no routine and files closed. Other variables have no
+// value until assigned.
+func (in *Interpreter) VisitVarDecl(n Node) Value {
+	node := n.(*VarDecl)
+	in.declareEnums(node.TNode)
+	in.CallStack.Peek().Domains[node.VNode.(*Var).Value] = node.Domain
+	switch ResolveType(node.TNode).(type) {
+	case *ArrayType, *RecordType, *ProceduralType:
+		in.CallStack.Peek().Set(node.VNode.(*Var).Value, in.zeroValue(node.TNode))
+	default:
+		if isFileType(node.TNode) {
+			in.CallStack.Peek().Set(node.VNode.(*Var).Value, in.zeroValue(node.TNode))
+		}
+	}
+	return nil
+}
+
+// zeroValue ...
+// Returns a new value of the type given by a type spec
+func (in *Interpreter) zeroValue(n Node) Value {
+	switch node := ResolveType(n).(type) {
+	case *ArrayType:
+		return in.zeroArray(node.Indexes, node.Elem)
+	case *RecordType:
+		record := NewRecordValue()
+		for _, field := range node.Fields {
+			decl := field.(*VarDecl)
+			record.Add(decl.VNode.(*Var).Value, in.zeroValue(decl.TNode))
+		}
+		return record
+	case *SetType:
+		return SetValue{}
+	case *PointerType:
+		return Pointer{}
+	case *ProceduralType:
+		return Routine{}
+	case *FileType:
+		return &FileValue{Type: typeString(node), Elem: node.Elem, Encoding: node.Encoding, Ranges: node.Ranges}
+	case *TypeN:
+		switch node.Tok.Type {
+		case STRING:
+			return ""
+		case TEXT:
+			return &FileValue{Type: keyword(TEXT)}
+		}
+	}
+	return 0.0
+}
+
+func (in *Interpreter) zeroArray(indexes []Node, elem Node) Value {
+	if len(indexes) == 0 {
+		return in.zeroValue(elem)
+	}
+	low, high := in.bounds(indexes[0])
+	return NewArrayValue(low, high, func() Value {
+		return in.zeroArray(indexes[1:], elem)
+	})
+}
+
+// bounds ...
+// Returns the range of the index type of an array
+func (in *Interpreter) bounds(n Node) (low, high int) {
+	switch node := ResolveType(n).(type) {
+	case *Subrange:
+		return int(in.number(node.Low)), int(in.number(node.High))
+	case *Enum:
+		return 0, len(node.Names) - 1
+	case *TypeN:
+		switch node.Tok.Type {
+		case BOOLEAN:
+			return 0, len(Booleans) - 1
+		case CHAR:
+			return 0, MaxSetElement
+		}
+	}
+	in.Error()
+	return
+}
+
+// declareEnums ...
+// The names of the enumerations in a type spec are constants
+// holding their position
+func (in *Interpreter) declareEnums(n Node) {
+	switch node := n.(type) {
+	case *Enum:
+		for i, name := range node.Names {
+			in.CallStack.Peek().SetConstant(name.Svalue, float64(i))
+		}
+	case *ArrayType:
+		for _, index := range node.Indexes {
+			in.declareEnums(index)
+		}
+		in.declareEnums(node.Elem)
+	case *RecordType:
+		for _, field := range node.Fields {
+			in.declareEnums(field.(*VarDecl).TNode)
+		}
+	case *SetType:
+		in.declareEnums(node.Elem)
+	case *FileType:
+		in.declareEnums(node.Elem)
+	}
 }
 
 // VisitType ...
-func (in *Interpreter) VisitType(n Node) float64 {
-	return 0
+func (in *Interpreter) VisitType(n Node) Value {
+	return nil
+}
+
+// VisitTypeDecl ...
+func (in *Interpreter) VisitTypeDecl(n Node) Value {
+	in.declareEnums(n.(*TypeDecl).TNode)
+	return nil
+}
+
+// VisitConstDecl ...
+// Constants live in the activation record next to the variables,
+// the semantic analyzer keeps them from being assigned
+func (in *Interpreter) VisitConstDecl(n Node) Value {
+	node := n.(*ConstDecl)
+	in.CallStack.Peek().SetConstant(node.Name, in.Visit(node.Expr))
+	return nil
 }
 
 // VisitBlock ...
-func (in *Interpreter) VisitBlock(n Node) float64 {
+func (in *Interpreter) VisitBlock(n Node) Value {
 	node := n.(*Block)
 	for _, declaration := range node.Decls {
 		in.Visit(declaration)
 	}
 	in.Visit(node.CompoundStmt)
-	return 0
+	return nil
 }
 
 // VisitBinOp ...
-func (in *Interpreter) VisitBinOp(n Node) float64 {
+// Comparisons give the position of False or True. A character
+// compared with a string is taken as a string of one character.
+// DIV truncates toward zero.
+func (in *Interpreter) VisitBinOp(n Node) Value {
 	node := n.(*BinOp)
+	if node.Op == IN {
+		value := in.number(node.Left)
+		return boolean(in.Visit(node.Right).(SetValue).Contains(int(value)))
+	}
+	left, right := in.Visit(node.Left), in.Visit(node.Right)
+	if set, ok := left.(SetValue); ok {
+		return in.setOperation(node, set, right.(SetValue))
+	}
+	if node.Concat {
+		return text(left) + text(right)
+	}
+	if p, ok := left.(Pointer); ok {
+		return boolean((p == right.(Pointer)) == (node.Op == EQUAL))
+	}
+	_, lstring := left.(string)
+	_, rstring := right.(string)
+	if lstring || rstring {
+		return in.compareStrings(node, text(left), text(right))
+	}
+	x, y := left.(float64), right.(float64)
+	if (node.Op == INTEGERDIV || node.Op == FLOATDIV) && y == 0 {
+		in.runtimeError(node.Tok, "division by zero")
+	}
 	switch node.Op {
 	case PLUS:
-		return in.Visit(node.Left) + in.Visit(node.Right)
+		return in.checkInteger(node, x+y)
 	case MINUS:
-		return in.Visit(node.Left) - in.Visit(node.Right)
+		return in.checkInteger(node, x-y)
 	case MUL:
-		return in.Visit(node.Left) * in.Visit(node.Right)
+		return in.checkInteger(node, x*y)
 	case INTEGERDIV:
-		return in.Visit(node.Left) / in.Visit(node.Right)
+		return in.checkInteger(node, math.Trunc(x/y))
 	case FLOATDIV:
-		return in.Visit(node.Left) / in.Visit(node.Right)
+		return x / y
+	case EQUAL:
+		return boolean(x == y)
+	case NOTEQUAL:
+		return boolean(x != y)
+	case LESS:
+		return boolean(x < y)
+	case LESSEQUAL:
+		return boolean(x <= y)
+	case GREATER:
+		return boolean(x > y)
+	case GREATEREQUAL:
+		return boolean(x >= y)
+	}
+	in.Error()
+	return nil
+}
+
+// checkInteger ...
+// Stops the program when an INTEGER operator gives a number beyond
+// MaxInteger, which float64s cannot hold exactly
+func (in *Interpreter) checkInteger(node *BinOp, x float64) float64 {
+	if node.Integer && math.Abs(x) > MaxInteger {
+		in.runtimeError(node.Tok, "integer overflow")
+	}
+	return x
+}
+
+// compareStrings ...
+// Strings compare character by character
+func (in *Interpreter) compareStrings(node *BinOp, x, y string) Value {
+	switch node.Op {
+	case EQUAL:
+		return boolean(x == y)
+	case NOTEQUAL:
+		return boolean(x != y)
+	case LESS:
+		return boolean(x < y)
+	case LESSEQUAL:
+		return boolean(x <= y)
+	case GREATER:
+		return boolean(x > y)
+	case GREATEREQUAL:
+		return boolean(x >= y)
 	}
 	in.Error()
-	return 0
+	return nil
+}
+
+// setOperation ...
+// <= and >= test for inclusion
+func (in *Interpreter) setOperation(node *BinOp, x, y SetValue) Value {
+	switch node.Op {
+	case PLUS:
+		return x.Union(y)
+	case MINUS:
+		return x.Difference(y)
+	case MUL:
+		return x.Intersection(y)
+	case EQUAL:
+		return boolean(x == y)
+	case NOTEQUAL:
+		return boolean(x != y)
+	case LESSEQUAL:
+		return boolean(x.SubsetOf(y))
+	case GREATEREQUAL:
+		return boolean(y.SubsetOf(x))
+	}
+	in.Error()
+	return nil
+}
+
+// VisitSetConstructor ...
+// A range whose low bound is above its high bound adds nothing
+func (in *Interpreter) VisitSetConstructor(n Node) Value {
+	node := n.(*SetConstructor)
+	var set SetValue
+	for _, elem := range node.Elems {
+		low, high := elem, elem
+		if subrange, ok := elem.(*Subrange); ok {
+			low, high = subrange.Low, subrange.High
+		}
+		lowvalue := in.setElement(low)
+		highvalue := lowvalue
+		if high != low {
+			highvalue = in.setElement(high)
+		}
+		for v := lowvalue; v <= highvalue; v++ {
+			set.Add(v)
+		}
+	}
+	return set
+}
+
+// setElement ...
+// Evaluates an element of a set constructor, stopping the program
+// when a set can not hold it
+func (in *Interpreter) setElement(n Node) int {
+	v := in.number(n)
+	if v < 0 || v > MaxSetElement {
+		in.runtimeError(ExprToken(n), "set element %v out of range 0..%d", v, MaxSetElement)
+	}
+	return int(v)
 }
 
 // VisitUnaryOp ...
-func (in *Interpreter) VisitUnaryOp(n Node) float64 {
+func (in *Interpreter) VisitUnaryOp(n Node) Value {
 	node := n.(*UnaryOp)
 	switch node.Op {
 	case PLUS:
-		return +in.Visit(node.Expr)
+		return +in.number(node.Expr)
 	case MINUS:
-		return -in.Visit(node.Expr)
+		return -in.number(node.Expr)
 	}
 	in.Error()
-	return 0
+	return nil
 }
 
 // VisitNum ...
-func (in *Interpreter) VisitNum(n Node) float64 {
+func (in *Interpreter) VisitNum(n Node) Value {
 	return n.(*Num).Value
 }
 
+// VisitStr ...
+// A string of one character is a character, that is its code
+func (in *Interpreter) VisitStr(n Node) Value {
+	if s := n.(*Str).Value; len(s) != 1 {
+		return s
+	}
+	return float64(n.(*Str).Value[0])
+}
+
 // VisitCompound ...
-func (in *Interpreter) VisitCompound(n Node) float64 {
+func (in *Interpreter) VisitCompound(n Node) Value {
 	node := n.(*Compound)
 	for i := range node.Children {
 		in.Visit(node.Children[i])
 	}
-	return 0
+	return nil
 }
 
 // VisitAssign ...
-func (in *Interpreter) VisitAssign(n Node) float64 {
+func (in *Interpreter) VisitAssign(n Node) Value {
 	node := n.(*Assign)
-	varname := node.Left.(*Var).Value
-	in.GLOBALSCOPE[varname] = in.Visit(node.Right)
-	return 0
+	value := copyValue(in.Visit(node.Right))
+	if node.CharToString {
+		value = text(value)
+	}
+	in.checkBounds(node.Right, value, node.Range)
+	_, set, varname := in.reference(node.Left)
+	set(value)
+	for _, o := range in.Observers {
+		o.Assign(varname, value, node)
+	}
+	return nil
+}
+
+// checkBounds ...
+// Checks the value of expression n against the bounds of the
+// subrange or set variable it is assigned to, if any
+func (in *Interpreter) checkBounds(n Node, value Value, bounds *Bounds) {
+	if bounds == nil {
+		return
+	}
+	switch v := value.(type) {
+	case float64:
+		in.checkRange(ExprToken(n), v, bounds)
+	case SetValue:
+		in.checkSet(ExprToken(n), v, bounds)
+	}
+}
+
+// checkRange ...
+// Stops the program when a value is outside the bounds of its type
+func (in *Interpreter) checkRange(tok Token, value float64, bounds *Bounds) {
+	if value < float64(bounds.Low) || value > float64(bounds.High) {
+		in.runtimeError(tok, "value %v out of range %d..%d", value, bounds.Low, bounds.High)
+	}
+}
+
+// checkSet ...
+// Stops the program when a set holds an element outside the bounds
+// of the element type of a set variable
+func (in *Interpreter) checkSet(tok Token, set SetValue, bounds *Bounds) {
+	for _, v := range set.Elems() {
+		if v < bounds.Low || v > bounds.High {
+			in.runtimeError(tok, "set element %d out of range %d..%d", v, bounds.Low, bounds.High)
+		}
+	}
+}
+
+// VisitFor ...
+// The bounds are evaluated once, before the first iteration, and
+// checked against the range of the loop variable if the body runs
+// at all. Each test of the loop condition is reported as arm 0
+// when the body runs and arm 1 when the loop ends.
+func (in *Interpreter) VisitFor(n Node) Value {
+	node := n.(*For)
+	start, end := in.number(node.Start), in.number(node.End)
+	_, set, varname := in.reference(node.Var)
+	step := 1.0
+	if node.Down {
+		step = -1
+	}
+	if node.Range != nil && (start-end)*step <= 0 {
+		in.checkRange(ExprToken(node.Start), start, node.Range)
+		in.checkRange(ExprToken(node.End), end, node.Range)
+	}
+	// the loop stops at end rather than stepping past it, which
+	// could go beyond MaxInteger
+	for i := start; (end-i)*step >= 0; i += step {
+		in.branch(node, 0)
+		set(i)
+		for _, o := range in.Observers {
+			o.Assign(varname, i, node)
+		}
+		in.Visit(node.Body)
+		if i == end {
+			break
+		}
+	}
+	in.branch(node, 1)
+	return nil
+}
+
+// VisitCase ...
+// Runs the arm whose labels hold the selector, or the ELSE arm,
+// which is reported as the arm after the last one. Without an ELSE
+// arm nothing is run when no label matches.
+func (in *Interpreter) VisitCase(n Node) Value {
+	node := n.(*Case)
+	value := int(in.number(node.Expr))
+	dispatch, exists := in.cases[node]
+	if !exists {
+		dispatch = newCaseDispatch(node.Ranges)
+		in.cases[node] = dispatch
+	}
+	arm := dispatch.arm(value)
+	if arm < 0 {
+		in.branch(node, len(node.Arms))
+		for _, stmt := range node.Else {
+			in.Visit(stmt)
+		}
+		return nil
+	}
+	in.branch(node, arm)
+	in.Visit(node.Arms[arm].Body)
+	return nil
+}
+
+// maxJumpTable ...
+// Largest span of label values given a jump table
+const maxJumpTable = 1024
+
+// caseDispatch ...
+// Finds the arm of a CASE statement for a selector value. Dense
+// labels, covering at least half of the values between the lowest
+// and the highest, get a jump table indexed by value. Other labels
+// are found by binary search of their ranges.
+type caseDispatch struct {
+	low int
+	// jump holds the arm plus one for each value from low, 0 when
+	// no label matches
+	jump   []int
+	ranges []CaseRange
+}
+
+// newCaseDispatch ...
+// ranges must be in ascending order
+func newCaseDispatch(ranges []CaseRange) *caseDispatch {
+	d := &caseDispatch{ranges: ranges}
+	if len(ranges) == 0 {
+		return d
+	}
+	low, high := ranges[0].Low, ranges[len(ranges)-1].High
+	// high - low overflows an int for labels far apart, their
+	// difference as unsigned numbers does not
+	if uint64(high)-uint64(low) >= maxJumpTable {
+		return d
+	}
+	span, covered := high-low+1, 0
+	for _, r := range ranges {
+		covered += r.High - r.Low + 1
+	}
+	if 2*covered >= span {
+		d.low = low
+		d.jump = make([]int, span)
+		for _, r := range ranges {
+			for i := r.Low - low; i <= r.High-low; i++ {
+				d.jump[i] = r.Arm + 1
+			}
+		}
+	}
+	return d
+}
+
+// arm ...
+// Returns the arm for value, -1 when no label matches
+func (d *caseDispatch) arm(value int) int {
+	if d.jump != nil {
+		if value < d.low || uint64(value)-uint64(d.low) >= uint64(len(d.jump)) {
+			return -1
+		}
+		return d.jump[value-d.low] - 1
+	}
+	i := sort.Search(len(d.ranges), func(i int) bool {
+		return d.ranges[i].High >= value
+	})
+	if i < len(d.ranges) && d.ranges[i].Low <= value {
+		return d.ranges[i].Arm
+	}
+	return -1
+}
+
+// VisitCall ...
+// Runs a function declared by the program, one held by a procedural
+// variable or a builtin one. Ordinal values are numbers.
+func (in *Interpreter) VisitCall(n Node) Value {
+	node := n.(*Call)
+	switch {
+	case node.Decl != nil:
+		return in.invoke(in.closure(node.Decl), node.Args, node.Ranges, node.Tok)
+	case node.Variable != nil:
+		return in.invoke(in.Visit(node.Variable).(Routine), node.Args, node.Ranges, node.Tok)
+	}
+	if value, ok := in.stringFunction(node); ok {
+		return value
+	}
+	if value, ok := in.fileFunction(node); ok {
+		return value
+	}
+	switch node.Name {
+	case "Low":
+		return float64(node.Range.Low)
+	case "High":
+		return float64(node.Range.High)
+	}
+	value := in.number(node.Args[0])
+	switch node.Name {
+	case "Succ":
+		value++
+	case "Pred":
+		value--
+	}
+	if node.Range != nil {
+		in.checkRange(node.Tok, value, node.Range)
+	}
+	if node.Integer && math.Abs(value) > MaxInteger {
+		in.runtimeError(node.Tok, "integer overflow")
+	}
+	return value
 }
 
 // VisitVar ...
-func (in *Interpreter) VisitVar(n Node) float64 {
+// False and True are predefined, names declared by the program
+// hide them. The name of a procedure or function is a procedural
+// value, or calls the function when it takes no arguments. Reading
+// a variable that was never assigned stops the program.
+func (in *Interpreter) VisitVar(n Node) Value {
 	node := n.(*Var)
+	if node.Routine != nil {
+		r := in.closure(node.Routine)
+		if node.Invoke {
+			return in.invoke(r, nil, nil, node.Tok)
+		}
+		return r
+	}
+	if node.With != nil {
+		return in.withs[node.With][node.WithIndex]().(*RecordValue).Get(node.Value)
+	}
 	varname := node.Value
-	if varvalue, exists := in.GLOBALSCOPE[varname]; exists {
+	if varvalue, exists := in.frame(node).Get(varname); exists {
 		return varvalue
 	}
-	in.Error()
-	return 0
+	for i, name := range Booleans {
+		if name == varname {
+			return float64(i)
+		}
+	}
+	in.runtimeError(node.Tok, "variable '%s' used before it is assigned", varname)
+	return nil
+}
+
+// VisitIndex ...
+func (in *Interpreter) VisitIndex(n Node) Value {
+	get, _, _ := in.reference(n)
+	return get()
+}
+
+// VisitField ...
+func (in *Interpreter) VisitField(n Node) Value {
+	get, _, _ := in.reference(n)
+	return get()
+}
+
+// VisitDeref ...
+func (in *Interpreter) VisitDeref(n Node) Value {
+	get, _, _ := in.reference(n)
+	return get()
+}
+
+// VisitNil ...
+func (in *Interpreter) VisitNil(n Node) Value {
+	return Pointer{}
+}
+
+// VisitWith ...
+// The references to the records are resolved once, before the body
+// runs, so their indexes are evaluated once. The record a function
+// returns is held instead. Each one may be a field of the records
+// before it.
+func (in *Interpreter) VisitWith(n Node) Value {
+	node := n.(*With)
+	saved := in.withs[node]
+	in.withs[node] = nil
+	for _, record := range node.Records {
+		var get func() Value
+		if v, ok := record.(*Var); ok && v.Routine != nil {
+			value := in.Visit(record)
+			get = func() Value { return value }
+		} else {
+			get, _, _ = in.reference(record)
+		}
+		in.withs[node] = append(in.withs[node], get)
+	}
+	in.Visit(node.Body)
+	in.withs[node] = saved
+	return nil
+}
+
+// reference ...
+// Resolves a variable reference to accessors for the variable,
+// element or field it names, and spells it with the index values
+// as in m[2, 3].x. Every index is evaluated and checked against
+// the bounds once. The accessors find the array or record holding
+// an element or field again on each use, as assigning a whole
+// array or record gives the variable another one, and check that
+// the variable a pointer points to is not disposed. Strings are
+// values, so a character is set by setting the whole string it is
+// in.
+func (in *Interpreter) reference(n Node) (get func() Value, set func(Value), name string) {
+	switch node := n.(type) {
+	case *Index:
+		get, set, name = in.reference(node.Array)
+		value := get()
+		var indexes []string
+		for _, expr := range node.Indexes {
+			if s, ok := value.(string); ok {
+				i := in.stringIndex(node, s, expr)
+				indexes = append(indexes, strconv.Itoa(i))
+				value = float64(s[i-1])
+				get, set = in.character(get, set, i)
+				continue
+			}
+			array := value.(*ArrayValue)
+			i := in.index(node, array, expr)
+			indexes = append(indexes, strconv.Itoa(i))
+			value = array.Get(i)
+			parent := get
+			get = func() Value { return parent().(*ArrayValue).Get(i) }
+			set = func(v Value) { parent().(*ArrayValue).Set(i, v) }
+		}
+		return get, set, name + "[" + strings.Join(indexes, ", ") + "]"
+	case *Field:
+		parent, _, name := in.reference(node.Record)
+		get = func() Value { return parent().(*RecordValue).Get(node.Name) }
+		set = func(v Value) { parent().(*RecordValue).Set(node.Name, v) }
+		return get, set, name + "." + node.Name
+	case *Deref:
+		get, _, name = in.reference(node.Pointer)
+		p := get().(Pointer)
+		in.cell(node, p)
+		get = func() Value { return in.cell(node, p).Value }
+		set = func(v Value) { in.cell(node, p).Value = v }
+		return get, set, name + "^"
+	}
+	node := n.(*Var)
+	if node.With != nil {
+		record := in.withs[node.With][node.WithIndex]
+		get = func() Value { return record().(*RecordValue).Get(node.Value) }
+		return get, func(v Value) { record().(*RecordValue).Set(node.Value, v) }, node.Value
+	}
+	ar := in.frame(node)
+	get = func() Value {
+		if v, exists := ar.Get(node.Value); exists {
+			return v
+		}
+		return in.Visit(node)
+	}
+	return get, func(v Value) { ar.Set(node.Value, v) }, node.Value
+}
+
+// frame ...
+// The activation record holding a variable, reached through the
+// static links from the running body for the nesting level of its
+// declaration. Predefined names are looked up in the running body,
+// where the program may hide them.
+func (in *Interpreter) frame(node *Var) *ActivationRecord {
+	if node.Level == 0 {
+		return in.CallStack.Peek()
+	}
+	return in.CallStack.Peek().Enclosing(node.Level)
+}
+
+// VisitProcedureDecl ...
+// Procedures are run when they are called
+func (in *Interpreter) VisitProcedureDecl(n Node) Value {
+	return nil
+}
+
+// closure ...
+// A procedure or function named in the running body, linked to the
+// record of the body declaring it, the running one or one it is
+// nested in
+func (in *Interpreter) closure(decl *ProcedureDecl) Routine {
+	return Routine{Decl: decl, Link: in.CallStack.Peek().Enclosing(decl.Level - 1)}
+}
+
+// invoke ...
+// Runs a procedure or function in a new activation record, linked
+// to the record the routine carries, and returns the result of a
+// function. The arguments are evaluated by the caller, in order: a
+// VAR parameter stands for the variable passed, a value parameter
+// gets a copy of the value and a CONST parameter the value itself,
+// since it is never assigned.
+func (in *Interpreter) invoke(r Routine, args []Node, ranges []*Bounds, tok Token) Value {
+	decl := r.Decl
+	if decl == nil {
+		in.runtimeError(tok, "call of unassigned procedural variable '%s'", tok.Svalue)
+	}
+	if in.CallStack.Depth() >= MaxCallDepth {
+		in.runtimeError(tok, "stack overflow, calls nested more than %d deep", MaxCallDepth)
+	}
+	artype := ProcedureAR
+	if decl.Result != nil {
+		artype = FunctionAR
+	}
+	ar := NewActivationRecord(decl.Name, artype, decl.Level)
+	ar.Decl, ar.Link = decl, r.Link
+	if decl.Result != nil {
+		ar.Domains[decl.Name] = decl.ResultDomain
+	}
+	for i, param := range decl.Params {
+		arg := args[i]
+		ar.Domains[param.Name] = param.Domain
+		if param.Mode == VarArg {
+			get, set, _ := in.reference(arg)
+			ar.Members[param.Name] = &Alias{Get: get, Set: set}
+			continue
+		}
+		value := in.Visit(arg)
+		if t, ok := ResolveType(param.TNode).(*TypeN); ok && t.Tok.Type == STRING {
+			value = text(value)
+		}
+		in.checkBounds(arg, value, ranges[i])
+		if param.Mode == ConstArg {
+			ar.SetConstant(param.Name, value)
+			continue
+		}
+		ar.Set(param.Name, copyValue(value))
+	}
+	in.CallStack.Push(ar)
+	for _, o := range in.Observers {
+		o.Call(ar)
+	}
+	in.Visit(decl.BlockNode)
+	for _, o := range in.Observers {
+		o.Return(ar)
+	}
+	in.CallStack.Pop()
+	if decl.Result == nil {
+		return nil
+	}
+	result, exists := ar.Members[decl.Name]
+	if !exists {
+		in.runtimeError(tok, "function '%s' returned no result", decl.Name)
+	}
+	return result
+}
+
+// index ...
+// Evaluates an index into array, stopping the program when it is
+// out of bounds
+func (in *Interpreter) index(n *Index, array *ArrayValue, expr Node) int {
+	v := in.number(expr)
+	if v != math.Trunc(v) {
+		in.runtimeError(n.Tok, "array index %v is not an integer", v)
+	}
+	i := int(v)
+	if !array.Contains(i) {
+		in.runtimeError(n.Tok, "index %d out of bounds %d..%d of %s", i, array.Low, array.High, exprString(n.Array))
+	}
+	return i
+}
+
+// cell ...
+// Returns the heap cell p points to, stopping the program when
+// there is none
+func (in *Interpreter) cell(n *Deref, p Pointer) *HeapCell {
+	switch {
+	case p.Cell == nil:
+		in.runtimeError(n.Tok, "dereference of NIL pointer %s", exprString(n.Pointer))
+	case p.Cell.Disposed != nil:
+		in.runtimeError(n.Tok, "dereference of %s, disposed at %d:%d", exprString(n.Pointer), p.Cell.Disposed.Line, p.Cell.Disposed.Column)
+	}
+	return p.Cell
+}
+
+// stringIndex ...
+// Evaluates an index into s, stopping the program when it is not
+// the position of one of its characters
+func (in *Interpreter) stringIndex(n *Index, s string, expr Node) int {
+	i := in.integer(expr)
+	if i < 1 || i > len(s) {
+		in.runtimeError(n.Tok, "index %d out of bounds 1..%d of %s", i, len(s), exprString(n.Array))
+	}
+	return i
+}
+
+// character ...
+// Accessors for the i-th character of the string that get and
+// set access
+func (in *Interpreter) character(get func() Value, set func(Value), i int) (func() Value, func(Value)) {
+	return func() Value {
+			return float64(get().(string)[i-1])
+		}, func(v Value) {
+			s := get().(string)
+			set(s[:i-1] + text(v) + s[i:])
+		}
+}
+
+// integer ...
+// Evaluates an expression the semantic analyzer has checked to be
+// an INTEGER
+func (in *Interpreter) integer(n Node) int {
+	return int(in.number(n))
+}
+
+// number ...
+// Evaluates an expression the semantic analyzer has checked to be numeric
+func (in *Interpreter) number(n Node) float64 {
+	return in.Visit(n).(float64)
 }
 
 // VisitNoOp ...
-func (in *Interpreter) VisitNoOp(n Node) float64 { return 0 }
+func (in *Interpreter) VisitNoOp(n Node) Value { return nil }
+
+// branch ...
+// Tells the observers which arm of n is taken
+func (in *Interpreter) branch(n Node, arm int) {
+	for _, o := range in.Observers {
+		o.Branch(n, arm)
+	}
+}
 
 // Visit ...
-func (in *Interpreter) Visit(n Node) float64 {
-	return in.VisitMap[n.Type()](n)
+func (in *Interpreter) Visit(n Node) Value {
+	if in.Hook != nil {
+		if tok, ok := StatementToken(n); ok {
+			in.Hook.BeforeStatement(n, tok)
+		}
+	}
+	for _, o := range in.Observers {
+		o.EnterNode(n)
+	}
+	value := in.VisitMap[n.Type()](n)
+	for _, o := range in.Observers {
+		o.ExitNode(n)
+	}
+	return value
 }
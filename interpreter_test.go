@@ -0,0 +1,1386 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run ...
+// Checks and interprets a program whose files live in files,
+// returning its globals or the first error
+func run(t *testing.T, source string, files MapFS) (map[string]Value, error) {
+	t.Helper()
+	tree, err := ParseSource(source)
+	if err != nil {
+		t.Fatalf("parse: %s\n%s", err, source)
+	}
+	if errs := NewSemanticAnalyzer().Analyze(tree); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	in := NewInterpreter()
+	in.Files = files
+	if err := in.Interpret(tree); err != nil {
+		return nil, err
+	}
+	return in.GLOBALSCOPE, nil
+}
+
+// program ...
+// A program with the given declarations and statements
+func program(decls, stmts string) string {
+	return "PROGRAM T;\n" + decls + "\nBEGIN\n" + stmts + "\nEND.\n"
+}
+
+// interpretTest ...
+// A program run by runTests on the files in files, which checks the
+// numbers in want, the other globals in values as formatValue spells
+// them, or that it fails with an error containing errors
+type interpretTest struct {
+	name   string
+	decls  string
+	stmts  string
+	files  MapFS
+	want   map[string]float64
+	values map[string]string
+	errors string
+}
+
+// runTests ...
+// Runs the programs of the tests as subtests
+func runTests(t *testing.T, tests []interpretTest) {
+	t.Helper()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := test.files
+			if files == nil {
+				files = MapFS{}
+			}
+			globals, err := run(t, program(test.decls, test.stmts), files)
+			if test.errors != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errors) {
+					t.Fatalf("got error %v, want %q", err, test.errors)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := globals[name]; got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+			for name, want := range test.values {
+				if got := formatValue(globals[name]); got != want {
+					t.Errorf("%s = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestArrays(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "multi-dimensional indexing",
+			decls: "VAR m : ARRAY[1..3, 1..4] OF REAL; n : ARRAY[1..2] OF ARRAY[0..1] OF INTEGER; x : REAL; i : INTEGER;",
+			stmts: "m[2, 3] := 1.5; m[3][4] := m[2][3] * 2; x := m[3, 4]; n[2, 1] := 7; i := n[2][1]",
+			want:  map[string]float64{"x": 3, "i": 7},
+		},
+		{
+			name:  "whole arrays are assigned by value",
+			decls: "TYPE V = ARRAY[1..2] OF INTEGER;\nVAR a, b : V; i : INTEGER;",
+			stmts: "a[1] := 1; a[2] := 2; b := a; a[1] := 5; i := b[1] + b[2]",
+			want:  map[string]float64{"i": 3},
+		},
+		{
+			name:   "index out of bounds",
+			decls:  "VAR a : ARRAY[1..3] OF INTEGER; i : INTEGER;",
+			stmts:  "i := 4; a[i] := 1",
+			errors: "runtime error: 4:10: index 4 out of bounds 1..3 of a",
+		},
+		{
+			name:   "too many values",
+			decls:  "VAR a : ARRAY[1..100000000] OF INTEGER;",
+			stmts:  "a[1] := 1",
+			errors: "type ARRAY[1..100000000] OF INTEGER is too large, a variable of it would hold more than 1048576 values",
+		},
+		{
+			name:   "too many values in nested arrays and records",
+			decls:  "TYPE R = RECORD a : ARRAY[1..1024] OF INTEGER; b : INTEGER END;\nVAR m : ARRAY[1..1024] OF R;",
+			stmts:  "m[1].b := 1",
+			errors: "is too large",
+		},
+		{
+			name:  "as many values as allowed",
+			decls: "VAR m : ARRAY[1..1024, 1..1024] OF CHAR;",
+			stmts: "m[1024, 1024] := 'a'",
+		},
+		{
+			name:  "DIV gives an array index",
+			decls: "VAR arr : ARRAY[0..10] OF INTEGER; lo, hi, mid : INTEGER;",
+			stmts: "lo := 0; hi := 11; mid := (lo + hi) DIV 2; arr[mid] := 1",
+			want:  map[string]float64{"mid": 5},
+		},
+		{
+			name:  "fields and elements start at zero",
+			decls: "VAR r : RECORD a : INTEGER END; v : ARRAY[1..2] OF REAL; i : INTEGER; x : REAL;",
+			stmts: "i := r.a; x := v[2]",
+			want:  map[string]float64{"i": 0, "x": 0},
+		},
+	})
+}
+
+func TestValueCount(t *testing.T) {
+	// bounds this far apart are beyond INTEGER literals, the count of
+	// their elements would overflow an int
+	wide := &Symbol{Kind: ArrayTypeSymbol, Low: -1 << 62, High: 1 << 62, Type: &Symbol{}}
+	square := &Symbol{Kind: ArrayTypeSymbol, Low: 1, High: 1024, Type: &Symbol{Kind: ArrayTypeSymbol, Low: 1, High: 1024, Type: &Symbol{}}}
+	for _, test := range []struct {
+		t    *Symbol
+		want int
+	}{
+		{wide, MaxValues + 1},
+		{&Symbol{Kind: ArrayTypeSymbol, Low: math.MinInt, High: math.MaxInt, Type: &Symbol{}}, MaxValues + 1},
+		{&Symbol{Kind: ArrayTypeSymbol, Low: 0, High: 1, Type: wide}, MaxValues + 1},
+		{square, MaxValues},
+	} {
+		if got := valueCount(test.t); got != test.want {
+			t.Errorf("valueCount(%d..%d) = %d, want %d", test.t.Low, test.t.High, got, test.want)
+		}
+	}
+}
+
+func TestDiv(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "DIV truncates",
+			decls: "VAR a, b, c : INTEGER;",
+			stmts: "a := 7 DIV 2; b := -7 DIV 2; c := 6 DIV 3",
+			want:  map[string]float64{"a": 3, "b": -3, "c": 2},
+		},
+		{
+			name:   "DIV by zero",
+			decls:  "VAR a, b : INTEGER;",
+			stmts:  "a := 0; b := 7 DIV a",
+			errors: "runtime error: 4:16: division by zero",
+		},
+		{
+			name:   "real division by zero",
+			decls:  "VAR a, x : REAL;",
+			stmts:  "a := 0; x := 1 / a",
+			errors: "division by zero",
+		},
+		{
+			name:  "constant DIV folds to an integer",
+			decls: "CONST N = 7; Half = N DIV 2; Third = -N DIV 3;\nTYPE A = ARRAY[0..Half] OF INTEGER;\nVAR a : A; i : INTEGER;",
+			stmts: "a[Half] := 1; i := Third",
+			want:  map[string]float64{"Half": 3, "i": -2},
+		},
+	})
+}
+
+func TestOverflow(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:   "addition past MaxInteger",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 9007199254740991; i := i + 1",
+			errors: "runtime error: 4:31: integer overflow",
+		},
+		{
+			name:   "subtraction past -MaxInteger",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := -9007199254740991; i := i - 1",
+			errors: "runtime error: 4:32: integer overflow",
+		},
+		{
+			name:   "multiplication past MaxInteger",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 94906267; i := i * i",
+			errors: "runtime error: 4:23: integer overflow",
+		},
+		{
+			name:   "Succ of MaxInteger",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 9007199254740991; i := Succ(i)",
+			errors: "runtime error: 4:29: integer overflow",
+		},
+		{
+			name:   "Pred of -MaxInteger",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := -9007199254740991; i := Pred(i)",
+			errors: "runtime error: 4:30: integer overflow",
+		},
+		{
+			name:  "REAL arithmetic past MaxInteger",
+			decls: "VAR x : REAL; i : INTEGER;",
+			stmts: "i := 9007199254740991; x := i; x := x * 2; i := i DIV 2 + 1",
+			want:  map[string]float64{"x": 1<<54 - 2, "i": 1 << 52},
+		},
+		{
+			name:  "FOR up to MaxInteger and down to -MaxInteger",
+			decls: "VAR i, j, n : INTEGER;",
+			stmts: "n := 0; FOR i := 9007199254740989 TO 9007199254740991 DO n := n + 1;\nFOR j := -9007199254740990 DOWNTO -9007199254740991 DO n := n + 1",
+			want:  map[string]float64{"n": 5, "i": 9007199254740991, "j": -9007199254740991},
+		},
+		{
+			name:   "constant past MaxInteger",
+			decls:  "CONST N = 9007199254740991 + 1;",
+			errors: "semantic error: 2:28: integer overflow",
+		},
+		{
+			name:   "constant Succ of MaxInteger",
+			decls:  "CONST N = 9007199254740991;\nTYPE R = 0..Succ(N);",
+			errors: "semantic error: 3:13: integer overflow",
+		},
+		{
+			name:   "INTEGER past MaxInteger in a TEXT file",
+			decls:  "VAR f : TEXT; i : INTEGER;",
+			stmts:  "Assign(f, 'in.txt'); Reset(f); Read(f, i)",
+			files:  MapFS{"in.txt": []byte("9007199254740992\n")},
+			errors: "runtime error: 4:32: integer overflow reading file f",
+		},
+		{
+			name:   "INTEGER past MaxInteger in a typed file",
+			decls:  "VAR f : FILE OF INTEGER; i : INTEGER;",
+			stmts:  "Assign(f, 'n.dat'); Reset(f); Read(f, i)",
+			files:  MapFS{"n.dat": []byte{0, 0, 0, 0, 0, 0, 0, 0x10}},
+			errors: "runtime error: 4:39: integer overflow",
+		},
+	})
+}
+
+// TestEncodeOverflow ...
+// No program gets an INTEGER past MaxInteger, encode checks anyway
+// rather than convert it to an int64 that is not the number
+func TestEncodeOverflow(t *testing.T) {
+	in := NewInterpreter()
+	f := &FileValue{Encoding: []int{IntegerEncoding}}
+	arg := NewNum(Token{Line: 3, Column: 7})
+	defer func() {
+		if err, ok := recover().(*Error); !ok || err.Error() != "runtime error: 3:7: integer overflow" {
+			t.Errorf("got %v, want an integer overflow", err)
+		}
+	}()
+	in.encode(f, 1e19, arg)
+}
+
+func TestUnassigned(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:   "unassigned INTEGER",
+			decls:  "VAR i, j : INTEGER;",
+			stmts:  "i := j",
+			errors: "runtime error: 4:6: variable 'j' used before it is assigned",
+		},
+		{
+			name:   "unassigned STRING",
+			decls:  "VAR s, t : STRING; c : CHAR;",
+			stmts:  "c := t[1]",
+			errors: "variable 't' used before it is assigned",
+		},
+		{
+			name:   "unassigned pointer",
+			decls:  "TYPE P = ^INTEGER;\nVAR p : P; i : INTEGER;",
+			stmts:  "i := p^",
+			errors: "variable 'p' used before it is assigned",
+		},
+		{
+			name:   "unassigned set",
+			decls:  "VAR s, t : SET OF CHAR;",
+			stmts:  "s := t + ['a']",
+			errors: "variable 't' used before it is assigned",
+		},
+		{
+			name:   "unassigned variable in an enclosing procedure",
+			decls:  "PROCEDURE Outer;\nVAR o, r : INTEGER;\n   PROCEDURE Inner;\n   BEGIN\n   r := o\n   END;\nBEGIN\nInner\nEND;",
+			stmts:  "Outer",
+			errors: "variable 'o' used before it is assigned",
+		},
+	})
+}
+
+func TestTypes(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:   "DIV on REAL",
+			decls:  "VAR x : REAL; i : INTEGER;",
+			stmts:  "x := 7.5; i := x DIV 2",
+			errors: "operator 'DIV' is not defined for REAL and INTEGER",
+		},
+		{
+			name:   "REAL is not assignable to INTEGER",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 7 / 2",
+			errors: "incompatible types, cannot assign REAL to INTEGER",
+		},
+		{
+			name:   "REAL argument for an INTEGER parameter",
+			decls:  "VAR i : INTEGER;\nPROCEDURE P(n : INTEGER);\nBEGIN\nEND;",
+			stmts:  "P(2.5)",
+			errors: "argument 1 of 'P' must be INTEGER, not REAL",
+		},
+		{
+			name:  "INTEGER widens to REAL",
+			decls: "VAR x : REAL;\nPROCEDURE P(r : REAL);\nBEGIN\nx := r\nEND;",
+			stmts: "P(7 DIV 2); x := x + 1",
+			want:  map[string]float64{"x": 4},
+		},
+		{
+			name:  "type names stand for the same type",
+			decls: "TYPE V = ARRAY[1..2] OF INTEGER; W = V;\nVAR v : V; w : W; i : INTEGER;",
+			stmts: "v[1] := 1; w := v; i := w[1]",
+			want:  map[string]float64{"i": 1},
+		},
+		{
+			name:   "every type spec makes a new type",
+			decls:  "TYPE V = ARRAY[1..2] OF INTEGER; U = ARRAY[1..2] OF INTEGER;\nVAR v : V; u : U;",
+			stmts:  "v[1] := 1; u := v",
+			errors: "incompatible types, cannot assign V to U",
+		},
+		{
+			name:   "variables declared apart have different types",
+			decls:  "VAR a : ARRAY[1..2] OF INTEGER; b : ARRAY[1..2] OF INTEGER;",
+			stmts:  "a[1] := 1; b := a",
+			errors: "incompatible types",
+		},
+		{
+			name:  "variables declared together share their type",
+			decls: "VAR a, b : RECORD x : INTEGER END; i : INTEGER;",
+			stmts: "a.x := 2; b := a; i := b.x",
+			want:  map[string]float64{"i": 2},
+		},
+	})
+}
+
+func TestConstants(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "folded constants as bounds and labels",
+			decls: "CONST N = 10 * 2; Pi = 3.14159; Last = N - 1; Two = Succ(1);\nVAR a : ARRAY[0..Last] OF INTEGER; i, j : INTEGER; x : REAL;",
+			stmts: "a[Last] := N; i := a[19]; CASE i OF Two: j := 2; N: j := 20 END; x := Pi",
+			want:  map[string]float64{"N": 20, "Last": 19, "i": 20, "j": 20, "x": 3.14159},
+		},
+		{
+			name:  "constant real division",
+			decls: "CONST Ratio = 7 / 2;\nVAR x : REAL;",
+			stmts: "x := Ratio",
+			want:  map[string]float64{"x": 3.5},
+		},
+		{
+			name:   "constants cannot be assigned",
+			decls:  "CONST N = 1;",
+			stmts:  "N := 2",
+			errors: "semantic error: 4:1: cannot assign to constant 'N'",
+		},
+		{
+			name:   "variables are no constants",
+			decls:  "VAR v : INTEGER;\nCONST N = v + 1;",
+			errors: "semantic error: 3:11: constant expression expected",
+		},
+		{
+			name:   "constant division by zero",
+			decls:  "CONST Z = 0; X = 1 + 1 DIV Z;",
+			errors: "semantic error: 2:24: division by zero",
+		},
+		{
+			name:   "real constant division by zero",
+			decls:  "CONST X = -(1 / 0);",
+			errors: "semantic error: 2:15: division by zero",
+		},
+		{
+			name:   "subrange bound dividing by zero",
+			decls:  "VAR a : ARRAY[1..1 DIV 0] OF INTEGER;",
+			errors: "semantic error: 2:20: division by zero",
+		},
+		{
+			name:   "CASE label dividing by zero",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 1; CASE i OF 1 DIV 0: i := 2 END",
+			errors: "semantic error: 4:21: division by zero",
+		},
+	})
+}
+
+func TestFiles(t *testing.T) {
+	files := MapFS{"in.txt": []byte("12 2.5\nhello\n")}
+	globals, err := run(t, program(`TYPE
+   Point = RECORD x : INTEGER; ok : BOOLEAN; c : CHAR END;
+VAR
+   fin, fout : TEXT;
+   f : FILE OF Point;
+   p, q : Point;
+   a, n, size : INTEGER;
+   x : REAL;
+   s : STRING;`, `Assign(fin, 'in.txt');
+Reset(fin);
+ReadLn(fin, a, x);
+ReadLn(fin, s);
+Close(fin);
+Assign(fout, 'out.txt');
+Rewrite(fout);
+WriteLn(fout, s, ' ', a + 1, ' ', True);
+Close(fout);
+Assign(f, 'points.dat');
+Rewrite(f);
+FOR n := 1 TO 3 DO
+BEGIN
+   p.x := -n; p.ok := True; p.c := 'z';
+   Write(f, p)
+END;
+Seek(f, 1);
+Read(f, q);
+Seek(f, 2);
+Truncate(f);
+size := FileSize(f);
+Close(f)`), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if globals["a"] != 12.0 || globals["x"] != 2.5 || globals["s"] != "hello" {
+		t.Errorf("read a = %v, x = %v, s = %v", globals["a"], globals["x"], globals["s"])
+	}
+	if got := string(files["out.txt"]); got != "hello 13 True\n" {
+		t.Errorf("out.txt = %q", got)
+	}
+	if q := globals["q"].(*RecordValue); q.Get("x") != -2.0 || q.Get("c") != float64('z') {
+		t.Errorf("q = %s, want the second point", formatValue(q))
+	}
+	if globals["size"] != 2.0 {
+		t.Errorf("size = %v, want 2", globals["size"])
+	}
+	// each component is an 8-byte INTEGER, a BOOLEAN and a CHAR, the
+	// second holding -2, True and 'z'
+	want := []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1, 'z'}
+	if got := files["points.dat"]; len(got) != 20 || string(got[10:]) != string(want) {
+		t.Errorf("points.dat = % x, want the second component % x", got, want)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "nested records",
+			decls: "TYPE P = RECORD x, y : INTEGER END; L = RECORD a, b : P END;\nVAR l : L; i : INTEGER;",
+			stmts: "l.a.x := 1; l.b.y := 2; i := l.a.x + l.b.y",
+			want:  map[string]float64{"i": 3},
+		},
+		{
+			name:  "records are assigned by value",
+			decls: "TYPE P = RECORD x : INTEGER; v : ARRAY[1..2] OF INTEGER END;\nVAR p, q : P; i : INTEGER;",
+			stmts: "p.x := 1; p.v[2] := 2; q := p; p.x := 5; p.v[2] := 6; i := q.x + q.v[2]",
+			want:  map[string]float64{"i": 3},
+		},
+		{
+			name:  "WITH brings the fields into scope",
+			decls: "VAR p : RECORD x, y : INTEGER END; x, i : INTEGER;",
+			stmts: "x := 10; WITH p DO BEGIN x := 1; y := x + 1 END; i := x + p.x + p.y",
+			want:  map[string]float64{"i": 13},
+		},
+		{
+			name:  "nested WITH on an element",
+			decls: "TYPE P = RECORD x : INTEGER; q : RECORD y : INTEGER END END;\nVAR a : ARRAY[1..2] OF P; i : INTEGER;",
+			stmts: "i := 2; WITH a[i], q DO BEGIN x := 3; y := x * 2 END; i := a[2].x + a[2].q.y",
+			want:  map[string]float64{"i": 9},
+		},
+		{
+			name:  "WITH on a record assigned whole in the body",
+			decls: "TYPE R = RECORD f : INTEGER END;\nVAR r, o : R; k, l : INTEGER;",
+			stmts: "r.f := 1; o.f := 9; WITH r DO BEGIN r := o; k := f; f := 4 END; l := r.f",
+			want:  map[string]float64{"k": 9, "l": 4},
+		},
+		{
+			name:  "WITH on the result of a function",
+			decls: "TYPE R = RECORD f : INTEGER END;\nVAR k : INTEGER;\nFUNCTION Make : R;\nVAR r : R;\nBEGIN\nr.f := 7; Make := r\nEND;",
+			stmts: "WITH Make DO k := f",
+			want:  map[string]float64{"k": 7},
+		},
+		{
+			name:   "unknown field",
+			decls:  "VAR p : RECORD x : INTEGER END;",
+			stmts:  "p.z := 1",
+			errors: "semantic error: 4:3: 'p' has no field 'z'",
+		},
+	})
+}
+
+func TestOrdinals(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "Ord, Succ and Pred",
+			decls: "TYPE Color = (Red, Green, Blue);\nVAR c : Color; i, j, k : INTEGER; ch : CHAR;",
+			stmts: "c := Succ(Red); i := Ord(c); j := Ord(Pred(Blue)) + Ord('A'); ch := Succ('a'); k := Ord(ch) + Succ(-1)",
+			want:  map[string]float64{"c": 1, "i": 1, "j": 66, "ch": 'b', "k": 98},
+		},
+		{
+			name:  "Low and High",
+			decls: "TYPE Color = (Red, Green, Blue); Digit = '0'..'9'; Small = 1..5;\nVAR c : Color; d : Digit; s : Small; i : INTEGER;",
+			stmts: "c := High(Color); d := Low(Digit); i := High(s) - Low(Small) + Ord(Low(c))",
+			want:  map[string]float64{"c": 2, "d": '0', "i": 4},
+		},
+		{
+			name:  "FOR over an enumeration and a character subrange",
+			decls: "TYPE Color = (Red, Green, Blue); Lower = 'a'..'z';\nVAR c : Color; ch : Lower; n, m : INTEGER;",
+			stmts: "n := 0; FOR c := Red TO Blue DO n := n * 10 + Ord(c) + 1; m := 0; FOR ch := 'z' DOWNTO 'x' DO m := m + 1",
+			want:  map[string]float64{"n": 123, "m": 3, "c": 2, "ch": 'x'},
+		},
+		{
+			name:  "enumerations index arrays",
+			decls: "TYPE Color = (Red, Green, Blue);\nVAR a : ARRAY[Color] OF INTEGER; b : ARRAY[Green..Blue] OF INTEGER; c : Color; i : INTEGER;",
+			stmts: "FOR c := Red TO Blue DO a[c] := Ord(c) * 2; b[Blue] := a[Blue]; i := b[Blue] + a[Green]",
+			want:  map[string]float64{"i": 6},
+		},
+		{
+			name:   "assignment out of a subrange",
+			decls:  "TYPE Small = 1..5;\nVAR s : Small; i : INTEGER;",
+			stmts:  "i := 6; s := i",
+			errors: "runtime error: 5:14: value 6 out of range 1..5",
+		},
+		{
+			name:   "Succ of the last value",
+			decls:  "TYPE Color = (Red, Green, Blue);\nVAR c : Color;",
+			stmts:  "c := Blue; c := Succ(c)",
+			errors: "runtime error: 5:17: value 3 out of range 0..2",
+		},
+		{
+			name:   "FOR bounds out of the loop variable's subrange",
+			decls:  "TYPE Small = 1..5;\nVAR s : Small; n : INTEGER;",
+			stmts:  "n := 6; FOR s := 1 TO n DO",
+			errors: "runtime error: 5:23: value 6 out of range 1..5",
+		},
+		{
+			name:   "assignment to the loop variable",
+			decls:  "VAR i, n : INTEGER;",
+			stmts:  "n := 0; FOR i := 1 TO 3 DO BEGIN i := 10; n := n + 1 END",
+			errors: "semantic error: 4:34: cannot assign to loop variable 'i'",
+		},
+		{
+			name:   "loop variable of an enclosing FOR",
+			decls:  "VAR i, n : INTEGER;",
+			stmts:  "n := 0; FOR i := 1 TO 3 DO FOR i := 1 TO 2 DO n := n + 1",
+			errors: "semantic error: 4:32: cannot assign to loop variable 'i'",
+		},
+		{
+			name:   "loop variable as a VAR argument",
+			decls:  "VAR i : INTEGER;\nPROCEDURE Inc(VAR n : INTEGER);\nBEGIN\nn := n + 1\nEND;",
+			stmts:  "FOR i := 1 TO 3 DO Inc(i)",
+			errors: "semantic error: 8:24: argument 1 of 'Inc' cannot be loop variable 'i'",
+		},
+		{
+			name:   "loop variable read into",
+			decls:  "VAR i, code : INTEGER;",
+			stmts:  "FOR i := 1 TO 3 DO Val('5', i, code)",
+			errors: "semantic error: 4:29: argument 2 of 'Val' cannot be loop variable 'i'",
+		},
+		{
+			name:  "loop variable assigned after the loop and passed by value",
+			decls: "VAR i, n : INTEGER;\nPROCEDURE Add(k : INTEGER);\nBEGIN\nn := n + k\nEND;",
+			stmts: "n := 0; FOR i := 1 TO 3 DO Add(i); i := 7",
+			want:  map[string]float64{"n": 6, "i": 7},
+		},
+		{
+			name:   "enumerations are not integers",
+			decls:  "TYPE Color = (Red, Green, Blue);\nVAR c : Color; i : INTEGER;",
+			stmts:  "i := Green",
+			errors: "incompatible types, cannot assign Color to INTEGER",
+		},
+		{
+			name:   "empty subrange",
+			decls:  "TYPE Empty = 5..1;",
+			errors: "semantic error: 2:14: subrange 5..1 is empty",
+		},
+	})
+}
+
+func TestCase(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "label lists, ranges and ELSE",
+			decls: "VAR i, n, s : INTEGER;",
+			stmts: "s := 0; FOR i := 0 TO 12 DO CASE i OF 1, 2: s := s + 1; 3..9: s := s + 10; 11: ; ELSE s := s + 100 END",
+			want:  map[string]float64{"s": 372},
+		},
+		{
+			name:  "OTHERWISE and no matching label",
+			decls: "VAR i, j, k : INTEGER;",
+			stmts: "i := 5; j := 0; k := 0; CASE i OF 1: j := 1 OTHERWISE j := 2; j := j * 3 END; CASE i OF 1: k := 1; 2..4: k := 2 END",
+			want:  map[string]float64{"j": 6, "k": 0},
+		},
+		{
+			name:  "enumeration and character selectors",
+			decls: "TYPE Color = (Red, Green, Blue);\nVAR c : Color; ch : CHAR; i, j : INTEGER;",
+			stmts: "c := Blue; CASE c OF Red, Green: i := 1; Blue: i := 2 END; ch := 'q'; CASE ch OF 'a'..'m': j := 1; 'n'..'z': j := 2 END",
+			want:  map[string]float64{"i": 2, "j": 2},
+		},
+		{
+			name:  "sparse labels",
+			decls: "VAR i, s : INTEGER;",
+			stmts: "s := 0; i := -100000; CASE i OF -100000: s := 1; 0: s := 2; 100000..100005: s := 3 END; i := 100003; CASE i OF -100000: s := s + 10; 0: ; 100000..100005: s := s + 30 END",
+			want:  map[string]float64{"s": 31},
+		},
+		{
+			name:   "duplicate label",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 1; CASE i OF 1: ; 2, 1: END",
+			errors: "semantic error: 4:27: duplicate CASE label 1",
+		},
+		{
+			name:   "overlapping ranges",
+			decls:  "TYPE Color = (Red, Green, Blue);\nVAR c : Color;",
+			stmts:  "c := Red; CASE c OF Red..Green: ; Blue, Green..Blue: END",
+			errors: "semantic error: 5:41: CASE label Green..Blue overlaps Red..Green",
+		},
+		{
+			name:   "empty label range",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 1; CASE i OF 5..1: END",
+			errors: "semantic error: 4:19: CASE label range 5..1 is empty",
+		},
+		{
+			name:   "labels must be constants",
+			decls:  "VAR i, j : INTEGER;",
+			stmts:  "i := 1; j := 1; CASE i OF j: END",
+			errors: "semantic error: 4:27: CASE label must be a constant",
+		},
+		{
+			name:   "labels of another type",
+			decls:  "VAR i : INTEGER;",
+			stmts:  "i := 1; CASE i OF 'a': END",
+			errors: "incompatible types, CASE label is CHAR, selector is INTEGER",
+		},
+		{
+			name:   "REAL selector",
+			decls:  "VAR x : REAL;",
+			stmts:  "x := 1; CASE x OF 1: END",
+			errors: "semantic error: 4:14: CASE selector must be ordinal, not REAL",
+		},
+	})
+}
+
+func TestCaseDispatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []CaseRange
+		jump   bool
+		arms   map[int]int
+	}{
+		{
+			name:   "dense labels",
+			ranges: []CaseRange{{1, 2, 0}, {4, 4, 2}, {5, 9, 1}},
+			jump:   true,
+			arms:   map[int]int{0: -1, 1: 0, 2: 0, 3: -1, 4: 2, 7: 1, 9: 1, 10: -1},
+		},
+		{
+			name:   "sparse labels",
+			ranges: []CaseRange{{-5, -5, 1}, {0, 0, 0}, {100, 102, 2}},
+			jump:   false,
+			arms:   map[int]int{-6: -1, -5: 1, -4: -1, 0: 0, 99: -1, 100: 2, 102: 2, 103: -1},
+		},
+		{
+			name:   "span too wide for a table",
+			ranges: []CaseRange{{0, maxJumpTable, 0}},
+			jump:   false,
+			arms:   map[int]int{-1: -1, 0: 0, maxJumpTable: 0, maxJumpTable + 1: -1},
+		},
+		{
+			name:   "labels at the ends of int",
+			ranges: []CaseRange{{math.MinInt, math.MinInt, 1}, {0, 0, 0}, {math.MaxInt, math.MaxInt, 2}},
+			jump:   false,
+			arms:   map[int]int{math.MinInt: 1, math.MinInt + 1: -1, 0: 0, math.MaxInt - 1: -1, math.MaxInt: 2},
+		},
+		{
+			name:   "range wider than int",
+			ranges: []CaseRange{{-1 << 62, 1 << 62, 0}},
+			jump:   false,
+			arms:   map[int]int{math.MinInt: -1, -1 << 62: 0, 0: 0, 1 << 62: 0, 1<<62 + 1: -1},
+		},
+		{
+			name:   "dense labels next to the largest int",
+			ranges: []CaseRange{{math.MaxInt - 2, math.MaxInt, 0}},
+			jump:   true,
+			arms:   map[int]int{math.MaxInt - 3: -1, math.MaxInt - 2: 0, math.MaxInt: 0, math.MinInt: -1},
+		},
+		{
+			name: "no labels",
+			arms: map[int]int{0: -1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newCaseDispatch(test.ranges)
+			if got := d.jump != nil; got != test.jump {
+				t.Errorf("jump table = %v, want %v", got, test.jump)
+			}
+			for value, want := range test.arms {
+				if got := d.arm(value); got != want {
+					t.Errorf("arm(%d) = %d, want %d", value, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSets(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:   "constructors and operators",
+			decls:  "VAR s, t, u, d, i, e : SET OF 0..20; n : INTEGER;",
+			stmts:  "n := 4; s := [1, 3..5, n * 2]; t := [5..9]; u := s + t; d := s - t; i := s * t; e := []",
+			values: map[string]string{"s": "[1, 3, 4, 5, 8]", "u": "[1, 3, 4, 5, 6, 7, 8, 9]", "d": "[1, 3, 4]", "i": "[5, 8]", "e": "[]"},
+		},
+		{
+			name:  "membership and inclusion",
+			decls: "TYPE Color = (Red, Green, Blue);\nVAR s : SET OF Color; a, b, c, d, e, f : BOOLEAN;",
+			stmts: "s := [Red, Blue]; a := Green IN s; b := Blue IN s; c := [Red] <= s; d := s >= [Red, Green]; e := s = [Blue, Red]; f := s <> []",
+			want:  map[string]float64{"a": 0, "b": 1, "c": 1, "d": 0, "e": 1, "f": 1},
+		},
+		{
+			name:   "sets of characters",
+			decls:  "VAR s : SET OF CHAR; ch : CHAR; n : INTEGER;",
+			stmts:  "s := ['a'..'e', 'x'] - ['c']; n := 0; FOR ch := 'a' TO 'z' DO n := n + Ord(ch IN s)",
+			want:   map[string]float64{"n": 5},
+			values: map[string]string{"s": "[97, 98, 100, 101, 120]"},
+		},
+		{
+			name:   "assignment copies",
+			decls:  "VAR s, t : SET OF 0..9;",
+			stmts:  "s := [1]; t := s; s := s + [2]",
+			values: map[string]string{"s": "[1, 2]", "t": "[1]"},
+		},
+		{
+			name:   "element type too large",
+			decls:  "VAR s : SET OF INTEGER;",
+			errors: "semantic error: 2:16: set element type must be an enumeration or a subrange within 0..255, not INTEGER",
+		},
+		{
+			name:   "constant element out of the element type",
+			decls:  "VAR s : SET OF 1..5;",
+			stmts:  "s := [2, 6]",
+			errors: "semantic error: 4:10: set element 6 out of range 1..5",
+		},
+		{
+			name:   "element out of the element type",
+			decls:  "VAR s : SET OF 1..5; n : INTEGER;",
+			stmts:  "n := 7; s := [n]",
+			errors: "runtime error: 4:14: set element 7 out of range 1..5",
+		},
+		{
+			name:   "element no set can hold",
+			decls:  "VAR b : BOOLEAN; n : INTEGER;",
+			stmts:  "n := 300; b := 1 IN [n]",
+			errors: "runtime error: 4:22: set element 300 out of range 0..255",
+		},
+		{
+			name:   "sets of different types",
+			decls:  "TYPE Color = (Red, Green, Blue);\nVAR s : SET OF Color; t : SET OF 0..9;",
+			stmts:  "s := [Red] + t",
+			errors: "incompatible types, operator '+' on SET OF Color and SET OF 0..9",
+		},
+		{
+			name:   "elements of another type",
+			decls:  "VAR s : SET OF 0..9;",
+			stmts:  "s := [1, 'a']",
+			errors: "incompatible types, set element is CHAR, expected INTEGER",
+		},
+		{
+			name:   "DIV on sets",
+			decls:  "VAR s : SET OF 0..9;",
+			stmts:  "s := [1] DIV [2]",
+			errors: "operator 'DIV' is not defined",
+		},
+	})
+}
+
+func TestStrings(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:   "literals, quotes and character codes",
+			decls:  "VAR s, t, e : STRING; c, d : CHAR;",
+			stmts:  "s := 'it''s'; t := #72'i'#33; e := ''; c := 'x'; d := #65",
+			want:   map[string]float64{"c": 'x', "d": 'A'},
+			values: map[string]string{"s": "'it''s'", "t": "'Hi!'", "e": "''"},
+		},
+		{
+			name:  "concatenation, comparison and indexing",
+			decls: "VAR s : STRING; c : CHAR; a, b, d, e : BOOLEAN;",
+			stmts: "s := 'ab' + 'c'; c := s[2]; s[1] := 'x'; s := s + c + 'd'; a := 'abc' < 'abd'; b := 'b' > 'abc'; d := s = 'xbcbd'; e := 'a' <> 'a'",
+			want:  map[string]float64{"c": 'b', "a": 1, "b": 1, "d": 1, "e": 0},
+		},
+		{
+			name:  "Length, Pos and UpCase",
+			decls: "VAR s : STRING; i, j, k, l : INTEGER; c : CHAR;",
+			stmts: "s := 'hello'; i := Length(s); j := Pos('ll', s); k := Pos('z', s) + Pos('', s); l := Length('') + Length('q'); c := UpCase(s[1])",
+			want:  map[string]float64{"i": 5, "j": 3, "k": 0, "l": 1, "c": 'H'},
+		},
+		{
+			name:   "Copy and Concat",
+			decls:  "VAR a, b, c, d : STRING;",
+			stmts:  "a := Copy('pascal', 2, 3); b := Copy('pascal', 4, 100); c := Copy('pascal', 9, 1); d := Concat(a, '-', 'x', b)",
+			values: map[string]string{"a": "'asc'", "b": "'cal'", "c": "''", "d": "'asc-xcal'"},
+		},
+		{
+			name:   "Insert and Delete",
+			decls:  "VAR s, t : STRING;",
+			stmts:  "s := 'held'; Insert('llo wor', s, 3); t := 'abcdef'; Delete(t, 2, 3); Insert('!', t, 99)",
+			values: map[string]string{"s": "'hello world'", "t": "'aef!'"},
+		},
+		{
+			name:   "IntToStr, Str and Val",
+			decls:  "VAR s, t, u : STRING; i, code, bad : INTEGER; x : REAL;",
+			stmts:  "s := IntToStr(-42); Str(3.5, t); Str(7, u); Val('123', i, code); Val('2.5e1', x, code); bad := 5; Val('12x', bad, code)",
+			want:   map[string]float64{"i": 123, "x": 25, "bad": 5, "code": 3},
+			values: map[string]string{"s": "'-42'", "t": "'3.5'", "u": "'7'"},
+		},
+		{
+			name:  "Val of a number too large for an INTEGER",
+			decls: "VAR i, code : INTEGER; x : REAL;",
+			stmts: "i := 1; Val('99999999999999999999999', i, code); Val('99999999999999999999999', x, code)",
+			want:  map[string]float64{"i": 1, "x": 1e23, "code": 0},
+		},
+		{
+			name:  "Val of an integer too large reports where",
+			decls: "VAR i, code : INTEGER;",
+			stmts: "Val('-99999999999999999999999', i, code)",
+			want:  map[string]float64{"code": 24},
+		},
+		{
+			name:   "Val into a subrange",
+			decls:  "VAR s : 1..10; code : INTEGER;",
+			stmts:  "Val('99', s, code)",
+			errors: "runtime error: 4:11: value 99 out of range 1..10",
+		},
+		{
+			name:   "index past the end",
+			decls:  "VAR s : STRING; c : CHAR;",
+			stmts:  "s := 'ab'; c := s[3]",
+			errors: "runtime error: 4:18: index 3 out of bounds 1..2 of s",
+		},
+		{
+			name:   "constant index below 1",
+			decls:  "VAR s : STRING; c : CHAR;",
+			stmts:  "s := 'ab'; c := s[0]",
+			errors: "semantic error: 4:19: string index 0 is below 1",
+		},
+		{
+			name:   "strings are not characters",
+			decls:  "VAR c : CHAR;",
+			stmts:  "c := 'ab'",
+			errors: "incompatible types, cannot assign STRING to CHAR",
+		},
+	})
+}
+
+func TestLexerErrors(t *testing.T) {
+	for _, test := range []struct{ source, message string }{
+		{"PROGRAM T; BEGIN s := 'ab\nEND.", "lexer error: 1:26: unterminated string"},
+		{"PROGRAM T; BEGIN s := #256 END.", "lexer error: 1:24: character code 256 out of range 0..255"},
+		{"PROGRAM T; BEGIN i := 9007199254740992 END.", "lexer error: 1:23: integer 9007199254740992 out of range 0..9007199254740991"},
+	} {
+		if _, err := ParseSource(test.source); err == nil || err.Error() != test.message {
+			t.Errorf("%q: got error %v, want %q", test.source, err, test.message)
+		}
+	}
+}
+
+func TestPointers(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "a linked list",
+			decls: "TYPE PNode = ^Node; Node = RECORD value : INTEGER; next : PNode END;\nVAR head, p : PNode; i, sum, count : INTEGER;",
+			stmts: "head := NIL; FOR i := 1 TO 4 DO BEGIN New(p); p^.value := i; p^.next := head; head := p END; sum := 0; count := 0; p := head; FOR i := 1 TO 4 DO BEGIN sum := sum * 10 + p^.value; p := p^.next END; count := Ord(p = NIL)",
+			want:  map[string]float64{"sum": 4321, "count": 1},
+		},
+		{
+			name:   "pointers share their variable",
+			decls:  "VAR p, q : ^INTEGER; a, b : BOOLEAN; i : INTEGER;",
+			stmts:  "New(p); q := p; q^ := 7; i := p^; a := p = q; New(q); b := p <> q; Dispose(q); Dispose(p)",
+			want:   map[string]float64{"i": 7, "a": 1, "b": 1},
+			values: map[string]string{"p": "^1", "q": "^2"},
+		},
+		{
+			name:   "NIL dereference",
+			decls:  "VAR p : ^INTEGER; i : INTEGER;",
+			stmts:  "p := NIL; i := p^",
+			errors: "runtime error: 4:17: dereference of NIL pointer p",
+		},
+		{
+			name:   "use after Dispose",
+			decls:  "VAR p, q : ^INTEGER;",
+			stmts:  "New(p); q := p; Dispose(p); q^ := 1",
+			errors: "runtime error: 4:30: dereference of q, disposed at 4:17",
+		},
+		{
+			name:   "VAR parameter disposed in the callee",
+			decls:  "VAR q : ^INTEGER;\nPROCEDURE P(VAR x : INTEGER);\nBEGIN\nDispose(q); x := 5\nEND;",
+			stmts:  "New(q); P(q^)",
+			errors: "runtime error: 8:12: dereference of q, disposed at 5:1",
+		},
+		{
+			name:   "element of a variable disposed in the callee",
+			decls:  "TYPE A = ARRAY[1..2] OF INTEGER;\nVAR q : ^A;\nPROCEDURE P(VAR x : INTEGER);\nBEGIN\nDispose(q); x := 5\nEND;",
+			stmts:  "New(q); P(q^[1])",
+			errors: "runtime error: 9:12: dereference of q, disposed at 6:1",
+		},
+		{
+			name:   "WITH on a variable disposed in the body",
+			decls:  "TYPE R = RECORD f : INTEGER END;\nVAR q : ^R;",
+			stmts:  "New(q); WITH q^ DO BEGIN Dispose(q); f := 5 END",
+			errors: "runtime error: 5:15: dereference of q, disposed at 5:26",
+		},
+		{
+			name:   "double Dispose",
+			decls:  "VAR p, q : ^INTEGER;",
+			stmts:  "New(p); q := p; Dispose(p); Dispose(q)",
+			errors: "runtime error: 4:29: Dispose of q, disposed already at 4:17",
+		},
+		{
+			name:   "Dispose of NIL",
+			decls:  "VAR p : ^INTEGER;",
+			stmts:  "p := NIL; Dispose(p)",
+			errors: "runtime error: 4:11: Dispose of NIL pointer p",
+		},
+		{
+			name:   "pointers to different types",
+			decls:  "VAR p : ^INTEGER; q : ^CHAR;",
+			stmts:  "New(p); q := p",
+			errors: "incompatible types",
+		},
+	})
+}
+
+func TestLeaks(t *testing.T) {
+	source := program("VAR p, q : ^INTEGER; i : INTEGER;",
+		"FOR i := 1 TO 3 DO New(p);\nNew(q); Dispose(q); New(q)")
+	tree, err := ParseSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := NewSemanticAnalyzer().Analyze(tree); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	in := NewInterpreter()
+	if err := in.Interpret(tree); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range in.Heap.Leaks() {
+		got = append(got, w.String())
+	}
+	want := []string{
+		"warning: 4:20: 3 variables allocated here are never disposed",
+		"warning: 5:21: variable allocated here is never disposed",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got leaks\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParameters(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "value parameters copy",
+			decls: "TYPE A = ARRAY[1..2] OF INTEGER;\nVAR a : A; i, j, k : INTEGER;\nPROCEDURE P(n : INTEGER; b : A);\nBEGIN\nn := n + 1; b[1] := n; j := b[1]\nEND;",
+			stmts: "i := 1; a[1] := 5; P(i, a); k := a[1]",
+			want:  map[string]float64{"i": 1, "j": 2, "k": 5},
+		},
+		{
+			name:  "VAR parameters alias variables, elements and fields",
+			decls: "VAR i : INTEGER; a : ARRAY[1..3] OF INTEGER; r : RECORD x : INTEGER END; s : STRING; k, l, m : INTEGER;\nPROCEDURE Inc(VAR n : INTEGER);\nBEGIN\nn := n + 1\nEND;\nPROCEDURE Up(VAR c : CHAR);\nBEGIN\nc := UpCase(c)\nEND;",
+			stmts: "i := 1; a[2] := 10; r.x := 20; s := 'abc'; Inc(i); Inc(a[2]); Inc(a[2]); Inc(r.x); Up(s[2]); k := a[2]; l := r.x; m := Ord(s = 'aBc')",
+			want:  map[string]float64{"i": 2, "k": 12, "l": 21, "m": 1},
+		},
+		{
+			name:  "VAR parameters alias fields of records assigned whole",
+			decls: "TYPE R = RECORD f : INTEGER END;\nVAR r, o : R; k : INTEGER;\nPROCEDURE P(VAR x : INTEGER);\nBEGIN\nr := o; x := 5\nEND;",
+			stmts: "r.f := 1; o.f := 9; P(r.f); k := r.f",
+			want:  map[string]float64{"k": 5},
+		},
+		{
+			name:  "VAR parameters alias elements of arrays assigned whole",
+			decls: "TYPE A = ARRAY[1..2] OF INTEGER;\nVAR a, b : A; k, l : INTEGER;\nPROCEDURE P(VAR x : INTEGER);\nBEGIN\na := b; x := x + 5\nEND;",
+			stmts: "a[1] := 1; b[1] := 2; P(a[1]); k := a[1]; l := b[1]",
+			want:  map[string]float64{"k": 7, "l": 2},
+		},
+		{
+			name:  "CONST parameters",
+			decls: "TYPE A = ARRAY[1..2] OF INTEGER;\nVAR a : A; i : INTEGER;\nFUNCTION Sum(CONST b : A) : INTEGER;\nBEGIN\nSum := b[1] + b[2]\nEND;",
+			stmts: "a[1] := 3; a[2] := 4; i := Sum(a)",
+			want:  map[string]float64{"i": 7},
+		},
+		{
+			name:   "expressions passed to VAR parameters",
+			decls:  "VAR i : INTEGER;\nPROCEDURE Inc(VAR n : INTEGER);\nBEGIN\nn := n + 1\nEND;",
+			stmts:  "i := 1; Inc(i + 1)",
+			errors: "semantic error: 8:13: argument 1 of 'Inc' must be a variable",
+		},
+		{
+			name:   "VAR parameters of another type",
+			decls:  "TYPE Small = 1..5;\nVAR s : Small;\nPROCEDURE Inc(VAR n : INTEGER);\nBEGIN\nn := n + 1\nEND;",
+			stmts:  "s := 1; Inc(s)",
+			errors: "semantic error: 9:13: argument 1 of 'Inc' must be INTEGER, not Small",
+		},
+		{
+			name:   "assignment to a CONST parameter",
+			decls:  "PROCEDURE P(CONST n : INTEGER);\nBEGIN\nn := 1\nEND;",
+			errors: "semantic error: 4:1: cannot assign to CONST parameter 'n'",
+		},
+		{
+			name:   "argument count",
+			decls:  "PROCEDURE P(a, b : INTEGER);\nBEGIN\nEND;",
+			stmts:  "P(1)",
+			errors: "semantic error: 6:1: 'P' takes 2 arguments, not 1",
+		},
+		{
+			name:   "value argument out of range",
+			decls:  "TYPE Small = 1..5;\nVAR i : INTEGER;\nPROCEDURE P(s : Small);\nBEGIN\nEND;",
+			stmts:  "i := 9; P(i)",
+			errors: "runtime error: 8:11: value 9 out of range 1..5",
+		},
+		{
+			name:   "runaway recursion",
+			decls:  "PROCEDURE P;\nBEGIN\nP\nEND;",
+			stmts:  "P",
+			errors: "runtime error: 4:1: stack overflow, calls nested more than 10000 deep",
+		},
+		{
+			name:   "runaway recursion through arguments",
+			decls:  "FUNCTION F(n : INTEGER) : INTEGER;\nBEGIN\nF := F(n + 1)\nEND;\nVAR i : INTEGER;",
+			stmts:  "i := F(0)",
+			errors: "stack overflow",
+		},
+	})
+}
+
+func TestNesting(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "nested procedures read and write enclosing locals",
+			decls: "VAR r : INTEGER;\nPROCEDURE Outer;\nVAR n : INTEGER;\n   PROCEDURE Add(k : INTEGER);\n      PROCEDURE Twice;\n      BEGIN\n      n := n + k; n := n + k\n      END;\n   BEGIN\n   Twice\n   END;\nBEGIN\nn := 1; Add(2); Add(10); r := n\nEND;",
+			stmts: "Outer",
+			want:  map[string]float64{"r": 25},
+		},
+		{
+			name:  "static links under recursion",
+			decls: "VAR r : INTEGER;\nPROCEDURE Walk(depth : INTEGER);\n   PROCEDURE Note;\n   BEGIN\n   r := r * 10 + depth\n   END;\n   PROCEDURE Down;\n   BEGIN\n   Walk(depth - 1)\n   END;\nBEGIN\nCASE depth OF 0: ; ELSE Note; Down; Note END\nEND;",
+			stmts: "r := 0; Walk(3)",
+			want:  map[string]float64{"r": 321123},
+		},
+		{
+			name:  "locals hide enclosing variables",
+			decls: "VAR x, r, s : INTEGER;\nPROCEDURE Outer;\nVAR x : INTEGER;\n   PROCEDURE Inner;\n   VAR x : INTEGER;\n   BEGIN\n   x := 3; r := x\n   END;\nBEGIN\nx := 2; Inner; s := x\nEND;",
+			stmts: "x := 1; Outer",
+			want:  map[string]float64{"x": 1, "r": 3, "s": 2},
+		},
+		{
+			name:   "nested procedures are local",
+			decls:  "PROCEDURE Outer;\n   PROCEDURE Inner;\n   BEGIN\n   END;\nBEGIN\nEND;",
+			stmts:  "Inner",
+			errors: "semantic error: 9:1: identifier not found 'Inner'",
+		},
+	})
+}
+
+func TestProcedural(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "procedural variables",
+			decls: "TYPE TFunc = FUNCTION(x : REAL) : REAL;\nVAR f : TFunc; a, b : REAL;\nFUNCTION Double(x : REAL) : REAL;\nBEGIN\nDouble := 2 * x\nEND;\nFUNCTION Square(x : REAL) : REAL;\nBEGIN\nSquare := x * x\nEND;",
+			stmts: "f := Double; a := f(1.5); f := Square; b := f(f(3))",
+			want:  map[string]float64{"a": 3, "b": 81},
+		},
+		{
+			name:  "an integrator taking a function parameter",
+			decls: "VAR area : REAL;\nFUNCTION Line(x : REAL) : REAL;\nBEGIN\nLine := x\nEND;\nFUNCTION Integrate(FUNCTION f(x : REAL) : REAL; a, b : REAL; n : INTEGER) : REAL;\nVAR i : INTEGER; h, s : REAL;\nBEGIN\nh := (b - a) / n; s := 0;\nFOR i := 0 TO n - 1 DO s := s + f(a + (i + 0.5) * h) * h;\nIntegrate := s\nEND;",
+			stmts: "area := Integrate(Line, 0, 4, 8)",
+			want:  map[string]float64{"area": 8},
+		},
+		{
+			name:  "sorting with a comparison callback",
+			decls: "TYPE Less = FUNCTION(a, b : INTEGER) : BOOLEAN;\nVAR v : ARRAY[1..4] OF INTEGER; r : INTEGER;\nFUNCTION Down(a, b : INTEGER) : BOOLEAN;\nBEGIN\nDown := a > b\nEND;\nPROCEDURE Sort(less : Less);\nVAR i, j, t : INTEGER;\nBEGIN\nFOR i := 1 TO 3 DO FOR j := 1 TO 4 - i DO\nCASE less(v[j + 1], v[j]) OF True: BEGIN t := v[j]; v[j] := v[j + 1]; v[j + 1] := t END; False: END\nEND;",
+			stmts: "v[1] := 3; v[2] := 9; v[3] := 1; v[4] := 5; Sort(Down); r := v[1] * 1000 + v[2] * 100 + v[3] * 10 + v[4]",
+			want:  map[string]float64{"r": 9531},
+		},
+		{
+			name:  "nested procedures keep their enclosing frame",
+			decls: "TYPE Proc = PROCEDURE;\nVAR r : INTEGER;\nPROCEDURE Run(p : Proc);\nVAR n : INTEGER;\nBEGIN\nn := 100; p\nEND;\nPROCEDURE Outer;\nVAR n : INTEGER;\n   PROCEDURE Bump;\n   BEGIN\n   n := n + 1\n   END;\nBEGIN\nn := 1; Run(Bump); Run(Bump); r := n\nEND;",
+			stmts: "Outer",
+			want:  map[string]float64{"r": 3},
+		},
+		{
+			name:   "call of an unassigned procedural variable",
+			decls:  "VAR p : PROCEDURE;",
+			stmts:  "p",
+			errors: "runtime error: 4:1: call of unassigned procedural variable 'p'",
+		},
+		{
+			name:   "routines of another signature",
+			decls:  "TYPE TFunc = FUNCTION(x : REAL) : REAL;\nVAR f : TFunc;\nFUNCTION Half(x : INTEGER) : REAL;\nBEGIN\nHalf := x / 2\nEND;",
+			stmts:  "f := Half",
+			errors: "semantic error: 9:1: incompatible types, cannot assign FUNCTION(x : INTEGER) : REAL to TFunc",
+		},
+	})
+}
+
+func TestForward(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "mutual recursion",
+			decls: "VAR a, b : BOOLEAN;\nFUNCTION IsOdd(n : INTEGER) : BOOLEAN; FORWARD;\nFUNCTION IsEven(n : INTEGER) : BOOLEAN;\nBEGIN\nCASE n OF 0: IsEven := True ELSE IsEven := IsOdd(n - 1) END\nEND;\nFUNCTION IsOdd(n : INTEGER) : BOOLEAN;\nBEGIN\nCASE n OF 0: IsOdd := False ELSE IsOdd := IsEven(n - 1) END\nEND;",
+			stmts: "a := IsEven(10); b := IsOdd(7)",
+			want:  map[string]float64{"a": 1, "b": 1},
+		},
+		{
+			name:  "forward procedures taken as values",
+			decls: "TYPE Proc = PROCEDURE(VAR n : INTEGER);\nVAR p : Proc; i : INTEGER;\nPROCEDURE Inc(VAR n : INTEGER); FORWARD;\nPROCEDURE Take;\nBEGIN\np := Inc\nEND;\nPROCEDURE Inc(VAR n : INTEGER);\nBEGIN\nn := n + 1\nEND;",
+			stmts: "i := 1; Take; p(i); p(i)",
+			want:  map[string]float64{"i": 3},
+		},
+		{
+			name:   "forward declaration without a body",
+			decls:  "PROCEDURE P; FORWARD;",
+			errors: "semantic error: 2:11: FORWARD declaration of 'P' has no body",
+		},
+		{
+			name:   "body with other parameter names",
+			decls:  "PROCEDURE P(a : INTEGER); FORWARD;\nPROCEDURE P(b : INTEGER);\nBEGIN\nEND;",
+			errors: "semantic error: 3:11: heading of 'P' does not match its FORWARD declaration PROCEDURE P(a : INTEGER)",
+		},
+		{
+			name:   "body with another result type",
+			decls:  "FUNCTION F : INTEGER; FORWARD;\nFUNCTION F : REAL;\nBEGIN\nF := 1\nEND;",
+			errors: "semantic error: 3:10: heading of 'F' does not match its FORWARD declaration",
+		},
+		{
+			name:   "body with other parameter modes",
+			decls:  "PROCEDURE P(VAR a : INTEGER); FORWARD;\nPROCEDURE P(a : INTEGER);\nBEGIN\nEND;",
+			errors: "semantic error: 3:11: heading of 'P' does not match its FORWARD declaration",
+		},
+	})
+}
+
+func TestTextFiles(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "Eof and Eoln",
+			decls: "VAR f : TEXT; c : CHAR; i, chars, lines : INTEGER; e : BOOLEAN;",
+			stmts: "Assign(f, 'in.txt'); Reset(f); chars := 0; lines := 0;\nFOR i := 1 TO 20 DO CASE Ord(Eof(f)) * 2 + Ord(Eoln(f)) OF 0: BEGIN Read(f, c); chars := chars + 1 END; 1: BEGIN ReadLn(f); lines := lines + 1 END; 3: END;\ne := Eof(f); Close(f)",
+			files: MapFS{"in.txt": []byte("ab\n\ncde\n")},
+			want:  map[string]float64{"chars": 5, "lines": 3, "e": 1},
+		},
+		{
+			name:   "Rewrite, Append and Reset",
+			decls:  "VAR f : TEXT; s, t, u : STRING; e : BOOLEAN;",
+			stmts:  "Assign(f, 'log.txt'); Rewrite(f); WriteLn(f, 'one', ' ', 1); Close(f);\nAppend(f); Write(f, 2.5, 'x'); Reset(f); ReadLn(f, s); ReadLn(f, t); e := Eof(f); Close(f);\nAssign(f, 'new.txt'); Rewrite(f); Reset(f); ReadLn(f, u)",
+			values: map[string]string{"s": "'one 1'", "t": "'2.5x'", "u": "''"},
+			want:   map[string]float64{"e": 1},
+		},
+		{
+			name:   "missing file",
+			decls:  "VAR f : TEXT;",
+			stmts:  "Assign(f, 'none.txt'); Reset(f)",
+			errors: "runtime error: 4:24: cannot open file 'none.txt': file does not exist",
+		},
+		{
+			name:   "file never assigned",
+			decls:  "VAR f : TEXT;",
+			stmts:  "Rewrite(f)",
+			errors: "runtime error: 4:1: file f is not assigned",
+		},
+		{
+			name:   "Assign to an open file",
+			decls:  "VAR f : TEXT;",
+			stmts:  "Assign(f, 'a.txt'); Rewrite(f); Assign(f, 'b.txt')",
+			errors: "runtime error: 4:33: Assign to open file f",
+		},
+		{
+			name:   "read from a file open for writing",
+			decls:  "VAR f : TEXT; c : CHAR;",
+			stmts:  "Assign(f, 'a.txt'); Rewrite(f); Read(f, c)",
+			errors: "runtime error: 4:33: file f is not open for reading",
+		},
+		{
+			name:   "Eof of a closed file",
+			decls:  "VAR f : TEXT; e : BOOLEAN;",
+			stmts:  "e := Eof(f)",
+			errors: "runtime error: 4:6: file f is not open",
+		},
+		{
+			name:   "read past the end",
+			decls:  "VAR f : TEXT; i : INTEGER;",
+			stmts:  "Assign(f, 'in.txt'); Reset(f); ReadLn(f, i); ReadLn(f, i)",
+			files:  MapFS{"in.txt": []byte("7\n")},
+			errors: "runtime error: 4:46: read past end of file f",
+		},
+		{
+			name:   "invalid number",
+			decls:  "VAR f : TEXT; i : INTEGER;",
+			stmts:  "Assign(f, 'in.txt'); Reset(f); Read(f, i)",
+			files:  MapFS{"in.txt": []byte("12x\n")},
+			errors: "runtime error: 4:32: invalid number in file f",
+		},
+		{
+			name:   "read value out of range",
+			decls:  "VAR f : TEXT; d : 0..9;",
+			stmts:  "Assign(f, 'in.txt'); Reset(f); Read(f, d)",
+			files:  MapFS{"in.txt": []byte("12\n")},
+			errors: "runtime error: 4:40: value 12 out of range 0..9",
+		},
+		{
+			name:   "files are not assignable",
+			decls:  "VAR f, g : TEXT;",
+			stmts:  "f := g",
+			errors: "semantic error: 4:1: cannot assign files",
+		},
+		{
+			name:   "records holding files are not assignable",
+			decls:  "TYPE R = RECORD f : TEXT END;\nVAR a, b : R;",
+			stmts:  "b := a",
+			errors: "semantic error: 5:1: cannot assign files",
+		},
+		{
+			name:   "arrays of files are not assignable",
+			decls:  "VAR a, b : ARRAY[1..2] OF TEXT;",
+			stmts:  "b := a",
+			errors: "semantic error: 4:1: cannot assign files",
+		},
+		{
+			name:   "records holding files are not value parameters",
+			decls:  "TYPE R = RECORD n : INTEGER; f : ARRAY[1..2] OF TEXT END;\nPROCEDURE P(r : R);\nBEGIN\nEND;",
+			errors: "semantic error: 3:13: file parameter 'r' must be a VAR parameter",
+		},
+		{
+			name:   "functions returning records holding files",
+			decls:  "TYPE R = RECORD f : TEXT END;\nFUNCTION F : R;\nBEGIN\nEND;",
+			errors: "semantic error: 3:14: function 'F' cannot return a file",
+		},
+		{
+			name:   "records holding files as VAR parameters",
+			decls:  "TYPE R = RECORD f : TEXT; n : INTEGER END;\nVAR a : R; s : STRING;\nPROCEDURE Open(VAR r : R);\nBEGIN\nAssign(r.f, 'x.txt'); Rewrite(r.f); WriteLn(r.f, 'hi'); Reset(r.f)\nEND;",
+			stmts:  "Open(a); ReadLn(a.f, s); Close(a.f)",
+			values: map[string]string{"s": "'hi'"},
+		},
+	})
+}
+
+func TestTypedFiles(t *testing.T) {
+	runTests(t, []interpretTest{
+		{
+			name:  "Seek, FilePos and FileSize",
+			decls: "VAR f : FILE OF INTEGER; i, n, a, b, pos, size, last : INTEGER; e : BOOLEAN;",
+			stmts: "Assign(f, 'n.dat'); Rewrite(f); FOR i := 1 TO 5 DO Write(f, i * i); size := FileSize(f); last := FilePos(f);\nSeek(f, 3); Read(f, a); pos := FilePos(f); Seek(f, 1); Write(f, -1); Seek(f, 1); Read(f, b, n); e := Eof(f); Close(f)",
+			want:  map[string]float64{"size": 5, "last": 5, "a": 16, "pos": 4, "b": -1, "n": 9, "e": 0},
+		},
+		{
+			name:  "Reset keeps the components and Truncate drops the rest",
+			decls: "VAR f : FILE OF REAL; x, y : REAL; size : INTEGER; e : BOOLEAN;",
+			stmts: "Assign(f, 'r.dat'); Rewrite(f); Write(f, 1.5, 2.5, 3.5); Close(f);\nReset(f); Read(f, x); Truncate(f); size := FileSize(f); Seek(f, 0); Read(f, y); e := Eof(f); Close(f)",
+			want:  map[string]float64{"x": 1.5, "y": 1.5, "size": 1, "e": 1},
+		},
+		{
+			name:  "arrays of enumerations",
+			decls: "TYPE Color = (Red, Green, Blue); Row = ARRAY[1..2] OF Color;\nVAR f : FILE OF Row; r, s : Row; a, b : Color;",
+			stmts: "r[1] := Blue; r[2] := Green; Assign(f, 'c.dat'); Rewrite(f); Write(f, r); Seek(f, 0); Read(f, s); a := s[1]; b := s[2]; Close(f)",
+			want:  map[string]float64{"a": 2, "b": 1},
+		},
+		{
+			name:   "Seek past the end",
+			decls:  "VAR f : FILE OF INTEGER;",
+			stmts:  "Assign(f, 'n.dat'); Rewrite(f); Write(f, 1); Seek(f, 2)",
+			errors: "runtime error: 4:46: Seek to component 2 of file f of 1 components",
+		},
+		{
+			name:   "read past the end",
+			decls:  "VAR f : FILE OF INTEGER; i : INTEGER;",
+			stmts:  "Assign(f, 'n.dat'); Reset(f); Read(f, i)",
+			files:  MapFS{"n.dat": []byte{1, 0, 0}},
+			errors: "runtime error: 4:31: read past end of file f",
+		},
+		{
+			name:   "component out of its subrange",
+			decls:  "TYPE Digit = 0..9;\nVAR f : FILE OF Digit; d : Digit;",
+			stmts:  "Assign(f, 'd.dat'); Reset(f); Read(f, d)",
+			files:  MapFS{"d.dat": []byte{12, 0, 0, 0, 0, 0, 0, 0}},
+			errors: "runtime error: 5:39: value 12 out of range 0..9",
+		},
+		{
+			name:   "BOOLEAN field out of range",
+			decls:  "TYPE P = RECORD c : CHAR; ok : BOOLEAN END;\nVAR f : FILE OF P; p : P;",
+			stmts:  "Assign(f, 'p.dat'); Reset(f); Read(f, p)",
+			files:  MapFS{"p.dat": []byte{'a', 5}},
+			errors: "runtime error: 5:39: value 5 out of range 0..1",
+		},
+		{
+			name:   "Seek on a TEXT file",
+			decls:  "VAR f : TEXT;",
+			stmts:  "Seek(f, 0)",
+			errors: "semantic error: 4:6: argument 1 of 'Seek' must be a typed file, not TEXT",
+		},
+		{
+			name:   "components of strings",
+			decls:  "VAR f : FILE OF STRING;",
+			errors: "semantic error: 2:9: file component type cannot be STRING",
+		},
+		{
+			name:   "components of another type",
+			decls:  "VAR f : FILE OF INTEGER; x : REAL;",
+			stmts:  "Assign(f, 'n.dat'); Rewrite(f); Write(f, x)",
+			errors: "semantic error: 4:42: argument 2 of 'Write' must be INTEGER, not REAL",
+		},
+	})
+}
+
+func TestDirFS(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Skip("no symbolic links:", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	files := DirFS(dir)
+
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := files.WriteFile(name, []byte(name)); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+		data, err := files.ReadFile(name)
+		if err != nil || string(data) != name {
+			t.Errorf("read %s: got %q, %v, want %q", name, data, err, name)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "sub", "b.txt")); err != nil || string(data) != "sub/b.txt" {
+		t.Errorf("sub/b.txt on disk: got %q, %v", data, err)
+	}
+
+	for _, name := range []string{"../secret.txt", "sub/../../secret.txt", "link.txt", "out/secret.txt"} {
+		if data, err := files.ReadFile(name); err == nil {
+			t.Errorf("read %s: got %q, want an error", name, data)
+		}
+		if err := files.WriteFile(name, []byte("x")); err == nil {
+			t.Errorf("write %s succeeded, want an error", name)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(outside, "secret.txt")); err != nil || string(data) != "secret" {
+		t.Errorf("file outside: got %q, %v, want it untouched", data, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == "secret.txt" {
+			t.Errorf("a file was written next to the directory")
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 // Lexer ...
@@ -11,6 +13,15 @@ type Lexer struct {
 	// Pos is an index into Text
 	Pos         int
 	CurrentChar byte
+	// Line and Column of CurrentChar, both starting at 1
+	Line, Column int
+	// KeepTrivia attaches whitespace and comments to the tokens
+	// so that the source can be rebuilt byte for byte
+	KeepTrivia bool
+
+	// comments read since the last token, and the line the last token ended on
+	comments []Comment
+	lastLine int
 }
 
 // NewLexer ...
@@ -18,27 +29,61 @@ func NewLexer(input string) *Lexer {
 	l := &Lexer{}
 	l.Text = input
 	l.Pos = 0
-	l.CurrentChar = l.Text[l.Pos]
+	l.Line = 1
+	l.Column = 1
+	if len(l.Text) > 0 {
+		l.CurrentChar = l.Text[l.Pos]
+	}
 	return l
 }
 
 func (l *Lexer) Error() {
-	panic("error lexing input")
+	tok := Token{Line: l.Line, Column: l.Column}
+	if l.CurrentChar == 0 {
+		panic(&Error{Kind: LexerError, Tok: tok, Message: "unexpected end of input"})
+	}
+	tok.Text = string(l.CurrentChar)
+	panic(&Error{Kind: LexerError, Tok: tok, Message: fmt.Sprintf("unexpected character %q", l.CurrentChar)})
 }
 
 // ReservedWords ...
 var ReservedWords = map[string]Token{
-	"PROGRAM": Token{Type: PROGRAM},
-	"VAR":     Token{Type: VAR},
-	"DIV":     Token{Type: INTEGERDIV},
-	"INTEGER": Token{Type: INTEGER},
-	"REAL":    Token{Type: REAL},
-	"BEGIN":   Token{Type: BEGIN},
-	"END":     Token{Type: END},
+	"PROGRAM":   Token{Type: PROGRAM},
+	"VAR":       Token{Type: VAR},
+	"DIV":       Token{Type: INTEGERDIV},
+	"INTEGER":   Token{Type: INTEGER},
+	"REAL":      Token{Type: REAL},
+	"BEGIN":     Token{Type: BEGIN},
+	"END":       Token{Type: END},
+	"ARRAY":     Token{Type: ARRAY},
+	"OF":        Token{Type: OF},
+	"RECORD":    Token{Type: RECORD},
+	"WITH":      Token{Type: WITH},
+	"DO":        Token{Type: DO},
+	"TYPE":      Token{Type: TYPE},
+	"CONST":     Token{Type: CONST},
+	"FOR":       Token{Type: FOR},
+	"TO":        Token{Type: TO},
+	"DOWNTO":    Token{Type: DOWNTO},
+	"CASE":      Token{Type: CASE},
+	"ELSE":      Token{Type: ELSE},
+	"OTHERWISE": Token{Type: OTHERWISE},
+	"SET":       Token{Type: SET},
+	"IN":        Token{Type: IN},
+	"BOOLEAN":   Token{Type: BOOLEAN},
+	"CHAR":      Token{Type: CHAR},
+	"STRING":    Token{Type: STRING},
+	"NIL":       Token{Type: NIL},
+	"PROCEDURE": Token{Type: PROCEDURE},
+	"FUNCTION":  Token{Type: FUNCTION},
+	"FORWARD":   Token{Type: FORWARD},
+	"TEXT":      Token{Type: TEXT},
+	"FILE":      Token{Type: FILE},
 }
 
 // ID ...
-// Handle identifiers and reserved keywords
+// Handle identifiers and reserved keywords, which may be written
+// in any case
 func (l *Lexer) ID() Token {
 	buffer := make([]byte, 0)
 	for l.CurrentChar != 0 && isAlphaNumeric(l.CurrentChar) {
@@ -46,7 +91,7 @@ func (l *Lexer) ID() Token {
 		l.Advance()
 	}
 	str := string(buffer)
-	if tok, exists := ReservedWords[str]; exists {
+	if tok, exists := ReservedWords[strings.ToUpper(str)]; exists {
 		return tok
 	}
 	return Token{Type: IDENT, Svalue: str}
@@ -55,6 +100,12 @@ func (l *Lexer) ID() Token {
 // Advance ...
 func (l *Lexer) Advance() {
 	// Advance the 'pos' pointer and set the 'current_char' variable.
+	if l.CurrentChar == '\n' {
+		l.Line++
+		l.Column = 1
+	} else {
+		l.Column++
+	}
 	l.Pos++
 	if l.Pos > len(l.Text)-1 {
 		l.CurrentChar = 0 // Indicates end of input
@@ -82,23 +133,54 @@ func (l *Lexer) SkipWhitespace() {
 	}
 }
 
-// SkipComment ...
-func (l *Lexer) SkipComment() {
-	for l.CurrentChar != '}' {
+// atComment ...
+// Reports whether a { or (* comment starts at CurrentChar
+func (l *Lexer) atComment() bool {
+	return l.CurrentChar == '{' || (l.CurrentChar == '(' && l.Peek() == '*')
+}
+
+// Comment ...
+// Consume a {...} or (*...*) comment and return it including
+// the delimiters
+func (l *Lexer) Comment() Comment {
+	c := Comment{Line: l.Line, Trailing: l.Line == l.lastLine}
+	start := l.Pos
+	if l.CurrentChar == '{' {
+		for l.CurrentChar != '}' {
+			if l.CurrentChar == 0 {
+				l.Error()
+			}
+			l.Advance()
+		}
+		l.Advance() // For closing }
+	} else {
+		l.Advance()
+		l.Advance()
+		for !(l.CurrentChar == '*' && l.Peek() == ')') {
+			if l.CurrentChar == 0 {
+				l.Error()
+			}
+			l.Advance()
+		}
+		l.Advance() // For closing *)
 		l.Advance()
 	}
-	l.Advance() // For closing }
+	c.Text = l.Text[start:l.Pos]
+	return c
 }
 
 // Number ...
 // Return a (multidigit) integer or float consumed from the input.
+// Integers beyond MaxInteger cannot be held exactly and are an error.
 func (l *Lexer) Number() Token {
+	tok := Token{Line: l.Line, Column: l.Column}
 	var buffer []byte
 	for isDigit(l.CurrentChar) {
 		buffer = append(buffer, l.CurrentChar)
 		l.Advance()
 	}
-	if l.CurrentChar == '.' {
+	// a '.' followed by another one starts a DOTDOT, as in 1..10
+	if l.CurrentChar == '.' && l.Peek() != '.' {
 		buffer = append(buffer, l.CurrentChar)
 		l.Advance()
 		for isDigit(l.CurrentChar) {
@@ -109,6 +191,10 @@ func (l *Lexer) Number() Token {
 		return Token{Type: REALCONST, Value: val}
 	}
 	val, _ := strconv.ParseFloat(string(buffer), 64)
+	if val > MaxInteger {
+		tok.Text = string(buffer)
+		panic(&Error{Kind: LexerError, Tok: tok, Message: fmt.Sprintf("integer %s out of range 0..%d", buffer, MaxInteger)})
+	}
 	return Token{Type: INTEGERCONST, Value: val}
 }
 
@@ -118,65 +204,205 @@ func (l *Lexer) Number() Token {
 // This method is responsible for breaking a sentence
 // apart into tokens. One token at a time.
 func (l *Lexer) GetNextToken() Token {
+	var leading []Trivia
 	for l.CurrentChar != 0 {
+		start := l.Pos
 		if l.CurrentChar == ' ' ||
 			l.CurrentChar == '\n' ||
 			l.CurrentChar == '\r' ||
 			l.CurrentChar == '\t' {
 			l.SkipWhitespace()
+			leading = l.trivia(leading, WhitespaceTrivia, start)
 			continue
 		}
-		if isAlpha(l.CurrentChar) {
-			return l.ID()
+		if l.atComment() {
+			l.comments = append(l.comments, l.Comment())
+			leading = l.trivia(leading, CommentTrivia, start)
+			continue
 		}
-		if isDigit(l.CurrentChar) {
-			return l.Number()
+		line, column := l.Line, l.Column
+		tok := l.scanToken()
+		tok.Line, tok.Column = line, column
+		tok.Text = l.Text[start:l.Pos]
+		tok.Comments, l.comments = l.comments, nil
+		l.lastLine = l.Line
+		if l.KeepTrivia {
+			tok.Leading = leading
+			tok.Trailing = l.trailingTrivia()
 		}
-		if l.CurrentChar == ':' && l.Peek() == '=' {
-			l.Advance()
-			l.Advance()
-			return Token{Type: ASSIGN}
+		return tok
+	}
+	tok := Token{Type: EOF, Line: l.Line, Column: l.Column}
+	tok.Comments, l.comments = l.comments, nil
+	tok.Leading = leading
+	return tok
+}
+
+// trivia ...
+// Appends the source text from start up to Pos to list when
+// trivia is being kept
+func (l *Lexer) trivia(list []Trivia, kind int, start int) []Trivia {
+	if !l.KeepTrivia {
+		return list
+	}
+	return append(list, Trivia{Kind: kind, Text: l.Text[start:l.Pos]})
+}
+
+// trailingTrivia ...
+// Consume the spaces and comments that follow a token on the
+// same line. The line break is left for the next token.
+func (l *Lexer) trailingTrivia() []Trivia {
+	var trailing []Trivia
+	for {
+		start := l.Pos
+		switch {
+		case l.CurrentChar == ' ' || l.CurrentChar == '\t':
+			for l.CurrentChar == ' ' || l.CurrentChar == '\t' {
+				l.Advance()
+			}
+			trailing = l.trivia(trailing, WhitespaceTrivia, start)
+		case l.atComment():
+			l.comments = append(l.comments, l.Comment())
+			trailing = l.trivia(trailing, CommentTrivia, start)
+		default:
+			return trailing
 		}
-		switch l.CurrentChar {
-		case '{':
+	}
+}
+
+// scanToken ...
+// Consume a single token starting at CurrentChar
+func (l *Lexer) scanToken() Token {
+	if isAlpha(l.CurrentChar) {
+		return l.ID()
+	}
+	if isDigit(l.CurrentChar) {
+		return l.Number()
+	}
+	if l.CurrentChar == '\'' || l.CurrentChar == '#' {
+		return l.StringConst()
+	}
+	if l.CurrentChar == ':' && l.Peek() == '=' {
+		l.Advance()
+		l.Advance()
+		return Token{Type: ASSIGN}
+	}
+	if l.CurrentChar == '.' && l.Peek() == '.' {
+		l.Advance()
+		l.Advance()
+		return Token{Type: DOTDOT}
+	}
+	if l.CurrentChar == '<' || l.CurrentChar == '>' {
+		return l.relation()
+	}
+	switch l.CurrentChar {
+	case ';':
+		l.Advance()
+		return Token{Type: SEMI}
+	case ':':
+		l.Advance()
+		return Token{Type: COLON}
+	case ',':
+		l.Advance()
+		return Token{Type: COMMA}
+	case '=':
+		l.Advance()
+		return Token{Type: EQUAL}
+	case '+':
+		l.Advance()
+		return Token{Type: PLUS}
+	case '-':
+		l.Advance()
+		return Token{Type: MINUS}
+	case '*':
+		l.Advance()
+		return Token{Type: MUL}
+	case '/':
+		l.Advance()
+		return Token{Type: FLOATDIV}
+	case '(':
+		l.Advance()
+		return Token{Type: LPAREN}
+	case ')':
+		l.Advance()
+		return Token{Type: RPAREN}
+	case '[':
+		l.Advance()
+		return Token{Type: LBRACKET}
+	case '^':
+		l.Advance()
+		return Token{Type: CARET}
+	case ']':
+		l.Advance()
+		return Token{Type: RBRACKET}
+	case '.':
+		l.Advance()
+		return Token{Type: DOT}
+	}
+	l.Error()
+	return Token{}
+}
+
+// StringConst ...
+// Handle quoted strings, in which a doubled quote stands for one,
+// and #n character codes. Quoted strings and codes that follow each
+// other make up a single string, as in 'one'#13#10'two'.
+func (l *Lexer) StringConst() Token {
+	var buffer []byte
+	for l.CurrentChar == '\'' || l.CurrentChar == '#' {
+		if l.CurrentChar == '#' {
 			l.Advance()
-			l.SkipComment()
+			if !isDigit(l.CurrentChar) {
+				l.Error()
+			}
+			tok := Token{Line: l.Line, Column: l.Column}
+			code := 0
+			for isDigit(l.CurrentChar) {
+				code = code*10 + int(l.CurrentChar-'0')
+				l.Advance()
+			}
+			if code > MaxSetElement {
+				panic(&Error{Kind: LexerError, Tok: tok, Message: fmt.Sprintf("character code %d out of range 0..%d", code, MaxSetElement)})
+			}
+			buffer = append(buffer, byte(code))
 			continue
-		case ';':
-			l.Advance()
-			return Token{Type: SEMI}
-		case ':':
-			l.Advance()
-			return Token{Type: COLON}
-		case ',':
-			l.Advance()
-			return Token{Type: COMMA}
-		case '+':
-			l.Advance()
-			return Token{Type: PLUS}
-		case '-':
-			l.Advance()
-			return Token{Type: MINUS}
-		case '*':
-			l.Advance()
-			return Token{Type: MUL}
-		case '/':
-			l.Advance()
-			return Token{Type: FLOATDIV}
-		case '(':
-			l.Advance()
-			return Token{Type: LPAREN}
-		case ')':
-			l.Advance()
-			return Token{Type: RPAREN}
-		case '.':
+		}
+		l.Advance()
+		for l.CurrentChar != '\'' || l.Peek() == '\'' {
+			if l.CurrentChar == 0 || l.CurrentChar == '\n' {
+				tok := Token{Line: l.Line, Column: l.Column}
+				panic(&Error{Kind: LexerError, Tok: tok, Message: "unterminated string"})
+			}
+			if l.CurrentChar == '\'' {
+				l.Advance()
+			}
+			buffer = append(buffer, l.CurrentChar)
 			l.Advance()
-			return Token{Type: DOT}
-		default:
-			l.Error()
 		}
+		l.Advance()
+	}
+	return Token{Type: STRINGCONST, Svalue: string(buffer)}
+}
+
+// relation ...
+// Handle the relational operators starting with < or >
+func (l *Lexer) relation() Token {
+	first := l.CurrentChar
+	l.Advance()
+	switch {
+	case first == '<' && l.CurrentChar == '=':
+		l.Advance()
+		return Token{Type: LESSEQUAL}
+	case first == '<' && l.CurrentChar == '>':
+		l.Advance()
+		return Token{Type: NOTEQUAL}
+	case first == '<':
+		return Token{Type: LESS}
+	case l.CurrentChar == '=':
+		l.Advance()
+		return Token{Type: GREATEREQUAL}
 	}
-	return Token{Type: EOF}
+	return Token{Type: GREATER}
 }
 
 func isDigit(b byte) bool {
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LSPServer ...
+// Language Server Protocol server speaking JSON-RPC over a pair of
+// streams, stdin and stdout for `spi lsp`. Documents are synced in
+// full and re-analyzed on every change.
+type LSPServer struct {
+	// Handlers maps a method name to its handler. Notifications
+	// are handled the same way and their result is dropped.
+	Handlers map[string]func(params json.RawMessage) (interface{}, error)
+
+	reader    *bufio.Reader
+	writer    io.Writer
+	documents map[string]*lspDocument
+}
+
+// lspDocument ...
+// An open text document and the result of analyzing it. Tree and
+// Analyzer are nil when the text does not parse.
+type lspDocument struct {
+	Text     string
+	Tree     Node
+	Analyzer *SemanticAnalyzer
+}
+
+// JSON-RPC messages
+
+type rpcRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   rpcError         `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// Protocol types, only the fields that are used
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Position     lspPosition               `json:"position"`
+}
+
+// Diagnostic severities and symbol kinds
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+
+	lspSymbolModule   = 2
+	lspSymbolFunction = 12
+	lspSymbolVariable = 13
+)
+
+// NewLSPServer ...
+func NewLSPServer(r io.Reader, w io.Writer) *LSPServer {
+	s := &LSPServer{
+		reader:    bufio.NewReader(r),
+		writer:    w,
+		documents: make(map[string]*lspDocument),
+	}
+	s.Handlers = make(map[string]func(params json.RawMessage) (interface{}, error))
+	s.Handlers["initialize"] = s.Initialize
+	s.Handlers["initialized"] = s.Initialized
+	s.Handlers["shutdown"] = s.Shutdown
+	s.Handlers["textDocument/didOpen"] = s.DidOpen
+	s.Handlers["textDocument/didChange"] = s.DidChange
+	s.Handlers["textDocument/didClose"] = s.DidClose
+	s.Handlers["textDocument/definition"] = s.Definition
+	s.Handlers["textDocument/references"] = s.References
+	s.Handlers["textDocument/hover"] = s.Hover
+	s.Handlers["textDocument/documentSymbol"] = s.DocumentSymbol
+	s.Handlers["textDocument/formatting"] = s.Formatting
+	return s
+}
+
+// Serve ...
+// Handles messages until the client sends exit or closes the stream.
+func (s *LSPServer) Serve() error {
+	for {
+		body, err := readMessage(s.reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request rpcRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			s.replyError(nil, rpcParseError, err.Error())
+			continue
+		}
+		if request.Method == "exit" {
+			return nil
+		}
+		handler, exists := s.Handlers[request.Method]
+		if !exists {
+			if request.ID != nil {
+				s.replyError(request.ID, rpcMethodNotFound, "method not found: "+request.Method)
+			}
+			continue
+		}
+		result, code, err := s.call(handler, request.Params)
+		if request.ID == nil {
+			continue
+		}
+		if err != nil {
+			s.replyError(request.ID, code, err.Error())
+			continue
+		}
+		s.write(rpcResponse{JSONRPC: "2.0", ID: request.ID, Result: result})
+	}
+}
+
+// call ...
+// Runs a handler and returns the error code to reply with when it
+// fails. A handler that panics fails with an internal error instead
+// of stopping the server.
+func (s *LSPServer) call(handler func(params json.RawMessage) (interface{}, error), params json.RawMessage) (result interface{}, code int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, code, err = nil, rpcInternalError, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	result, err = handler(params)
+	return result, rpcInvalidParams, err
+}
+
+// readMessage ...
+// Reads the headers and returns the body of the next message.
+// LSP and DAP share this base protocol.
+func readMessage(reader *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(reader, body)
+	return body, err
+}
+
+// writeMessage ...
+// Writes message as JSON with the base protocol header
+func writeMessage(w io.Writer, message interface{}) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *LSPServer) write(message interface{}) {
+	writeMessage(s.writer, message)
+}
+
+func (s *LSPServer) replyError(id *json.RawMessage, code int, message string) {
+	s.write(rpcErrorResponse{JSONRPC: "2.0", ID: id, Error: rpcError{Code: code, Message: message}})
+}
+
+func (s *LSPServer) notify(method string, params interface{}) {
+	s.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Initialize ...
+func (s *LSPServer) Initialize(params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":           1, // full
+			"definitionProvider":         true,
+			"referencesProvider":         true,
+			"hoverProvider":              true,
+			"documentSymbolProvider":     true,
+			"documentFormattingProvider": true,
+		},
+		"serverInfo": map[string]string{"name": "spi"},
+	}, nil
+}
+
+// Initialized ...
+func (s *LSPServer) Initialized(params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// Shutdown ...
+func (s *LSPServer) Shutdown(params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// DidOpen ...
+func (s *LSPServer) DidOpen(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	s.analyze(p.TextDocument.URI, p.TextDocument.Text)
+	return nil, nil
+}
+
+// DidChange ...
+// With full sync the last change holds the whole text
+func (s *LSPServer) DidChange(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument   lspTextDocumentIdentifier `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.ContentChanges) > 0 {
+		s.analyze(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	}
+	return nil, nil
+}
+
+// DidClose ...
+func (s *LSPServer) DidClose(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	delete(s.documents, p.TextDocument.URI)
+	s.publishDiagnostics(p.TextDocument.URI, []lspDiagnostic{})
+	return nil, nil
+}
+
+// analyze ...
+// Parses and checks the document and publishes the diagnostics.
+// Data-flow warnings are only reported for programs without errors.
+func (s *LSPServer) analyze(uri, text string) {
+	doc := &lspDocument{Text: text}
+	s.documents[uri] = doc
+	diagnostics := []lspDiagnostic{}
+	tree, err := ParseSource(text)
+	if err != nil {
+		e := err.(*Error)
+		diagnostics = append(diagnostics, lspDiagnostic{tokenRange(e.Tok), lspSeverityError, "spi", e.Message})
+		s.publishDiagnostics(uri, diagnostics)
+		return
+	}
+	doc.Tree = tree
+	doc.Analyzer = NewSemanticAnalyzer()
+	for _, e := range doc.Analyzer.Analyze(tree) {
+		diagnostics = append(diagnostics, lspDiagnostic{tokenRange(e.Tok), lspSeverityError, "spi", e.Message})
+	}
+	if len(diagnostics) == 0 {
+		for _, w := range AnalyzeDataFlow(tree) {
+			diagnostics = append(diagnostics, lspDiagnostic{tokenRange(w.Tok), lspSeverityWarning, "spi", w.Message})
+		}
+	}
+	s.publishDiagnostics(uri, diagnostics)
+}
+
+func (s *LSPServer) publishDiagnostics(uri string, diagnostics []lspDiagnostic) {
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+}
+
+// symbolAt ...
+// Decodes position params and looks up the symbol under the cursor
+func (s *LSPServer) symbolAt(params json.RawMessage) (string, *lspDocument, *Symbol, error) {
+	var p lspTextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return "", nil, nil, err
+	}
+	doc, exists := s.documents[p.TextDocument.URI]
+	if !exists || doc.Analyzer == nil {
+		return p.TextDocument.URI, doc, nil, nil
+	}
+	symbol := doc.Analyzer.SymbolAt(p.Position.Line+1, p.Position.Character+1)
+	return p.TextDocument.URI, doc, symbol, nil
+}
+
+// Definition ...
+// The builtins are declared nowhere in the source and have none
+func (s *LSPServer) Definition(params json.RawMessage) (interface{}, error) {
+	uri, _, symbol, err := s.symbolAt(params)
+	if err != nil || symbol == nil || symbol.Tok.Line == 0 {
+		return nil, err
+	}
+	return lspLocation{URI: uri, Range: tokenRange(symbol.Tok)}, nil
+}
+
+// References ...
+func (s *LSPServer) References(params json.RawMessage) (interface{}, error) {
+	uri, doc, symbol, err := s.symbolAt(params)
+	if err != nil || symbol == nil {
+		return nil, err
+	}
+	var p struct {
+		Context struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		} `json:"context"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	locations := []lspLocation{}
+	for _, tok := range doc.Analyzer.References[symbol] {
+		declaration := tok.Line == symbol.Tok.Line && tok.Column == symbol.Tok.Column
+		if declaration && !p.Context.IncludeDeclaration {
+			continue
+		}
+		locations = append(locations, lspLocation{URI: uri, Range: tokenRange(tok)})
+	}
+	return locations, nil
+}
+
+// Hover ...
+// Shows the declaration of the symbol under the cursor
+func (s *LSPServer) Hover(params json.RawMessage) (interface{}, error) {
+	_, _, symbol, err := s.symbolAt(params)
+	if err != nil || symbol == nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{
+			"kind":  "markdown",
+			"value": "```pascal\n" + symbol.String() + "\n```",
+		},
+	}, nil
+}
+
+// DocumentSymbol ...
+// The program with its variables and procedures as children, and
+// the procedures with theirs
+func (s *LSPServer) DocumentSymbol(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, exists := s.documents[p.TextDocument.URI]
+	if !exists || doc.Analyzer == nil {
+		return []lspDocumentSymbol{}, nil
+	}
+	var result []lspDocumentSymbol
+	for _, scope := range doc.Analyzer.Scopes {
+		for _, symbol := range scope.Symbols {
+			if symbol.Kind != ProgramSymbol {
+				continue
+			}
+			program := lspDocumentSymbol{
+				Name:           symbol.Name,
+				Kind:           lspSymbolModule,
+				Range:          lspRange{End: endPosition(doc.Text)},
+				SelectionRange: tokenRange(symbol.Tok),
+			}
+			program.Children = s.scopeSymbols(doc.Analyzer, innerScope(doc.Analyzer, scope, "global"))
+			result = append(result, program)
+		}
+	}
+	return result, nil
+}
+
+// scopeSymbols ...
+// The variables, procedures and functions declared in a scope, in
+// order
+func (s *LSPServer) scopeSymbols(sa *SemanticAnalyzer, scope *ScopedSymbolTable) []lspDocumentSymbol {
+	var symbols []lspDocumentSymbol
+	if scope == nil {
+		return symbols
+	}
+	for _, symbol := range scope.Symbols {
+		switch symbol.Kind {
+		case VarSymbol:
+			detail := ""
+			if symbol.Type != nil {
+				detail = symbol.Type.Name
+			}
+			symbols = append(symbols, lspDocumentSymbol{
+				Name:           symbol.Name,
+				Detail:         detail,
+				Kind:           lspSymbolVariable,
+				Range:          tokenRange(symbol.Tok),
+				SelectionRange: tokenRange(symbol.Tok),
+			})
+		case ProcedureSymbol, FunctionSymbol:
+			symbols = append(symbols, lspDocumentSymbol{
+				Name:           symbol.Name,
+				Detail:         symbol.String(),
+				Kind:           lspSymbolFunction,
+				Range:          tokenRange(symbol.Tok),
+				SelectionRange: tokenRange(symbol.Tok),
+				Children:       s.scopeSymbols(sa, innerScope(sa, scope, symbol.Name)),
+			})
+		}
+	}
+	return symbols
+}
+
+// innerScope ...
+// The scope named name that scope encloses, nil if there is none
+func innerScope(sa *SemanticAnalyzer, scope *ScopedSymbolTable, name string) *ScopedSymbolTable {
+	for _, inner := range sa.Scopes {
+		if inner.Enclosing == scope && inner.ScopeName == name {
+			return inner
+		}
+	}
+	return nil
+}
+
+// Formatting ...
+// Replaces the whole document with the output of the Formatter.
+// Documents that do not parse are left alone.
+func (s *LSPServer) Formatting(params json.RawMessage) (interface{}, error) {
+	var p struct {
+		TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	doc, exists := s.documents[p.TextDocument.URI]
+	if !exists || doc.Tree == nil {
+		return nil, nil
+	}
+	formatted := NewFormatter().Format(doc.Tree)
+	if formatted == doc.Text {
+		return []lspTextEdit{}, nil
+	}
+	return []lspTextEdit{{Range: lspRange{End: endPosition(doc.Text)}, NewText: formatted}}, nil
+}
+
+// tokenRange ...
+// Token positions start at 1, LSP positions at 0
+func tokenRange(tok Token) lspRange {
+	start := lspPosition{Line: tok.Line - 1, Character: tok.Column - 1}
+	end := lspPosition{Line: start.Line, Character: start.Character + len(tok.Text)}
+	return lspRange{Start: start, End: end}
+}
+
+// endPosition ...
+// Position just past the last character of text
+func endPosition(text string) lspPosition {
+	line := strings.Count(text, "\n")
+	return lspPosition{Line: line, Character: len(text) - (strings.LastIndex(text, "\n") + 1)}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// rpcMessage ...
+// Any message a server writes, decoded as far as the tests need
+type rpcMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Event  string          `json:"event"`
+	Result json.RawMessage `json:"result"`
+	Params json.RawMessage `json:"params"`
+	Error  *rpcError       `json:"error"`
+}
+
+// frame ...
+// The messages with their Content-Length headers, one after another
+func frame(messages ...interface{}) *bytes.Buffer {
+	var in bytes.Buffer
+	for _, message := range messages {
+		writeMessage(&in, message)
+	}
+	return &in
+}
+
+// readMessages ...
+// Decodes every message in out
+func readMessages(t *testing.T, out *bytes.Buffer) []rpcMessage {
+	t.Helper()
+	reader := bufio.NewReader(out)
+	var messages []rpcMessage
+	for reader.Buffered() > 0 || out.Len() > 0 {
+		body, err := readMessage(reader)
+		if err != nil {
+			t.Fatalf("reading message: %s", err)
+		}
+		var message rpcMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatalf("decoding %s: %s", body, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func request(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func notification(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+const lspTestURI = "file:///test.pas"
+
+const lspTestProgram = `PROGRAM P;
+VAR
+   x : INTEGER;
+BEGIN
+   x := 1
+END.
+`
+
+func TestLSPSession(t *testing.T) {
+	document := map[string]string{"uri": lspTestURI}
+	in := frame(
+		request(1, "initialize", map[string]interface{}{}),
+		notification("initialized", map[string]interface{}{}),
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": lspTestURI, "languageId": "pascal", "version": 1, "text": lspTestProgram},
+		}),
+		request(2, "textDocument/definition", map[string]interface{}{
+			"textDocument": document,
+			"position":     lspPosition{Line: 4, Character: 3},
+		}),
+		notification("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": lspTestURI, "version": 2},
+			"contentChanges": []map[string]string{{"text": strings.Replace(lspTestProgram, "x := 1", "x := y", 1)}},
+		}),
+		request(3, "textDocument/unknown", map[string]interface{}{}),
+		request(4, "shutdown", nil),
+		notification("exit", nil),
+	)
+	var out bytes.Buffer
+	if err := NewLSPServer(in, &out).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	messages := readMessages(t, &out)
+
+	var responses = make(map[int]rpcMessage)
+	var diagnostics [][]lspDiagnostic
+	for _, message := range messages {
+		switch {
+		case message.ID != nil:
+			responses[*message.ID] = message
+		case message.Method == "textDocument/publishDiagnostics":
+			var params struct {
+				URI         string          `json:"uri"`
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			}
+			if err := json.Unmarshal(message.Params, &params); err != nil {
+				t.Fatal(err)
+			}
+			if params.URI != lspTestURI {
+				t.Errorf("diagnostics for %s, want %s", params.URI, lspTestURI)
+			}
+			diagnostics = append(diagnostics, params.Diagnostics)
+		}
+	}
+
+	var initialize struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(responses[1].Result, &initialize); err != nil {
+		t.Fatal(err)
+	}
+	if initialize.Capabilities["definitionProvider"] != true {
+		t.Errorf("initialize: capabilities %v lack definitionProvider", initialize.Capabilities)
+	}
+
+	if len(diagnostics) != 2 {
+		t.Fatalf("got %d publishDiagnostics, want 2", len(diagnostics))
+	}
+	for _, d := range diagnostics[0] {
+		if d.Severity == lspSeverityError {
+			t.Errorf("didOpen: unexpected error %q", d.Message)
+		}
+	}
+	if len(diagnostics[1]) != 1 || diagnostics[1][0].Severity != lspSeverityError || !strings.Contains(diagnostics[1][0].Message, "'y'") {
+		t.Errorf("didChange: diagnostics %+v, want one error about 'y'", diagnostics[1])
+	} else if start := diagnostics[1][0].Range.Start; start != (lspPosition{Line: 4, Character: 8}) {
+		t.Errorf("didChange: error at %+v, want line 4 character 8", start)
+	}
+
+	var location lspLocation
+	if err := json.Unmarshal(responses[2].Result, &location); err != nil {
+		t.Fatal(err)
+	}
+	want := lspLocation{URI: lspTestURI, Range: lspRange{Start: lspPosition{2, 3}, End: lspPosition{2, 4}}}
+	if location != want {
+		t.Errorf("definition: got %+v, want %+v", location, want)
+	}
+
+	if e := responses[3].Error; e == nil || e.Code != rpcMethodNotFound {
+		t.Errorf("unknown method: got error %+v, want code %d", e, rpcMethodNotFound)
+	}
+	if _, ok := responses[4]; !ok {
+		t.Errorf("shutdown: no response")
+	}
+}
+
+func TestLSPRecovers(t *testing.T) {
+	in := frame(
+		request(1, "test/panic", nil),
+		notification("test/panic", nil),
+		request(2, "shutdown", nil),
+	)
+	var out bytes.Buffer
+	server := NewLSPServer(in, &out)
+	server.Handlers["test/panic"] = func(params json.RawMessage) (interface{}, error) {
+		var document *lspDocument
+		return document.Text, nil
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	messages := readMessages(t, &out)
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want a reply to each request", len(messages))
+	}
+	if e := messages[0].Error; e == nil || e.Code != rpcInternalError || !strings.Contains(e.Message, "nil pointer") {
+		t.Errorf("panicking request: got error %+v, want code %d", e, rpcInternalError)
+	}
+	if messages[1].ID == nil || *messages[1].ID != 2 || messages[1].Error != nil {
+		t.Errorf("shutdown: got %+v after the panics", messages[1])
+	}
+}
+
+const lspNavigationProgram = `PROGRAM P;
+VAR
+   x, y : INTEGER;
+PROCEDURE Inc(VAR n : INTEGER);
+BEGIN
+   n := n + 1
+END;
+BEGIN
+   x := Ord('a');
+   y   :=   Ord('b');
+   Inc(x)
+END.
+`
+
+func TestLSPNavigation(t *testing.T) {
+	document := map[string]string{"uri": lspTestURI}
+	at := func(line, character int) map[string]interface{} {
+		return map[string]interface{}{"textDocument": document, "position": lspPosition{Line: line, Character: character}}
+	}
+	references := func(line, character int, declaration bool) map[string]interface{} {
+		params := at(line, character)
+		params["context"] = map[string]bool{"includeDeclaration": declaration}
+		return params
+	}
+	in := frame(
+		request(1, "initialize", map[string]interface{}{}),
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": lspTestURI, "languageId": "pascal", "version": 1, "text": lspNavigationProgram},
+		}),
+		request(2, "textDocument/hover", at(8, 3)),
+		request(3, "textDocument/hover", at(8, 9)),
+		request(4, "textDocument/definition", at(8, 9)),
+		request(5, "textDocument/references", references(8, 3, false)),
+		request(6, "textDocument/references", references(8, 3, true)),
+		request(7, "textDocument/references", references(8, 9, false)),
+		request(8, "textDocument/documentSymbol", map[string]interface{}{"textDocument": document}),
+		request(9, "textDocument/formatting", map[string]interface{}{"textDocument": document}),
+		notification("textDocument/didClose", map[string]interface{}{"textDocument": document}),
+		request(10, "textDocument/hover", at(8, 3)),
+		request(11, "shutdown", nil),
+		notification("exit", nil),
+	)
+	var out bytes.Buffer
+	if err := NewLSPServer(in, &out).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	responses := make(map[int]rpcMessage)
+	var diagnostics []json.RawMessage
+	for _, message := range readMessages(t, &out) {
+		switch {
+		case message.ID != nil:
+			responses[*message.ID] = message
+		case message.Method == "textDocument/publishDiagnostics":
+			diagnostics = append(diagnostics, message.Params)
+		}
+	}
+	result := func(id int, v interface{}) {
+		t.Helper()
+		response, ok := responses[id]
+		if !ok || response.Error != nil {
+			t.Fatalf("request %d: got %+v, want a result", id, response)
+		}
+		if err := json.Unmarshal(response.Result, v); err != nil {
+			t.Fatalf("request %d: %s", id, err)
+		}
+	}
+	position := func(line, character, length int) lspLocation {
+		start := lspPosition{Line: line, Character: character}
+		return lspLocation{URI: lspTestURI, Range: lspRange{Start: start, End: lspPosition{Line: line, Character: character + length}}}
+	}
+
+	var hover struct {
+		Contents struct {
+			Value string `json:"value"`
+		} `json:"contents"`
+	}
+	result(2, &hover)
+	if !strings.Contains(hover.Contents.Value, "VAR x : INTEGER") {
+		t.Errorf("hover on x: got %q", hover.Contents.Value)
+	}
+	result(3, &hover)
+	if !strings.Contains(hover.Contents.Value, "FUNCTION Ord") {
+		t.Errorf("hover on Ord: got %q", hover.Contents.Value)
+	}
+
+	if got := string(responses[4].Result); got != "null" {
+		t.Errorf("definition of a builtin: got %s, want null", got)
+	}
+
+	var locations []lspLocation
+	result(5, &locations)
+	if want := []lspLocation{position(8, 3, 1), position(10, 7, 1)}; !equalLocations(locations, want) {
+		t.Errorf("references to x: got %+v, want %+v", locations, want)
+	}
+	result(6, &locations)
+	if want := []lspLocation{position(2, 3, 1), position(8, 3, 1), position(10, 7, 1)}; !equalLocations(locations, want) {
+		t.Errorf("references to x with its declaration: got %+v, want %+v", locations, want)
+	}
+	result(7, &locations)
+	if want := []lspLocation{position(8, 8, 3), position(9, 12, 3)}; !equalLocations(locations, want) {
+		t.Errorf("references to a builtin: got %+v, want %+v", locations, want)
+	}
+
+	var symbols []lspDocumentSymbol
+	result(8, &symbols)
+	if len(symbols) != 1 || symbols[0].Name != "P" {
+		t.Fatalf("documentSymbol: got %+v, want the program P", symbols)
+	}
+	var names []string
+	for _, symbol := range symbols[0].Children {
+		names = append(names, symbol.Name)
+		for _, child := range symbol.Children {
+			names = append(names, symbol.Name+"."+child.Name)
+		}
+	}
+	if got := strings.Join(names, " "); got != "x y Inc Inc.n" {
+		t.Errorf("documentSymbol: got %s, want x y Inc Inc.n", got)
+	}
+
+	var edits []lspTextEdit
+	result(9, &edits)
+	tree, err := ParseSource(lspNavigationProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted := NewFormatter().Format(tree)
+	if len(edits) != 1 || edits[0].NewText != formatted || edits[0].Range.End != endPosition(lspNavigationProgram) {
+		t.Errorf("formatting: got %+v, want the whole document replaced by\n%s", edits, formatted)
+	}
+
+	if len(diagnostics) != 2 || !strings.Contains(string(diagnostics[1]), `"diagnostics":[]`) {
+		t.Errorf("didClose: got diagnostics %s, want them cleared", diagnostics)
+	}
+	if got := string(responses[10].Result); got != "null" {
+		t.Errorf("hover on a closed document: got %s, want null", got)
+	}
+}
+
+// equalLocations ...
+// Reports whether got and want hold the same locations in order
+func equalLocations(got, want []lspLocation) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
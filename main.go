@@ -1,17 +1,70 @@
 package main
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
 
 // program : PROGRAM variable SEMI block DOT
 //
 //     block : declarations compound_statement
 //
-//     declarations : VAR (variable_declaration SEMI)+
-//                  | empty
+//     declarations : (CONST (const_declaration SEMI)+
+//                    | TYPE (type_declaration SEMI)+
+//                    | VAR (variable_declaration SEMI)+
+//                    | procedure_declaration)*
+//
+//     const_declaration : ID EQUAL expr
+//
+//     type_declaration : ID EQUAL type_spec
+//
+//     procedure_declaration : (PROCEDURE | FUNCTION) ID signature SEMI (block | FORWARD) SEMI
+//
+//     signature : formal_parameter_list? (COLON type_name)?
+//
+//     formal_parameter_list : LPAREN formal_parameters (SEMI formal_parameters)* RPAREN
+//
+//     formal_parameters : (VAR | CONST)? ID (COMMA ID)* COLON type_name
+//                       | (PROCEDURE | FUNCTION) ID signature
+//
+//     type_name : ID | INTEGER | REAL | BOOLEAN | CHAR | STRING | TEXT
 //
 //     variable_declaration : ID (COMMA ID)* COLON type_spec
 //
 //     type_spec : INTEGER
+//               | REAL
+//               | BOOLEAN
+//               | CHAR
+//               | STRING
+//               | TEXT
+//               | ID
+//               | enum_type
+//               | subrange
+//               | array_type
+//               | record_type
+//               | set_type
+//               | pointer_type
+//               | procedural_type
+//               | file_type
+//
+//     enum_type : LPAREN ID (COMMA ID)* RPAREN
+//
+//     subrange : expr DOTDOT expr
+//
+//     array_type : ARRAY LBRACKET type_spec (COMMA type_spec)* RBRACKET OF type_spec
+//
+//     set_type : SET OF type_spec
+//
+//     pointer_type : CARET type_name
+//
+//     procedural_type : (PROCEDURE | FUNCTION) signature
+//
+//     file_type : FILE OF type_spec
+//
+//     record_type : RECORD variable_declaration (SEMI variable_declaration)* SEMI? END
 //
 //     compound_statement : BEGIN statement_list END
 //
@@ -20,13 +73,33 @@ import "fmt"
 //
 //     statement : compound_statement
 //               | assignment_statement
+//               | with_statement
+//               | for_statement
+//               | case_statement
+//               | procedure_call_statement
 //               | empty
 //
 //     assignment_statement : variable ASSIGN expr
 //
+//     with_statement : WITH variable (COMMA variable)* DO statement
+//
+//     for_statement : FOR ID ASSIGN expr (TO | DOWNTO) expr DO statement
+//
+//     procedure_call_statement : ID (LPAREN expr (COMMA expr)* RPAREN)?
+//
+//     case_statement : CASE expr OF case_arm (SEMI case_arm)* SEMI?
+//                      ((ELSE | OTHERWISE) statement_list)? END
+//
+//     case_arm : expr_range (COMMA expr_range)* COLON statement
+//
+//     expr_range : expr (DOTDOT expr)?
+//
 //     empty :
 //
-//     expr : term ((PLUS | MINUS) term)*
+//     expr : simple_expr ((EQUAL | NOTEQUAL | LESS | LESS_EQUAL
+//                          | GREATER | GREATER_EQUAL | IN) simple_expr)?
+//
+//     simple_expr : term ((PLUS | MINUS) term)*
 //
 //     term : factor ((MUL | INTEGER_DIV | FLOAT_DIV) factor)*
 //
@@ -34,10 +107,14 @@ import "fmt"
 //            | MINUS factor
 //            | INTEGER_CONST
 //            | REAL_CONST
+//            | STRING_CONST
 //            | LPAREN expr RPAREN
+//            | LBRACKET (expr_range (COMMA expr_range)*)? RBRACKET
+//            | NIL
+//            | ID LPAREN expr (COMMA expr)* RPAREN
 //            | variable
 //
-// 	variable: ID
+// 	variable: ID (LBRACKET expr (COMMA expr)* RBRACKET | DOT ID | CARET)*
 
 var pascalsample1 = `PROGRAM Part10;
 VAR
@@ -74,20 +151,260 @@ BEGIN {Part10AST}
 END.  {Part10AST}`
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			fmtCommand(os.Args[2:])
+			return
+		case "run":
+			runCommand(os.Args[2:])
+			return
+		case "cover":
+			coverCommand(os.Args[2:])
+			return
+		case "debug":
+			debugCommand(os.Args[2:])
+			return
+		case "dap":
+			if err := NewDAPServer(os.Stdin, os.Stdout).Serve(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "lsp":
+			if err := NewLSPServer(os.Stdin, os.Stdout).Serve(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "usage: spi [run [--trace] file.pas | cover [--html file] file.pas | fmt file.pas... | debug file.pas | dap | lsp]\n")
+			os.Exit(2)
+		}
+	}
+
 	lexer := NewLexer(pascalsample2)
 	parser := NewParser(lexer)
 	tree := parser.Parse()
 
-	interpreter := NewInterpreter()
-	interpreter.Interpret(tree)
+	analyzer := NewSemanticAnalyzer()
+	if errs := analyzer.Analyze(tree); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
 
-	fmt.Printf("GLOBALSCOPE Table\n")
-	fmt.Printf("-----------------\n")
+	for _, warning := range AnalyzeDataFlow(tree) {
+		fmt.Println(warning)
+	}
 
-	for str, val := range interpreter.GLOBALSCOPE {
-		fmt.Printf("%-10s | %f\n", str, val)
+	interpreter := NewInterpreter()
+	if err := interpreter.Interpret(tree); err != nil {
+		fmt.Println(err)
 	}
+	printGlobals(os.Stdout, interpreter, analyzer)
 
 	av := NewASTVisualizer()
 	av.Generate(tree)
+
+	GenerateCFG(NewCFGBuilder().Build(tree))
+}
+
+// fmtCommand ...
+// spi fmt file.pas...
+// Prints each file canonically formatted to stdout
+func fmtCommand(files []string) {
+	for _, file := range files {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		tree, err := ParseSource(string(text))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			os.Exit(1)
+		}
+		fmt.Print(NewFormatter().Format(tree))
+	}
+}
+
+// loadProgram ...
+// Reads, parses and checks a source file, exiting on errors. The
+// analyzer that checked it is returned as well.
+func loadProgram(file string) (string, Node, *SemanticAnalyzer) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	tree, err := ParseSource(string(text))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+		os.Exit(1)
+	}
+	analyzer := NewSemanticAnalyzer()
+	if errs := analyzer.Analyze(tree); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+		}
+		os.Exit(1)
+	}
+	return string(text), tree, analyzer
+}
+
+// coverCommand ...
+// spi cover [--html file] file.pas
+// Interprets the program and prints its coverage summary and the
+// annotated source, optionally writing an HTML report as well. The
+// coverage of a program stopped by a runtime error is still reported.
+func coverCommand(args []string) {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	htmlFile := flags.String("html", "", "write an HTML coverage report to `file`")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: spi cover [--html file] file.pas\n")
+		os.Exit(2)
+	}
+	source, tree, _ := loadProgram(flags.Arg(0))
+
+	interpreter := NewInterpreter()
+	coverage := NewCoverage(tree)
+	interpreter.Observe(coverage)
+	runErr := interpreter.Interpret(tree)
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(0), runErr)
+	}
+
+	coverage.WriteSummary(os.Stdout)
+	fmt.Println()
+	coverage.WriteAnnotated(os.Stdout, source)
+	if *htmlFile != "" {
+		f, err := os.Create(*htmlFile)
+		if err == nil {
+			err = coverage.WriteHTML(f, flags.Arg(0), source)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
+
+// debugCommand ...
+// spi debug file.pas
+// Runs the program under the debugger reading commands from stdin
+func debugCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: spi debug file.pas\n")
+		os.Exit(2)
+	}
+	text, tree, _ := loadProgram(args[0])
+	debugger := NewDebugger(NewInterpreter(), text, os.Stdin, os.Stdout)
+	if err := debugger.Run(tree); err != nil {
+		os.Exit(1)
+	}
+}
+
+// printGlobals ...
+// Writes the program's variables that have a value sorted by name,
+// each spelled by the type the analyzer found for it. Constants,
+// enumeration names and types are left out.
+func printGlobals(w io.Writer, interpreter *Interpreter, analyzer *SemanticAnalyzer) {
+	fmt.Fprintf(w, "GLOBALSCOPE Table\n")
+	fmt.Fprintf(w, "-----------------\n")
+
+	var variables []*Symbol
+	for _, symbol := range analyzer.GlobalScope().Symbols {
+		if _, exists := interpreter.GLOBALSCOPE[symbol.Name]; exists && symbol.Kind == VarSymbol {
+			variables = append(variables, symbol)
+		}
+	}
+	sort.Slice(variables, func(i, j int) bool {
+		return variables[i].Name < variables[j].Name
+	})
+	for _, symbol := range variables {
+		val := interpreter.GLOBALSCOPE[symbol.Name]
+		text := formatValue(val)
+		if symbol.Type != nil {
+			text = symbol.Type.Spell(val)
+		}
+		fmt.Fprintf(w, "%-10s | %s\n", symbol.Name, text)
+	}
+}
+
+// runCommand ...
+// spi run [--trace] [--trace-format text|json] [--profile] [--pprof file] file.pas
+// Interprets the program and prints its global variables. The
+// data-flow warnings, the trace of executed statements, the profile
+// report and the heap variables the program never disposed go to
+// stderr.
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	trace := flags.Bool("trace", false, "trace executed statements to stderr")
+	traceFormat := flags.String("trace-format", "text", "trace format, text or json")
+	profile := flags.Bool("profile", false, "write a profile report sorted by cost to stderr")
+	pprofFile := flags.String("pprof", "", "write a pprof profile to `file`")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: spi run [--trace] [--trace-format text|json] [--profile] [--pprof file] file.pas\n")
+		os.Exit(2)
+	}
+	_, tree, analyzer := loadProgram(flags.Arg(0))
+	for _, warning := range AnalyzeDataFlow(tree) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(0), warning)
+	}
+
+	interpreter := NewInterpreter()
+	var tracer *Tracer
+	if *trace {
+		switch *traceFormat {
+		case "text":
+			tracer = NewTracer(os.Stderr, TraceText)
+		case "json":
+			tracer = NewTracer(os.Stderr, TraceJSON)
+		default:
+			fmt.Fprintf(os.Stderr, "unknown trace format %q\n", *traceFormat)
+			os.Exit(2)
+		}
+		tracer.Types = analyzer.ExprTypes
+		interpreter.Observe(tracer)
+	}
+	var profiler *Profiler
+	if *profile || *pprofFile != "" {
+		profiler = NewProfiler(flags.Arg(0))
+		interpreter.Observe(profiler)
+	}
+	err := interpreter.Interpret(tree)
+	if tracer != nil && tracer.Err != nil {
+		fmt.Fprintf(os.Stderr, "%s: trace: %s\n", flags.Arg(0), tracer.Err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(0), err)
+		os.Exit(1)
+	}
+	for _, leak := range interpreter.Heap.Leaks() {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flags.Arg(0), leak)
+	}
+	printGlobals(os.Stdout, interpreter, analyzer)
+
+	if *profile {
+		profiler.WriteReport(os.Stderr)
+	}
+	if *pprofFile != "" {
+		f, err := os.Create(*pprofFile)
+		if err == nil {
+			err = profiler.WritePprof(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
 }
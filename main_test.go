@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintGlobals(t *testing.T) {
+	tree, err := ParseSource(`PROGRAM G;
+CONST N = 3; Greeting = 'hi';
+TYPE Color = (Red, Green, Blue); Digit = 0..9;
+VAR
+   zeta, alpha : INTEGER; c : Color; b : BOOLEAN; ch : CHAR;
+   x : REAL; d : Digit; s : SET OF Color; unset : INTEGER;
+BEGIN
+   zeta := N; alpha := 1; c := Green; b := True; ch := 'q';
+   x := 2.5; d := 7; s := [Red, Blue]
+END.
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer := NewSemanticAnalyzer()
+	if errs := analyzer.Analyze(tree); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	in := NewInterpreter()
+	if err := in.Interpret(tree); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	printGlobals(&out, in, analyzer)
+	want := `GLOBALSCOPE Table
+-----------------
+alpha      | 1
+b          | True
+c          | Green
+ch         | 'q'
+d          | 7
+s          | [Red, Blue]
+x          | 2.5
+zeta       | 3
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -4,6 +4,12 @@ package main
 type Parser struct {
 	CurrentToken Token
 	lexer        *Lexer
+	// comments read but not yet attached to a node
+	comments []Comment
+	// Syntax is the lossless syntax tree, only built when
+	// the lexer keeps trivia
+	Syntax *SyntaxNode
+	cst    *cstBuilder
 }
 
 // NewParser ...
@@ -14,16 +20,29 @@ func NewParser(l *Lexer) *Parser {
 }
 
 func (p *Parser) Error() {
-	panic("invalid syntax")
+	found := p.CurrentToken.Text
+	if p.CurrentToken.Type == EOF {
+		found = "end of file"
+	}
+	panic(&Error{Kind: ParserError, Tok: p.CurrentToken, Message: "invalid syntax, unexpected " + found})
 }
 
 // Parse ...
 func (p *Parser) Parse() Node {
 	p.CurrentToken = p.lexer.GetNextToken()
+	p.comments = p.CurrentToken.Comments
+	if p.lexer.KeepTrivia {
+		p.cst = &cstBuilder{}
+		p.open("File")
+	}
 	node := p.Program()
 	if p.CurrentToken.Type != EOF {
 		p.Error()
 	}
+	if p.cst != nil {
+		p.cst.token(p.CurrentToken)
+		p.Syntax = p.cst.close()
+	}
 	return node
 }
 
@@ -34,22 +53,53 @@ func (p *Parser) Parse() Node {
 // otherwise panic
 func (p *Parser) Eat(tokenType int) {
 	if p.CurrentToken.Type == tokenType {
+		if p.cst != nil {
+			p.cst.token(p.CurrentToken)
+		}
 		p.CurrentToken = p.lexer.GetNextToken()
+		p.comments = append(p.comments, p.CurrentToken.Comments...)
 	} else {
 		p.Error()
 	}
 }
 
+// takeComments ...
+// Returns the comments read since the last call, for
+// attaching to the node that is about to be parsed
+func (p *Parser) takeComments() []Comment {
+	comments := p.comments
+	p.comments = nil
+	return comments
+}
+
 // Expr ...
+// expr : simple_expr ((EQUAL | NOTEQUAL | LESS | LESSEQUAL
+//                     | GREATER | GREATEREQUAL | IN) simple_expr)?
+func (p *Parser) Expr() Node {
+	mark := p.mark()
+	node := p.SimpleExpr()
+	switch token := p.CurrentToken; token.Type {
+	case EQUAL, NOTEQUAL, LESS, LESSEQUAL, GREATER, GREATEREQUAL, IN:
+		p.Eat(token.Type)
+		binop := NewBinOp(node, token.Type, p.SimpleExpr())
+		binop.Tok = token
+		p.wrap(mark, "BinOp")
+		return binop
+	}
+	return node
+}
+
+// SimpleExpr ...
 // Arithmetic expression parser / interpreter.
 //
 // >  14 + 2 * 3 - 6 / 2
 // =  17
 //
-// expr   : term ((PLUS | MINUS) term)*
-// term   : factor ((MUL | DIV) factor)*
-// factor : INTEGER
-func (p *Parser) Expr() Node {
+// simple_expr : term ((PLUS | MINUS) term)*
+// term        : factor ((MUL | DIV) factor)*
+// factor      : INTEGER
+func (p *Parser) SimpleExpr() Node {
+	mark := p.mark()
 	node := p.Term()
 	for p.CurrentToken.Type == PLUS ||
 		p.CurrentToken.Type == MINUS {
@@ -60,7 +110,10 @@ func (p *Parser) Expr() Node {
 		case MINUS:
 			p.Eat(MINUS)
 		}
-		node = NewBinOp(node, token.Type, p.Term())
+		binop := NewBinOp(node, token.Type, p.Term())
+		binop.Tok = token
+		node = binop
+		p.wrap(mark, "BinOp")
 	}
 	return node
 }
@@ -68,6 +121,7 @@ func (p *Parser) Expr() Node {
 // Term ...
 // term : factor ((MUL | INTEGER_DIV | FLOAT_DIV) factor)*
 func (p *Parser) Term() Node {
+	mark := p.mark()
 	node := p.Factor()
 	for p.CurrentToken.Type == MUL ||
 		p.CurrentToken.Type == INTEGERDIV ||
@@ -81,7 +135,10 @@ func (p *Parser) Term() Node {
 		case FLOATDIV:
 			p.Eat(FLOATDIV)
 		}
-		node = NewBinOp(node, token.Type, p.Factor())
+		binop := NewBinOp(node, token.Type, p.Factor())
+		binop.Tok = token
+		node = binop
+		p.wrap(mark, "BinOp")
 	}
 	return node
 }
@@ -91,73 +148,342 @@ func (p *Parser) Term() Node {
 //        | MINUS factor
 //        | INTEGERCONST
 //        | REALCONST
+//        | STRINGCONST
 //        | LPAREN expr RPAREN
+//        | set_constructor
+//        | NIL
 //        | variable
 func (p *Parser) Factor() Node {
 	token := p.CurrentToken
 	switch token.Type {
 	case PLUS:
+		p.open("UnaryOp")
+		defer p.close()
 		p.Eat(PLUS)
-		return NewUnaryOp(PLUS, p.Factor())
+		node := NewUnaryOp(PLUS, p.Factor())
+		node.Tok = token
+		return node
 	case MINUS:
+		p.open("UnaryOp")
+		defer p.close()
 		p.Eat(MINUS)
-		return NewUnaryOp(MINUS, p.Factor())
+		node := NewUnaryOp(MINUS, p.Factor())
+		node.Tok = token
+		return node
 	case INTEGERCONST:
 		p.Eat(INTEGERCONST)
 		return NewNum(token)
 	case REALCONST:
 		p.Eat(REALCONST)
 		return NewNum(token)
+	case STRINGCONST:
+		p.Eat(STRINGCONST)
+		return NewStr(token)
+	case NIL:
+		p.Eat(NIL)
+		return NewNil(token)
 	case LPAREN:
+		p.open("Paren")
+		defer p.close()
 		p.Eat(LPAREN)
 		node := p.Expr()
 		p.Eat(RPAREN)
 		return node
+	case LBRACKET:
+		return p.SetConstructor()
 	default:
-		return p.Variable()
+		mark := p.mark()
+		node := p.Variable()
+		if v, ok := node.(*Var); ok && p.CurrentToken.Type == LPAREN {
+			return p.Call(mark, v.Tok)
+		}
+		return node
 	}
 }
 
+// SetConstructor ...
+// set_constructor : LBRACKET (expr_range (COMMA expr_range)*)? RBRACKET
+func (p *Parser) SetConstructor() Node {
+	p.open("SetConstructor")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(LBRACKET)
+	var elems []Node
+	if p.CurrentToken.Type != RBRACKET {
+		elems = append(elems, p.ExprRange())
+		for p.CurrentToken.Type == COMMA {
+			p.Eat(COMMA)
+			elems = append(elems, p.ExprRange())
+		}
+	}
+	p.Eat(RBRACKET)
+	return NewSetConstructor(token, elems)
+}
+
+// Call ...
+// call : IDENT arguments
+// The name has been read already, starting at mark
+func (p *Parser) Call(mark int, token Token) Node {
+	args := p.Arguments()
+	p.wrap(mark, "Call")
+	return NewCall(token, args)
+}
+
+// Arguments ...
+// arguments : LPAREN expr (COMMA expr)* RPAREN
+func (p *Parser) Arguments() []Node {
+	p.Eat(LPAREN)
+	args := []Node{p.Expr()}
+	for p.CurrentToken.Type == COMMA {
+		p.Eat(COMMA)
+		args = append(args, p.Expr())
+	}
+	p.Eat(RPAREN)
+	return args
+}
+
 // Program ...
-// program : PROGRAM variable SEMI block DOT
+// program : PROGRAM IDENT SEMI block DOT
 func (p *Parser) Program() Node {
+	comments := p.takeComments()
+	p.open("Program")
+	defer p.close()
 	p.Eat(PROGRAM)
-	varnode := p.Variable()
-	programname := varnode.(*Var).Value
+	nametoken := p.CurrentToken
+	p.Eat(IDENT)
 	p.Eat(SEMI)
 	blocknode := p.Block()
-	programnode := NewProgram(programname, blocknode)
+	programnode := NewProgram(nametoken.Svalue, blocknode)
+	programnode.Tok = nametoken
+	programnode.Comments = comments
 	p.Eat(DOT)
+	programnode.EndComments = p.takeComments()
 	return programnode
 }
 
 // Block ...
 // block : declarations compound_statement
 func (p *Parser) Block() Node {
+	p.open("Block")
+	defer p.close()
+	comments := p.takeComments()
 	declnodes := p.Declarations()
 	compoundstatementnode := p.CompoundStatement()
-	return NewBlock(declnodes, compoundstatementnode)
+	blocknode := NewBlock(declnodes, compoundstatementnode)
+	blocknode.Comments = comments
+	return blocknode
 }
 
 // Declarations ...
-// declarations : VAR (variabledeclaration SEMI)+
-//              | empty
+// declarations : (CONST (const_declaration SEMI)+
+//                | TYPE (type_declaration SEMI)+
+//                | VAR (variable_declaration SEMI)+
+//                | procedure_declaration)*
 func (p *Parser) Declarations() []Node {
 	var declnodes []Node
-	if p.CurrentToken.Type == VAR {
-		p.Eat(VAR)
-		for p.CurrentToken.Type == IDENT {
-			vardecl := p.VariableDeclaration()
-			declnodes = append(declnodes, vardecl...)
-			p.Eat(SEMI)
+	for {
+		switch p.CurrentToken.Type {
+		case CONST:
+			declnodes = append(declnodes, p.ConstSection()...)
+		case TYPE:
+			declnodes = append(declnodes, p.TypeSection()...)
+		case VAR:
+			declnodes = append(declnodes, p.VarSection()...)
+		case PROCEDURE, FUNCTION:
+			declnodes = append(declnodes, p.ProcedureDeclaration())
+		default:
+			return declnodes
 		}
 	}
+}
+
+// ConstSection ...
+// CONST (const_declaration SEMI)+
+func (p *Parser) ConstSection() []Node {
+	p.open("Declarations")
+	defer p.close()
+	comments := p.takeComments()
+	p.Eat(CONST)
+	var declnodes []Node
+	for ok := true; ok; ok = p.CurrentToken.Type == IDENT {
+		declnodes = append(declnodes, p.ConstDeclaration())
+		p.Eat(SEMI)
+	}
+	declnodes[0].(*ConstDecl).KeywordComments = comments
+	return declnodes
+}
+
+// ConstDeclaration ...
+// const_declaration : IDENT EQUAL expr
+func (p *Parser) ConstDeclaration() Node {
+	p.open("ConstDecl")
+	defer p.close()
+	comments := p.takeComments()
+	token := p.CurrentToken
+	p.Eat(IDENT)
+	p.Eat(EQUAL)
+	constdecl := NewConstDecl(token, p.Expr())
+	constdecl.Comments = comments
+	return constdecl
+}
+
+// TypeSection ...
+// TYPE (type_declaration SEMI)+
+func (p *Parser) TypeSection() []Node {
+	p.open("Declarations")
+	defer p.close()
+	comments := p.takeComments()
+	p.Eat(TYPE)
+	var declnodes []Node
+	for ok := true; ok; ok = p.CurrentToken.Type == IDENT {
+		declnodes = append(declnodes, p.TypeDeclaration())
+		p.Eat(SEMI)
+	}
+	declnodes[0].(*TypeDecl).KeywordComments = comments
 	return declnodes
 }
 
+// VarSection ...
+// VAR (variable_declaration SEMI)+
+func (p *Parser) VarSection() []Node {
+	p.open("Declarations")
+	defer p.close()
+	comments := p.takeComments()
+	p.Eat(VAR)
+	var declnodes []Node
+	for p.CurrentToken.Type == IDENT {
+		vardecl := p.VariableDeclaration()
+		declnodes = append(declnodes, vardecl...)
+		p.Eat(SEMI)
+	}
+	if len(declnodes) > 0 {
+		declnodes[0].(*VarDecl).KeywordComments = comments
+	}
+	return declnodes
+}
+
+// ProcedureDeclaration ...
+// procedure_declaration : (PROCEDURE | FUNCTION) IDENT signature SEMI (block | FORWARD) SEMI
+func (p *Parser) ProcedureDeclaration() Node {
+	p.open("ProcedureDecl")
+	defer p.close()
+	comments := p.takeComments()
+	function := p.CurrentToken.Type == FUNCTION
+	p.Eat(p.CurrentToken.Type)
+	token := p.CurrentToken
+	p.Eat(IDENT)
+	params, result := p.Signature(function)
+	p.Eat(SEMI)
+	var block Node
+	if p.CurrentToken.Type == FORWARD {
+		p.Eat(FORWARD)
+	} else {
+		block = p.Block()
+	}
+	proceduredecl := NewProcedureDecl(token, params, result, block)
+	p.Eat(SEMI)
+	proceduredecl.Comments = comments
+	return proceduredecl
+}
+
+// Signature ...
+// signature : formal_parameter_list? (COLON type_name)?
+// The result type follows the parameters of a function only
+func (p *Parser) Signature(function bool) ([]*Param, Node) {
+	var params []*Param
+	if p.CurrentToken.Type == LPAREN {
+		params = p.FormalParameterList()
+	}
+	var result Node
+	if function {
+		p.Eat(COLON)
+		result = p.TypeName()
+	}
+	return params, result
+}
+
+// ProceduralType ...
+// procedural_type : (PROCEDURE | FUNCTION) signature
+func (p *Parser) ProceduralType() Node {
+	p.open("ProceduralType")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(token.Type)
+	params, result := p.Signature(token.Type == FUNCTION)
+	return NewProceduralType(token, params, result)
+}
+
+// FormalParameterList ...
+// formal_parameter_list : LPAREN formal_parameters (SEMI formal_parameters)* RPAREN
+func (p *Parser) FormalParameterList() []*Param {
+	p.open("Params")
+	defer p.close()
+	p.Eat(LPAREN)
+	params := p.FormalParameters()
+	for p.CurrentToken.Type == SEMI {
+		p.Eat(SEMI)
+		params = append(params, p.FormalParameters()...)
+	}
+	p.Eat(RPAREN)
+	return params
+}
+
+// FormalParameters ...
+// formal_parameters : (VAR | CONST)? IDENT (COMMA IDENT)* COLON type_name
+//                   | (PROCEDURE | FUNCTION) IDENT signature
+func (p *Parser) FormalParameters() []*Param {
+	mode := ValueArg
+	switch p.CurrentToken.Type {
+	case PROCEDURE, FUNCTION:
+		token := p.CurrentToken
+		p.Eat(token.Type)
+		name := p.CurrentToken
+		p.Eat(IDENT)
+		params, result := p.Signature(token.Type == FUNCTION)
+		return []*Param{NewParam(name, ValueArg, NewProceduralType(token, params, result))}
+	case VAR:
+		p.Eat(VAR)
+		mode = VarArg
+	case CONST:
+		p.Eat(CONST)
+		mode = ConstArg
+	}
+	names := []Token{p.CurrentToken}
+	p.Eat(IDENT)
+	for p.CurrentToken.Type == COMMA {
+		p.Eat(COMMA)
+		names = append(names, p.CurrentToken)
+		p.Eat(IDENT)
+	}
+	p.Eat(COLON)
+	typenode := p.TypeName()
+	var params []*Param
+	for _, name := range names {
+		params = append(params, NewParam(name, mode, typenode))
+	}
+	return params
+}
+
+// TypeDeclaration ...
+// type_declaration : IDENT EQUAL type_spec
+func (p *Parser) TypeDeclaration() Node {
+	p.open("TypeDecl")
+	defer p.close()
+	comments := p.takeComments()
+	token := p.CurrentToken
+	p.Eat(IDENT)
+	p.Eat(EQUAL)
+	typedecl := NewTypeDecl(token, p.TypeSpec())
+	typedecl.Comments = comments
+	return typedecl
+}
+
 // VariableDeclaration ...
 // variabledeclaration : IDENT (COMMA IDENT)* COLON typespec
 func (p *Parser) VariableDeclaration() []Node {
+	p.open("VarDecl")
+	defer p.close()
+	comments := p.takeComments()
 	varnodes := []*Var{NewVar(p.CurrentToken, p.CurrentToken.Svalue)}
 	p.Eat(IDENT)
 	for p.CurrentToken.Type == COMMA {
@@ -171,32 +497,192 @@ func (p *Parser) VariableDeclaration() []Node {
 	for _, varnode := range varnodes {
 		vardeclarations = append(vardeclarations, NewVarDecl(varnode, typenode))
 	}
+	vardeclarations[0].(*VarDecl).Comments = comments
 	return vardeclarations
 }
 
 // TypeSpec ...
 // type_spec : INTEGER
 //           | REAL
+//           | IDENT
+//           | enum_type
+//           | BOOLEAN
+//           | CHAR
+//           | STRING
+//           | TEXT
+//           | subrange
+//           | array_type
+//           | record_type
+//           | set_type
+//           | pointer_type
+//           | procedural_type
+//           | file_type
 func (p *Parser) TypeSpec() Node {
 	token := p.CurrentToken
 	switch token.Type {
+	case FILE:
+		return p.FileType()
+	case CARET:
+		return p.PointerType()
+	case PROCEDURE, FUNCTION:
+		return p.ProceduralType()
+	case ARRAY:
+		return p.ArrayType()
+	case RECORD:
+		return p.RecordType()
+	case SET:
+		return p.SetType()
+	case LPAREN:
+		return p.EnumType()
 	case INTEGER:
 		p.Eat(INTEGER)
 	case REAL:
-		fallthrough
-	default:
 		p.Eat(REAL)
+	case BOOLEAN:
+		p.Eat(BOOLEAN)
+	case CHAR:
+		p.Eat(CHAR)
+	case STRING:
+		p.Eat(STRING)
+	case TEXT:
+		p.Eat(TEXT)
+	default:
+		return p.Subrange()
 	}
 	return NewTypeN(token)
 }
 
+// EnumType ...
+// enum_type : LPAREN IDENT (COMMA IDENT)* RPAREN
+func (p *Parser) EnumType() Node {
+	p.open("Enum")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(LPAREN)
+	names := []Token{p.CurrentToken}
+	p.Eat(IDENT)
+	for p.CurrentToken.Type == COMMA {
+		p.Eat(COMMA)
+		names = append(names, p.CurrentToken)
+		p.Eat(IDENT)
+	}
+	p.Eat(RPAREN)
+	return NewEnum(token, names)
+}
+
+// ArrayType ...
+// array_type : ARRAY LBRACKET type_spec (COMMA type_spec)* RBRACKET OF type_spec
+func (p *Parser) ArrayType() Node {
+	p.open("ArrayType")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(ARRAY)
+	p.Eat(LBRACKET)
+	indexes := []Node{p.TypeSpec()}
+	for p.CurrentToken.Type == COMMA {
+		p.Eat(COMMA)
+		indexes = append(indexes, p.TypeSpec())
+	}
+	p.Eat(RBRACKET)
+	p.Eat(OF)
+	return NewArrayType(token, indexes, p.TypeSpec())
+}
+
+// PointerType ...
+// pointer_type : CARET type_name
+// The type name is only looked up once the declarations after it
+// have been read
+func (p *Parser) PointerType() Node {
+	p.open("PointerType")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(CARET)
+	return NewPointerType(token, p.TypeName())
+}
+
+// TypeName ...
+// type_name : IDENT | INTEGER | REAL | BOOLEAN | CHAR | STRING
+func (p *Parser) TypeName() Node {
+	token := p.CurrentToken
+	switch token.Type {
+	case IDENT, INTEGER, REAL, BOOLEAN, CHAR, STRING, TEXT:
+		p.Eat(token.Type)
+	default:
+		p.Error()
+	}
+	return NewTypeN(token)
+}
+
+// SetType ...
+// set_type : SET OF type_spec
+func (p *Parser) SetType() Node {
+	p.open("SetType")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(SET)
+	p.Eat(OF)
+	return NewSetType(token, p.TypeSpec())
+}
+
+// FileType ...
+// file_type : FILE OF type_spec
+func (p *Parser) FileType() Node {
+	p.open("FileType")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(FILE)
+	p.Eat(OF)
+	return NewFileType(token, p.TypeSpec())
+}
+
+// RecordType ...
+// record_type : RECORD variabledeclaration (SEMI variabledeclaration)* SEMI? END
+func (p *Parser) RecordType() Node {
+	p.open("RecordType")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(RECORD)
+	var fields []Node
+	for p.CurrentToken.Type == IDENT {
+		fields = append(fields, p.VariableDeclaration()...)
+		if p.CurrentToken.Type != SEMI {
+			break
+		}
+		p.Eat(SEMI)
+	}
+	node := NewRecordType(token, fields)
+	node.EndComments = p.takeComments()
+	p.Eat(END)
+	return node
+}
+
+// Subrange ...
+// subrange : expr DOTDOT expr
+// A lone identifier is the name of a type instead
+func (p *Parser) Subrange() Node {
+	mark := p.mark()
+	low := p.Expr()
+	if v, ok := low.(*Var); ok && p.CurrentToken.Type != DOTDOT {
+		return NewTypeN(v.Tok)
+	}
+	p.Eat(DOTDOT)
+	node := NewSubrange(low, p.Expr())
+	p.wrap(mark, "Subrange")
+	return node
+}
+
 // CompoundStatement ...
 // compoundstatement: BEGIN statement_list END
 func (p *Parser) CompoundStatement() Node {
+	p.open("Compound")
+	defer p.close()
+	comments := p.takeComments()
 	p.Eat(BEGIN)
 	nodes := p.StatementList()
-	p.Eat(END)
 	node := NewCompound(nodes...)
+	node.Comments = comments
+	node.EndComments = p.takeComments()
+	p.Eat(END)
 	return node
 }
 
@@ -220,32 +706,192 @@ func (p *Parser) StatementList() []Node {
 // Statement ...
 // statement : compoundstatement
 // | assignmentstatement
+// | withstatement
+// | forstatement
+// | casestatement
+// | procedurecallstatement
 // | empty
 func (p *Parser) Statement() Node {
 	if p.CurrentToken.Type == BEGIN {
 		return p.CompoundStatement()
-	} else if p.CurrentToken.Type == IDENT {
-		return p.AssignmentStatement()
 	}
-	return p.Empty()
+	comments := p.takeComments()
+	var node Node
+	switch p.CurrentToken.Type {
+	case IDENT:
+		mark := p.mark()
+		left := p.Variable()
+		if v, ok := left.(*Var); ok && p.CurrentToken.Type != ASSIGN {
+			node = p.ProcedureCallStatement(mark, v.Tok)
+		} else {
+			node = p.AssignmentStatement(mark, left)
+		}
+	case WITH:
+		node = p.WithStatement()
+	case FOR:
+		node = p.ForStatement()
+	case CASE:
+		node = p.CaseStatement()
+	default:
+		node = p.Empty()
+	}
+	node.(interface{ SetComments([]Comment) }).SetComments(comments)
+	return node
 }
 
 // AssignmentStatement ...
 // assignmentstatement : variable ASSIGN expr
-func (p *Parser) AssignmentStatement() Node {
-	left := p.Variable()
+// The variable has been read already, starting at mark
+func (p *Parser) AssignmentStatement(mark int, left Node) Node {
 	token := p.CurrentToken
 	p.Eat(ASSIGN)
 	right := p.Expr()
+	p.wrap(mark, "Assign")
 	return NewAssign(left, token.Type, right)
 }
 
+// ProcedureCallStatement ...
+// procedure_call_statement : IDENT arguments?
+// The name has been read already, starting at mark
+func (p *Parser) ProcedureCallStatement(mark int, token Token) Node {
+	var args []Node
+	if p.CurrentToken.Type == LPAREN {
+		args = p.Arguments()
+	}
+	p.wrap(mark, "ProcedureCall")
+	return NewProcedureCall(token, args)
+}
+
+// ForStatement ...
+// forstatement : FOR IDENT ASSIGN expr (TO | DOWNTO) expr DO statement
+func (p *Parser) ForStatement() Node {
+	p.open("For")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(FOR)
+	v := NewVar(p.CurrentToken, p.CurrentToken.Svalue)
+	p.Eat(IDENT)
+	p.Eat(ASSIGN)
+	start := p.Expr()
+	down := p.CurrentToken.Type == DOWNTO
+	if down {
+		p.Eat(DOWNTO)
+	} else {
+		p.Eat(TO)
+	}
+	end := p.Expr()
+	p.Eat(DO)
+	return NewFor(token, v, start, down, end, p.Statement())
+}
+
+// CaseStatement ...
+// casestatement : CASE expr OF case_arm (SEMI case_arm)* SEMI?
+//                 ((ELSE | OTHERWISE) statement_list)? END
+func (p *Parser) CaseStatement() Node {
+	p.open("Case")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(CASE)
+	expr := p.Expr()
+	p.Eat(OF)
+	arms := []*CaseArm{p.CaseArm()}
+	for p.CurrentToken.Type == SEMI {
+		p.Eat(SEMI)
+		if p.CurrentToken.Type == ELSE || p.CurrentToken.Type == OTHERWISE || p.CurrentToken.Type == END {
+			break
+		}
+		arms = append(arms, p.CaseArm())
+	}
+	node := NewCase(token, expr, arms)
+	if p.CurrentToken.Type == ELSE || p.CurrentToken.Type == OTHERWISE {
+		p.Eat(p.CurrentToken.Type)
+		node.Else = p.StatementList()
+	}
+	node.EndComments = p.takeComments()
+	p.Eat(END)
+	return node
+}
+
+// CaseArm ...
+// case_arm : expr_range (COMMA expr_range)* COLON statement
+func (p *Parser) CaseArm() *CaseArm {
+	p.open("CaseArm")
+	defer p.close()
+	arm := &CaseArm{}
+	arm.Comments = p.takeComments()
+	arm.Labels = []Node{p.ExprRange()}
+	for p.CurrentToken.Type == COMMA {
+		p.Eat(COMMA)
+		arm.Labels = append(arm.Labels, p.ExprRange())
+	}
+	p.Eat(COLON)
+	arm.Body = p.Statement()
+	return arm
+}
+
+// ExprRange ...
+// expr_range : expr (DOTDOT expr)?
+// A CASE label or an element of a set constructor
+func (p *Parser) ExprRange() Node {
+	mark := p.mark()
+	low := p.Expr()
+	if p.CurrentToken.Type != DOTDOT {
+		return low
+	}
+	p.Eat(DOTDOT)
+	node := NewSubrange(low, p.Expr())
+	p.wrap(mark, "Subrange")
+	return node
+}
+
+// WithStatement ...
+// withstatement : WITH variable (COMMA variable)* DO statement
+func (p *Parser) WithStatement() Node {
+	p.open("With")
+	defer p.close()
+	token := p.CurrentToken
+	p.Eat(WITH)
+	records := []Node{p.Variable()}
+	for p.CurrentToken.Type == COMMA {
+		p.Eat(COMMA)
+		records = append(records, p.Variable())
+	}
+	p.Eat(DO)
+	return NewWith(token, records, p.Statement())
+}
+
 // Variable ...
-// variable : IDENT
+// variable : IDENT (LBRACKET expr (COMMA expr)* RBRACKET | DOT IDENT | CARET)*
 func (p *Parser) Variable() Node {
-	node := NewVar(p.CurrentToken, p.CurrentToken.Svalue)
+	mark := p.mark()
+	var node Node = NewVar(p.CurrentToken, p.CurrentToken.Svalue)
 	p.Eat(IDENT)
-	return node
+	for {
+		switch p.CurrentToken.Type {
+		case LBRACKET:
+			token := p.CurrentToken
+			p.Eat(LBRACKET)
+			indexes := []Node{p.Expr()}
+			for p.CurrentToken.Type == COMMA {
+				p.Eat(COMMA)
+				indexes = append(indexes, p.Expr())
+			}
+			p.Eat(RBRACKET)
+			node = NewIndex(token, node, indexes)
+			p.wrap(mark, "Index")
+		case DOT:
+			p.Eat(DOT)
+			node = NewField(p.CurrentToken, node)
+			p.Eat(IDENT)
+			p.wrap(mark, "Field")
+		case CARET:
+			node = NewDeref(p.CurrentToken, node)
+			p.Eat(CARET)
+			p.wrap(mark, "Deref")
+		default:
+			return node
+		}
+	}
 }
 
 // Empty ...
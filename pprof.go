@@ -0,0 +1,162 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// protobuf ...
+// Just enough of the protocol buffer wire format to write the
+// profile.proto messages used by pprof.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) key(tag int, wiretype int) {
+	b.varint(uint64(tag)<<3 | uint64(wiretype))
+}
+
+func (b *protobuf) uint64Field(tag int, x uint64) {
+	if x != 0 {
+		b.key(tag, 0)
+		b.varint(x)
+	}
+}
+
+func (b *protobuf) int64Field(tag int, x int64) {
+	b.uint64Field(tag, uint64(x))
+}
+
+func (b *protobuf) bytesField(tag int, data []byte) {
+	b.key(tag, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protobuf) packedField(tag int, xs []uint64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(tag, packed.data)
+}
+
+func (b *protobuf) message(tag int, fill func(m *protobuf)) {
+	var m protobuf
+	fill(&m)
+	b.bytesField(tag, m.data)
+}
+
+// pprofBuilder ...
+// Collects the string table, functions and locations of a profile
+type pprofBuilder struct {
+	filename  string
+	profile   protobuf
+	strings   map[string]int64
+	table     []string
+	functions map[string]uint64
+	locations map[profileLocation]uint64
+}
+
+func newPprofBuilder(filename string) *pprofBuilder {
+	pb := &pprofBuilder{
+		filename:  filename,
+		strings:   make(map[string]int64),
+		functions: make(map[string]uint64),
+		locations: make(map[profileLocation]uint64),
+	}
+	pb.str("")
+	// sample_type: executions/count and time/nanoseconds
+	for _, vt := range [][2]string{{"executions", "count"}, {"time", "nanoseconds"}} {
+		typ, unit := pb.str(vt[0]), pb.str(vt[1])
+		pb.profile.message(1, func(m *protobuf) {
+			m.int64Field(1, typ)
+			m.int64Field(2, unit)
+		})
+	}
+	return pb
+}
+
+// str ...
+// Index of s in the string table
+func (pb *pprofBuilder) str(s string) int64 {
+	if i, exists := pb.strings[s]; exists {
+		return i
+	}
+	i := int64(len(pb.table))
+	pb.strings[s] = i
+	pb.table = append(pb.table, s)
+	return i
+}
+
+// function ...
+// Id of the function for a program or procedure body
+func (pb *pprofBuilder) function(name string) uint64 {
+	if id, exists := pb.functions[name]; exists {
+		return id
+	}
+	id := uint64(len(pb.functions) + 1)
+	pb.functions[name] = id
+	nameIndex, fileIndex := pb.str(name), pb.str(pb.filename)
+	pb.profile.message(5, func(m *protobuf) {
+		m.uint64Field(1, id)
+		m.int64Field(2, nameIndex)
+		m.int64Field(3, nameIndex)
+		m.int64Field(4, fileIndex)
+	})
+	return id
+}
+
+// location ...
+// Id of the location for a line in a body
+func (pb *pprofBuilder) location(body string, line int) uint64 {
+	key := profileLocation{body, line}
+	if id, exists := pb.locations[key]; exists {
+		return id
+	}
+	id := uint64(len(pb.locations) + 1)
+	pb.locations[key] = id
+	function := pb.function(body)
+	pb.profile.message(4, func(m *protobuf) {
+		m.uint64Field(1, id)
+		m.message(4, func(l *protobuf) {
+			l.uint64Field(1, function)
+			l.int64Field(2, int64(line))
+		})
+	})
+	return id
+}
+
+// sample ...
+// locations run from the innermost frame outwards
+func (pb *pprofBuilder) sample(locations []uint64, count int64, nanoseconds int64) {
+	pb.profile.message(2, func(m *protobuf) {
+		m.packedField(1, locations)
+		m.packedField(2, []uint64{uint64(count), uint64(nanoseconds)})
+	})
+}
+
+// write ...
+// Finishes the profile and writes it gzipped
+func (pb *pprofBuilder) write(w io.Writer, start time.Time, duration time.Duration) error {
+	profile := pb.profile
+	profile.int64Field(9, start.UnixNano())
+	profile.int64Field(10, int64(duration))
+	for _, s := range pb.table {
+		profile.bytesField(6, []byte(s))
+	}
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
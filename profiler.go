@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Profiler ...
+// Observer counting how often each statement runs and how long
+// statements and program/procedure bodies take in wall time.
+// Bodies are told apart by their declarations and named by their
+// names qualified with those of the procedures they are nested in,
+// so that nested procedures of the same name are not merged.
+type Profiler struct {
+	// Filename is recorded in the pprof profile
+	Filename string
+	// Statements and Bodies hold the statistics, keyed by statement
+	// and by the Program or ProcedureDecl
+	Statements map[Node]*StatementProfile
+	Bodies     map[Node]*BodyProfile
+
+	start   time.Time
+	elapsed time.Duration
+	frames  []*profileFrame
+	open    []*profileStatement
+	// samples accumulates self time per call stack for pprof
+	samples map[string]*profileSample
+}
+
+// StatementProfile ...
+type StatementProfile struct {
+	Tok    Token
+	Body   string
+	Source string
+	Count  int
+	// Time includes the time spent in calls made by the statement
+	Time time.Duration
+}
+
+// BodyProfile ...
+// Statistics of a program or procedure body, Name is qualified as
+// in Outer.Inner
+type BodyProfile struct {
+	Name  string
+	Calls int
+	// Time counts recursive activations only once
+	Time time.Duration
+}
+
+type profileFrame struct {
+	decl  Node
+	name  string
+	start time.Time
+}
+
+type profileStatement struct {
+	node     Node
+	body     string
+	line     int
+	start    time.Time
+	children time.Duration
+}
+
+// profileSample ...
+// Executions sharing one call stack, innermost frame first
+type profileSample struct {
+	stack []profileLocation
+	count int64
+	self  time.Duration
+}
+
+type profileLocation struct {
+	body string
+	line int
+}
+
+// NewProfiler ...
+func NewProfiler(filename string) *Profiler {
+	return &Profiler{
+		Filename:   filename,
+		Statements: make(map[Node]*StatementProfile),
+		Bodies:     make(map[Node]*BodyProfile),
+		samples:    make(map[string]*profileSample),
+	}
+}
+
+// EnterNode ...
+func (p *Profiler) EnterNode(n Node) {
+	tok, ok := StatementToken(n)
+	if !ok {
+		return
+	}
+	body := p.frames[len(p.frames)-1].name
+	if _, exists := p.Statements[n]; !exists {
+		p.Statements[n] = &StatementProfile{Tok: tok, Body: body, Source: stmtString(n)}
+	}
+	p.open = append(p.open, &profileStatement{node: n, body: body, line: tok.Line, start: time.Now()})
+}
+
+// ExitNode ...
+func (p *Profiler) ExitNode(n Node) {
+	if _, ok := StatementToken(n); !ok {
+		return
+	}
+	stmt := p.open[len(p.open)-1]
+	elapsed := time.Since(stmt.start)
+
+	stats := p.Statements[n]
+	stats.Count++
+	recursive := false
+	for _, outer := range p.open[:len(p.open)-1] {
+		recursive = recursive || outer.node == n
+	}
+	if !recursive {
+		stats.Time += elapsed
+	}
+
+	key := ""
+	stack := make([]profileLocation, 0, len(p.open))
+	for i := len(p.open) - 1; i >= 0; i-- {
+		location := profileLocation{p.open[i].body, p.open[i].line}
+		stack = append(stack, location)
+		key += fmt.Sprintf("%s:%d;", location.body, location.line)
+	}
+	sample, exists := p.samples[key]
+	if !exists {
+		sample = &profileSample{stack: stack}
+		p.samples[key] = sample
+	}
+	sample.count++
+	sample.self += elapsed - stmt.children
+
+	p.open = p.open[:len(p.open)-1]
+	if len(p.open) > 0 {
+		p.open[len(p.open)-1].children += elapsed
+	}
+}
+
+// Assign ...
+func (p *Profiler) Assign(name string, value Value, n Node) {}
+
+// Call ...
+func (p *Profiler) Call(ar *ActivationRecord) {
+	now := time.Now()
+	if len(p.frames) == 0 {
+		p.start = now
+	}
+	body, exists := p.Bodies[ar.Decl]
+	if !exists {
+		body = &BodyProfile{Name: bodyName(ar)}
+		p.Bodies[ar.Decl] = body
+	}
+	body.Calls++
+	p.frames = append(p.frames, &profileFrame{decl: ar.Decl, name: body.Name, start: now})
+}
+
+// bodyName ...
+// The name of the body running in ar, qualified with the names of
+// the procedures it is nested in
+func bodyName(ar *ActivationRecord) string {
+	name := ar.Name
+	for outer := ar.Link; outer != nil && outer.Type != ProgramAR; outer = outer.Link {
+		name = outer.Name + "." + name
+	}
+	return name
+}
+
+// Return ...
+func (p *Profiler) Return(ar *ActivationRecord) {
+	frame := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+	recursive := false
+	for _, outer := range p.frames {
+		recursive = recursive || outer.decl == frame.decl
+	}
+	if !recursive {
+		p.Bodies[frame.decl].Time += time.Since(frame.start)
+	}
+	if len(p.frames) == 0 {
+		p.elapsed = time.Since(p.start)
+	}
+}
+
+// Branch ...
+func (p *Profiler) Branch(n Node, arm int) {}
+
+// WriteReport ...
+// Writes the bodies and then the statements, most expensive first
+func (p *Profiler) WriteReport(w io.Writer) {
+	var bodies []*BodyProfile
+	for _, b := range p.Bodies {
+		bodies = append(bodies, b)
+	}
+	sort.Slice(bodies, func(i, j int) bool {
+		if bodies[i].Time != bodies[j].Time {
+			return bodies[i].Time > bodies[j].Time
+		}
+		return bodies[i].Name < bodies[j].Name
+	})
+	fmt.Fprintf(w, "%-20s %8s %14s\n", "BODY", "CALLS", "TIME")
+	for _, b := range bodies {
+		fmt.Fprintf(w, "%-20s %8d %14s\n", b.Name, b.Calls, b.Time)
+	}
+
+	var stmts []*StatementProfile
+	for _, s := range p.Statements {
+		stmts = append(stmts, s)
+	}
+	sort.Slice(stmts, func(i, j int) bool {
+		if stmts[i].Time != stmts[j].Time {
+			return stmts[i].Time > stmts[j].Time
+		}
+		return stmts[i].Tok.Line < stmts[j].Tok.Line
+	})
+	fmt.Fprintf(w, "\n%6s %-20s %8s %14s  %s\n", "LINE", "BODY", "COUNT", "TIME", "STATEMENT")
+	for _, s := range stmts {
+		fmt.Fprintf(w, "%6d %-20s %8d %14s  %s\n", s.Tok.Line, s.Body, s.Count, s.Time, s.Source)
+	}
+}
+
+// WritePprof ...
+// Writes the samples as a gzipped pprof profile. Every program or
+// procedure body is a function and every statement a location in
+// it, so a sample's stack runs from the statement through the
+// statements that made the calls leading to it.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	pb := newPprofBuilder(p.Filename)
+	var keys []string
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sample := p.samples[key]
+		var locations []uint64
+		for _, l := range sample.stack {
+			locations = append(locations, pb.location(l.body, l.line))
+		}
+		pb.sample(locations, sample.count, int64(sample.self))
+	}
+	return pb.write(w, p.start, p.elapsed)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+const profileTestProgram = `PROGRAM T;
+VAR n, i : INTEGER;
+PROCEDURE A;
+   PROCEDURE Q;
+   BEGIN
+      n := n + 1
+   END;
+BEGIN
+   Q; Q
+END;
+PROCEDURE B;
+   PROCEDURE Q;
+   BEGIN
+      n := n + 10
+   END;
+BEGIN
+   Q
+END;
+BEGIN
+   n := 0;
+   FOR i := 1 TO 2 DO A;
+   B
+END.
+`
+
+// profile runs profileTestProgram under a profiler
+func profile(t *testing.T) *Profiler {
+	t.Helper()
+	tree := analyze(t, profileTestProgram)
+	profiler := NewProfiler("t.pas")
+	in := NewInterpreter()
+	in.Observe(profiler)
+	if err := in.Interpret(tree); err != nil {
+		t.Fatal(err)
+	}
+	return profiler
+}
+
+func TestProfileBodies(t *testing.T) {
+	calls := map[string]int{}
+	for _, body := range profile(t).Bodies {
+		if _, exists := calls[body.Name]; exists {
+			t.Errorf("two bodies named %s", body.Name)
+		}
+		calls[body.Name] = body.Calls
+	}
+	want := map[string]int{"T": 1, "A": 2, "A.Q": 4, "B": 1, "B.Q": 1}
+	if len(calls) != len(want) {
+		t.Errorf("bodies %v, want %v", calls, want)
+	}
+	for name, n := range want {
+		if calls[name] != n {
+			t.Errorf("%s called %d times, want %d", name, calls[name], n)
+		}
+	}
+}
+
+func TestProfileStatements(t *testing.T) {
+	p := profile(t)
+	counts := map[int]int{}
+	for _, s := range p.Statements {
+		counts[s.Tok.Line] += s.Count
+	}
+	for line, want := range map[int]int{6: 4, 9: 4, 14: 1, 17: 1, 20: 1} {
+		if counts[line] != want {
+			t.Errorf("line %d ran %d times, want %d", line, counts[line], want)
+		}
+	}
+	var out strings.Builder
+	p.WriteReport(&out)
+	for _, want := range []string{"A.Q                         4", "n := n + 10"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// protoField ...
+// A field of a protocol buffer message, varints in value and
+// length-delimited fields in data
+type protoField struct {
+	tag   int
+	value uint64
+	data  []byte
+}
+
+// decodeProto splits a message into its fields
+func decodeProto(t *testing.T, data []byte) []protoField {
+	t.Helper()
+	varint := func() uint64 {
+		var x uint64
+		for shift := 0; ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatal("truncated varint")
+			}
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+	var fields []protoField
+	for len(data) > 0 {
+		key := varint()
+		field := protoField{tag: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			field.value = varint()
+		case 2:
+			n := varint()
+			field.data, data = data[:n], data[n:]
+		default:
+			t.Fatalf("wire type %d", key&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// decodePacked reads the varints of a packed field
+func decodePacked(t *testing.T, data []byte) []uint64 {
+	t.Helper()
+	var xs []uint64
+	for len(data) > 0 {
+		var x uint64
+		for shift := 0; ; shift += 7 {
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		xs = append(xs, x)
+	}
+	return xs
+}
+
+func TestWritePprof(t *testing.T) {
+	p := profile(t)
+	var out bytes.Buffer
+	if err := p.WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var samples, locations, functions int
+	var executions uint64
+	var table []string
+	for _, field := range decodeProto(t, data) {
+		switch field.tag {
+		case 2:
+			samples++
+			for _, f := range decodeProto(t, field.data) {
+				if f.tag == 2 {
+					executions += decodePacked(t, f.data)[0]
+				}
+			}
+		case 4:
+			locations++
+		case 5:
+			functions++
+		case 6:
+			table = append(table, string(field.data))
+		}
+	}
+
+	var count int
+	lines := map[profileLocation]bool{}
+	for _, s := range p.Statements {
+		count += s.Count
+		lines[profileLocation{s.Body, s.Tok.Line}] = true
+	}
+	if executions != uint64(count) {
+		t.Errorf("samples count %d executions, want %d", executions, count)
+	}
+	if samples != len(p.samples) || samples == 0 {
+		t.Errorf("%d samples, want %d", samples, len(p.samples))
+	}
+	if locations != len(lines) {
+		t.Errorf("%d locations, want one per line of a body, %d", locations, len(lines))
+	}
+	if functions != len(p.Bodies) {
+		t.Errorf("%d functions, want one per body, %d", functions, len(p.Bodies))
+	}
+	strs := strings.Join(table, " ")
+	for _, want := range []string{"executions", "nanoseconds", "t.pas", "A.Q", "B.Q"} {
+		if !strings.Contains(strs, want) {
+			t.Errorf("string table %q lacks %s", table, want)
+		}
+	}
+}
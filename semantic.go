@@ -0,0 +1,1839 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SemanticAnalyzer ...
+// Builds the symbol tables and checks that every variable is
+// declared exactly once before it is used. Visiting an expression
+// returns its type and visiting a type spec the type it denotes,
+// nil when it is unknown because of an error already reported.
+type SemanticAnalyzer struct {
+	VisitMap     map[NodeType]func(n Node) *Symbol
+	CurrentScope *ScopedSymbolTable
+	// Scopes in the order they were entered
+	Scopes []*ScopedSymbolTable
+	// References holds, for each symbol, the identifier tokens that
+	// refer to it. The declaring token comes first.
+	References map[*Symbol][]Token
+	// ExprTypes holds the type of each expression, variable reference
+	// and type spec analyzed
+	ExprTypes map[Node]*Symbol
+	Errors    []*Error
+
+	// withs holds the records of the enclosing WITH statements,
+	// innermost last
+	withs []withScope
+	// types holds the type each type spec denotes, names declared
+	// together share the type spec and so the type
+	types map[Node]*Symbol
+	// typeDecls maps type names to their declarations
+	typeDecls map[*Symbol]*TypeDecl
+	// pointerTypes maps pointer types to their type specs
+	pointerTypes map[*Symbol]*PointerType
+	// forward holds the pointer types whose type name is looked up
+	// at the end of the declarations
+	forward []*Symbol
+	// procedures maps procedure and function names to their
+	// declarations
+	procedures map[*Symbol]*ProcedureDecl
+	// functions holds the functions whose bodies are being analyzed,
+	// innermost last. Their names stand for their results there.
+	functions []*Symbol
+	// loops holds the variables of the FOR statements whose bodies
+	// are being analyzed, innermost last
+	loops []*Symbol
+	// announced holds the procedures and functions declared FORWARD
+	// in the block being analyzed that have no body yet
+	announced []*Symbol
+	// fixups holds the references to the FORWARD declarations of the
+	// announced procedures, pointed at their bodies once declared
+	fixups map[*Symbol][]**ProcedureDecl
+}
+
+// withScope ...
+// A record whose fields a WITH statement brings into scope
+type withScope struct {
+	with   *With
+	index  int
+	record *Symbol
+}
+
+// NewSemanticAnalyzer ...
+func NewSemanticAnalyzer() *SemanticAnalyzer {
+	sa := &SemanticAnalyzer{}
+	sa.References = make(map[*Symbol][]Token)
+	sa.ExprTypes = make(map[Node]*Symbol)
+	sa.types = make(map[Node]*Symbol)
+	sa.typeDecls = make(map[*Symbol]*TypeDecl)
+	sa.pointerTypes = make(map[*Symbol]*PointerType)
+	sa.procedures = make(map[*Symbol]*ProcedureDecl)
+	sa.fixups = make(map[*Symbol][]**ProcedureDecl)
+	sa.VisitMap = make(map[NodeType]func(n Node) *Symbol)
+	sa.VisitMap[BinOpNode] = sa.VisitBinOp
+	sa.VisitMap[UnaryOpNode] = sa.VisitUnaryOp
+	sa.VisitMap[NumNode] = sa.VisitNum
+	sa.VisitMap[CompoundNode] = sa.VisitCompound
+	sa.VisitMap[AssignNode] = sa.VisitAssign
+	sa.VisitMap[VarNode] = sa.VisitVar
+	sa.VisitMap[NoOpNode] = sa.VisitNoOp
+	sa.VisitMap[ProgramNode] = sa.VisitProgram
+	sa.VisitMap[BlockNode] = sa.VisitBlock
+	sa.VisitMap[VarDeclNode] = sa.VisitVarDecl
+	sa.VisitMap[TypeNode] = sa.VisitType
+	sa.VisitMap[ArrayTypeNode] = sa.VisitArrayType
+	sa.VisitMap[IndexNode] = sa.VisitIndex
+	sa.VisitMap[RecordTypeNode] = sa.VisitRecordType
+	sa.VisitMap[FieldNode] = sa.VisitField
+	sa.VisitMap[WithNode] = sa.VisitWith
+	sa.VisitMap[TypeDeclNode] = sa.VisitTypeDecl
+	sa.VisitMap[ConstDeclNode] = sa.VisitConstDecl
+	sa.VisitMap[SubrangeNode] = sa.VisitSubrange
+	sa.VisitMap[EnumNode] = sa.VisitEnum
+	sa.VisitMap[CallNode] = sa.VisitCall
+	sa.VisitMap[ForNode] = sa.VisitFor
+	sa.VisitMap[CaseNode] = sa.VisitCase
+	sa.VisitMap[SetTypeNode] = sa.VisitSetType
+	sa.VisitMap[SetConstructorNode] = sa.VisitSetConstructor
+	sa.VisitMap[StrNode] = sa.VisitStr
+	sa.VisitMap[ProcedureCallNode] = sa.VisitProcedureCall
+	sa.VisitMap[PointerTypeNode] = sa.VisitPointerType
+	sa.VisitMap[DerefNode] = sa.VisitDeref
+	sa.VisitMap[NilNode] = sa.VisitNil
+	sa.VisitMap[ProcedureDeclNode] = sa.VisitProcedureDecl
+	sa.VisitMap[ProceduralTypeNode] = sa.VisitProceduralType
+	sa.VisitMap[FileTypeNode] = sa.VisitFileType
+	return sa
+}
+
+// Analyze ...
+// Returns the errors found, if any
+func (sa *SemanticAnalyzer) Analyze(n Node) []*Error {
+	sa.Visit(n)
+	return sa.Errors
+}
+
+// Visit ...
+func (sa *SemanticAnalyzer) Visit(n Node) *Symbol {
+	t := sa.VisitMap[n.Type()](n)
+	if t != nil {
+		sa.ExprTypes[n] = t
+	}
+	return t
+}
+
+func (sa *SemanticAnalyzer) error(tok Token, format string, args ...interface{}) {
+	sa.Errors = append(sa.Errors, &Error{Kind: SemanticError, Tok: tok, Message: fmt.Sprintf(format, args...)})
+}
+
+// declare ...
+func (sa *SemanticAnalyzer) declare(s *Symbol) {
+	if sa.CurrentScope.Lookup(s.Name, true) != nil {
+		sa.error(s.Tok, "duplicate identifier '%s' found", s.Name)
+		return
+	}
+	sa.CurrentScope.Insert(s)
+	sa.References[s] = append(sa.References[s], s.Tok)
+}
+
+// builtin ...
+// The predefined type for a type keyword
+func (sa *SemanticAnalyzer) builtin(tokType int) *Symbol {
+	return sa.Scopes[0].Lookup(keyword(tokType), true)
+}
+
+// constant ...
+// Folds a constant expression, ok is false if n is not one. The
+// expression must have been visited, so that fields named inside
+// a WITH statement are told apart from constants. Characters fold
+// to their code, strings are not folded.
+func (sa *SemanticAnalyzer) constant(n Node) (value float64, ok bool) {
+	switch node := n.(type) {
+	case *Num:
+		return node.Value, true
+	case *Str:
+		if len(node.Value) != 1 {
+			return 0, false
+		}
+		return float64(node.Value[0]), true
+	case *Var:
+		s := sa.CurrentScope.Lookup(node.Value, false)
+		if node.With != nil || s == nil || s.Kind != ConstSymbol {
+			return 0, false
+		}
+		return s.Value, true
+	case *Call:
+		switch {
+		case node.Range != nil && node.Name == "Low":
+			return float64(node.Range.Low), true
+		case node.Range != nil && node.Name == "High":
+			return float64(node.Range.High), true
+		case len(node.Args) != 1 || !sa.isBuiltin(node):
+			return 0, false
+		}
+		value, ok = sa.constant(node.Args[0])
+		switch node.Name {
+		case "Ord", "Chr":
+		case "Succ":
+			value++
+		case "Pred":
+			value--
+		default:
+			return 0, false
+		}
+		return value, ok && !(node.Integer && math.Abs(value) > MaxInteger)
+	case *UnaryOp:
+		value, ok = sa.constant(node.Expr)
+		if node.Op == MINUS {
+			value = -value
+		}
+		return value, ok
+	case *BinOp:
+		left, lok := sa.constant(node.Left)
+		right, rok := sa.constant(node.Right)
+		if !lok || !rok || node.Concat {
+			return 0, false
+		}
+		switch node.Op {
+		case PLUS:
+			return left + right, !(node.Integer && math.Abs(left+right) > MaxInteger)
+		case MINUS:
+			return left - right, !(node.Integer && math.Abs(left-right) > MaxInteger)
+		case MUL:
+			return left * right, !(node.Integer && math.Abs(left*right) > MaxInteger)
+		case INTEGERDIV:
+			return math.Trunc(left / right), right != 0
+		case FLOATDIV:
+			return left / right, right != 0
+		case EQUAL:
+			return boolean(left == right), true
+		case NOTEQUAL:
+			return boolean(left != right), true
+		case LESS:
+			return boolean(left < right), true
+		case LESSEQUAL:
+			return boolean(left <= right), true
+		case GREATER:
+			return boolean(left > right), true
+		case GREATEREQUAL:
+			return boolean(left >= right), true
+		}
+	}
+	return 0, false
+}
+
+// notConstant ...
+// Reports that n has no constant value. A constant expression
+// dividing by zero or going beyond MaxInteger is reported as such.
+func (sa *SemanticAnalyzer) notConstant(n Node, format string, args ...interface{}) {
+	if tok, message, ok := sa.foldError(n); ok {
+		sa.error(tok, "%s", message)
+		return
+	}
+	sa.error(ExprToken(n), format, args...)
+}
+
+// foldError ...
+// Finds the operator or function of a division by zero or of an
+// INTEGER overflow in a constant expression
+func (sa *SemanticAnalyzer) foldError(n Node) (Token, string, bool) {
+	switch node := n.(type) {
+	case *Call:
+		if len(node.Args) != 1 {
+			break
+		}
+		if tok, message, ok := sa.foldError(node.Args[0]); ok {
+			return tok, message, ok
+		}
+		if _, ok := sa.constant(node.Args[0]); ok && node.Integer {
+			if _, ok := sa.constant(node); !ok {
+				return node.Tok, "integer overflow", true
+			}
+		}
+	case *UnaryOp:
+		return sa.foldError(node.Expr)
+	case *BinOp:
+		if tok, message, ok := sa.foldError(node.Left); ok {
+			return tok, message, ok
+		}
+		if tok, message, ok := sa.foldError(node.Right); ok {
+			return tok, message, ok
+		}
+		_, lok := sa.constant(node.Left)
+		right, rok := sa.constant(node.Right)
+		if !lok || !rok {
+			break
+		}
+		if right == 0 && (node.Op == INTEGERDIV || node.Op == FLOATDIV) {
+			return node.Tok, "division by zero", true
+		}
+		if _, ok := sa.constant(node); !ok && node.Integer {
+			return node.Tok, "integer overflow", true
+		}
+	}
+	return Token{}, "", false
+}
+
+// constantText ...
+// Reports whether n is a constant string or character
+func (sa *SemanticAnalyzer) constantText(n Node) bool {
+	switch node := n.(type) {
+	case *Str:
+		return true
+	case *Var:
+		s := sa.CurrentScope.Lookup(node.Value, false)
+		return node.With == nil && s != nil && s.Kind == ConstSymbol && s.Type != nil && s.Type.IsText()
+	case *BinOp:
+		return node.Concat && sa.constantText(node.Left) && sa.constantText(node.Right)
+	}
+	_, ok := sa.constant(n)
+	return ok
+}
+
+// enterScope ...
+func (sa *SemanticAnalyzer) enterScope(name string) {
+	level := 0
+	if sa.CurrentScope != nil {
+		level = sa.CurrentScope.ScopeLevel + 1
+	}
+	sa.CurrentScope = NewScopedSymbolTable(name, level, sa.CurrentScope)
+	sa.Scopes = append(sa.Scopes, sa.CurrentScope)
+}
+
+// GlobalScope ...
+// The scope of the program's own declarations, enclosed by the
+// scope of the builtins. It is nil until a program is analyzed.
+func (sa *SemanticAnalyzer) GlobalScope() *ScopedSymbolTable {
+	for _, scope := range sa.Scopes {
+		if scope.Enclosing != nil && scope.Enclosing.Enclosing == nil {
+			return scope
+		}
+	}
+	return nil
+}
+
+// SymbolAt ...
+// Returns the symbol referred to by the identifier at line and
+// column, or nil if there is none.
+func (sa *SemanticAnalyzer) SymbolAt(line, column int) *Symbol {
+	for s, refs := range sa.References {
+		for _, tok := range refs {
+			if tok.Line == line && tok.Column <= column && column < tok.Column+len(tok.Text) {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// VisitProgram ...
+func (sa *SemanticAnalyzer) VisitProgram(n Node) *Symbol {
+	node := n.(*Program)
+	sa.CurrentScope = NewBuiltinsScope()
+	sa.Scopes = append(sa.Scopes, sa.CurrentScope)
+	sa.declare(&Symbol{Kind: ProgramSymbol, Name: node.Name, Tok: node.Tok})
+	sa.enterScope("global")
+	sa.Visit(node.BlockNode)
+	sa.CurrentScope = sa.CurrentScope.Enclosing
+	return nil
+}
+
+// VisitBlock ...
+// Pointer types declared ahead of the type they point to are
+// completed once all the declarations are in, and every procedure
+// declared FORWARD must have been given its body by then
+func (sa *SemanticAnalyzer) VisitBlock(n Node) *Symbol {
+	node := n.(*Block)
+	enclosing, announced := sa.forward, sa.announced
+	sa.forward, sa.announced = nil, nil
+	for _, declaration := range node.Decls {
+		sa.Visit(declaration)
+	}
+	for _, pointer := range sa.forward {
+		pointer.Type = sa.Visit(sa.pointerTypes[pointer].Elem)
+	}
+	for _, s := range sa.announced {
+		sa.error(s.Tok, "FORWARD declaration of '%s' has no body", s.Name)
+	}
+	sa.forward, sa.announced = enclosing, announced
+	sa.Visit(node.CompoundStmt)
+	return nil
+}
+
+// VisitProcedureDecl ...
+// The procedure is declared before its body, which may call it.
+// The parameters are variables of the procedure's scope, which is
+// nested in the scope declaring the procedure. In the body of a
+// function its name stands for the result. A FORWARD declaration
+// only declares the procedure, the body follows later in the block
+// with the same heading.
+func (sa *SemanticAnalyzer) VisitProcedureDecl(n Node) *Symbol {
+	node := n.(*ProcedureDecl)
+	s := &Symbol{Kind: ProcedureSymbol, Name: node.Name, Tok: node.Tok}
+	if node.Result != nil {
+		s.Kind = FunctionSymbol
+		s.Type = sa.Visit(node.Result)
+		if s.Type != nil && s.Type.HoldsFile() {
+			sa.error(ExprToken(node.Result), "function '%s' cannot return a file", node.Name)
+		}
+		node.ResultDomain = domain(s.Type)
+	}
+	s.Params = sa.params(node.Params)
+	switch forward := sa.announcement(node.Name); {
+	case node.BlockNode == nil:
+		sa.declare(s)
+		if sa.CurrentScope.Lookup(s.Name, true) == s {
+			sa.procedures[s] = node
+			sa.announced = append(sa.announced, s)
+		}
+		return nil
+	case forward != nil:
+		sa.define(forward, s, node)
+		s = forward
+	default:
+		sa.declare(s)
+	}
+	sa.procedures[s] = node
+	sa.enterScope(node.Name)
+	node.Level = sa.CurrentScope.ScopeLevel
+	for _, param := range s.Params {
+		sa.declare(param)
+	}
+	if s.Kind == FunctionSymbol {
+		sa.functions = append(sa.functions, s)
+	}
+	sa.Visit(node.BlockNode)
+	if s.Kind == FunctionSymbol {
+		sa.functions = sa.functions[:len(sa.functions)-1]
+	}
+	sa.CurrentScope = sa.CurrentScope.Enclosing
+	return nil
+}
+
+// announcement ...
+// The procedure or function declared FORWARD in the block being
+// analyzed under name, nil if there is none waiting for its body
+func (sa *SemanticAnalyzer) announcement(name string) *Symbol {
+	for _, s := range sa.announced {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// define ...
+// Gives the procedure announced by forward its body, declared by
+// node with the heading s. The heading must repeat the one of the
+// FORWARD declaration: the same kind of routine, parameter names,
+// modes and types, and result type. References made so far to the
+// FORWARD declaration are pointed at the body.
+func (sa *SemanticAnalyzer) define(forward, s *Symbol, node *ProcedureDecl) {
+	same := forward.Kind == s.Kind && congruent(forward, s)
+	for i := 0; same && i < len(s.Params); i++ {
+		same = forward.Params[i].Name == s.Params[i].Name
+	}
+	if !same {
+		sa.error(node.Tok, "heading of '%s' does not match its FORWARD declaration %s", node.Name, forward)
+	}
+	forward.Kind, forward.Type, forward.Params = s.Kind, s.Type, s.Params
+	sa.References[forward] = append(sa.References[forward], node.Tok)
+	for _, decl := range sa.fixups[forward] {
+		*decl = node
+	}
+	delete(sa.fixups, forward)
+	for i, announced := range sa.announced {
+		if announced == forward {
+			sa.announced = append(sa.announced[:i], sa.announced[i+1:]...)
+			break
+		}
+	}
+}
+
+// refer ...
+// Points decl at the declaration of the procedure or function s,
+// to be pointed at the body later when s has only been announced
+func (sa *SemanticAnalyzer) refer(s *Symbol, decl **ProcedureDecl) {
+	*decl = sa.procedures[s]
+	if (*decl).BlockNode == nil {
+		sa.fixups[s] = append(sa.fixups[s], decl)
+	}
+}
+
+// params ...
+// The symbols of formal parameters, in order. Files, and arrays
+// and records holding them, can only be passed as VAR parameters.
+func (sa *SemanticAnalyzer) params(params []*Param) []*Symbol {
+	var symbols []*Symbol
+	for _, param := range params {
+		p := &Symbol{Kind: VarSymbol, Name: param.Name, Type: sa.typeOf(param.TNode), Mode: param.Mode, Tok: param.Tok}
+		if p.Type != nil && p.Type.HoldsFile() && p.Mode != VarArg {
+			sa.error(param.Tok, "file parameter '%s' must be a VAR parameter", param.Name)
+		}
+		param.Domain = domain(p.Type)
+		symbols = append(symbols, p)
+	}
+	return symbols
+}
+
+// isResult ...
+// Reports whether s is a function whose body is being analyzed
+func (sa *SemanticAnalyzer) isResult(s *Symbol) bool {
+	for _, function := range sa.functions {
+		if function == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VisitProceduralType ...
+// The parameter names only document the type
+func (sa *SemanticAnalyzer) VisitProceduralType(n Node) *Symbol {
+	node := n.(*ProceduralType)
+	var result *Symbol
+	if node.Result != nil {
+		result = sa.Visit(node.Result)
+	}
+	return NewProceduralTypeSymbol(sa.params(node.Params), result)
+}
+
+// routineSymbol ...
+// The procedure or function a name stands for, nil if n is not the
+// name of one or names the result of a function
+func (sa *SemanticAnalyzer) routineSymbol(n Node) *Symbol {
+	v, ok := n.(*Var)
+	if !ok || sa.isField(v.Value) {
+		return nil
+	}
+	s := sa.CurrentScope.Lookup(v.Value, false)
+	if s == nil || (s.Kind != ProcedureSymbol && s.Kind != FunctionSymbol) || sa.isResult(s) {
+		return nil
+	}
+	return s
+}
+
+// expect ...
+// Visits expression n where a value of type want is expected. The
+// name of a procedure or function stands for the routine itself when
+// want is a procedural type, rather than calling it.
+func (sa *SemanticAnalyzer) expect(n Node, want *Symbol) *Symbol {
+	s := sa.routineSymbol(n)
+	if s == nil || want == nil || want.Kind != ProceduralTypeSymbol {
+		return sa.Visit(n)
+	}
+	v := n.(*Var)
+	sa.References[s] = append(sa.References[s], v.Tok)
+	sa.refer(s, &v.Routine)
+	return NewProceduralTypeSymbol(s.Params, s.Type)
+}
+
+// VisitVarDecl ...
+func (sa *SemanticAnalyzer) VisitVarDecl(n Node) *Symbol {
+	node := n.(*VarDecl)
+	typesymbol := sa.typeOf(node.TNode)
+	vnode := node.VNode.(*Var)
+	sa.declare(&Symbol{Kind: VarSymbol, Name: vnode.Value, Type: typesymbol, Tok: vnode.Tok})
+	node.Domain = domain(typesymbol)
+	return nil
+}
+
+// domain ...
+// The numbers a variable of the type may hold, nil for types that
+// are neither numeric nor ordinal
+func domain(typesymbol *Symbol) *Domain {
+	switch {
+	case typesymbol == nil || !typesymbol.IsNumeric() && !typesymbol.IsOrdinal():
+		return nil
+	case typesymbol.IsBounded():
+		return &Domain{Ordinal: true, Range: &Bounds{typesymbol.Low, typesymbol.High}}
+	}
+	return &Domain{Ordinal: typesymbol.IsOrdinal()}
+}
+
+// typeOf ...
+// Visits a type spec once, however many names are declared with it
+func (sa *SemanticAnalyzer) typeOf(n Node) *Symbol {
+	if typesymbol, exists := sa.types[n]; exists {
+		return typesymbol
+	}
+	typesymbol := sa.Visit(n)
+	sa.types[n] = typesymbol
+	return typesymbol
+}
+
+// VisitTypeDecl ...
+// A new array or record type takes the declared name, a type
+// name declared as another one stands for the same type
+func (sa *SemanticAnalyzer) VisitTypeDecl(n Node) *Symbol {
+	node := n.(*TypeDecl)
+	typesymbol := sa.typeOf(node.TNode)
+	if _, alias := node.TNode.(*TypeN); !alias && typesymbol != nil {
+		typesymbol.Name = node.Name
+	}
+	s := &Symbol{Kind: TypeSymbol, Name: node.Name, Type: typesymbol, Tok: node.Tok}
+	sa.typeDecls[s] = node
+	sa.declare(s)
+	return nil
+}
+
+// VisitConstDecl ...
+// The value is computed here already, for constants to be usable
+// wherever the language needs a constant. String constants are
+// left for the interpreter to compute.
+func (sa *SemanticAnalyzer) VisitConstDecl(n Node) *Symbol {
+	node := n.(*ConstDecl)
+	typesymbol := sa.Visit(node.Expr)
+	value, ok := sa.constant(node.Expr)
+	if typesymbol != nil && !ok && !sa.constantText(node.Expr) {
+		sa.notConstant(node.Expr, "constant expression expected")
+	}
+	sa.declare(&Symbol{Kind: ConstSymbol, Name: node.Name, Type: typesymbol, Value: value, Tok: node.Tok})
+	return nil
+}
+
+// VisitType ...
+// Type names are annotated with their declaration
+func (sa *SemanticAnalyzer) VisitType(n Node) *Symbol {
+	node := n.(*TypeN)
+	if node.Tok.Type != IDENT {
+		return sa.builtin(node.Tok.Type)
+	}
+	s := sa.CurrentScope.Lookup(node.Tok.Svalue, false)
+	if s == nil {
+		sa.error(node.Tok, "identifier not found '%s'", node.Tok.Svalue)
+		return nil
+	}
+	sa.References[s] = append(sa.References[s], node.Tok)
+	if s.Kind != TypeSymbol {
+		sa.error(node.Tok, "'%s' is not a type", node.Tok.Svalue)
+		return nil
+	}
+	node.Decl = sa.typeDecls[s]
+	return s.Type
+}
+
+// VisitArrayType ...
+// A multi-dimensional array is an array of arrays, one per
+// remaining dimension
+func (sa *SemanticAnalyzer) VisitArrayType(n Node) *Symbol {
+	node := n.(*ArrayType)
+	indexes := make([]*Symbol, len(node.Indexes))
+	for i, index := range node.Indexes {
+		indexes[i] = sa.typeOf(index)
+		if indexes[i] != nil && !indexes[i].IsBounded() {
+			sa.error(ExprToken(index), "array index type must be an enumeration or a subrange, not %s", indexes[i].Name)
+			indexes[i] = nil
+		}
+	}
+	typesymbol := sa.typeOf(node.Elem)
+	for i := len(indexes) - 1; i >= 0; i-- {
+		if indexes[i] == nil || typesymbol == nil {
+			typesymbol = nil
+			continue
+		}
+		typesymbol = NewArrayTypeSymbol(indexes[i], typesymbol)
+	}
+	return sa.sized(node.Tok, typesymbol)
+}
+
+// MaxValues ...
+// The values of arrays and records are created whole when they
+// are declared, so a variable may hold at most this many, counting
+// every element and field
+const MaxValues = 1 << 20
+
+// valueCount ...
+// The number of values a variable of type t holds, up to
+// MaxValues+1
+func valueCount(t *Symbol) int {
+	switch t.Kind {
+	case ArrayTypeSymbol:
+		// t.High - t.Low + 1 overflows an int for bounds far apart,
+		// their difference as unsigned numbers does not
+		elem := valueCount(t.Type)
+		if uint64(t.High)-uint64(t.Low) >= uint64(MaxValues/elem) {
+			return MaxValues + 1
+		}
+		return (t.High - t.Low + 1) * elem
+	case RecordTypeSymbol:
+		count := 0
+		for _, field := range t.Fields.Symbols {
+			count = min(count+valueCount(field.Type), MaxValues+1)
+		}
+		return max(count, 1)
+	}
+	return 1
+}
+
+// sized ...
+// Reports an array or record type whose variables would hold more
+// than MaxValues values, returning nil for it
+func (sa *SemanticAnalyzer) sized(tok Token, typesymbol *Symbol) *Symbol {
+	if typesymbol != nil && valueCount(typesymbol) > MaxValues {
+		sa.error(tok, "type %s is too large, a variable of it would hold more than %d values", typesymbol.Name, MaxValues)
+		return nil
+	}
+	return typesymbol
+}
+
+// VisitSubrange ...
+func (sa *SemanticAnalyzer) VisitSubrange(n Node) *Symbol {
+	node := n.(*Subrange)
+	lowtype := sa.Visit(node.Low)
+	hightype := sa.Visit(node.High)
+	low, lok := sa.bound(node.Low, lowtype)
+	high, hok := sa.bound(node.High, hightype)
+	if !lok || !hok {
+		return nil
+	}
+	if !SameType(lowtype, hightype) {
+		sa.error(ExprToken(node.High), "subrange bounds %s and %s have different types", lowtype.Name, hightype.Name)
+		return nil
+	}
+	host := lowtype.Base()
+	if low > high {
+		sa.error(ExprToken(node.Low), "subrange %s..%s is empty", host.OrdinalName(low), host.OrdinalName(high))
+		return nil
+	}
+	return NewSubrangeTypeSymbol(host, low, high)
+}
+
+// VisitEnum ...
+// The names are declared as constants of the new type, holding
+// their position
+func (sa *SemanticAnalyzer) VisitEnum(n Node) *Symbol {
+	node := n.(*Enum)
+	enum := &Symbol{Kind: EnumTypeSymbol, High: len(node.Names) - 1}
+	for i, tok := range node.Names {
+		c := &Symbol{Kind: ConstSymbol, Name: tok.Svalue, Type: enum, Value: float64(i), Tok: tok}
+		enum.Consts = append(enum.Consts, c)
+		sa.declare(c)
+	}
+	enum.Name = enum.Spelling()
+	return enum
+}
+
+// VisitSetType ...
+// The elements must fit the bits of a set value
+func (sa *SemanticAnalyzer) VisitSetType(n Node) *Symbol {
+	node := n.(*SetType)
+	elem := sa.typeOf(node.Elem)
+	if elem == nil {
+		return nil
+	}
+	if !elem.IsBounded() || elem.Low < 0 || elem.High > MaxSetElement {
+		sa.error(ExprToken(node.Elem), "set element type must be an enumeration or a subrange within 0..%d, not %s", MaxSetElement, elem.Name)
+		return nil
+	}
+	return NewSetTypeSymbol(elem)
+}
+
+// VisitFileType ...
+// Components are stored in a fixed size, so that Seek can find
+// them. Strings, sets, pointers, routines and files cannot be.
+func (sa *SemanticAnalyzer) VisitFileType(n Node) *Symbol {
+	node := n.(*FileType)
+	elem := sa.typeOf(node.Elem)
+	if elem == nil {
+		return nil
+	}
+	encoding, ranges, ok := fileEncoding(elem)
+	if !ok {
+		sa.error(node.Tok, "file component type cannot be %s", elem.Name)
+		return nil
+	}
+	node.Encoding, node.Ranges = encoding, ranges
+	return NewFileTypeSymbol(elem)
+}
+
+// fileEncoding ...
+// How the scalars of a file component of type t are stored, in
+// order: arrays element by element, records field by field. ranges
+// holds the bounds of each scalar of a subrange or an enumeration,
+// nil for the others. ok is false if t cannot be stored.
+func fileEncoding(t *Symbol) (encoding []int, ranges []*Bounds, ok bool) {
+	var bounds *Bounds
+	if t.Kind == EnumTypeSymbol || t.Kind == SubrangeTypeSymbol {
+		bounds = &Bounds{t.Low, t.High}
+	}
+	switch {
+	case t.Accepts(IntegerArg):
+		return []int{IntegerEncoding}, []*Bounds{bounds}, true
+	case t.Accepts(NumberArg):
+		return []int{RealEncoding}, []*Bounds{nil}, true
+	case t.Accepts(CharArg), t.Accepts(BooleanArg):
+		return []int{ByteEncoding}, []*Bounds{bounds}, true
+	case t.Base().Kind == EnumTypeSymbol:
+		return []int{IntegerEncoding}, []*Bounds{bounds}, true
+	case t.Kind == ArrayTypeSymbol:
+		elem, elemranges, ok := fileEncoding(t.Type)
+		for i := t.Low; ok && i <= t.High; i++ {
+			encoding = append(encoding, elem...)
+			ranges = append(ranges, elemranges...)
+		}
+		return encoding, ranges, ok
+	case t.Kind == RecordTypeSymbol:
+		for _, field := range t.Fields.Symbols {
+			if field.Type == nil {
+				return nil, nil, false
+			}
+			scalars, scalarranges, ok := fileEncoding(field.Type)
+			if !ok {
+				return nil, nil, false
+			}
+			encoding = append(encoding, scalars...)
+			ranges = append(ranges, scalarranges...)
+		}
+		return encoding, ranges, true
+	}
+	return nil, nil, false
+}
+
+// VisitPointerType ...
+// A type name that is not declared yet is looked up again at the
+// end of the declarations
+func (sa *SemanticAnalyzer) VisitPointerType(n Node) *Symbol {
+	node := n.(*PointerType)
+	elem := node.Elem.(*TypeN)
+	pointer := NewPointerTypeSymbol(elem.Tok.Text)
+	sa.pointerTypes[pointer] = node
+	if elem.Tok.Type == IDENT && sa.CurrentScope.Lookup(elem.Tok.Svalue, false) == nil {
+		sa.forward = append(sa.forward, pointer)
+	} else {
+		pointer.Type = sa.Visit(elem)
+	}
+	return pointer
+}
+
+// VisitRecordType ...
+func (sa *SemanticAnalyzer) VisitRecordType(n Node) *Symbol {
+	node := n.(*RecordType)
+	fields := NewScopedSymbolTable("RECORD", 0, nil)
+	for _, field := range node.Fields {
+		decl := field.(*VarDecl)
+		typesymbol := sa.typeOf(decl.TNode)
+		vnode := decl.VNode.(*Var)
+		if fields.Lookup(vnode.Value, true) != nil {
+			sa.error(vnode.Tok, "duplicate field '%s' found", vnode.Value)
+			continue
+		}
+		if typesymbol == nil {
+			return nil
+		}
+		s := &Symbol{Kind: FieldSymbol, Name: vnode.Value, Type: typesymbol, Tok: vnode.Tok}
+		fields.Insert(s)
+		sa.References[s] = append(sa.References[s], s.Tok)
+	}
+	return sa.sized(node.Tok, NewRecordTypeSymbol(fields))
+}
+
+// bound ...
+// Checks that a subrange bound is an ordinal constant
+func (sa *SemanticAnalyzer) bound(n Node, typesymbol *Symbol) (int, bool) {
+	if typesymbol == nil {
+		return 0, false
+	}
+	value, ok := sa.constant(n)
+	if !ok || !typesymbol.IsOrdinal() || value != float64(int(value)) {
+		sa.notConstant(n, "subrange bound must be an ordinal constant")
+		return 0, false
+	}
+	return int(value), true
+}
+
+// VisitCompound ...
+func (sa *SemanticAnalyzer) VisitCompound(n Node) *Symbol {
+	for _, child := range n.(*Compound).Children {
+		sa.Visit(child)
+	}
+	return nil
+}
+
+// VisitAssign ...
+func (sa *SemanticAnalyzer) VisitAssign(n Node) *Symbol {
+	node := n.(*Assign)
+	if s := sa.routineSymbol(node.Left); s != nil {
+		sa.References[s] = append(sa.References[s], ExprToken(node.Left))
+		sa.Visit(node.Right)
+		sa.assignable(node.Left)
+		return nil
+	}
+	var left, right *Symbol
+	if sa.routineSymbol(node.Right) != nil {
+		left = sa.Visit(node.Left)
+		right = sa.expect(node.Right, left)
+	} else {
+		right = sa.Visit(node.Right)
+		left = sa.Visit(node.Left)
+	}
+	if !sa.assignable(node.Left) {
+		return nil
+	}
+	switch {
+	case left == nil || right == nil:
+	case left.HoldsFile():
+		sa.error(ExprToken(node.Left), "cannot assign files")
+	case Assignable(left, right):
+		node.Range = sa.checkRange(node.Right, left)
+	case left.Base().isBuiltin(STRING) && right.Base().isBuiltin(CHAR):
+		node.CharToString = true
+	case left.Name == right.Name:
+		sa.error(ExprToken(node.Left), "incompatible types, cannot assign %s to %s declared separately", right.Name, left.Name)
+	default:
+		sa.error(ExprToken(node.Left), "incompatible types, cannot assign %s to %s", right.Name, left.Name)
+	}
+	return nil
+}
+
+// readOnly ...
+// The constant, CONST parameter, procedure or function a variable
+// reference starts from, or the loop variable of an enclosing FOR
+// statement it is, nil if it may be assigned to. Functions may be
+// assigned their results in their bodies.
+func (sa *SemanticAnalyzer) readOnly(n Node) *Symbol {
+	v := BaseVar(n)
+	if v == nil {
+		return nil
+	}
+	s := sa.CurrentScope.Lookup(v.Value, false)
+	switch {
+	case s == nil:
+	case s.Kind == ConstSymbol, s.Kind == VarSymbol && s.Mode == ConstArg:
+		return s
+	case n == Node(v) && sa.loopVariable(v) == s:
+		return s
+	case s.Kind == ProcedureSymbol, s.Kind == FunctionSymbol && !sa.isResult(s):
+		return s
+	}
+	return nil
+}
+
+// assignable ...
+// Reports assignments to constants and CONST parameters, or to
+// their elements and fields, and to the variables of enclosing FOR
+// statements
+func (sa *SemanticAnalyzer) assignable(n Node) bool {
+	s := sa.readOnly(n)
+	switch {
+	case s == nil:
+		return true
+	case s.Kind == ConstSymbol:
+		sa.error(ExprToken(n), "cannot assign to constant '%s'", s.Name)
+	case s.Kind == VarSymbol && s.Mode != ConstArg:
+		sa.error(ExprToken(n), "cannot assign to loop variable '%s'", s.Name)
+	case s.Kind == ProcedureSymbol:
+		sa.error(ExprToken(n), "cannot assign to procedure '%s'", s.Name)
+	case s.Kind == FunctionSymbol:
+		sa.error(ExprToken(n), "cannot assign to function '%s'", s.Name)
+	default:
+		sa.error(ExprToken(n), "cannot assign to CONST parameter '%s'", s.Name)
+	}
+	return false
+}
+
+// checkRange ...
+// Returns the bounds a value of expression n must be checked
+// against when it is assigned to a subrange, reporting constants
+// that are out of range already. The elements of a set assigned
+// to a set variable are checked against its element type.
+func (sa *SemanticAnalyzer) checkRange(n Node, typesymbol *Symbol) *Bounds {
+	if typesymbol.Kind == SetTypeSymbol {
+		if set, ok := n.(*SetConstructor); ok {
+			for _, elem := range set.Elems {
+				sa.checkSetElement(elem, typesymbol)
+			}
+		}
+		return &Bounds{typesymbol.Low, typesymbol.High}
+	}
+	if typesymbol.Kind != SubrangeTypeSymbol {
+		return nil
+	}
+	if value, ok := sa.constant(n); ok && (value < float64(typesymbol.Low) || value > float64(typesymbol.High)) {
+		sa.error(ExprToken(n), "value %s out of range %s", typesymbol.Base().OrdinalName(int(value)), typesymbol.Name)
+	}
+	return &Bounds{typesymbol.Low, typesymbol.High}
+}
+
+// checkSetElement ...
+// Reports the constant bounds of a set element that the elements
+// of a set type do not range over
+func (sa *SemanticAnalyzer) checkSetElement(elem Node, typesymbol *Symbol) {
+	bounds := []Node{elem}
+	if subrange, ok := elem.(*Subrange); ok {
+		bounds = []Node{subrange.Low, subrange.High}
+	}
+	for _, bound := range bounds {
+		if value, ok := sa.constant(bound); ok && (value < float64(typesymbol.Low) || value > float64(typesymbol.High)) {
+			sa.error(ExprToken(bound), "set element %s out of range %s", typesymbol.Type.Base().OrdinalName(int(value)), typesymbol.Type.Name)
+			return
+		}
+	}
+}
+
+// VisitFor ...
+// The loop variable must be an ordinal variable, its bounds are
+// assigned to it
+func (sa *SemanticAnalyzer) VisitFor(n Node) *Symbol {
+	node := n.(*For)
+	v := node.Var.(*Var)
+	vartype := sa.Visit(v)
+	if !sa.assignable(v) {
+		vartype = nil
+	}
+	if vartype != nil && !vartype.IsOrdinal() {
+		sa.error(v.Tok, "loop variable '%s' must be ordinal, not %s", v.Value, vartype.Name)
+		vartype = nil
+	}
+	for _, bound := range []Node{node.Start, node.End} {
+		boundtype := sa.Visit(bound)
+		if vartype == nil || boundtype == nil {
+			continue
+		}
+		if !SameType(vartype, boundtype) || !boundtype.IsOrdinal() {
+			sa.error(ExprToken(bound), "incompatible types, cannot assign %s to %s", boundtype.Name, vartype.Name)
+			continue
+		}
+		node.Range = sa.checkRange(bound, vartype)
+	}
+	if !sa.isField(v.Value) {
+		sa.loops = append(sa.loops, sa.CurrentScope.Lookup(v.Value, false))
+		defer func() { sa.loops = sa.loops[:len(sa.loops)-1] }()
+	}
+	sa.Visit(node.Body)
+	return nil
+}
+
+// loopVariable ...
+// The symbol of v if it is the variable of an enclosing FOR
+// statement, nil otherwise
+func (sa *SemanticAnalyzer) loopVariable(v *Var) *Symbol {
+	if sa.isField(v.Value) {
+		return nil
+	}
+	s := sa.CurrentScope.Lookup(v.Value, false)
+	for _, loop := range sa.loops {
+		if loop != nil && loop == s {
+			return s
+		}
+	}
+	return nil
+}
+
+// notVariable ...
+// Reports that argument i of a call, counting from 1, cannot be
+// assigned by the routine named
+func (sa *SemanticAnalyzer) notVariable(arg Node, i int, name string) {
+	if v, ok := arg.(*Var); ok && sa.loopVariable(v) != nil {
+		sa.error(ExprToken(arg), "argument %d of '%s' cannot be loop variable '%s'", i, name, v.Value)
+		return
+	}
+	sa.error(ExprToken(arg), "argument %d of '%s' must be a variable", i, name)
+}
+
+// VisitCase ...
+// The labels must be constants of the selector type and no value
+// may choose more than one arm. Their ranges are recorded in order
+// for the interpreter.
+func (sa *SemanticAnalyzer) VisitCase(n Node) *Symbol {
+	node := n.(*Case)
+	selector := sa.Visit(node.Expr)
+	if selector != nil && !selector.IsOrdinal() {
+		sa.error(ExprToken(node.Expr), "CASE selector must be ordinal, not %s", selector.Name)
+		selector = nil
+	}
+	node.Ranges = nil
+	for i, arm := range node.Arms {
+		for _, label := range arm.Labels {
+			low, high := label, label
+			if subrange, ok := label.(*Subrange); ok {
+				low, high = subrange.Low, subrange.High
+			}
+			lowvalue, lok := sa.caseLabel(low, selector)
+			highvalue, hok := lowvalue, lok
+			if high != low {
+				highvalue, hok = sa.caseLabel(high, selector)
+			}
+			if !lok || !hok {
+				continue
+			}
+			if lowvalue > highvalue {
+				sa.error(ExprToken(label), "CASE label range %s is empty", sa.caseRange(selector, lowvalue, highvalue))
+				continue
+			}
+			sa.addCaseRange(node, CaseRange{lowvalue, highvalue, i}, label, selector)
+		}
+		sa.Visit(arm.Body)
+	}
+	for _, stmt := range node.Else {
+		sa.Visit(stmt)
+	}
+	sort.Slice(node.Ranges, func(i, j int) bool {
+		return node.Ranges[i].Low < node.Ranges[j].Low
+	})
+	return nil
+}
+
+// caseLabel ...
+// Checks one bound of a CASE label, ok is false after an error or
+// when the selector type is unknown
+func (sa *SemanticAnalyzer) caseLabel(n Node, selector *Symbol) (int, bool) {
+	typesymbol := sa.Visit(n)
+	if typesymbol == nil {
+		return 0, false
+	}
+	value, ok := sa.constant(n)
+	if !ok {
+		sa.notConstant(n, "CASE label must be a constant")
+		return 0, false
+	}
+	if selector == nil {
+		return 0, false
+	}
+	if !typesymbol.IsOrdinal() || !SameType(typesymbol, selector) {
+		sa.error(ExprToken(n), "incompatible types, CASE label is %s, selector is %s", typesymbol.Name, selector.Name)
+		return 0, false
+	}
+	return int(value), true
+}
+
+// addCaseRange ...
+// Records the values of a label, reporting those already taken
+func (sa *SemanticAnalyzer) addCaseRange(node *Case, r CaseRange, label Node, selector *Symbol) {
+	for _, other := range node.Ranges {
+		if r.Low > other.High || other.Low > r.High {
+			continue
+		}
+		if r.Low == r.High && other.Low == other.High {
+			sa.error(ExprToken(label), "duplicate CASE label %s", sa.caseRange(selector, r.Low, r.High))
+		} else {
+			sa.error(ExprToken(label), "CASE label %s overlaps %s", sa.caseRange(selector, r.Low, r.High), sa.caseRange(selector, other.Low, other.High))
+		}
+		return
+	}
+	node.Ranges = append(node.Ranges, r)
+}
+
+// caseRange ...
+// Spells the values low..high of the selector type
+func (sa *SemanticAnalyzer) caseRange(selector *Symbol, low, high int) string {
+	base := selector.Base()
+	if low == high {
+		return base.OrdinalName(low)
+	}
+	return base.OrdinalName(low) + ".." + base.OrdinalName(high)
+}
+
+// VisitWith ...
+// Each record is in scope for the records after it as well as
+// for the body
+func (sa *SemanticAnalyzer) VisitWith(n Node) *Symbol {
+	node := n.(*With)
+	depth := len(sa.withs)
+	for i, record := range node.Records {
+		typesymbol := sa.Visit(record)
+		if typesymbol == nil {
+			continue
+		}
+		if typesymbol.Kind != RecordTypeSymbol {
+			sa.error(ExprToken(record), "'%s' is not a record", exprString(record))
+			continue
+		}
+		sa.withs = append(sa.withs, withScope{node, i, typesymbol})
+	}
+	sa.Visit(node.Body)
+	sa.withs = sa.withs[:depth]
+	return nil
+}
+
+// isField ...
+// Reports whether name is a field of the records of the enclosing
+// WITH statements
+func (sa *SemanticAnalyzer) isField(name string) bool {
+	for _, scope := range sa.withs {
+		if scope.record.Fields.Lookup(name, true) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// VisitVar ...
+// Fields of the records of enclosing WITH statements hide the
+// variables with the same name
+func (sa *SemanticAnalyzer) VisitVar(n Node) *Symbol {
+	node := n.(*Var)
+	for i := len(sa.withs) - 1; i >= 0; i-- {
+		scope := sa.withs[i]
+		if field := scope.record.Fields.Lookup(node.Value, true); field != nil {
+			node.With, node.WithIndex = scope.with, scope.index
+			sa.References[field] = append(sa.References[field], node.Tok)
+			return field.Type
+		}
+	}
+	s := sa.CurrentScope.Lookup(node.Value, false)
+	switch {
+	case s == nil:
+	case s.Kind == FunctionSymbol:
+		return sa.functionName(node, s)
+	case s.Kind == ProcedureSymbol:
+		sa.References[s] = append(sa.References[s], node.Tok)
+		sa.error(node.Tok, "procedure '%s' has no value", node.Value)
+		return nil
+	case s.Kind == VarSymbol, s.Kind == ConstSymbol:
+		sa.References[s] = append(sa.References[s], node.Tok)
+		node.Level = sa.CurrentScope.Scope(node.Value).ScopeLevel
+		// builtins written in another case go by their own spelling
+		node.Value = s.Name
+		return s.Type
+	}
+	sa.error(node.Tok, "identifier not found '%s'", node.Value)
+	return nil
+}
+
+// functionName ...
+// The name of a function stands for its result in its body and
+// calls it anywhere else, when it takes no arguments
+func (sa *SemanticAnalyzer) functionName(node *Var, s *Symbol) *Symbol {
+	sa.References[s] = append(sa.References[s], node.Tok)
+	if sa.isResult(s) {
+		node.Level = sa.procedures[s].Level
+		return s.Type
+	}
+	if len(s.Params) > 0 {
+		sa.error(node.Tok, "'%s' takes %d argument%s, not 0", node.Value, len(s.Params), plural(len(s.Params)))
+		return nil
+	}
+	sa.refer(s, &node.Routine)
+	node.Invoke = true
+	return s.Type
+}
+
+// VisitIndex ...
+// Returns the element type, indexes that are constant are checked
+// against the bounds here already. Indexing a string gives its
+// characters, counting from 1.
+func (sa *SemanticAnalyzer) VisitIndex(n Node) *Symbol {
+	node := n.(*Index)
+	typesymbol := sa.Visit(node.Array)
+	for i, index := range node.Indexes {
+		indextype := sa.Visit(index)
+		if typesymbol == nil {
+			continue
+		}
+		if typesymbol.Base().isBuiltin(STRING) {
+			if indextype != nil && !indextype.Accepts(IntegerArg) {
+				sa.error(ExprToken(index), "string index must be INTEGER, not %s", indextype.Name)
+			} else if value, ok := sa.constant(index); ok && value < 1 {
+				sa.error(ExprToken(index), "string index %v is below 1", value)
+			}
+			typesymbol = sa.builtin(CHAR)
+			continue
+		}
+		if typesymbol.Kind != ArrayTypeSymbol {
+			if i == 0 {
+				sa.error(node.Tok, "'%s' is not an array or a string", exprString(node.Array))
+			} else {
+				sa.error(node.Tok, "too many indexes for '%s'", exprString(node.Array))
+			}
+			typesymbol = nil
+			continue
+		}
+		if indextype != nil && (!indextype.IsOrdinal() || !SameType(indextype, typesymbol.Index)) {
+			sa.error(ExprToken(index), "array index must be %s, not %s", typesymbol.Index.Base().Name, indextype.Name)
+		} else if value, ok := sa.constant(index); ok && (value < float64(typesymbol.Low) || value > float64(typesymbol.High)) {
+			sa.error(ExprToken(index), "index %s out of bounds %s", typesymbol.Index.Base().OrdinalName(int(value)), typesymbol.Index.Name)
+		}
+		typesymbol = typesymbol.Type
+	}
+	return typesymbol
+}
+
+// VisitField ...
+func (sa *SemanticAnalyzer) VisitField(n Node) *Symbol {
+	node := n.(*Field)
+	typesymbol := sa.Visit(node.Record)
+	if typesymbol == nil {
+		return nil
+	}
+	if typesymbol.Kind != RecordTypeSymbol {
+		sa.error(node.Tok, "'%s' is not a record", exprString(node.Record))
+		return nil
+	}
+	field := typesymbol.Fields.Lookup(node.Name, true)
+	if field == nil {
+		sa.error(node.Tok, "'%s' has no field '%s'", exprString(node.Record), node.Name)
+		return nil
+	}
+	sa.References[field] = append(sa.References[field], node.Tok)
+	return field.Type
+}
+
+// VisitDeref ...
+// Returns the type the pointer points to
+func (sa *SemanticAnalyzer) VisitDeref(n Node) *Symbol {
+	node := n.(*Deref)
+	typesymbol := sa.Visit(node.Pointer)
+	if typesymbol == nil {
+		return nil
+	}
+	if typesymbol.Kind != PointerTypeSymbol || typesymbol.isNil() {
+		sa.error(node.Tok, "'%s' is not a pointer", exprString(node.Pointer))
+		return nil
+	}
+	return typesymbol.Type
+}
+
+// VisitNil ...
+func (sa *SemanticAnalyzer) VisitNil(n Node) *Symbol {
+	return sa.builtin(NIL)
+}
+
+// VisitSetConstructor ...
+// The elements are ordinals of one type, its set type is the type
+// of the constructor. Constant elements are checked against the
+// range of set elements here already.
+func (sa *SemanticAnalyzer) VisitSetConstructor(n Node) *Symbol {
+	node := n.(*SetConstructor)
+	var elem *Symbol
+	ok := true
+	for _, e := range node.Elems {
+		bounds := []Node{e}
+		if subrange, isrange := e.(*Subrange); isrange {
+			bounds = []Node{subrange.Low, subrange.High}
+		}
+		for _, bound := range bounds {
+			typesymbol := sa.Visit(bound)
+			switch {
+			case typesymbol == nil:
+				ok = false
+			case !typesymbol.IsOrdinal():
+				sa.error(ExprToken(bound), "set element must be ordinal, not %s", typesymbol.Name)
+				ok = false
+			case elem != nil && !SameType(typesymbol, elem):
+				sa.error(ExprToken(bound), "incompatible types, set element is %s, expected %s", typesymbol.Name, elem.Name)
+				ok = false
+			default:
+				if elem == nil {
+					elem = typesymbol.Base()
+				}
+				if value, isconst := sa.constant(bound); isconst && (value < 0 || value > MaxSetElement) {
+					sa.error(ExprToken(bound), "set element %s out of range 0..%d", elem.OrdinalName(int(value)), MaxSetElement)
+				}
+			}
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return NewSetTypeSymbol(elem)
+}
+
+// isBuiltin ...
+// Reports whether a call is of a builtin function
+func (sa *SemanticAnalyzer) isBuiltin(node *Call) bool {
+	s := sa.CurrentScope.Lookup(node.Name, false)
+	return s != nil && s.Kind == BuiltinFunctionSymbol
+}
+
+// VisitCall ...
+// Returns the result type of the function. Of the builtins, Low and
+// High take a type name as well as a variable, the functions with a
+// Signature take arguments of the kinds it gives. A builtin written
+// in another case is renamed to its own spelling.
+func (sa *SemanticAnalyzer) VisitCall(n Node) *Symbol {
+	node := n.(*Call)
+	s := sa.CurrentScope.Lookup(node.Name, false)
+	if sa.isField(node.Name) || s != nil && s.Kind == VarSymbol {
+		node.Variable = NewVar(node.Tok, node.Name)
+		t := sa.procedural(node.Variable, node.Args, true)
+		if t == nil {
+			return nil
+		}
+		_, node.Ranges = sa.parameters(node.Tok, node.Args, t.Params)
+		return t.Type
+	}
+	if s != nil && s.Kind == FunctionSymbol {
+		sa.References[s] = append(sa.References[s], node.Tok)
+		sa.refer(s, &node.Decl)
+		_, node.Ranges = sa.parameters(node.Tok, node.Args, s.Params)
+		return s.Type
+	}
+	if s == nil || s.Kind != BuiltinFunctionSymbol {
+		if s == nil {
+			sa.error(node.Tok, "identifier not found '%s'", node.Name)
+		} else {
+			sa.References[s] = append(sa.References[s], node.Tok)
+			sa.error(node.Tok, "'%s' is not a function", node.Name)
+		}
+		for _, arg := range node.Args {
+			sa.Visit(arg)
+		}
+		return nil
+	}
+	sa.References[s] = append(sa.References[s], node.Tok)
+	node.Name = s.Name
+	if signature, exists := Signatures[node.Name]; exists {
+		if _, ok := sa.arguments(node.Tok, node.Args, signature); !ok {
+			return nil
+		}
+		if node.Name == "Chr" {
+			node.Range = &Bounds{0, MaxSetElement}
+		}
+		return sa.builtin(signature.Result)
+	}
+	if len(node.Args) != 1 {
+		sa.error(node.Tok, "'%s' takes 1 argument, not %d", node.Name, len(node.Args))
+		for _, arg := range node.Args {
+			sa.Visit(arg)
+		}
+		return nil
+	}
+	var argtype *Symbol
+	if node.Name == "Low" || node.Name == "High" {
+		argtype = sa.typeArg(node.Args[0])
+	} else {
+		argtype = sa.Visit(node.Args[0])
+	}
+	if argtype == nil {
+		return nil
+	}
+	switch node.Name {
+	case "Ord":
+		if argtype.IsOrdinal() {
+			return sa.builtin(INTEGER)
+		}
+	case "Succ", "Pred":
+		if argtype.IsOrdinal() {
+			if base := argtype.Base(); base.IsBounded() {
+				node.Range = &Bounds{base.Low, base.High}
+			}
+			node.Integer = argtype.Accepts(IntegerArg)
+			return argtype.Base()
+		}
+	case "Low", "High":
+		if argtype.Kind == ArrayTypeSymbol {
+			argtype = argtype.Index
+		}
+		if argtype.IsBounded() {
+			node.Range = &Bounds{argtype.Low, argtype.High}
+			return argtype.Base()
+		}
+	}
+	sa.error(node.Tok, "'%s' is not defined for %s", node.Name, argtype.Name)
+	return nil
+}
+
+// VisitProcedureCall ...
+// Records how each argument is passed. Val reading an INTEGER
+// is told apart from Val reading a REAL, and New is given the type
+// to allocate. A builtin written in another case is renamed to its
+// own spelling.
+func (sa *SemanticAnalyzer) VisitProcedureCall(n Node) *Symbol {
+	node := n.(*ProcedureCall)
+	s := sa.CurrentScope.Lookup(node.Name, false)
+	if sa.isField(node.Name) || s != nil && s.Kind == VarSymbol {
+		node.Variable = NewVar(node.Tok, node.Name)
+		if t := sa.procedural(node.Variable, node.Args, false); t != nil {
+			node.Modes, node.Ranges = sa.parameters(node.Tok, node.Args, t.Params)
+		}
+		return nil
+	}
+	if s != nil && s.Kind == ProcedureSymbol {
+		sa.References[s] = append(sa.References[s], node.Tok)
+		sa.refer(s, &node.Decl)
+		node.Modes, node.Ranges = sa.parameters(node.Tok, node.Args, s.Params)
+		return nil
+	}
+	if s == nil || s.Kind != BuiltinProcedureSymbol {
+		if s == nil {
+			sa.error(node.Tok, "identifier not found '%s'", node.Name)
+		} else {
+			sa.References[s] = append(sa.References[s], node.Tok)
+			sa.error(node.Tok, "'%s' is not a procedure", node.Name)
+		}
+		for _, arg := range node.Args {
+			sa.Visit(arg)
+		}
+		return nil
+	}
+	sa.References[s] = append(sa.References[s], node.Tok)
+	node.Name = s.Name
+	switch node.Name {
+	case "Read", "ReadLn", "Write", "WriteLn":
+		sa.transfer(node)
+		return nil
+	}
+	types, ok := sa.arguments(node.Tok, node.Args, Signatures[node.Name])
+	if !ok {
+		return nil
+	}
+	node.Modes = Signatures[node.Name].Modes
+	if node.Name == "Val" {
+		node.Integer = types[1].Accepts(IntegerArg)
+		node.Ranges = []*Bounds{nil, sa.checkRange(node.Args[1], types[1]), nil}
+	}
+	if node.Name == "New" {
+		node.Alloc = sa.pointerTypes[types[0]].Elem
+	}
+	return nil
+}
+
+// transfer ...
+// Checks the arguments of Read, ReadLn, Write and WriteLn: a file
+// variable, then the variables read into or the values written
+func (sa *SemanticAnalyzer) transfer(node *ProcedureCall) {
+	types := make([]*Symbol, len(node.Args))
+	for i, arg := range node.Args {
+		types[i] = sa.Visit(arg)
+	}
+	if len(node.Args) == 0 {
+		sa.error(node.Tok, "'%s' takes a file as its first argument", node.Name)
+		return
+	}
+	switch {
+	case types[0] != nil && !types[0].Accepts(FileArg):
+		sa.error(ExprToken(node.Args[0]), "'%s' takes a file as its first argument, not %s", node.Name, types[0].Name)
+	case !sa.isVariable(node.Args[0]):
+		sa.notVariable(node.Args[0], 1, node.Name)
+	case types[0] != nil && types[0].Kind == FileTypeSymbol:
+		sa.components(node, types[1:], types[0])
+		return
+	}
+	read := node.Name == "Read" || node.Name == "ReadLn"
+	mode, kinds, spelling := ValueArg, WriteKinds, "CHAR, STRING, INTEGER, REAL or BOOLEAN"
+	if read {
+		mode, kinds, spelling = OutArg, ReadKinds, "CHAR, STRING, INTEGER or REAL"
+	}
+	node.Modes = []int{VarArg}
+	node.Ranges = []*Bounds{nil}
+	for i, arg := range node.Args[1:] {
+		kind := -1
+		for _, k := range kinds {
+			if types[i+1] != nil && types[i+1].Accepts(k) {
+				kind = k
+				break
+			}
+		}
+		switch {
+		case read && !sa.isVariable(arg):
+			sa.notVariable(arg, i+2, node.Name)
+		case types[i+1] != nil && kind < 0:
+			sa.error(ExprToken(arg), "argument %d of '%s' must be %s, not %s", i+2, node.Name, spelling, types[i+1].Name)
+		}
+		var bounds *Bounds
+		if read && types[i+1] != nil {
+			bounds = sa.checkRange(arg, types[i+1])
+		}
+		node.Modes = append(node.Modes, mode)
+		node.Ranges = append(node.Ranges, bounds)
+		node.Kinds = append(node.Kinds, kind)
+	}
+}
+
+// components ...
+// Checks the arguments of Read and Write on a typed file, the
+// variables read into or the values written, which must be of its
+// component type. ReadLn and WriteLn are for lines of text only.
+func (sa *SemanticAnalyzer) components(node *ProcedureCall, types []*Symbol, file *Symbol) {
+	if node.Name == "ReadLn" || node.Name == "WriteLn" {
+		sa.error(node.Tok, "'%s' takes a TEXT file, not %s", node.Name, file.Name)
+		return
+	}
+	read := node.Name == "Read"
+	node.Modes = []int{VarArg}
+	node.Ranges = []*Bounds{nil}
+	for i, arg := range node.Args[1:] {
+		mode := ValueArg
+		if read {
+			mode = OutArg
+		}
+		var bounds *Bounds
+		switch {
+		case read && !sa.isVariable(arg):
+			sa.notVariable(arg, i+2, node.Name)
+		case types[i] == nil:
+		case read && !sameSymbol(types[i], file.Type), !read && !Assignable(file.Type, types[i]):
+			sa.error(ExprToken(arg), "argument %d of '%s' must be %s, not %s", i+2, node.Name, file.Type.Name, types[i].Name)
+		case !read:
+			bounds = sa.checkRange(arg, file.Type)
+		}
+		node.Modes = append(node.Modes, mode)
+		node.Ranges = append(node.Ranges, bounds)
+	}
+}
+
+// procedural ...
+// Visits the variable a call goes through, returning its procedural
+// type, a function type if function is set. The arguments are
+// visited anyway when it is not one.
+func (sa *SemanticAnalyzer) procedural(v *Var, args []Node, function bool) *Symbol {
+	t := sa.Visit(v)
+	if t != nil && t.Kind == ProceduralTypeSymbol && (t.Type != nil) == function {
+		return t
+	}
+	if t != nil && function {
+		sa.error(v.Tok, "'%s' is not a function", v.Value)
+	} else if t != nil {
+		sa.error(v.Tok, "'%s' is not a procedure", v.Value)
+	}
+	for _, arg := range args {
+		sa.Visit(arg)
+	}
+	return nil
+}
+
+// parameters ...
+// Checks the arguments of a call against the parameters of the
+// procedure or function, returning how each one is passed and the
+// bounds it is checked against. A VAR parameter takes a variable of
+// the same type, a value or CONST parameter anything that could be
+// assigned to it.
+func (sa *SemanticAnalyzer) parameters(tok Token, args []Node, params []*Symbol) ([]int, []*Bounds) {
+	types := make([]*Symbol, len(args))
+	for i, arg := range args {
+		if i < len(params) {
+			types[i] = sa.expect(arg, params[i].Type)
+		} else {
+			types[i] = sa.Visit(arg)
+		}
+	}
+	if len(args) != len(params) {
+		sa.error(tok, "'%s' takes %d argument%s, not %d", tok.Svalue, len(params), plural(len(params)), len(args))
+		return nil, nil
+	}
+	modes := make([]int, len(params))
+	ranges := make([]*Bounds, len(params))
+	for i, param := range params {
+		arg, argtype := args[i], types[i]
+		modes[i] = param.Mode
+		switch {
+		case param.Mode == VarArg && !sa.isVariable(arg):
+			sa.notVariable(arg, i+1, tok.Svalue)
+		case argtype == nil || param.Type == nil:
+		case param.Mode == VarArg:
+			if !sameSymbol(argtype, param.Type) {
+				sa.error(ExprToken(arg), "argument %d of '%s' must be %s, not %s", i+1, tok.Svalue, param.Type.Name, argtype.Name)
+			}
+		case Assignable(param.Type, argtype):
+			ranges[i] = sa.checkRange(arg, param.Type)
+		case param.Type.Base().isBuiltin(STRING) && argtype.Base().isBuiltin(CHAR):
+		default:
+			sa.error(ExprToken(arg), "argument %d of '%s' must be %s, not %s", i+1, tok.Svalue, param.Type.Name, argtype.Name)
+		}
+	}
+	return modes, ranges
+}
+
+// plural ...
+func plural(count int) string {
+	if count == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// arguments ...
+// Checks the arguments of a call against the signature of the
+// builtin, returning their types. Arguments passed by reference
+// must be variables.
+func (sa *SemanticAnalyzer) arguments(tok Token, args []Node, signature *Signature) ([]*Symbol, bool) {
+	types := make([]*Symbol, len(args))
+	for i, arg := range args {
+		types[i] = sa.Visit(arg)
+	}
+	count := len(signature.Kinds)
+	if len(args) != count && (!signature.Variadic || len(args) < count) {
+		sa.error(tok, "'%s' takes %d argument%s, not %d", tok.Svalue, count, plural(count), len(args))
+		return nil, false
+	}
+	ok := true
+	for i, arg := range args {
+		kind := signature.Kinds[min(i, count-1)]
+		if signature.Modes != nil && signature.Modes[i] != ValueArg && !sa.isVariable(arg) {
+			sa.notVariable(arg, i+1, tok.Svalue)
+			ok = false
+		} else if types[i] == nil {
+			ok = false
+		} else if !types[i].Accepts(kind) {
+			sa.error(ExprToken(arg), "argument %d of '%s' must be %s, not %s", i+1, tok.Svalue, ArgKinds[kind], types[i].Name)
+			ok = false
+		}
+	}
+	return types, ok
+}
+
+// isVariable ...
+// Reports whether n is a variable, an element, a field or a
+// dynamic variable, that can be assigned to
+func (sa *SemanticAnalyzer) isVariable(n Node) bool {
+	switch n.(type) {
+	case *Var, *Index, *Field, *Deref:
+		return sa.readOnly(n) == nil
+	}
+	return false
+}
+
+// typeArg ...
+// Visits an argument that may be a type name, returning the type
+func (sa *SemanticAnalyzer) typeArg(n Node) *Symbol {
+	if v, ok := n.(*Var); ok && !sa.isField(v.Value) {
+		if s := sa.CurrentScope.Lookup(v.Value, false); s != nil && s.Kind == TypeSymbol {
+			sa.References[s] = append(sa.References[s], v.Tok)
+			return s.Type
+		}
+	}
+	return sa.Visit(n)
+}
+
+// numeric ...
+// Reports operands that are not numbers
+func (sa *SemanticAnalyzer) numeric(op Token, operand *Symbol) bool {
+	if operand != nil && !operand.IsNumeric() {
+		sa.error(op, "operator '%s' is not defined for %s", op.Text, operand.Name)
+		return false
+	}
+	return operand != nil
+}
+
+// isSet ...
+func isSet(s *Symbol) bool {
+	return s != nil && s.Kind == SetTypeSymbol
+}
+
+// VisitBinOp ...
+// Arithmetic operators on sets stand for union, difference and
+// intersection. + joins strings and characters into a string.
+// Comparisons and IN give a BOOLEAN, DIV takes and gives integers.
+func (sa *SemanticAnalyzer) VisitBinOp(n Node) *Symbol {
+	node := n.(*BinOp)
+	left := sa.Visit(node.Left)
+	right := sa.Visit(node.Right)
+	switch node.Op {
+	case IN:
+		return sa.membership(node, left, right)
+	case EQUAL, NOTEQUAL, LESS, LESSEQUAL, GREATER, GREATEREQUAL:
+		return sa.comparison(node, left, right)
+	}
+	if node.Op == PLUS && left != nil && right != nil && left.IsText() && right.IsText() {
+		node.Concat = true
+		return sa.builtin(STRING)
+	}
+	if isSet(left) || isSet(right) {
+		return sa.setOperation(node, left, right)
+	}
+	if !sa.numeric(node.Tok, left) || !sa.numeric(node.Tok, right) {
+		return nil
+	}
+	node.Integer = left.Accepts(IntegerArg) && right.Accepts(IntegerArg) && node.Op != FLOATDIV
+	if node.Op == INTEGERDIV {
+		if !node.Integer {
+			sa.error(node.Tok, "operator '%s' is not defined for %s and %s", node.Tok.Text, left.Name, right.Name)
+			return nil
+		}
+		return sa.builtin(INTEGER)
+	}
+	if node.Op == FLOATDIV || left.Base() != right.Base() {
+		return sa.builtin(REAL)
+	}
+	return left.Base()
+}
+
+// setOperation ...
+// Returns the type of the left set, unless it is the empty set
+func (sa *SemanticAnalyzer) setOperation(node *BinOp, left, right *Symbol) *Symbol {
+	switch {
+	case left == nil || right == nil:
+		return nil
+	case node.Op == INTEGERDIV || node.Op == FLOATDIV || !isSet(left) || !isSet(right):
+		sa.error(node.Tok, "operator '%s' is not defined for %s and %s", node.Tok.Text, left.Name, right.Name)
+		return nil
+	case !SameType(left, right):
+		sa.error(node.Tok, "incompatible types, operator '%s' on %s and %s", node.Tok.Text, left.Name, right.Name)
+		return nil
+	case left.Type == nil:
+		return right
+	}
+	return left
+}
+
+// comparison ...
+// Numbers compare with each other, other ordinals with their own
+// type. Strings compare with strings and characters. Sets compare
+// for equality and inclusion, pointers for equality only.
+func (sa *SemanticAnalyzer) comparison(node *BinOp, left, right *Symbol) *Symbol {
+	switch {
+	case left == nil || right == nil:
+		return nil
+	case isSet(left) && isSet(right) && node.Op != LESS && node.Op != GREATER:
+		if !SameType(left, right) {
+			sa.error(node.Tok, "incompatible types, operator '%s' on %s and %s", node.Tok.Text, left.Name, right.Name)
+			return nil
+		}
+	case left.Kind == PointerTypeSymbol && right.Kind == PointerTypeSymbol && (node.Op == EQUAL || node.Op == NOTEQUAL):
+		if !SameType(left, right) {
+			sa.error(node.Tok, "incompatible types, operator '%s' on %s and %s", node.Tok.Text, left.Name, right.Name)
+			return nil
+		}
+	case left.IsNumeric() && right.IsNumeric():
+	case left.IsText() && right.IsText():
+	case left.IsOrdinal() && right.IsOrdinal():
+		if !SameType(left, right) {
+			sa.error(node.Tok, "incompatible types, operator '%s' on %s and %s", node.Tok.Text, left.Name, right.Name)
+			return nil
+		}
+	default:
+		sa.error(node.Tok, "operator '%s' is not defined for %s and %s", node.Tok.Text, left.Name, right.Name)
+		return nil
+	}
+	return sa.builtin(BOOLEAN)
+}
+
+// membership ...
+// x IN s, x must be of the element type of s
+func (sa *SemanticAnalyzer) membership(node *BinOp, left, right *Symbol) *Symbol {
+	switch {
+	case left == nil || right == nil:
+		return nil
+	case !left.IsOrdinal() || !isSet(right):
+		sa.error(node.Tok, "operator '%s' is not defined for %s and %s", node.Tok.Text, left.Name, right.Name)
+		return nil
+	case right.Type != nil && !SameType(left, right.Type):
+		sa.error(node.Tok, "incompatible types, operator '%s' on %s and %s", node.Tok.Text, left.Name, right.Name)
+		return nil
+	}
+	return sa.builtin(BOOLEAN)
+}
+
+// VisitUnaryOp ...
+func (sa *SemanticAnalyzer) VisitUnaryOp(n Node) *Symbol {
+	node := n.(*UnaryOp)
+	operand := sa.Visit(node.Expr)
+	if !sa.numeric(node.Tok, operand) {
+		return nil
+	}
+	return operand.Base()
+}
+
+// VisitNum ...
+func (sa *SemanticAnalyzer) VisitNum(n Node) *Symbol {
+	if n.(*Num).Tok.Type == REALCONST {
+		return sa.builtin(REAL)
+	}
+	return sa.builtin(INTEGER)
+}
+
+// VisitStr ...
+// A string of one character is a CHAR
+func (sa *SemanticAnalyzer) VisitStr(n Node) *Symbol {
+	if len(n.(*Str).Value) == 1 {
+		return sa.builtin(CHAR)
+	}
+	return sa.builtin(STRING)
+}
+
+// VisitNoOp ...
+func (sa *SemanticAnalyzer) VisitNoOp(n Node) *Symbol { return nil }
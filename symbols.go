@@ -0,0 +1,638 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Symbol kinds
+const (
+	BuiltinTypeSymbol = iota
+	VarSymbol
+	ProgramSymbol
+	ArrayTypeSymbol
+	RecordTypeSymbol
+	FieldSymbol
+	TypeSymbol
+	ConstSymbol
+	EnumTypeSymbol
+	SubrangeTypeSymbol
+	BuiltinFunctionSymbol
+	SetTypeSymbol
+	BuiltinProcedureSymbol
+	PointerTypeSymbol
+	ProcedureSymbol
+	FunctionSymbol
+	ProceduralTypeSymbol
+	FileTypeSymbol
+)
+
+// Symbol ...
+type Symbol struct {
+	Kind int
+	Name string
+	// Type of a variable or field, the element type of an array or a
+	// set type, the host type of a subrange, the type a pointer type
+	// points to, the type a type name stands for, the result type
+	// of a function or a procedural type or the component type of a
+	// file type
+	Type *Symbol
+	// Index is the index type of an array type
+	Index *Symbol
+	// Low and High bound the values of an ordinal type, the index
+	// of an array type or the elements of a set type
+	Low, High int
+	// Consts are the names of an enumerated type
+	Consts []*Symbol
+	// Value of a constant
+	Value float64
+	// Fields of a record type
+	Fields *ScopedSymbolTable
+	// Params of a procedure, a function or a procedural type, in
+	// order. A parameter is a variable with the Mode it is passed in.
+	Params []*Symbol
+	Mode   int
+	// Tok is the identifier in the declaration, builtin types have none
+	Tok Token
+}
+
+// NewArrayTypeSymbol ...
+// Array types have no name of their own, they are called by
+// their spelling until a TYPE declaration names them
+func NewArrayTypeSymbol(index, elem *Symbol) *Symbol {
+	s := &Symbol{
+		Kind:  ArrayTypeSymbol,
+		Type:  elem,
+		Index: index,
+		Low:   index.Low,
+		High:  index.High,
+	}
+	s.Name = s.Spelling()
+	return s
+}
+
+// NewRecordTypeSymbol ...
+// Record types are called by their spelling as well
+func NewRecordTypeSymbol(fields *ScopedSymbolTable) *Symbol {
+	s := &Symbol{
+		Kind:   RecordTypeSymbol,
+		Fields: fields,
+	}
+	s.Name = s.Spelling()
+	return s
+}
+
+// NewSetTypeSymbol ...
+// Set types are called by their spelling as well. The set type of
+// the empty set [] has no element type.
+func NewSetTypeSymbol(elem *Symbol) *Symbol {
+	s := &Symbol{
+		Kind: SetTypeSymbol,
+		Type: elem,
+	}
+	if elem != nil {
+		s.Low, s.High = elem.Low, elem.High
+	}
+	s.Name = s.Spelling()
+	return s
+}
+
+// NewPointerTypeSymbol ...
+// Pointer types are called by their spelling as well. The type
+// pointed to is set once its name has been found, which may be
+// declared after the pointer type.
+func NewPointerTypeSymbol(name string) *Symbol {
+	return &Symbol{
+		Kind: PointerTypeSymbol,
+		Name: "^" + name,
+	}
+}
+
+// NewProceduralTypeSymbol ...
+// Procedural types are called by their spelling until a TYPE
+// declaration names them. The result is nil for a procedure.
+func NewProceduralTypeSymbol(params []*Symbol, result *Symbol) *Symbol {
+	s := &Symbol{Kind: ProceduralTypeSymbol, Params: params, Type: result}
+	s.Name = s.Spelling()
+	return s
+}
+
+// NewFileTypeSymbol ...
+// File types are called by their spelling as well
+func NewFileTypeSymbol(elem *Symbol) *Symbol {
+	s := &Symbol{Kind: FileTypeSymbol, Type: elem}
+	s.Name = s.Spelling()
+	return s
+}
+
+// NewSubrangeTypeSymbol ...
+func NewSubrangeTypeSymbol(host *Symbol, low, high int) *Symbol {
+	s := &Symbol{
+		Kind: SubrangeTypeSymbol,
+		Type: host,
+		Low:  low,
+		High: high,
+	}
+	s.Name = s.Spelling()
+	return s
+}
+
+// Spelling ...
+// The type written out in Pascal, component types by their names
+func (s *Symbol) Spelling() string {
+	switch s.Kind {
+	case ArrayTypeSymbol:
+		return fmt.Sprintf("ARRAY[%s] OF %s", s.Index.Name, s.Type.Name)
+	case EnumTypeSymbol:
+		var list []string
+		for _, c := range s.Consts {
+			list = append(list, c.Name)
+		}
+		return "(" + strings.Join(list, ", ") + ")"
+	case SubrangeTypeSymbol:
+		return s.Type.OrdinalName(s.Low) + ".." + s.Type.OrdinalName(s.High)
+	case SetTypeSymbol:
+		if s.Type == nil {
+			return "[]"
+		}
+		return "SET OF " + s.Type.Name
+	case FileTypeSymbol:
+		return "FILE OF " + s.Type.Name
+	case RecordTypeSymbol:
+		var list []string
+		for _, field := range s.Fields.Symbols {
+			list = append(list, field.String())
+		}
+		return "RECORD " + strings.Join(list, "; ") + " END"
+	case ProceduralTypeSymbol:
+		if s.Type == nil {
+			return "PROCEDURE" + s.signature()
+		}
+		return "FUNCTION" + s.signature()
+	}
+	return s.Name
+}
+
+// signature ...
+// The parameter list of a procedure, a function or a procedural
+// type, followed by the result type if there is one
+func (s *Symbol) signature() string {
+	spelling := ""
+	if len(s.Params) > 0 {
+		params := make([]string, len(s.Params))
+		for i, param := range s.Params {
+			params[i] = param.paramString()
+		}
+		spelling = "(" + strings.Join(params, "; ") + ")"
+	}
+	if s.Type != nil {
+		spelling += " : " + s.Type.Name
+	}
+	return spelling
+}
+
+// congruent ...
+// Reports whether routines with the signatures of a and b can
+// stand for each other: their parameters are passed in the same
+// modes, with the same types, and so are their results
+func congruent(a, b *Symbol) bool {
+	if len(a.Params) != len(b.Params) || !sameSymbol(a.Type, b.Type) {
+		return false
+	}
+	for i, param := range a.Params {
+		if param.Mode != b.Params[i].Mode || !sameSymbol(param.Type, b.Params[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameSymbol ...
+// Reports whether a and b are the same type, procedural types
+// being the same when they are congruent
+func sameSymbol(a, b *Symbol) bool {
+	if a != nil && b != nil && a.Kind == ProceduralTypeSymbol && b.Kind == ProceduralTypeSymbol {
+		return congruent(a, b)
+	}
+	return a == b
+}
+
+// OrdinalName ...
+// Spells a value of an ordinal type, by name for enumerations and
+// quoted for characters
+func (s *Symbol) OrdinalName(value int) string {
+	if s.Kind == EnumTypeSymbol && 0 <= value && value < len(s.Consts) {
+		return s.Consts[value].Name
+	}
+	if s.isBuiltin(CHAR) && 0 <= value && value <= MaxSetElement {
+		return quoteString(string([]byte{byte(value)}))
+	}
+	return strconv.Itoa(value)
+}
+
+// Spell ...
+// Formats a value of type s like formatValue, but spelling
+// characters, BOOLEAN and the values of enumerations by name, in
+// arrays, records and sets as well
+func (s *Symbol) Spell(v Value) string {
+	base := s.Base()
+	switch v := v.(type) {
+	case float64:
+		if base.IsBounded() && v == float64(int(v)) {
+			return base.OrdinalName(int(v))
+		}
+	case *ArrayValue:
+		if base.Kind == ArrayTypeSymbol {
+			elems := make([]string, len(v.Elems))
+			for i, elem := range v.Elems {
+				elems[i] = base.Type.Spell(elem)
+			}
+			return "[" + strings.Join(elems, ", ") + "]"
+		}
+	case *RecordValue:
+		if base.Kind == RecordTypeSymbol {
+			fields := make([]string, len(v.Names))
+			for i, name := range v.Names {
+				fields[i] = name + ": " + base.Fields.Lookup(name, true).Type.Spell(v.Fields[name])
+			}
+			return "(" + strings.Join(fields, "; ") + ")"
+		}
+	case SetValue:
+		if base.Kind == SetTypeSymbol && base.Type != nil {
+			var elems []string
+			for _, elem := range v.Elems() {
+				elems = append(elems, base.Type.Base().OrdinalName(elem))
+			}
+			return "[" + strings.Join(elems, ", ") + "]"
+		}
+	case *Alias:
+		return s.Spell(v.Get())
+	}
+	return formatValue(v)
+}
+
+// isBuiltin ...
+// Reports whether s is the predefined type for a type keyword
+func (s *Symbol) isBuiltin(tokType int) bool {
+	return s.Kind == BuiltinTypeSymbol && s.Name == keyword(tokType)
+}
+
+// isNil ...
+// Reports whether s is the type of NIL
+func (s *Symbol) isNil() bool {
+	return s.Kind == PointerTypeSymbol && s.Name == keyword(NIL)
+}
+
+// Base ...
+// The host type of a subrange, the type itself otherwise
+func (s *Symbol) Base() *Symbol {
+	if s.Kind == SubrangeTypeSymbol {
+		return s.Type.Base()
+	}
+	return s
+}
+
+// IsNumeric ...
+func (s *Symbol) IsNumeric() bool {
+	base := s.Base()
+	return base.isBuiltin(INTEGER) || base.isBuiltin(REAL)
+}
+
+// IsOrdinal ...
+// Integers, characters, enumerations and subranges of them
+func (s *Symbol) IsOrdinal() bool {
+	base := s.Base()
+	return base.Kind == EnumTypeSymbol || base.isBuiltin(INTEGER) || base.isBuiltin(CHAR)
+}
+
+// IsText ...
+// Strings and characters, which + joins into a string
+func (s *Symbol) IsText() bool {
+	base := s.Base()
+	return base.isBuiltin(STRING) || base.isBuiltin(CHAR)
+}
+
+// IsFile ...
+// Files are variables only, they cannot be assigned or passed by
+// value
+func (s *Symbol) IsFile() bool {
+	return s.isBuiltin(TEXT) || s.Kind == FileTypeSymbol
+}
+
+// HoldsFile ...
+// Reports whether s is a file or an array or record with a file
+// among its elements or fields, which cannot be assigned or passed
+// by value either
+func (s *Symbol) HoldsFile() bool {
+	switch {
+	case s.IsFile():
+		return true
+	case s.Kind == ArrayTypeSymbol:
+		return s.Type != nil && s.Type.HoldsFile()
+	case s.Kind == RecordTypeSymbol:
+		for _, field := range s.Fields.Symbols {
+			if field.Type != nil && field.Type.HoldsFile() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsBounded ...
+// Reports whether Low and High hold the range of an ordinal type
+func (s *Symbol) IsBounded() bool {
+	return s.Kind == EnumTypeSymbol || s.Kind == SubrangeTypeSymbol || s.isBuiltin(CHAR)
+}
+
+// SameType ...
+// Reports whether values of the two types can be assigned to each
+// other. A subrange goes with its host type. Other types follow the
+// name equivalence of Pascal: every type spec makes a new type,
+// which is only the same as itself, whatever its structure. Type
+// names declared as another type name stand for the same type.
+// INTEGER and REAL are different types, see Assignable. Sets go
+// together when their elements do, the empty set with any set. NIL
+// goes with any pointer. Procedural types go together when they
+// are congruent.
+func SameType(a, b *Symbol) bool {
+	a, b = a.Base(), b.Base()
+	if a.Kind == ProceduralTypeSymbol && b.Kind == ProceduralTypeSymbol {
+		return congruent(a, b)
+	}
+	if a.Kind == SetTypeSymbol && b.Kind == SetTypeSymbol {
+		return a.Type == nil || b.Type == nil || SameType(a.Type, b.Type)
+	}
+	if a.Kind == PointerTypeSymbol && b.Kind == PointerTypeSymbol && (a.isNil() || b.isNil()) {
+		return true
+	}
+	return a == b
+}
+
+// Assignable ...
+// Reports whether a value of type value can be assigned to a
+// variable of type target: a value of the same type, or an integer
+// widened to REAL
+func Assignable(target, value *Symbol) bool {
+	return SameType(target, value) || target.Base().isBuiltin(REAL) && value.Base().isBuiltin(INTEGER)
+}
+
+// String ...
+// The symbol as it would be declared in Pascal
+func (s *Symbol) String() string {
+	switch s.Kind {
+	case VarSymbol:
+		keyword := "VAR"
+		if s.Mode == ConstArg {
+			keyword = "CONST"
+		}
+		if s.Type == nil {
+			return keyword + " " + s.Name
+		}
+		return fmt.Sprintf("%s %s : %s", keyword, s.Name, s.Type.Name)
+	case FieldSymbol:
+		return fmt.Sprintf("%s : %s", s.Name, s.Type.Name)
+	case ConstSymbol:
+		if s.Type != nil && !s.Type.IsNumeric() {
+			return fmt.Sprintf("CONST %s : %s", s.Name, s.Type.Name)
+		}
+		return fmt.Sprintf("CONST %s = %s", s.Name, strconv.FormatFloat(s.Value, 'g', -1, 64))
+	case TypeSymbol:
+		if s.Type == nil {
+			return "TYPE " + s.Name
+		}
+		if s.Type.Name == s.Name {
+			return fmt.Sprintf("TYPE %s = %s", s.Name, s.Type.Spelling())
+		}
+		return fmt.Sprintf("TYPE %s = %s", s.Name, s.Type.Name)
+	case ProgramSymbol:
+		return fmt.Sprintf("PROGRAM %s", s.Name)
+	case BuiltinFunctionSymbol:
+		return fmt.Sprintf("FUNCTION %s", s.Name)
+	case BuiltinProcedureSymbol:
+		return fmt.Sprintf("PROCEDURE %s", s.Name)
+	case ProcedureSymbol:
+		return fmt.Sprintf("PROCEDURE %s%s", s.Name, s.signature())
+	case FunctionSymbol:
+		return fmt.Sprintf("FUNCTION %s%s", s.Name, s.signature())
+	}
+	return s.Name
+}
+
+// paramString ...
+// A parameter as it is declared in the parameter list
+func (s *Symbol) paramString() string {
+	spelling := s.Name
+	switch s.Mode {
+	case VarArg:
+		spelling = "VAR " + spelling
+	case ConstArg:
+		spelling = "CONST " + spelling
+	}
+	if s.Type == nil {
+		return spelling
+	}
+	return spelling + " : " + s.Type.Name
+}
+
+// ScopedSymbolTable ...
+type ScopedSymbolTable struct {
+	ScopeName  string
+	ScopeLevel int
+	Enclosing  *ScopedSymbolTable
+	// Symbols in the order they were declared
+	Symbols []*Symbol
+	// AnyCase makes names match whatever their case, as the names
+	// of the builtins do
+	AnyCase bool
+
+	symbols map[string]*Symbol
+}
+
+// NewScopedSymbolTable ...
+func NewScopedSymbolTable(name string, level int, enclosing *ScopedSymbolTable) *ScopedSymbolTable {
+	return &ScopedSymbolTable{
+		ScopeName:  name,
+		ScopeLevel: level,
+		Enclosing:  enclosing,
+		symbols:    make(map[string]*Symbol),
+	}
+}
+
+// Insert ...
+func (t *ScopedSymbolTable) Insert(s *Symbol) {
+	t.symbols[t.key(s.Name)] = s
+	t.Symbols = append(t.Symbols, s)
+}
+
+// Lookup ...
+// Search the scope and then its enclosing scopes, unless
+// currentScopeOnly is set. Returns nil if there is no such symbol.
+func (t *ScopedSymbolTable) Lookup(name string, currentScopeOnly bool) *Symbol {
+	if s, exists := t.symbols[t.key(name)]; exists {
+		return s
+	}
+	if currentScopeOnly || t.Enclosing == nil {
+		return nil
+	}
+	return t.Enclosing.Lookup(name, false)
+}
+
+// Scope ...
+// The scope that declares name, searching outwards from t like
+// Lookup. Returns nil if there is none.
+func (t *ScopedSymbolTable) Scope(name string) *ScopedSymbolTable {
+	for scope := t; scope != nil; scope = scope.Enclosing {
+		if _, exists := scope.symbols[scope.key(name)]; exists {
+			return scope
+		}
+	}
+	return nil
+}
+
+// key ...
+// The key name is held under in the table
+func (t *ScopedSymbolTable) key(name string) string {
+	if t.AnyCase {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// Booleans ...
+// The values of BOOLEAN, an enumeration predefined as (False, True)
+var Booleans = []string{"False", "True"}
+
+// NewBuiltinsScope ...
+// Scope holding the predefined types and functions, enclosing the
+// global scope. Their names may be written in any case.
+func NewBuiltinsScope() *ScopedSymbolTable {
+	t := NewScopedSymbolTable("builtins", 0, nil)
+	t.AnyCase = true
+	t.Insert(&Symbol{Kind: BuiltinTypeSymbol, Name: keyword(INTEGER)})
+	t.Insert(&Symbol{Kind: BuiltinTypeSymbol, Name: keyword(REAL)})
+	boolean := &Symbol{Kind: EnumTypeSymbol, Name: keyword(BOOLEAN), High: len(Booleans) - 1}
+	for i, name := range Booleans {
+		c := &Symbol{Kind: ConstSymbol, Name: name, Type: boolean, Value: float64(i)}
+		boolean.Consts = append(boolean.Consts, c)
+		t.Insert(c)
+	}
+	t.Insert(boolean)
+	t.Insert(&Symbol{Kind: BuiltinTypeSymbol, Name: keyword(CHAR), High: MaxSetElement})
+	t.Insert(&Symbol{Kind: BuiltinTypeSymbol, Name: keyword(STRING)})
+	t.Insert(&Symbol{Kind: BuiltinTypeSymbol, Name: keyword(TEXT)})
+	t.Insert(&Symbol{Kind: PointerTypeSymbol, Name: keyword(NIL)})
+	for _, name := range []string{"Ord", "Succ", "Pred", "Low", "High", "Chr",
+		"Length", "Copy", "Pos", "Concat", "UpCase", "IntToStr", "Eof", "Eoln", "FilePos", "FileSize"} {
+		t.Insert(&Symbol{Kind: BuiltinFunctionSymbol, Name: name})
+	}
+	for _, name := range []string{"Insert", "Delete", "Str", "Val", "New", "Dispose",
+		"Assign", "Reset", "Rewrite", "Append", "Close", "Read", "ReadLn", "Write", "WriteLn",
+		"Seek", "Truncate"} {
+		t.Insert(&Symbol{Kind: BuiltinProcedureSymbol, Name: name})
+	}
+	return t
+}
+
+// Argument kinds of the builtins with a Signature
+const (
+	// TextArg is a STRING or a CHAR
+	TextArg = iota
+	StringArg
+	CharArg
+	IntegerArg
+	// NumberArg is an INTEGER or a REAL
+	NumberArg
+	PointerArg
+	FileArg
+	BooleanArg
+	TextFileArg
+	TypedFileArg
+)
+
+// ArgKinds ...
+// Spells the argument kinds in error messages
+var ArgKinds = []string{"STRING or CHAR", "STRING", "CHAR", "INTEGER", "INTEGER or REAL", "a pointer", "a file", "BOOLEAN",
+	"a TEXT file", "a typed file"}
+
+// Signature ...
+// The arguments of a builtin function or procedure, and the type
+// keyword of the result of a function
+type Signature struct {
+	Kinds []int
+	// Modes holds the passing mode of each argument, all are passed
+	// by value when it is nil
+	Modes []int
+	// Variadic signatures repeat their last argument any number of times
+	Variadic bool
+	Result   int
+}
+
+// Signatures ...
+// The builtins whose arguments have fixed kinds. Ord, Succ, Pred,
+// Low and High take an argument of any ordinal type instead, Read
+// and Write a file and then values of any of several kinds.
+var Signatures = map[string]*Signature{
+	"Chr":      {Kinds: []int{IntegerArg}, Result: CHAR},
+	"Length":   {Kinds: []int{TextArg}, Result: INTEGER},
+	"Copy":     {Kinds: []int{TextArg, IntegerArg, IntegerArg}, Result: STRING},
+	"Pos":      {Kinds: []int{TextArg, TextArg}, Result: INTEGER},
+	"Concat":   {Kinds: []int{TextArg}, Variadic: true, Result: STRING},
+	"UpCase":   {Kinds: []int{CharArg}, Result: CHAR},
+	"IntToStr": {Kinds: []int{IntegerArg}, Result: STRING},
+	"Insert":   {Kinds: []int{TextArg, StringArg, IntegerArg}, Modes: []int{ValueArg, VarArg, ValueArg}},
+	"Delete":   {Kinds: []int{StringArg, IntegerArg, IntegerArg}, Modes: []int{VarArg, ValueArg, ValueArg}},
+	"Str":      {Kinds: []int{NumberArg, StringArg}, Modes: []int{ValueArg, OutArg}},
+	"Val":      {Kinds: []int{TextArg, NumberArg, IntegerArg}, Modes: []int{ValueArg, OutArg, OutArg}},
+	"New":      {Kinds: []int{PointerArg}, Modes: []int{OutArg}},
+	"Dispose":  {Kinds: []int{PointerArg}},
+	"Assign":   {Kinds: []int{FileArg, TextArg}, Modes: []int{VarArg, ValueArg}},
+	"Reset":    {Kinds: []int{FileArg}, Modes: []int{VarArg}},
+	"Rewrite":  {Kinds: []int{FileArg}, Modes: []int{VarArg}},
+	"Append":   {Kinds: []int{TextFileArg}, Modes: []int{VarArg}},
+	"Close":    {Kinds: []int{FileArg}, Modes: []int{VarArg}},
+	"Eof":      {Kinds: []int{FileArg}, Modes: []int{VarArg}, Result: BOOLEAN},
+	"Eoln":     {Kinds: []int{TextFileArg}, Modes: []int{VarArg}, Result: BOOLEAN},
+	"Seek":     {Kinds: []int{TypedFileArg, IntegerArg}, Modes: []int{VarArg, ValueArg}},
+	"Truncate": {Kinds: []int{TypedFileArg}, Modes: []int{VarArg}},
+	"FilePos":  {Kinds: []int{TypedFileArg}, Modes: []int{VarArg}, Result: INTEGER},
+	"FileSize": {Kinds: []int{TypedFileArg}, Modes: []int{VarArg}, Result: INTEGER},
+}
+
+// ReadKinds and WriteKinds ...
+// The kinds of the variables Read reads and of the values Write
+// writes, tried in order
+var (
+	ReadKinds  = []int{CharArg, StringArg, IntegerArg, NumberArg}
+	WriteKinds = []int{CharArg, StringArg, IntegerArg, NumberArg, BooleanArg}
+)
+
+// Accepts ...
+// Reports whether a value of type s may be passed as an argument
+// of the given kind
+func (s *Symbol) Accepts(kind int) bool {
+	switch kind {
+	case TextArg:
+		return s.IsText()
+	case StringArg:
+		return s.Base().isBuiltin(STRING)
+	case CharArg:
+		return s.Base().isBuiltin(CHAR)
+	case IntegerArg:
+		return s.Base().isBuiltin(INTEGER)
+	case NumberArg:
+		return s.IsNumeric()
+	case PointerArg:
+		return s.Kind == PointerTypeSymbol
+	case FileArg:
+		return s.IsFile()
+	case BooleanArg:
+		return s.Base().Kind == EnumTypeSymbol && s.Base().Name == keyword(BOOLEAN)
+	case TextFileArg:
+		return s.isBuiltin(TEXT)
+	case TypedFileArg:
+		return s.Kind == FileTypeSymbol
+	}
+	return false
+}
@@ -28,6 +28,41 @@ const (
 	VAR
 	COLON
 	COMMA
+	ARRAY
+	OF
+	LBRACKET
+	RBRACKET
+	DOTDOT
+	RECORD
+	WITH
+	DO
+	TYPE
+	EQUAL
+	CONST
+	FOR
+	TO
+	DOWNTO
+	CASE
+	ELSE
+	OTHERWISE
+	SET
+	IN
+	BOOLEAN
+	NOTEQUAL
+	LESS
+	LESSEQUAL
+	GREATER
+	GREATEREQUAL
+	STRINGCONST
+	CHAR
+	STRING
+	CARET
+	NIL
+	PROCEDURE
+	FUNCTION
+	FORWARD
+	TEXT
+	FILE
 	EOF
 )
 
@@ -56,6 +91,41 @@ var (
 		"var",
 		":",
 		",",
+		"array",
+		"of",
+		"[",
+		"]",
+		"..",
+		"record",
+		"with",
+		"do",
+		"type",
+		"=",
+		"const",
+		"for",
+		"to",
+		"downto",
+		"case",
+		"else",
+		"otherwise",
+		"set",
+		"in",
+		"boolean",
+		"<>",
+		"<",
+		"<=",
+		">",
+		">=",
+		"string const",
+		"char",
+		"string",
+		"^",
+		"nil",
+		"procedure",
+		"function",
+		"forward",
+		"text",
+		"file",
 		"eof",
 	}
 
@@ -82,6 +152,41 @@ var (
 		"VAR",
 		"COLON",
 		"COMMA",
+		"ARRAY",
+		"OF",
+		"LBRACKET",
+		"RBRACKET",
+		"DOTDOT",
+		"RECORD",
+		"WITH",
+		"DO",
+		"TYPE",
+		"EQUAL",
+		"CONST",
+		"FOR",
+		"TO",
+		"DOWNTO",
+		"CASE",
+		"ELSE",
+		"OTHERWISE",
+		"SET",
+		"IN",
+		"BOOLEAN",
+		"NOTEQUAL",
+		"LESS",
+		"LESSEQUAL",
+		"GREATER",
+		"GREATEREQUAL",
+		"STRING CONST",
+		"CHAR",
+		"STRING",
+		"CARET",
+		"NIL",
+		"PROCEDURE",
+		"FUNCTION",
+		"FORWARD",
+		"TEXT",
+		"FILE",
 		"EOF",
 	}
 )
@@ -91,6 +196,40 @@ type Token struct {
 	Type   int
 	Value  float64
 	Svalue string
+	// Line and Column of the first character, both starting at 1
+	Line, Column int
+	// Comments that appeared in the source before the token
+	Comments []Comment
+	// Text is the token exactly as it appears in the source
+	Text string
+	// Leading and Trailing trivia are only kept when
+	// the lexer runs with KeepTrivia set
+	Leading, Trailing []Trivia
+}
+
+// Trivia kinds
+const (
+	WhitespaceTrivia = iota
+	CommentTrivia
+)
+
+// Trivia ...
+// Source text between tokens. A token owns the trivia on the lines
+// before it as Leading, and the spaces and comments that follow it
+// up to the end of its line as Trailing.
+type Trivia struct {
+	Kind int
+	Text string
+}
+
+// Comment ...
+// A {...} or (*...*) comment retained by the lexer
+type Comment struct {
+	Text string
+	Line int
+	// Trailing is set when the comment starts on the same
+	// line that the previous token ended on
+	Trailing bool
 }
 
 // String representation of the class instance.
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Trace formats
+const (
+	TraceText = iota
+	TraceJSON
+)
+
+// Tracer ...
+// Observer writing one event per executed statement, assignment,
+// call and return, as text or as JSON Lines. A statement is written
+// when it starts, so the trace of a program stopped by a runtime
+// error ends with the statement that failed. Assignments follow
+// the statement that made them.
+type Tracer struct {
+	Format int
+	// Types holds the types of the expressions of the program, as
+	// found by the semantic analyzer, to spell the values assigned by
+	// their type. Without them values are written as numbers.
+	Types map[Node]*Symbol
+	// Err is the first error met writing the trace
+	Err error
+
+	out   io.Writer
+	depth int
+	// line is the line of the statement that started last, for
+	// assignments made by other nodes
+	line int
+}
+
+// traceEvent ...
+// One line of the trace. Name is the procedure called or returned
+// from, or the variable assigned Value.
+type traceEvent struct {
+	Event  string `json:"event"`
+	Line   int    `json:"line,omitempty"`
+	Depth  int    `json:"depth"`
+	Name   string `json:"name,omitempty"`
+	Source string `json:"source,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// NewTracer ...
+func NewTracer(w io.Writer, format int) *Tracer {
+	return &Tracer{Format: format, out: w}
+}
+
+// EnterNode ...
+func (t *Tracer) EnterNode(n Node) {
+	if tok, ok := StatementToken(n); ok {
+		t.line = tok.Line
+		t.write(&traceEvent{
+			Event:  "statement",
+			Line:   tok.Line,
+			Depth:  t.depth,
+			Source: stmtString(n),
+		})
+	}
+}
+
+// ExitNode ...
+func (t *Tracer) ExitNode(n Node) {}
+
+// Assign ...
+// The type of the value is that of the variable assigned, the
+// left side of an assignment, the variable of a FOR statement or
+// the argument a builtin procedure assigns
+func (t *Tracer) Assign(name string, value Value, n Node) {
+	line, target := t.line, n
+	if tok, ok := StatementToken(n); ok {
+		line = tok.Line
+	}
+	switch n := n.(type) {
+	case *Assign:
+		target = n.Left
+	case *For:
+		target = n.Var
+	}
+	text := formatValue(value)
+	if typ := t.Types[target]; typ != nil {
+		text = typ.Spell(value)
+	}
+	t.write(&traceEvent{Event: "assign", Line: line, Depth: t.depth, Name: name, Value: text})
+}
+
+// Call ...
+func (t *Tracer) Call(ar *ActivationRecord) {
+	t.depth++
+	t.write(&traceEvent{Event: "call", Depth: t.depth, Name: ar.Name})
+}
+
+// Return ...
+func (t *Tracer) Return(ar *ActivationRecord) {
+	t.write(&traceEvent{Event: "return", Depth: t.depth, Name: ar.Name})
+	t.depth--
+}
+
+// Branch ...
+func (t *Tracer) Branch(n Node, arm int) {}
+
+// write ...
+// Writes an event, keeping the first error in Err
+func (t *Tracer) write(event *traceEvent) {
+	var err error
+	switch {
+	case t.Format == TraceJSON:
+		var line []byte
+		if line, err = json.Marshal(event); err == nil {
+			_, err = fmt.Fprintf(t.out, "%s\n", line)
+		}
+	case event.Event == "call":
+		_, err = fmt.Fprintf(t.out, "%*s-> %s\n", event.Depth*2, "", event.Name)
+	case event.Event == "return":
+		_, err = fmt.Fprintf(t.out, "%*s<- %s\n", event.Depth*2, "", event.Name)
+	case event.Event == "assign":
+		_, err = fmt.Fprintf(t.out, "%*s      [%s := %s]\n", event.Depth*2, "", event.Name, event.Value)
+	default:
+		_, err = fmt.Fprintf(t.out, "%*s%4d: %s\n", event.Depth*2, "", event.Line, event.Source)
+	}
+	if err != nil && t.Err == nil {
+		t.Err = err
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const traceTestProgram = `PROGRAM T;
+TYPE
+   Color = (Red, Green, Blue);
+   Cell = RECORD c : Color; on : BOOLEAN END;
+VAR
+   ch : CHAR; ok : BOOLEAN; c : Color; s : SET OF Color;
+   cells : ARRAY[1..2] OF Cell; p : ^INTEGER; name : STRING;
+   i : INTEGER; x : REAL;
+PROCEDURE Fill(VAR t : STRING);
+BEGIN
+   t := 'ab';
+   Insert('c', t, 2)
+END;
+BEGIN
+   ch := 'x';
+   ok := ch > 'a';
+   c := Blue;
+   s := [Red, Blue];
+   cells[2].c := Green;
+   New(p);
+   Fill(name);
+   FOR i := 1 TO 1 DO x := i / 2;
+   Dispose(p)
+END.
+`
+
+// trace runs traceTestProgram with a tracer writing in format
+func trace(t *testing.T, format int) string {
+	t.Helper()
+	tree, err := ParseSource(traceTestProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer := NewSemanticAnalyzer()
+	if errs := analyzer.Analyze(tree); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	var out bytes.Buffer
+	tracer := NewTracer(&out, format)
+	tracer.Types = analyzer.ExprTypes
+	in := NewInterpreter()
+	in.Observe(tracer)
+	if err := in.Interpret(tree); err != nil {
+		t.Fatal(err)
+	}
+	if tracer.Err != nil {
+		t.Fatal(tracer.Err)
+	}
+	return out.String()
+}
+
+func TestTraceText(t *testing.T) {
+	want := `  -> T
+    15: ch := 'x'
+        [ch := 'x']
+    16: ok := ch > 'a'
+        [ok := True]
+    17: c := Blue
+        [c := Blue]
+    18: s := [Red, Blue]
+        [s := [Red, Blue]]
+    19: cells[2].c := Green
+        [cells[2].c := Green]
+    20: New(p)
+        [p := ^1]
+    21: Fill(name)
+    -> Fill
+      11: t := 'ab'
+          [t := 'ab']
+      12: Insert('c', t, 2)
+          [t := 'acb']
+    <- Fill
+    22: FOR i := 1 TO 1 DO
+        [i := 1]
+    22: x := i / 2
+        [x := 0.5]
+    23: Dispose(p)
+  <- T
+`
+	if got := trace(t, TraceText); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTraceJSON(t *testing.T) {
+	var events []traceEvent
+	for _, line := range strings.Split(strings.TrimSuffix(trace(t, TraceJSON), "\n"), "\n") {
+		var event traceEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("%s: %s", line, err)
+		}
+		events = append(events, event)
+	}
+	values := map[string]string{}
+	for _, event := range events {
+		if event.Event == "assign" {
+			values[event.Name] = event.Value
+		}
+	}
+	want := map[string]string{
+		"ch": "'x'", "ok": "True", "c": "Blue", "s": "[Red, Blue]", "cells[2].c": "Green",
+		"p": "^1", "t": "'acb'", "i": "1", "x": "0.5",
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+	call := events[14]
+	if call.Event != "call" || call.Name != "Fill" || call.Depth != 2 {
+		t.Errorf("event 14 is %+v, want the call of Fill", call)
+	}
+	if insert := events[18]; insert.Event != "assign" || insert.Line != 12 || insert.Depth != 2 {
+		t.Errorf("event 18 is %+v, want the assignment Insert made on line 12", insert)
+	}
+}
+
+func TestTraceSpellsWithoutTypes(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(&out, TraceText)
+	tracer.Assign("c", 2.0, nil)
+	if got := out.String(); got != "      [c := 2]\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTraceReportsWriteErrors(t *testing.T) {
+	for _, format := range []int{TraceText, TraceJSON} {
+		tracer := NewTracer(failingWriter{}, format)
+		tracer.Call(NewActivationRecord("P", ProcedureAR, 1))
+		tracer.Return(NewActivationRecord("Q", ProcedureAR, 1))
+		if tracer.Err == nil || tracer.Err.Error() != "disk full" {
+			t.Errorf("format %d: Err = %v, want the first write error", format, tracer.Err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Value ...
+// A value computed by the interpreter: a float64 for numbers, an
+// *ArrayValue for arrays, a *RecordValue for records, a SetValue
+// for sets, a string for strings, a Pointer for pointers, a
+// Routine for procedural values or a *FileValue for files.
+// Characters and the values of enumerations are numbers as well.
+type Value interface{}
+
+// ArrayValue ...
+// One dimension of an array, the elements of a multi-dimensional
+// array are arrays themselves.
+type ArrayValue struct {
+	Low, High int
+	Elems     []Value
+}
+
+// NewArrayValue ...
+// Creates an array with every element set to zero
+func NewArrayValue(low, high int, zero func() Value) *ArrayValue {
+	a := &ArrayValue{Low: low, High: high}
+	a.Elems = make([]Value, high-low+1)
+	for i := range a.Elems {
+		a.Elems[i] = zero()
+	}
+	return a
+}
+
+// Contains ...
+// Reports whether i is within the bounds of the array
+func (a *ArrayValue) Contains(i int) bool {
+	return a.Low <= i && i <= a.High
+}
+
+// Get ...
+func (a *ArrayValue) Get(i int) Value {
+	return a.Elems[i-a.Low]
+}
+
+// Set ...
+func (a *ArrayValue) Set(i int, v Value) {
+	a.Elems[i-a.Low] = v
+}
+
+func (a *ArrayValue) String() string {
+	elems := make([]string, len(a.Elems))
+	for i, elem := range a.Elems {
+		elems[i] = formatValue(elem)
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// RecordValue ...
+type RecordValue struct {
+	// Names of the fields in the order they were declared
+	Names  []string
+	Fields map[string]Value
+}
+
+// NewRecordValue ...
+func NewRecordValue() *RecordValue {
+	return &RecordValue{Fields: make(map[string]Value)}
+}
+
+// Add ...
+// Declares a field with its initial value
+func (r *RecordValue) Add(name string, v Value) {
+	r.Names = append(r.Names, name)
+	r.Fields[name] = v
+}
+
+// Get ...
+func (r *RecordValue) Get(name string) Value {
+	return r.Fields[name]
+}
+
+// Set ...
+func (r *RecordValue) Set(name string, v Value) {
+	r.Fields[name] = v
+}
+
+func (r *RecordValue) String() string {
+	fields := make([]string, len(r.Names))
+	for i, name := range r.Names {
+		fields[i] = name + ": " + formatValue(r.Fields[name])
+	}
+	return "(" + strings.Join(fields, "; ") + ")"
+}
+
+// MaxInteger ...
+// INTEGERs are held as float64s, which hold every integer up to
+// MaxInteger exactly. A number beyond it rounds to one beyond it.
+const MaxInteger = 1<<53 - 1
+
+// MaxSetElement ...
+// Sets hold ordinal values from 0 up to MaxSetElement
+const MaxSetElement = 255
+
+// SetValue ...
+// A set as a bitset, bit v of the words standing for the value v.
+// It is copied on assignment like any other Go value.
+type SetValue struct {
+	words [(MaxSetElement + 1) / 64]uint64
+}
+
+// Add ...
+// v must be between 0 and MaxSetElement
+func (s *SetValue) Add(v int) {
+	s.words[v/64] |= 1 << uint(v%64)
+}
+
+// Contains ...
+func (s SetValue) Contains(v int) bool {
+	return 0 <= v && v <= MaxSetElement && s.words[v/64]&(1<<uint(v%64)) != 0
+}
+
+// Union ...
+func (s SetValue) Union(o SetValue) SetValue {
+	for i := range s.words {
+		s.words[i] |= o.words[i]
+	}
+	return s
+}
+
+// Difference ...
+func (s SetValue) Difference(o SetValue) SetValue {
+	for i := range s.words {
+		s.words[i] &^= o.words[i]
+	}
+	return s
+}
+
+// Intersection ...
+func (s SetValue) Intersection(o SetValue) SetValue {
+	for i := range s.words {
+		s.words[i] &= o.words[i]
+	}
+	return s
+}
+
+// SubsetOf ...
+// Reports whether every element of s is in o
+func (s SetValue) SubsetOf(o SetValue) bool {
+	for i := range s.words {
+		if s.words[i]&^o.words[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Elems ...
+// The elements in ascending order
+func (s SetValue) Elems() []int {
+	var elems []int
+	for v := 0; v <= MaxSetElement; v++ {
+		if s.Contains(v) {
+			elems = append(elems, v)
+		}
+	}
+	return elems
+}
+
+func (s SetValue) String() string {
+	var elems []string
+	for _, v := range s.Elems() {
+		elems = append(elems, strconv.Itoa(v))
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// boolean ...
+// The BOOLEAN value for b, the position of False or True
+func boolean(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// text ...
+// Returns a string or a character as a string
+func text(v Value) string {
+	if c, ok := v.(float64); ok {
+		return string([]byte{byte(c)})
+	}
+	return v.(string)
+}
+
+// copyValue ...
+// Pascal assigns arrays and records by value, so they are copied whole
+func copyValue(v Value) Value {
+	switch v := v.(type) {
+	case *ArrayValue:
+		c := &ArrayValue{Low: v.Low, High: v.High, Elems: make([]Value, len(v.Elems))}
+		for i, elem := range v.Elems {
+			c.Elems[i] = copyValue(elem)
+		}
+		return c
+	case *RecordValue:
+		c := NewRecordValue()
+		for _, name := range v.Names {
+			c.Add(name, copyValue(v.Fields[name]))
+		}
+		return c
+	}
+	return v
+}
+
+// formatValue ...
+func formatValue(v Value) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case *ArrayValue:
+		return v.String()
+	case *RecordValue:
+		return v.String()
+	case SetValue:
+		return v.String()
+	case string:
+		return quoteString(v)
+	case Pointer:
+		return v.String()
+	case *Alias:
+		return formatValue(v.Get())
+	case Routine:
+		return v.String()
+	case *FileValue:
+		return v.String()
+	}
+	return "?"
+}
+
+// Routine ...
+// A procedural value, the procedure or function with the record of
+// the body it was taken in that its static link points to. Decl is
+// nil for a procedural variable never assigned.
+type Routine struct {
+	Decl *ProcedureDecl
+	Link *ActivationRecord
+}
+
+func (r Routine) String() string {
+	if r.Decl == nil {
+		return keyword(NIL)
+	}
+	return r.Decl.Name
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 )
 
 // ASTVisualizer ...
@@ -30,6 +31,29 @@ func NewASTVisualizer() *ASTVisualizer {
 	av.VisitMap[BlockNode] = av.VisitBlock
 	av.VisitMap[VarDeclNode] = av.VisitVarDecl
 	av.VisitMap[TypeNode] = av.VisitType
+	av.VisitMap[TypeDeclNode] = av.VisitTypeDecl
+	av.VisitMap[ConstDeclNode] = av.VisitConstDecl
+	av.VisitMap[EnumNode] = av.VisitEnum
+	av.VisitMap[CallNode] = av.VisitCall
+	av.VisitMap[ForNode] = av.VisitFor
+	av.VisitMap[CaseNode] = av.VisitCase
+	av.VisitMap[ArrayTypeNode] = av.VisitArrayType
+	av.VisitMap[SubrangeNode] = av.VisitSubrange
+	av.VisitMap[IndexNode] = av.VisitIndex
+	av.VisitMap[RecordTypeNode] = av.VisitRecordType
+	av.VisitMap[FieldNode] = av.VisitField
+	av.VisitMap[WithNode] = av.VisitWith
+	av.VisitMap[SetTypeNode] = av.VisitSetType
+	av.VisitMap[PointerTypeNode] = av.VisitPointerType
+	av.VisitMap[DerefNode] = av.VisitDeref
+	av.VisitMap[NilNode] = av.VisitNil
+	av.VisitMap[SetConstructorNode] = av.VisitSetConstructor
+	av.VisitMap[StrNode] = av.VisitStr
+	av.VisitMap[ProcedureCallNode] = av.VisitProcedureCall
+	av.VisitMap[ProcedureDeclNode] = av.VisitProcedureDecl
+	av.VisitMap[ParamNode] = av.VisitParam
+	av.VisitMap[ProceduralTypeNode] = av.VisitProceduralType
+	av.VisitMap[FileTypeNode] = av.VisitFileType
 	return av
 }
 
@@ -49,12 +73,18 @@ func (av *ASTVisualizer) Generate(n Node) {
 
 // RunDot ...
 func (av *ASTVisualizer) RunDot() {
+	runDot("astgraph.dot", "ast.png")
+}
+
+// runDot ...
+// Renders the graphviz file src into the png image dst
+func runDot(src, dst string) {
 	_, err := exec.LookPath("dot")
 	if err != nil {
 		fmt.Printf("could not locate program \"dot\"\n")
 		return
 	}
-	cmddot := exec.Command("dot", "-Tpng", "-oast.png", "astgraph.dot")
+	cmddot := exec.Command("dot", "-Tpng", "-o"+dst, src)
 	out, err := cmddot.CombinedOutput()
 	if err != nil {
 		fmt.Println("error:", err)
@@ -114,11 +144,367 @@ func (av *ASTVisualizer) VisitVarDecl(n Node) int {
 	return id
 }
 
+// VisitProcedureDecl ...
+func (av *ASTVisualizer) VisitProcedureDecl(n Node) int {
+	node := n.(*ProcedureDecl)
+	id := av.ID
+	av.ID++
+	label := "ProcedureDecl"
+	if node.Result != nil {
+		label = "FunctionDecl"
+	}
+	s := fmt.Sprintf("Node%d [label=\"%s\n%s\"]\n", id, label, node.Name)
+	av.buffer.WriteString(s)
+	av.signature(id, node.Params, node.Result)
+	if node.BlockNode == nil {
+		return id
+	}
+	childid := av.Visit(node.BlockNode)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitParam ...
+func (av *ASTVisualizer) VisitParam(n Node) int {
+	node := n.(*Param)
+	id := av.ID
+	av.ID++
+	label := node.Name
+	switch node.Mode {
+	case VarArg:
+		label = keyword(VAR) + " " + label
+	case ConstArg:
+		label = keyword(CONST) + " " + label
+	}
+	s := fmt.Sprintf("Node%d [label=\"Param\n%s\"]\n", id, label)
+	av.buffer.WriteString(s)
+	tid := av.Visit(node.TNode)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, tid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitTypeDecl ...
+func (av *ASTVisualizer) VisitTypeDecl(n Node) int {
+	node := n.(*TypeDecl)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"TypeDecl\n%s\"]\n", id, node.Name)
+	av.buffer.WriteString(s)
+	tid := av.Visit(node.TNode)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, tid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitConstDecl ...
+func (av *ASTVisualizer) VisitConstDecl(n Node) int {
+	node := n.(*ConstDecl)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"ConstDecl\n%s\"]\n", id, node.Name)
+	av.buffer.WriteString(s)
+	eid := av.Visit(node.Expr)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, eid)
+	av.buffer.WriteString(s)
+	return id
+}
+
 // VisitType ...
 func (av *ASTVisualizer) VisitType(n Node) int {
+	node := n.(*TypeN)
 	id := av.ID
 	av.ID++
-	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, TokenStr[n.(*TypeN).Tok.Type])
+	label := TokenStr[node.Tok.Type]
+	if node.Tok.Type == IDENT {
+		label = node.Tok.Svalue
+	}
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, label)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitArrayType ...
+func (av *ASTVisualizer) VisitArrayType(n Node) int {
+	node := n.(*ArrayType)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "array")
+	av.buffer.WriteString(s)
+	for _, index := range node.Indexes {
+		childid := av.Visit(index)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	childid := av.Visit(node.Elem)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitSubrange ...
+func (av *ASTVisualizer) VisitSubrange(n Node) int {
+	node := n.(*Subrange)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "..")
+	av.buffer.WriteString(s)
+	lid := av.Visit(node.Low)
+	rid := av.Visit(node.High)
+	s = fmt.Sprintf("Node%d -> Node%d\nNode%d -> Node%d\n", id, lid, id, rid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitSetType ...
+func (av *ASTVisualizer) VisitSetType(n Node) int {
+	node := n.(*SetType)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "set")
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Elem)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitFileType ...
+func (av *ASTVisualizer) VisitFileType(n Node) int {
+	node := n.(*FileType)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "file")
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Elem)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitProceduralType ...
+func (av *ASTVisualizer) VisitProceduralType(n Node) int {
+	node := n.(*ProceduralType)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, keyword(node.Tok.Type))
+	av.buffer.WriteString(s)
+	av.signature(id, node.Params, node.Result)
+	return id
+}
+
+// signature ...
+// Links the parameters and the result type of a function to node id
+func (av *ASTVisualizer) signature(id int, params []*Param, result Node) {
+	for _, param := range params {
+		childid := av.Visit(param)
+		s := fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	if result != nil {
+		childid := av.Visit(result)
+		s := fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+}
+
+// VisitPointerType ...
+func (av *ASTVisualizer) VisitPointerType(n Node) int {
+	node := n.(*PointerType)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "^")
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Elem)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitSetConstructor ...
+func (av *ASTVisualizer) VisitSetConstructor(n Node) int {
+	node := n.(*SetConstructor)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "[...]")
+	av.buffer.WriteString(s)
+	for _, elem := range node.Elems {
+		childid := av.Visit(elem)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	return id
+}
+
+// VisitEnum ...
+func (av *ASTVisualizer) VisitEnum(n Node) int {
+	node := n.(*Enum)
+	id := av.ID
+	av.ID++
+	var names []string
+	for _, name := range node.Names {
+		names = append(names, name.Svalue)
+	}
+	s := fmt.Sprintf("Node%d [label=\"(%s)\"]\n", id, strings.Join(names, ", "))
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitCall ...
+func (av *ASTVisualizer) VisitCall(n Node) int {
+	node := n.(*Call)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s()\"]\n", id, node.Name)
+	av.buffer.WriteString(s)
+	for _, arg := range node.Args {
+		childid := av.Visit(arg)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	return id
+}
+
+// VisitFor ...
+func (av *ASTVisualizer) VisitFor(n Node) int {
+	node := n.(*For)
+	id := av.ID
+	av.ID++
+	label := keyword(TO)
+	if node.Down {
+		label = keyword(DOWNTO)
+	}
+	s := fmt.Sprintf("Node%d [label=\"for %s\"]\n", id, strings.ToLower(label))
+	av.buffer.WriteString(s)
+	for _, child := range []Node{node.Var, node.Start, node.End, node.Body} {
+		childid := av.Visit(child)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	return id
+}
+
+// VisitCase ...
+// Each arm is a node labelled with its CASE labels
+func (av *ASTVisualizer) VisitCase(n Node) int {
+	node := n.(*Case)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "case")
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Expr)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	for _, arm := range node.Arms {
+		armid := av.caseArm(id, caseLabels(arm)+":")
+		childid = av.Visit(arm.Body)
+		s = fmt.Sprintf("Node%d -> Node%d\n", armid, childid)
+		av.buffer.WriteString(s)
+	}
+	if node.Else != nil {
+		armid := av.caseArm(id, strings.ToLower(keyword(ELSE)))
+		for _, stmt := range node.Else {
+			childid = av.Visit(stmt)
+			s = fmt.Sprintf("Node%d -> Node%d\n", armid, childid)
+			av.buffer.WriteString(s)
+		}
+	}
+	return id
+}
+
+// caseArm ...
+// Writes the node of one arm below the CASE node parent
+func (av *ASTVisualizer) caseArm(parent int, label string) int {
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\nNode%d -> Node%d\n", id, label, parent, id)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitIndex ...
+func (av *ASTVisualizer) VisitIndex(n Node) int {
+	node := n.(*Index)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "[]")
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Array)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	for _, index := range node.Indexes {
+		childid = av.Visit(index)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	return id
+}
+
+// VisitRecordType ...
+func (av *ASTVisualizer) VisitRecordType(n Node) int {
+	node := n.(*RecordType)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "record")
+	av.buffer.WriteString(s)
+	for _, field := range node.Fields {
+		childid := av.Visit(field)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	return id
+}
+
+// VisitField ...
+func (av *ASTVisualizer) VisitField(n Node) int {
+	node := n.(*Field)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\".%s\"]\n", id, node.Name)
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Record)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitDeref ...
+func (av *ASTVisualizer) VisitDeref(n Node) int {
+	node := n.(*Deref)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "^")
+	av.buffer.WriteString(s)
+	childid := av.Visit(node.Pointer)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitNil ...
+func (av *ASTVisualizer) VisitNil(n Node) int {
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, keyword(NIL))
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitWith ...
+func (av *ASTVisualizer) VisitWith(n Node) int {
+	node := n.(*With)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, "with")
+	av.buffer.WriteString(s)
+	for _, record := range node.Records {
+		childid := av.Visit(record)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	childid := av.Visit(node.Body)
+	s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
 	av.buffer.WriteString(s)
 	return id
 }
@@ -162,6 +548,32 @@ func (av *ASTVisualizer) VisitNum(n Node) int {
 	return id
 }
 
+// VisitStr ...
+func (av *ASTVisualizer) VisitStr(n Node) int {
+	node := n.(*Str)
+	id := av.ID
+	av.ID++
+	label := strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(quoteString(node.Value))
+	s := fmt.Sprintf("Node%d [label=\"%s\"]\n", id, label)
+	av.buffer.WriteString(s)
+	return id
+}
+
+// VisitProcedureCall ...
+func (av *ASTVisualizer) VisitProcedureCall(n Node) int {
+	node := n.(*ProcedureCall)
+	id := av.ID
+	av.ID++
+	s := fmt.Sprintf("Node%d [label=\"%s()\"]\n", id, node.Name)
+	av.buffer.WriteString(s)
+	for _, arg := range node.Args {
+		childid := av.Visit(arg)
+		s = fmt.Sprintf("Node%d -> Node%d\n", id, childid)
+		av.buffer.WriteString(s)
+	}
+	return id
+}
+
 // VisitCompound ...
 func (av *ASTVisualizer) VisitCompound(n Node) int {
 	node := n.(*Compound)